@@ -0,0 +1,182 @@
+// Package analytics detects the communities of channels which share their
+// audiences in the viewer-flow graph and ranks the channels by weighted
+// PageRank.
+package analytics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pmrt/viewergraph/config"
+	"github.com/pmrt/viewergraph/database/postgres"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+	"github.com/pmrt/viewergraph/utils"
+	"github.com/rs/zerolog"
+)
+
+// Analyze returns the community and the PageRank of each channel of the flow
+// `edges`, sorted by community, PageRank and channel. The window and
+// computation times are not set.
+//
+// Communities are detected with the Louvain method over the undirected graph,
+// where the weight between two channels is the sum of the flows in both
+// directions. They are labeled from 0, the largest first.
+func Analyze(edges []*clickhouse.FlowEdge) []*clickhouse.ChannelCommunity {
+	idx := make(map[string]int)
+	for _, e := range edges {
+		idx[e.Referrer] = 0
+		idx[e.Channel] = 0
+	}
+	// nodes are numbered in the order of the channels, so the result doesn't
+	// depend on the order of the edges
+	channels := make([]string, 0, len(idx))
+	for ch := range idx {
+		channels = append(channels, ch)
+	}
+	sort.Strings(channels)
+	for i, ch := range channels {
+		idx[ch] = i
+	}
+
+	type pair struct{ u, v int }
+	weights := make(map[pair]float64)
+	pairs := make([]pair, 0, len(edges))
+	for _, e := range edges {
+		if e.Referrer == e.Channel || e.Total == 0 {
+			continue
+		}
+		p := pair{idx[e.Referrer], idx[e.Channel]}
+		if _, ok := weights[p]; !ok {
+			pairs = append(pairs, p)
+		}
+		weights[p] += float64(e.Total)
+	}
+	// and so are the edges, floating point sums depend on their order
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].u != pairs[j].u {
+			return pairs[i].u < pairs[j].u
+		}
+		return pairs[i].v < pairs[j].v
+	})
+	und := newUndirected(len(channels))
+	dir := newDirected(len(channels))
+	for _, p := range pairs {
+		und.add(p.u, p.v, weights[p])
+		dir.add(p.u, p.v, weights[p])
+	}
+	comm := louvain(und)
+	pr := pageRank(dir)
+
+	// label the communities by size, then by their first channel
+	members := make(map[int][]int)
+	var ids []int
+	for i, c := range comm {
+		if _, ok := members[c]; !ok {
+			ids = append(ids, c)
+		}
+		members[c] = append(members[c], i)
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return len(members[ids[i]]) > len(members[ids[j]])
+	})
+	labels := make(map[int]uint32, len(ids))
+	for label, c := range ids {
+		labels[c] = uint32(label)
+	}
+
+	r := make([]*clickhouse.ChannelCommunity, 0, len(channels))
+	for i, ch := range channels {
+		r = append(r, &clickhouse.ChannelCommunity{
+			Channel:   ch,
+			Community: labels[comm[i]],
+			PageRank:  pr[i],
+		})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		a, b := r[i], r[j]
+		if a.Community != b.Community {
+			return a.Community < b.Community
+		}
+		if a.PageRank != b.PageRank {
+			return a.PageRank > b.PageRank
+		}
+		return a.Channel < b.Channel
+	})
+	return r
+}
+
+type RefresherOpts struct {
+	Events clickhouse.Repository
+	// Interval is how often the communities are recomputed
+	Interval time.Duration
+	// Window is the time range of the viewer-flow graph, ending at the
+	// current hour
+	Window time.Duration
+	// MinWeight is the minimum number of users of an edge of the graph
+	MinWeight uint64
+	// Now is the clock the window ends at, time.Now by default
+	Now func() time.Time
+}
+
+// OptsFromConfig reads the options from the communities section of `c`,
+// leaving Events to the caller.
+func OptsFromConfig(c *config.Config) *RefresherOpts {
+	return &RefresherOpts{
+		Interval:  c.Communities.RefreshInterval,
+		Window:    c.Communities.Window,
+		MinWeight: c.Communities.MinWeight,
+	}
+}
+
+// Refresher recomputes and stores the communities of the viewer-flow graph of
+// the last Window every Interval, see postgres.PeriodicJob.
+type Refresher struct {
+	*postgres.PeriodicJob
+	opts *RefresherOpts
+	l    zerolog.Logger
+}
+
+// Refresh computes and stores the communities of the viewer-flow graph of the
+// last Window.
+func (r *Refresher) Refresh() error {
+	now := r.opts.Now().UTC().Truncate(time.Second)
+	end := now.Truncate(time.Hour)
+	start := end.Add(-r.opts.Window)
+
+	edges, err := r.opts.Events.FlowEdges(start, end, r.opts.MinWeight)
+	if err != nil {
+		return err
+	}
+	cs := Analyze(edges)
+	for _, c := range cs {
+		c.WindowStart = start
+		c.WindowEnd = end
+		c.ComputedAt = now
+	}
+	if err := r.opts.Events.InsertCommunities(cs); err != nil {
+		return err
+	}
+	r.l.Info().Int("channels", len(cs)).Msg("refreshed communities")
+	return nil
+}
+
+func New(opts *RefresherOpts) *Refresher {
+	if opts.Interval == 0 {
+		opts.Interval = time.Hour
+	}
+	if opts.Window == 0 {
+		opts.Window = 7 * 24 * time.Hour
+	}
+	if opts.MinWeight == 0 {
+		opts.MinWeight = 1
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	r := &Refresher{
+		opts: opts,
+		l:    utils.Logger("analytics"),
+	}
+	r.PeriodicJob = postgres.NewPeriodicJob("communities", opts.Interval, r.Refresh)
+	return r
+}
@@ -0,0 +1,127 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+// twoClusters are two groups of channels, {a, b, c} and {x, y}, which share
+// most of their viewers inside the group, bridged by a weak flow from c to x
+func twoClusters() []*clickhouse.FlowEdge {
+	return []*clickhouse.FlowEdge{
+		{Referrer: "a", Channel: "b", Total: 10},
+		{Referrer: "b", Channel: "a", Total: 8},
+		{Referrer: "b", Channel: "c", Total: 9},
+		{Referrer: "c", Channel: "a", Total: 7},
+		{Referrer: "x", Channel: "y", Total: 12},
+		{Referrer: "y", Channel: "x", Total: 6},
+		{Referrer: "c", Channel: "x", Total: 1},
+	}
+}
+
+func communities(cs []*clickhouse.ChannelCommunity) map[string]uint32 {
+	r := make(map[string]uint32)
+	for _, c := range cs {
+		r[c.Channel] = c.Community
+	}
+	return r
+}
+
+func TestAnalyzeCommunities(t *testing.T) {
+	got := communities(Analyze(twoClusters()))
+	want := map[string]uint32{"a": 0, "b": 0, "c": 0, "x": 1, "y": 1}
+	for ch, c := range want {
+		if got[ch] != c {
+			t.Fatalf("expected %s in community %d, got %v", ch, c, got)
+		}
+	}
+
+	// the result doesn't depend on the order of the edges
+	edges := twoClusters()
+	for i, j := 0, len(edges)-1; i < j; i, j = i+1, j-1 {
+		edges[i], edges[j] = edges[j], edges[i]
+	}
+	reversed := Analyze(edges)
+	for i, c := range Analyze(twoClusters()) {
+		if *c != *reversed[i] {
+			t.Fatalf("expected %+v, got %+v", c, reversed[i])
+		}
+	}
+}
+
+func TestAnalyzePageRank(t *testing.T) {
+	// b and c only send viewers to a, which sends them nowhere
+	cs := Analyze([]*clickhouse.FlowEdge{
+		{Referrer: "b", Channel: "a", Total: 5},
+		{Referrer: "c", Channel: "a", Total: 5},
+	})
+	sum := 0.0
+	pr := make(map[string]float64)
+	for _, c := range cs {
+		sum += c.PageRank
+		pr[c.Channel] = c.PageRank
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Fatalf("expected the ranks to sum up to 1, got %v", sum)
+	}
+	if pr["a"] <= pr["b"] || pr["b"] != pr["c"] {
+		t.Fatalf("expected a to rank first and b and c to tie, got %v", pr)
+	}
+	if cs[0].Channel != "a" {
+		t.Fatalf("expected a first, got %s", cs[0].Channel)
+	}
+}
+
+func TestAnalyzeEmpty(t *testing.T) {
+	if cs := Analyze(nil); len(cs) != 0 {
+		t.Fatalf("expected no communities, got %d", len(cs))
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	events := clickhouse.NewMemory()
+	base := time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC)
+	for i, usr := range []string{"user1", "user2"} {
+		for j, ch := range []string{"a", "b"} {
+			events.InsertRawEvent(&clickhouse.RawEvent{
+				Ts:        base.Add(time.Duration(i*2+j) * time.Hour),
+				Username:  usr,
+				Channel:   ch,
+				EventType: "view",
+			})
+		}
+	}
+	if err := events.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 10, 12, 0, 30, 0, 0, time.UTC)
+	r := New(&RefresherOpts{
+		Events: events,
+		Window: 24 * time.Hour,
+		Now:    func() time.Time { return now },
+	})
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	end := time.Date(2020, 10, 12, 0, 0, 0, 0, time.UTC)
+	cs, err := events.Communities(end.Add(-24*time.Hour), end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 {
+		t.Fatalf("expected the communities of a and b, got %d", len(cs))
+	}
+	for _, c := range cs {
+		if !c.WindowStart.Equal(end.Add(-24*time.Hour)) || !c.WindowEnd.Equal(end) || !c.ComputedAt.Equal(now) {
+			t.Fatalf("unexpected window of %s: [%s, %s] computed at %s", c.Channel, c.WindowStart, c.WindowEnd, c.ComputedAt)
+		}
+		if c.Community != 0 {
+			t.Fatalf("expected a and b in the same community, got %d for %s", c.Community, c.Channel)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package analytics
+
+import "sort"
+
+// undirected is a weighted undirected graph of nodes 0..n-1. Self loops are
+// the weight inside the communities merged into a node.
+type undirected struct {
+	adj []map[int]float64
+	// deg is the weighted degree of each node, the sum of its row of adj
+	deg []float64
+	// m2 is twice the total weight of the graph
+	m2 float64
+}
+
+func newUndirected(n int) *undirected {
+	g := &undirected{
+		adj: make([]map[int]float64, n),
+		deg: make([]float64, n),
+	}
+	for i := range g.adj {
+		g.adj[i] = make(map[int]float64)
+	}
+	return g
+}
+
+func (g *undirected) add(u, v int, w float64) {
+	g.adj[u][v] += w
+	g.adj[v][u] += w
+	g.deg[u] += w
+	g.deg[v] += w
+	g.m2 += 2 * w
+}
+
+// move runs the local moving phase of Louvain: each node is moved to the
+// neighbour community with the largest modularity gain until no move improves
+// the modularity. Nodes are visited in order and ties keep the current
+// community, so the result is deterministic. It reports whether any node was
+// moved.
+func (g *undirected) move() ([]int, bool) {
+	n := len(g.adj)
+	comm := make([]int, n)
+	tot := make([]float64, n)
+	for i := range comm {
+		comm[i] = i
+		tot[i] = g.deg[i]
+	}
+	if g.m2 == 0 {
+		return comm, false
+	}
+
+	moved := false
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < n; i++ {
+			links := make(map[int]float64)
+			for j, w := range g.adj[i] {
+				if j != i {
+					links[comm[j]] += w
+				}
+			}
+			neighbours := make([]int, 0, len(links))
+			for c := range links {
+				neighbours = append(neighbours, c)
+			}
+			sort.Ints(neighbours)
+
+			ci := comm[i]
+			tot[ci] -= g.deg[i]
+			best, bestGain := ci, links[ci]-tot[ci]*g.deg[i]/g.m2
+			for _, c := range neighbours {
+				if gain := links[c] - tot[c]*g.deg[i]/g.m2; gain > bestGain+1e-12 {
+					best, bestGain = c, gain
+				}
+			}
+			tot[best] += g.deg[i]
+			if best != ci {
+				comm[i] = best
+				improved, moved = true, true
+			}
+		}
+	}
+	return comm, moved
+}
+
+// aggregate returns the graph whose nodes are the `k` communities `comm` of
+// the nodes of `g`
+func (g *undirected) aggregate(comm []int, k int) *undirected {
+	agg := newUndirected(k)
+	for i, row := range g.adj {
+		for j, w := range row {
+			agg.adj[comm[i]][comm[j]] += w
+		}
+		agg.deg[comm[i]] += g.deg[i]
+	}
+	agg.m2 = g.m2
+	return agg
+}
+
+// renumber maps the community ids of `comm` to 0..k-1
+func renumber(comm []int) ([]int, int) {
+	ids := make(map[int]int)
+	r := make([]int, len(comm))
+	for i, c := range comm {
+		id, ok := ids[c]
+		if !ok {
+			id = len(ids)
+			ids[c] = id
+		}
+		r[i] = id
+	}
+	return r, len(ids)
+}
+
+// louvain returns the community of each node of `g` which maximizes the
+// modularity, by the Louvain method: local moving followed by the
+// aggregation of the communities into nodes, until no node is moved.
+func louvain(g *undirected) []int {
+	member := make([]int, len(g.adj))
+	for i := range member {
+		member[i] = i
+	}
+	for {
+		comm, moved := g.move()
+		if !moved {
+			return member
+		}
+		comm, k := renumber(comm)
+		for i, c := range member {
+			member[i] = comm[c]
+		}
+		g = g.aggregate(comm, k)
+	}
+}
@@ -0,0 +1,78 @@
+package analytics
+
+import "math"
+
+const (
+	// Damping is the probability of following an edge instead of jumping to
+	// a random node
+	Damping = 0.85
+	// pageRankTolerance is the L1 change between iterations at which
+	// PageRank is considered converged
+	pageRankTolerance = 1e-10
+	pageRankMaxIter   = 100
+)
+
+// directed is a weighted directed graph of nodes 0..n-1
+type directed struct {
+	in  [][]link
+	out []float64
+}
+
+// link is an edge from `node` of weight `w`
+type link struct {
+	node int
+	w    float64
+}
+
+func newDirected(n int) *directed {
+	return &directed{
+		in:  make([][]link, n),
+		out: make([]float64, n),
+	}
+}
+
+func (g *directed) add(u, v int, w float64) {
+	g.in[v] = append(g.in[v], link{u, w})
+	g.out[u] += w
+}
+
+// pageRank returns the weighted PageRank of the nodes of `g`: a random
+// walker follows an edge with probability proportional to its weight. The
+// rank of nodes without out edges is spread over all the nodes, so the ranks
+// sum up to 1.
+func pageRank(g *directed) []float64 {
+	n := len(g.out)
+	if n == 0 {
+		return nil
+	}
+	pr := make([]float64, n)
+	for i := range pr {
+		pr[i] = 1 / float64(n)
+	}
+
+	next := make([]float64, n)
+	for iter := 0; iter < pageRankMaxIter; iter++ {
+		dangling := 0.0
+		for i, o := range g.out {
+			if o == 0 {
+				dangling += pr[i]
+			}
+		}
+		base := (1-Damping)/float64(n) + Damping*dangling/float64(n)
+
+		delta := 0.0
+		for v := range next {
+			r := base
+			for _, l := range g.in[v] {
+				r += Damping * pr[l.node] * l.w / g.out[l.node]
+			}
+			next[v] = r
+			delta += math.Abs(r - pr[v])
+		}
+		pr, next = next, pr
+		if delta < pageRankTolerance {
+			break
+		}
+	}
+	return pr
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/planner"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+	"github.com/pmrt/viewergraph/repo/postgres"
+	l "github.com/rs/zerolog/log"
+)
+
+type APIOpts struct {
+	Port string
+
+	// Planner is optional. If nil, the planner endpoints are not registered.
+	Planner *planner.Planner
+	// AdminToken protects the endpoints which change the state of the
+	// planner, requiring the header `Authorization: Bearer <AdminToken>`. If
+	// empty, those endpoints answer 403 Forbidden.
+	AdminToken string
+	// Health configures the dependencies reported by /readyz.
+	Health *HealthOpts
+	// Events is optional. If nil, the audience, flows, graph, communities,
+	// streams, cohorts, churn, recommendations and raids endpoints are not
+	// registered.
+	Events clickhouse.Repository
+	// Channels provides the metadata of the tracked channels of the graph. It
+	// is optional.
+	Channels postgres.Repository
+}
+
+// API is the HTTP API of viewergraph, used for introspection and by vgctl.
+type API struct {
+	opts *APIOpts
+	app  *fiber.App
+	// cancel stops the background checks of /readyz
+	cancel context.CancelFunc
+}
+
+// Listen starts serving the API. It blocks until the server is shut down.
+func (a *API) Listen() error {
+	l := l.With().
+		Str("context", "api").
+		Logger()
+
+	a.app.Hooks().OnListen(func() error {
+		l.Info().Msgf("api server listening on %s", a.opts.Port)
+		return nil
+	})
+	return a.app.Listen(":" + a.opts.Port)
+}
+
+func (a *API) Shutdown() error {
+	a.cancel()
+	return a.app.Shutdown()
+}
+
+func New(opts *APIOpts) *API {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &API{
+		opts:   opts,
+		app:    fiber.New(),
+		cancel: cancel,
+	}
+
+	a.app.Get("/metrics", metricsHandler())
+	a.app.Get("/healthz", healthzHandler())
+	a.app.Get("/readyz", readyzHandler(ctx, opts.Health, opts.Planner))
+
+	if opts.Planner != nil {
+		admin := adminHandler(opts.AdminToken)
+		pl := a.app.Group("/planner")
+		pl.Get("/schedule", scheduleHandler(opts.Planner))
+		pl.Get("/executors", executorsHandler(opts.Planner))
+		pl.Get("/executors/:bid", executorHandler(opts.Planner))
+		pl.Post("/executors/:bid/run", admin, runHandler(opts.Planner))
+		pl.Delete("/executors/:bid", admin, cancelHandler(opts.Planner))
+		pl.Post("/channels/:bid/online", admin, onlineHandler(opts.Planner))
+		pl.Post("/channels/:bid/offline", admin, offlineHandler(opts.Planner))
+		pl.Get("/channels/:bid/settings", settingsHandler(opts.Planner))
+		pl.Patch("/channels/:bid/settings", admin, updateSettingsHandler(opts.Planner))
+	}
+
+	if opts.Events != nil {
+		au := a.app.Group("/audience")
+		au.Get("/overlap", overlapHandler(opts.Events))
+		a.app.Get("/graph", graphHandler(opts.Events, opts.Channels))
+		a.app.Get("/communities", communitiesHandler(opts.Events))
+		fl := a.app.Group("/flows")
+		fl.Get("/dst/:channel", flowsByDstHandler(opts.Events))
+		fl.Get("/src/:channel", flowsBySrcHandler(opts.Events))
+		a.app.Get("/streams/:channel", streamsHandler(opts.Events))
+		a.app.Get("/cohorts/:channel", cohortsHandler(opts.Events))
+		a.app.Get("/churn/:channel", churnHandler(opts.Events))
+		a.app.Get("/recommendations/:channel", recommendationsHandler(opts.Events))
+		a.app.Get("/raids/:channel", raidsHandler(opts.Events))
+		a.app.Get("/raids/:channel/partners", raidPartnersHandler(opts.Events))
+	}
+	return a
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+// timeRange parses the `from` and `to` query params, in RFC3339.
+func timeRange(c *fiber.Ctx) (from, to time.Time, err error) {
+	from, err = time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		return from, to, fiber.NewError(fiber.StatusBadRequest, "Invalid 'from', expected a RFC3339 time")
+	}
+	to, err = time.Parse(time.RFC3339, c.Query("to"))
+	if err != nil {
+		return from, to, fiber.NewError(fiber.StatusBadRequest, "Invalid 'to', expected a RFC3339 time")
+	}
+	if to.Before(from) {
+		return from, to, fiber.NewError(fiber.StatusBadRequest, "'to' can't be before 'from'")
+	}
+	return from, to, nil
+}
+
+// overlapHandler returns the audience overlap matrix of the comma-separated
+// `channels` in the [from, to] range. See clickhouse.AudienceOverlap.
+func overlapHandler(repo clickhouse.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		from, to, err := timeRange(c)
+		if err != nil {
+			return err
+		}
+		channels := strings.Split(c.Query("channels"), ",")
+		if len(channels) < 2 {
+			return fiber.NewError(fiber.StatusBadRequest, "At least 2 channels are required")
+		}
+
+		m, err := repo.AudienceOverlap(channels, from, to)
+		if err != nil {
+			if errors.Is(err, clickhouse.ErrTooManyChannels) {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			return err
+		}
+		return c.JSON(m)
+	}
+}
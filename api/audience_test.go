@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+func TestOverlapHandler(t *testing.T) {
+	repo := clickhouse.NewMemory()
+	ts := time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC)
+	for _, vw := range []*clickhouse.Viewers{
+		{Ts: ts, Channel: "a", Viewers: []string{"user1", "user2"}},
+		{Ts: ts, Channel: "b", Viewers: []string{"user2", "user3"}},
+	} {
+		if err := repo.InsertViewers(context.Background(), vw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := New(&APIOpts{Events: repo})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/audience/overlap?channels=a,b&from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+
+	var got []*clickhouse.Overlap
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*clickhouse.Overlap{
+		{ChannelA: "a", ChannelB: "b", ViewersA: 2, ViewersB: 2, Shared: 1, Jaccard: 1.0 / 3, OverlapCoefficient: 0.5},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestOverlapHandlerBadRequest(t *testing.T) {
+	a := New(&APIOpts{Events: clickhouse.NewMemory()})
+
+	for _, target := range []string{
+		"/audience/overlap?channels=a,b&to=2020-10-12T00:00:00Z",
+		"/audience/overlap?channels=a,b&from=2020-10-12T00:00:00Z&to=2020-10-11T00:00:00Z",
+		"/audience/overlap?channels=a&from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z",
+	} {
+		resp, err := a.app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatalf("%s: expected status code to be 400, got %d", target, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const bearerScheme = "Bearer "
+
+// adminHandler requires the `Authorization: Bearer <token>` header. If
+// `token` is empty the admin endpoints are disabled and every request is
+// forbidden.
+func adminHandler(token string) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if token == "" {
+			return fiber.NewError(fiber.StatusForbidden, "Admin endpoints are disabled, no admin token is configured")
+		}
+		auth := c.Get(fiber.HeaderAuthorization)
+		if !strings.HasPrefix(auth, bearerScheme) {
+			return fiber.NewError(fiber.StatusUnauthorized, "Invalid admin token")
+		}
+		got := strings.TrimPrefix(auth, bearerScheme)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return fiber.NewError(fiber.StatusUnauthorized, "Invalid admin token")
+		}
+		return c.Next()
+	}
+}
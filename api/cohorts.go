@@ -0,0 +1,50 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+// cohortsHandler returns the new, returning and lapsed viewers of `:channel`
+// of each week in the [from, to] range. See clickhouse.Cohorts.
+func cohortsHandler(repo clickhouse.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		from, to, err := timeRange(c)
+		if err != nil {
+			return err
+		}
+
+		cohorts, err := repo.Cohorts(c.Params("channel"), from, to)
+		if err != nil {
+			return err
+		}
+		return c.JSON(cohorts)
+	}
+}
+
+// churnHandler returns the top `limit` channels the viewers of `:channel`
+// lapsed in the week of `week`, in RFC3339, went to last. See
+// clickhouse.Churn.
+func churnHandler(repo clickhouse.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		week, err := time.Parse(time.RFC3339, c.Query("week"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid 'week', expected a RFC3339 time")
+		}
+		limit := clickhouse.DefaultChurnLimit
+		if s := c.Query("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid 'limit', expected a positive integer")
+			}
+		}
+
+		dsts, err := repo.Churn(c.Params("channel"), week, limit)
+		if err != nil {
+			return err
+		}
+		return c.JSON(dsts)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+func cohortsRepo(t *testing.T) *clickhouse.Memory {
+	repo := clickhouse.NewMemory()
+	for _, evt := range []*clickhouse.RawEvent{
+		{Ts: time.Date(2020, 10, 6, 10, 0, 0, 0, time.UTC), Username: "user1", Channel: "a", EventType: "view"},
+		{Ts: time.Date(2020, 10, 6, 10, 0, 0, 0, time.UTC), Username: "user2", Channel: "a", EventType: "view"},
+		{Ts: time.Date(2020, 10, 6, 11, 0, 0, 0, time.UTC), Username: "user2", Channel: "b", EventType: "view"},
+		{Ts: time.Date(2020, 10, 13, 10, 0, 0, 0, time.UTC), Username: "user1", Channel: "a", EventType: "view"},
+	} {
+		repo.InsertRawEvent(evt)
+	}
+	if err := repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestCohortsHandler(t *testing.T) {
+	a := New(&APIOpts{Events: cohortsRepo(t)})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/cohorts/a?from=2020-10-05T00:00:00Z&to=2020-10-18T00:00:00Z", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+
+	var got []*clickhouse.Cohort
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*clickhouse.Cohort{
+		{Week: time.Date(2020, 10, 5, 0, 0, 0, 0, time.UTC), Viewers: 2, New: 2},
+		{Week: time.Date(2020, 10, 12, 0, 0, 0, 0, time.UTC), Viewers: 1, Returning: 1, Lapsed: 1},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestChurnHandler(t *testing.T) {
+	a := New(&APIOpts{Events: cohortsRepo(t)})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/churn/a?week=2020-10-12T00:00:00Z", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+
+	var got []*clickhouse.ChurnDestination
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*clickhouse.ChurnDestination{{Channel: "b", Users: 1}}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	for _, target := range []string{
+		"/churn/a",
+		"/churn/a?week=2020-10-12T00:00:00Z&limit=0",
+	} {
+		resp, err := a.app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatalf("%s: expected status code to be 400, got %d", target, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+// FlowsPage is a page of flows. NextOffset is the offset of the next page, nil
+// if no bucket has more flows.
+type FlowsPage[T any] struct {
+	Flows      []T  `json:"flows"`
+	NextOffset *int `json:"next_offset"`
+}
+
+// flowOpts parses the query params of the flow endpoints:
+//   - granularity: hour (default), day, week, month or total
+//   - limit, offset: pagination of the flows of each bucket
+//   - min_total: minimum number of users of a flow
+//   - include, exclude: comma-separated channels
+//   - order: total (default) or channel
+func flowOpts(c *fiber.Ctx) (*clickhouse.FlowOpts, error) {
+	g, err := clickhouse.ParseGranularity(c.Query("granularity", string(clickhouse.Hour)))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	order, err := clickhouse.ParseFlowOrder(c.Query("order", string(clickhouse.OrderTotal)))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	opts := &clickhouse.FlowOpts{
+		Granularity: g,
+		Limit:       clickhouse.DefaultFlowLimit,
+		Order:       order,
+		Include:     channelList(c.Query("include")),
+		Exclude:     channelList(c.Query("exclude")),
+	}
+	if s := c.Query("limit"); s != "" {
+		if opts.Limit, err = strconv.Atoi(s); err != nil || opts.Limit < 1 || opts.Limit > clickhouse.MaxFlowLimit {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid 'limit', expected an integer in the range [1, "+strconv.Itoa(clickhouse.MaxFlowLimit)+"]")
+		}
+	}
+	if s := c.Query("offset"); s != "" {
+		if opts.Offset, err = strconv.Atoi(s); err != nil || opts.Offset < 0 {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid 'offset', expected a positive integer")
+		}
+	}
+	if s := c.Query("min_total"); s != "" {
+		if opts.MinTotal, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid 'min_total', expected a positive integer")
+		}
+	}
+	return opts, nil
+}
+
+// channelList splits the comma-separated channels of `s`, skipping empty ones
+func channelList(s string) []string {
+	var r []string
+	for _, ch := range strings.Split(s, ",") {
+		if ch = strings.TrimSpace(ch); ch != "" {
+			r = append(r, ch)
+		}
+	}
+	return r
+}
+
+// nextOffset returns the offset of the next page of flows, where `buckets` are
+// the buckets of the flows of the current page, sorted. There is a next page
+// if any bucket is full.
+func nextOffset(opts *clickhouse.FlowOpts, buckets []time.Time) *int {
+	n := 0
+	for i, ts := range buckets {
+		if i > 0 && !ts.Equal(buckets[i-1]) {
+			n = 0
+		}
+		if n++; n == opts.Limit {
+			next := opts.Offset + opts.Limit
+			return &next
+		}
+	}
+	return nil
+}
+
+// flowErr maps the validation errors of the flow queries to bad requests
+func flowErr(err error) error {
+	if errors.Is(err, clickhouse.ErrUnknownGranularity) ||
+		errors.Is(err, clickhouse.ErrUnknownFlowOrder) ||
+		errors.Is(err, clickhouse.ErrInvalidLimit) ||
+		errors.Is(err, clickhouse.ErrInvalidOffset) {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// flowsByDstHandler returns the flows of users that came to `:channel` from
+// other channels in the [from, to] range. See flowOpts and
+// clickhouse.UserFlowsByDst.
+func flowsByDstHandler(repo clickhouse.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		from, to, err := timeRange(c)
+		if err != nil {
+			return err
+		}
+		opts, err := flowOpts(c)
+		if err != nil {
+			return err
+		}
+
+		flows, err := repo.UserFlowsByDst(c.Params("channel"), from, to, opts)
+		if err != nil {
+			return flowErr(err)
+		}
+		buckets := make([]time.Time, 0, len(flows))
+		for _, f := range flows {
+			buckets = append(buckets, f.Ts)
+		}
+		return c.JSON(&FlowsPage[*clickhouse.UserFlowDst]{
+			Flows:      flows,
+			NextOffset: nextOffset(opts, buckets),
+		})
+	}
+}
+
+// flowsBySrcHandler returns the flows of users that went from `:channel` to
+// other channels in the [from, to] range. See flowOpts and
+// clickhouse.UserFlowsBySrc.
+func flowsBySrcHandler(repo clickhouse.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		from, to, err := timeRange(c)
+		if err != nil {
+			return err
+		}
+		opts, err := flowOpts(c)
+		if err != nil {
+			return err
+		}
+
+		flows, err := repo.UserFlowsBySrc(c.Params("channel"), from, to, opts)
+		if err != nil {
+			return flowErr(err)
+		}
+		buckets := make([]time.Time, 0, len(flows))
+		for _, f := range flows {
+			buckets = append(buckets, f.Ts)
+		}
+		return c.JSON(&FlowsPage[*clickhouse.UserFlowSrc]{
+			Flows:      flows,
+			NextOffset: nextOffset(opts, buckets),
+		})
+	}
+}
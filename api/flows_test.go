@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+func flowsRepo(t *testing.T) *clickhouse.Memory {
+	repo := clickhouse.NewMemory()
+	ts := time.Date(2020, 10, 11, 9, 0, 0, 0, time.UTC)
+	for _, vw := range []*clickhouse.Viewers{
+		{Ts: ts, Channel: "a", Viewers: []string{"user1", "user2"}},
+		{Ts: ts, Channel: "b", Viewers: []string{"user3"}},
+		{Ts: ts, Channel: "c", Viewers: []string{"user4"}},
+		{Ts: ts.Add(time.Hour), Channel: "x", Viewers: []string{"user1", "user2", "user3", "user4"}},
+	} {
+		if err := repo.InsertViewers(context.Background(), vw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestFlowsByDstHandler(t *testing.T) {
+	a := New(&APIOpts{Events: flowsRepo(t)})
+	ts := time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		query string
+		want  *FlowsPage[*clickhouse.UserFlowDst]
+	}{
+		{
+			query: "limit=2",
+			want: &FlowsPage[*clickhouse.UserFlowDst]{
+				Flows: []*clickhouse.UserFlowDst{
+					{Ts: ts, Referrer: "a", Total: 2},
+					{Ts: ts, Referrer: "b", Total: 1},
+				},
+				NextOffset: intPtr(2),
+			},
+		},
+		{
+			query: "limit=2&offset=2",
+			want: &FlowsPage[*clickhouse.UserFlowDst]{
+				Flows: []*clickhouse.UserFlowDst{
+					{Ts: ts, Referrer: "c", Total: 1},
+				},
+			},
+		},
+		{
+			query: "exclude=a&order=channel",
+			want: &FlowsPage[*clickhouse.UserFlowDst]{
+				Flows: []*clickhouse.UserFlowDst{
+					{Ts: ts, Referrer: "b", Total: 1},
+					{Ts: ts, Referrer: "c", Total: 1},
+				},
+			},
+		},
+		{
+			query: "include=a,c&min_total=2",
+			want: &FlowsPage[*clickhouse.UserFlowDst]{
+				Flows: []*clickhouse.UserFlowDst{
+					{Ts: ts, Referrer: "a", Total: 2},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		target := "/flows/dst/x?from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z&" + test.query
+		resp, err := a.app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: expected status code to be 200, got %d", target, resp.StatusCode)
+		}
+		got := new(FlowsPage[*clickhouse.UserFlowDst])
+		err = json.NewDecoder(resp.Body).Decode(got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(got, test.want); diff != nil {
+			t.Fatalf("%s: %v", test.query, diff)
+		}
+	}
+}
+
+func TestFlowsBySrcHandler(t *testing.T) {
+	a := New(&APIOpts{Events: flowsRepo(t)})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/flows/src/a?from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z&granularity=day", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+	got := new(FlowsPage[*clickhouse.UserFlowSrc])
+	if err := json.NewDecoder(resp.Body).Decode(got); err != nil {
+		t.Fatal(err)
+	}
+	want := &FlowsPage[*clickhouse.UserFlowSrc]{
+		Flows: []*clickhouse.UserFlowSrc{
+			{Ts: time.Date(2020, 10, 11, 0, 0, 0, 0, time.UTC), Channel: "x", Total: 2},
+		},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestFlowsHandlerBadRequest(t *testing.T) {
+	a := New(&APIOpts{Events: clickhouse.NewMemory()})
+
+	for _, query := range []string{
+		"granularity=year",
+		"order=users",
+		"limit=0",
+		"limit=1001",
+		"offset=-1",
+		"min_total=-1",
+	} {
+		target := "/flows/dst/x?from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z&" + query
+		resp, err := a.app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatalf("%s: expected status code to be 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
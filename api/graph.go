@@ -0,0 +1,73 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/graph"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+	"github.com/pmrt/viewergraph/repo/postgres"
+)
+
+// graphHandler exports the viewer-flow graph in the [from, to] range, with
+// the edges of at least `min_weight` users (1 by default), in the `format`
+// query param: json (default), graphml, gexf or dot. With `communities` the
+// nodes include their community and PageRank, and the X-Communities-Source
+// header tells whether they were stored or computed on the fly. See
+// graph.Export.
+func graphHandler(events clickhouse.Repository, channels postgres.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		from, to, err := timeRange(c)
+		if err != nil {
+			return err
+		}
+		minWeight := uint64(1)
+		if s := c.Query("min_weight"); s != "" {
+			if minWeight, err = strconv.ParseUint(s, 10, 64); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid 'min_weight', expected a positive integer")
+			}
+		}
+		communities := false
+		if s := c.Query("communities"); s != "" {
+			if communities, err = strconv.ParseBool(s); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid 'communities', expected a boolean")
+			}
+		}
+		format, err := graph.ParseFormat(c.Query("format", string(graph.JSON)))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		g, err := graph.Export(events, channels, &graph.ExportOpts{
+			From:        from,
+			To:          to,
+			MinWeight:   minWeight,
+			Communities: communities,
+		})
+		if err != nil {
+			return err
+		}
+		if g.CommunitiesSource != "" {
+			c.Set("X-Communities-Source", string(g.CommunitiesSource))
+		}
+		c.Set(fiber.HeaderContentType, format.ContentType())
+		return graph.Encode(c, g, format)
+	}
+}
+
+// communitiesHandler returns the communities of the latest computation whose
+// time window overlaps the [from, to] range. See clickhouse.Communities.
+func communitiesHandler(repo clickhouse.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		from, to, err := timeRange(c)
+		if err != nil {
+			return err
+		}
+
+		cs, err := repo.Communities(from, to)
+		if err != nil {
+			return err
+		}
+		return c.JSON(cs)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+	"github.com/pmrt/viewergraph/repo/postgres"
+)
+
+func TestGraphHandler(t *testing.T) {
+	events := clickhouse.NewMemory()
+	ts := time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC)
+	events.InsertRawEvent(&clickhouse.RawEvent{Ts: ts, Username: "user1", Channel: "b", EventType: "view"})
+	events.InsertRawEvent(&clickhouse.RawEvent{Ts: ts.Add(time.Hour), Username: "user1", Channel: "a", EventType: "view"})
+	if err := events.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	channels := postgres.NewMemory()
+	if err := channels.Track(&model.TrackedChannels{BroadcasterID: "1", BroadcasterUsername: "a", BroadcasterDisplayName: "A"}); err != nil {
+		t.Fatal(err)
+	}
+	a := New(&APIOpts{Events: events, Channels: channels})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/graph?format=dot&from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/vnd.graphviz" {
+		t.Fatalf("got content type %q", ct)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"a" [label="A", tracked=true];`, `"b" -> "a" [weight=1, label=1];`} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("expected %q in:\n%s", want, b)
+		}
+	}
+
+	for _, target := range []string{
+		"/graph?format=csv&from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z",
+		"/graph?min_weight=-1&from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z",
+		"/graph?from=2020-10-11T00:00:00Z",
+		"/graph?communities=maybe&from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z",
+	} {
+		resp, err := a.app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatalf("%s: expected status code to be 400, got %d", target, resp.StatusCode)
+		}
+	}
+}
+
+func TestGraphHandlerCommunities(t *testing.T) {
+	events := clickhouse.NewMemory()
+	ts := time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC)
+	events.InsertRawEvent(&clickhouse.RawEvent{Ts: ts, Username: "user1", Channel: "b", EventType: "view"})
+	events.InsertRawEvent(&clickhouse.RawEvent{Ts: ts.Add(time.Hour), Username: "user1", Channel: "a", EventType: "view"})
+	if err := events.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	from, to := time.Date(2020, 10, 11, 0, 0, 0, 0, time.UTC), time.Date(2020, 10, 12, 0, 0, 0, 0, time.UTC)
+	stored := []*clickhouse.ChannelCommunity{
+		{WindowStart: from, WindowEnd: to, ComputedAt: to, Channel: "a", Community: 0, PageRank: 0.75},
+		{WindowStart: from, WindowEnd: to, ComputedAt: to, Channel: "b", Community: 0, PageRank: 0.25},
+	}
+	if err := events.InsertCommunities(stored); err != nil {
+		t.Fatal(err)
+	}
+	a := New(&APIOpts{Events: events})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/graph?format=dot&communities=true&from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Communities-Source"); got != "stored" {
+		t.Fatalf("got communities source %q, want stored", got)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"a" [label="a", tracked=false, community=0, pagerank=0.75];`; !strings.Contains(string(b), want) {
+		t.Fatalf("expected %q in:\n%s", want, b)
+	}
+
+	resp, err = a.app.Test(httptest.NewRequest("GET", "/communities?from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+	var got []*clickhouse.ChannelCommunity
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, stored); diff != nil {
+		t.Fatal(diff)
+	}
+}
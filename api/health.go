@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/database"
+	"github.com/pmrt/viewergraph/helix"
+	"github.com/pmrt/viewergraph/planner"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusError    = "error"
+	StatusDegraded = "degraded"
+)
+
+// HelixStatus is implemented by helix.Helix.
+type HelixStatus interface {
+	ValidateToken(ctx context.Context) error
+	Subscriptions(ctx context.Context) ([]*helix.Subscription, error)
+}
+
+type HealthOpts struct {
+	// Storages are the databases reported by /readyz, by name.
+	Storages map[string]database.Storage
+	// Helix is optional. If set, /readyz reports the validity of the app
+	// access token and the EventSub subscriptions by status.
+	Helix HelixStatus
+	// HelixInterval is how often Helix is checked. The checks run in
+	// background and /readyz reports the last result, so probes don't use up
+	// the Helix rate limit nor wait for Twitch. Defaults to 1m.
+	HelixInterval time.Duration
+	// Timeout is the maximum time each check can take. Defaults to 5s.
+	Timeout time.Duration
+}
+
+type CheckStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// WorkerPool is the load of the worker pool of the planner. See
+// planner.PoolStats.
+type WorkerPool struct {
+	// Queued runs waiting for a free worker.
+	Queued int `json:"queued"`
+	// Pending runs waiting for the previous run of the same channel.
+	Pending int64 `json:"pending"`
+	Running int64 `json:"running"`
+}
+
+type Readiness struct {
+	// Status is StatusOK if every check passed, StatusDegraded otherwise.
+	Status string                 `json:"status"`
+	Checks map[string]CheckStatus `json:"checks"`
+	// Subscriptions is the number of EventSub subscriptions by status, e.g.
+	// enabled or webhook_callback_verification_failed.
+	Subscriptions map[string]int `json:"subscriptions,omitempty"`
+	WorkerPool    *WorkerPool    `json:"worker_pool,omitempty"`
+}
+
+// report sets the result of the check `name`
+func (r *Readiness) report(name string, err error) {
+	if err != nil {
+		r.Status = StatusDegraded
+		r.Checks[name] = CheckStatus{Status: StatusError, Error: err.Error()}
+		return
+	}
+	r.Checks[name] = CheckStatus{Status: StatusOK}
+}
+
+var errNotChecked = errors.New("not checked yet")
+
+// helixCheck checks Helix every interval and keeps the result of the last
+// check.
+type helixCheck struct {
+	hx      HelixStatus
+	timeout time.Duration
+	// done is closed after the first check
+	done chan struct{}
+
+	mu       sync.Mutex
+	tokenErr error
+	subsErr  error
+	subs     map[string]int
+}
+
+func newHelixCheck(hx HelixStatus, timeout time.Duration) *helixCheck {
+	return &helixCheck{
+		hx:       hx,
+		timeout:  timeout,
+		done:     make(chan struct{}),
+		tokenErr: errNotChecked,
+		subsErr:  errNotChecked,
+	}
+}
+
+// refresh validates the app access token and counts the EventSub
+// subscriptions by status, concurrently.
+func (hc *helixCheck) refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, hc.timeout)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		tokenErr error
+		subs     []*helix.Subscription
+		subsErr  error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		tokenErr = hc.hx.ValidateToken(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		subs, subsErr = hc.hx.Subscriptions(ctx)
+	}()
+	wg.Wait()
+
+	var counts map[string]int
+	if subsErr == nil {
+		counts = make(map[string]int)
+		for _, sub := range subs {
+			counts[sub.Status]++
+		}
+	}
+	hc.mu.Lock()
+	hc.tokenErr, hc.subsErr, hc.subs = tokenErr, subsErr, counts
+	hc.mu.Unlock()
+}
+
+// run checks Helix right away and then every `interval`, until `ctx` is
+// done.
+func (hc *helixCheck) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	hc.refresh(ctx)
+	close(hc.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			hc.refresh(ctx)
+		}
+	}
+}
+
+// report adds the result of the last check to `r`. If Helix wasn't checked
+// yet it waits for the first check until `ctx` is done.
+func (hc *helixCheck) report(ctx context.Context, r *Readiness) {
+	select {
+	case <-hc.done:
+	case <-ctx.Done():
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	r.report("helix_token", hc.tokenErr)
+	r.report("helix_subscriptions", hc.subsErr)
+	r.Subscriptions = hc.subs
+}
+
+// healthzHandler reports that the process is alive, regardless of its
+// dependencies.
+func healthzHandler() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"status": StatusOK})
+	}
+}
+
+// readyzHandler reports the status of the dependencies of the server. It
+// responds with 503 if any of them is not available, so the server can run
+// degraded while a load balancer stops sending traffic to it. Helix is
+// checked in background until `ctx` is done.
+func readyzHandler(ctx context.Context, opts *HealthOpts, p *planner.Planner) func(c *fiber.Ctx) error {
+	if opts == nil {
+		opts = &HealthOpts{}
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	var hc *helixCheck
+	if opts.Helix != nil {
+		interval := opts.HelixInterval
+		if interval == 0 {
+			interval = time.Minute
+		}
+		hc = newHelixCheck(opts.Helix, timeout)
+		go hc.run(ctx, interval)
+	}
+
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+
+		r := readiness(ctx, opts.Storages)
+		if hc != nil {
+			hc.report(ctx, r)
+		}
+		if p != nil {
+			st := p.PoolStats()
+			r.WorkerPool = &WorkerPool{
+				Queued:  st.QueueDepth,
+				Pending: st.Pending,
+				Running: st.Running,
+			}
+		}
+		if r.Status != StatusOK {
+			c.Status(fiber.StatusServiceUnavailable)
+		}
+		return c.JSON(r)
+	}
+}
+
+// readiness pings the `storages` concurrently.
+func readiness(ctx context.Context, storages map[string]database.Storage) *Readiness {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+		r  = &Readiness{
+			Status: StatusOK,
+			Checks: make(map[string]CheckStatus),
+		}
+	)
+	for name, sto := range storages {
+		wg.Add(1)
+		go func(name string, sto database.Storage) {
+			defer wg.Done()
+			err := sto.Ping(ctx)
+			mu.Lock()
+			r.report(name, err)
+			mu.Unlock()
+		}(name, sto)
+	}
+	wg.Wait()
+	return r
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/database"
+	"github.com/pmrt/viewergraph/helix"
+	"github.com/pmrt/viewergraph/planner"
+)
+
+type fakeStorage struct {
+	database.Storage
+	err error
+}
+
+func (s *fakeStorage) Ping(ctx context.Context) error {
+	return s.err
+}
+
+type fakeHelix struct {
+	tokenErr error
+	subs     []*helix.Subscription
+	// checks is the number of token validations
+	checks int32
+}
+
+func (hx *fakeHelix) ValidateToken(ctx context.Context) error {
+	atomic.AddInt32(&hx.checks, 1)
+	return hx.tokenErr
+}
+
+func (hx *fakeHelix) Subscriptions(ctx context.Context) ([]*helix.Subscription, error) {
+	return hx.subs, nil
+}
+
+func TestHealthzHandler(t *testing.T) {
+	a := New(&APIOpts{})
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/healthz", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	p := planner.New(&planner.PlannerOpts{})
+	defer p.Stop()
+
+	tests := []struct {
+		name       string
+		opts       *HealthOpts
+		wantStatus int
+		want       *Readiness
+	}{
+		{
+			name:       "no checks",
+			wantStatus: 200,
+			want: &Readiness{
+				Status:     StatusOK,
+				Checks:     map[string]CheckStatus{},
+				WorkerPool: &WorkerPool{},
+			},
+		},
+		{
+			name: "all dependencies available",
+			opts: &HealthOpts{
+				Storages: map[string]database.Storage{
+					"clickhouse": &fakeStorage{},
+					"postgres":   &fakeStorage{},
+				},
+				Helix: &fakeHelix{subs: []*helix.Subscription{
+					{ID: "a", Status: "enabled"},
+					{ID: "b", Status: "enabled"},
+					{ID: "c", Status: "webhook_callback_verification_failed"},
+				}},
+			},
+			wantStatus: 200,
+			want: &Readiness{
+				Status: StatusOK,
+				Checks: map[string]CheckStatus{
+					"clickhouse":          {Status: StatusOK},
+					"postgres":            {Status: StatusOK},
+					"helix_token":         {Status: StatusOK},
+					"helix_subscriptions": {Status: StatusOK},
+				},
+				Subscriptions: map[string]int{
+					"enabled":                              2,
+					"webhook_callback_verification_failed": 1,
+				},
+				WorkerPool: &WorkerPool{},
+			},
+		},
+		{
+			name: "degraded",
+			opts: &HealthOpts{
+				Storages: map[string]database.Storage{
+					"clickhouse": &fakeStorage{},
+					"postgres":   &fakeStorage{err: sql.ErrConnDone},
+				},
+				Helix: &fakeHelix{tokenErr: helix.ErrInvalidToken},
+			},
+			wantStatus: 503,
+			want: &Readiness{
+				Status: StatusDegraded,
+				Checks: map[string]CheckStatus{
+					"clickhouse":          {Status: StatusOK},
+					"postgres":            {Status: StatusError, Error: sql.ErrConnDone.Error()},
+					"helix_token":         {Status: StatusError, Error: helix.ErrInvalidToken.Error()},
+					"helix_subscriptions": {Status: StatusOK},
+				},
+				WorkerPool: &WorkerPool{},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := New(&APIOpts{Planner: p, Health: test.opts})
+			defer a.Shutdown()
+			resp, err := a.app.Test(httptest.NewRequest("GET", "/readyz", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.wantStatus {
+				t.Fatalf("expected status code to be %d, got %d", test.wantStatus, resp.StatusCode)
+			}
+
+			var got *Readiness
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(got, test.want); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestReadyzHandlerCachesHelix(t *testing.T) {
+	hx := &fakeHelix{subs: []*helix.Subscription{{ID: "a", Status: "enabled"}}}
+	a := New(&APIOpts{Health: &HealthOpts{
+		Helix:         hx,
+		HelixInterval: time.Hour,
+	}})
+	defer a.Shutdown()
+
+	for i := 0; i < 3; i++ {
+		resp, err := a.app.Test(httptest.NewRequest("GET", "/readyz", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got *Readiness
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 || got.Subscriptions["enabled"] != 1 {
+			t.Fatalf("unexpected readiness %d %+v", resp.StatusCode, got)
+		}
+	}
+	// probes report the result of the first check instead of calling Helix
+	if n := atomic.LoadInt32(&hx.checks); n != 1 {
+		t.Fatalf("expected Helix to be checked once, got %d", n)
+	}
+}
@@ -0,0 +1,17 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// metricsHandler exposes the metrics of the default Prometheus registry. See
+// package metrics.
+func metricsHandler() func(c *fiber.Ctx) error {
+	h := fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+	return func(c *fiber.Ctx) error {
+		h(c.Context())
+		return nil
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pmrt/viewergraph/metrics"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	metrics.WebhookSignatureFailures.Inc()
+	a := New(&APIOpts{})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "viewergraph_helix_webhook_signature_failures_total") {
+		t.Fatal("expected metrics to include viewergraph metrics")
+	}
+}
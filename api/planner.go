@@ -0,0 +1,131 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/pmrt/viewergraph/planner"
+)
+
+// scheduleHandler reports how evenly the workers are distributed across the
+// balanced minutes. See planner.ScheduleReport.
+func scheduleHandler(p *planner.Planner) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(p.ScheduleReport())
+	}
+}
+
+// executorsHandler lists the active executors of the planner.
+func executorsHandler(p *planner.Planner) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(p.Executors())
+	}
+}
+
+func executorHandler(p *planner.Planner) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		e, ok := p.Executor(c.Params("bid"))
+		if !ok {
+			return fiber.NewError(fiber.StatusNotFound, planner.ErrExecutorNotFound.Error())
+		}
+		return c.JSON(e)
+	}
+}
+
+// runHandler forces a run of the worker of the channel.
+func runHandler(p *planner.Planner) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		p.RunNow(utils.CopyString(c.Params("bid")))
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+}
+
+// cancelHandler stops the active executor of the channel.
+func cancelHandler(p *planner.Planner) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if err := p.Cancel(c.Params("bid")); err != nil {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// onlineHandler simulates a stream.online event for the channel. The login
+// of the broadcaster can be passed in the `login` query param.
+func onlineHandler(p *planner.Planner) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		// the values returned by fiber are only valid within the handler, see
+		// fiber's zero allocation docs. The planner keeps them after it returns
+		p.SimulateOnline(utils.CopyString(c.Params("bid")), utils.CopyString(c.Query("login")))
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+}
+
+// offlineHandler simulates a stream.offline event for the channel.
+func offlineHandler(p *planner.Planner) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		p.SimulateOffline(utils.CopyString(c.Params("bid")), utils.CopyString(c.Query("login")))
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+}
+
+// channelSettings are the scheduling settings of a channel, see
+// planner.ChannelSettings. An interval of 0 minutes is the default interval
+// of the planner.
+type channelSettings struct {
+	IntervalMinutes int32            `json:"interval_minutes"`
+	Priority        planner.Priority `json:"priority"`
+}
+
+// settingsUpdate are the settings to change. Missing fields keep their value.
+type settingsUpdate struct {
+	IntervalMinutes *int32            `json:"interval_minutes"`
+	Priority        *planner.Priority `json:"priority"`
+}
+
+func newChannelSettings(s planner.ChannelSettings) channelSettings {
+	return channelSettings{
+		IntervalMinutes: int32(s.Interval / time.Minute),
+		Priority:        s.Priority,
+	}
+}
+
+// settingsHandler returns the scheduling settings of the channel.
+func settingsHandler(p *planner.Planner) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(newChannelSettings(p.Settings(c.Params("bid"))))
+	}
+}
+
+// updateSettingsHandler changes the scheduling settings of a tracked channel,
+// persisting them and applying them to its running executor. It returns the
+// new settings.
+func updateSettingsHandler(p *planner.Planner) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		var u settingsUpdate
+		if err := c.BodyParser(&u); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid body, expected JSON with 'interval_minutes' and/or 'priority'")
+		}
+		bid := utils.CopyString(c.Params("bid"))
+		s := p.Settings(bid)
+		if u.IntervalMinutes != nil {
+			s.Interval = time.Duration(*u.IntervalMinutes) * time.Minute
+		}
+		if u.Priority != nil {
+			s.Priority = *u.Priority
+		}
+
+		err := p.UpdateSettings(bid, s)
+		switch {
+		case errors.Is(err, planner.ErrInvalidSettings):
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		case errors.Is(err, planner.ErrChannelNotTracked):
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		case err != nil:
+			return err
+		}
+		return c.JSON(newChannelSettings(s))
+	}
+}
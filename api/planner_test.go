@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+	"github.com/pmrt/viewergraph/planner"
+	"github.com/pmrt/viewergraph/repo/postgres"
+)
+
+func TestScheduleHandler(t *testing.T) {
+	p := planner.FromChannels(&planner.PlannerOpts{}, []*model.TrackedChannels{
+		{BroadcasterID: "1"},
+		{BroadcasterID: "2"},
+	})
+	defer p.Stop()
+	a := New(&APIOpts{Planner: p})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/planner/schedule", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+
+	var r *planner.ScheduleReport
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Buckets) != 60 {
+		t.Fatalf("expected 60 buckets, got %d", len(r.Buckets))
+	}
+	if r.Assigned != 2 || r.Live != 0 {
+		t.Fatalf("got assigned=%d live=%d, want assigned=2 live=0", r.Assigned, r.Live)
+	}
+}
+
+func TestPlannerEndpointsWithoutPlanner(t *testing.T) {
+	a := New(&APIOpts{})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/planner/schedule", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Fatalf("expected status code to be 404, got %d", resp.StatusCode)
+	}
+}
+
+func newTestPlanner(ran chan string) *planner.Planner {
+	return planner.New(&planner.PlannerOpts{
+		TrackInterval:      time.Hour,
+		TrackOnlineTimeout: time.Hour,
+		WorkerTimeout:      10 * time.Second,
+		SkipAlign:          true,
+		Clock:              planner.NewFakeClock(time.Date(2022, 6, 22, 15, 0, 0, 0, time.UTC)),
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			ran <- bid
+			return 10, nil
+		},
+	})
+}
+
+func TestExecutorEndpoints(t *testing.T) {
+	ran := make(chan string, 1)
+	p := newTestPlanner(ran)
+	defer p.Stop()
+	a := New(&APIOpts{Planner: p, AdminToken: "secret"})
+
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/planner/executors/234783", 404},
+		{"POST", "/planner/channels/234783/online?login=ibai", 202},
+		{"GET", "/planner/executors/234783", 200},
+		{"POST", "/planner/executors/234783/run", 202},
+		{"DELETE", "/planner/executors/234783", 204},
+		{"DELETE", "/planner/executors/234783", 404},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := a.app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Fatalf("%s %s: expected status code to be %d, got %d", test.method, test.path, test.status, resp.StatusCode)
+		}
+		// online and forced runs run the worker
+		if test.status == 202 {
+			<-ran
+		}
+	}
+}
+
+func TestExecutorsHandler(t *testing.T) {
+	ran := make(chan string, 1)
+	p := newTestPlanner(ran)
+	defer p.Stop()
+	a := New(&APIOpts{Planner: p})
+
+	p.SimulateOnline("234783", "ibai")
+	<-ran
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/planner/executors", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got []planner.ExecutorInfo
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].BroadcasterID != "234783" || got[0].Login != "ibai" {
+		t.Fatalf("unexpected executors %+v", got)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	ran := make(chan string, 1)
+	p := newTestPlanner(ran)
+	defer p.Stop()
+	a := New(&APIOpts{Planner: p, AdminToken: "secret"})
+
+	tests := []struct {
+		auth   string
+		status int
+	}{
+		{"", 401},
+		{"Bearer wrong", 401},
+		{"secret", 401},
+		{"Basic secret", 401},
+		{"Bearer secret", 202},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/planner/executors/234783/run", nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		resp, err := a.app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Fatalf("auth %q: expected status code to be %d, got %d", test.auth, test.status, resp.StatusCode)
+		}
+	}
+	<-ran
+
+	// read-only endpoints are not protected
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/planner/executors", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminTokenUnset(t *testing.T) {
+	ran := make(chan string, 1)
+	p := newTestPlanner(ran)
+	defer p.Stop()
+	a := New(&APIOpts{Planner: p})
+
+	tests := []struct {
+		method, path string
+	}{
+		{"POST", "/planner/executors/234783/run"},
+		{"DELETE", "/planner/executors/234783"},
+		{"POST", "/planner/channels/234783/online?login=ibai"},
+		{"POST", "/planner/channels/234783/offline"},
+		{"PATCH", "/planner/channels/234783/settings"},
+	}
+	for _, test := range tests {
+		for _, auth := range []string{"", "Bearer ", "Bearer secret"} {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			resp, err := a.app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 403 {
+				t.Fatalf("%s %s with auth %q: expected status code to be 403, got %d", test.method, test.path, auth, resp.StatusCode)
+			}
+		}
+	}
+	if len(p.Executors()) != 0 {
+		t.Fatalf("expected no executors, got %d", len(p.Executors()))
+	}
+}
+
+func TestSettingsHandler(t *testing.T) {
+	repo := postgres.NewMemory()
+	if err := repo.Track(&model.TrackedChannels{BroadcasterID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	p := planner.FromChannels(&planner.PlannerOpts{
+		TrackInterval: time.Hour,
+		SaveSettings:  repo.SetChannelSettings,
+	}, []*model.TrackedChannels{{BroadcasterID: "1"}})
+	defer p.Stop()
+	a := New(&APIOpts{Planner: p, AdminToken: "secret"})
+
+	tests := []struct {
+		bid, body string
+		status    int
+		want      channelSettings
+	}{
+		{"1", `{"interval_minutes": 5}`, 200, channelSettings{5, planner.PriorityNormal}},
+		// missing fields keep their value
+		{"1", `{"priority": 1}`, 200, channelSettings{5, planner.PriorityHigh}},
+		{"1", `{"interval_minutes": 0}`, 200, channelSettings{0, planner.PriorityHigh}},
+		{"1", `{"interval_minutes": -5}`, 400, channelSettings{}},
+		{"1", `{"priority": 3}`, 400, channelSettings{}},
+		{"1", `{`, 400, channelSettings{}},
+		{"2", `{"priority": 1}`, 404, channelSettings{}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("PATCH", "/planner/channels/"+test.bid+"/settings", strings.NewReader(test.body))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := a.app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status {
+			resp.Body.Close()
+			t.Fatalf("%s: expected status code to be %d, got %d", test.body, test.status, resp.StatusCode)
+		}
+		if test.status == 200 {
+			var got channelSettings
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("%s: expected %+v, got %+v", test.body, test.want, got)
+			}
+		}
+		resp.Body.Close()
+	}
+
+	// the settings are persisted
+	chs, err := repo.Tracked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chs[0].TrackIntervalMinutes != nil || chs[0].Priority != 1 {
+		t.Fatalf("unexpected persisted settings %+v", chs[0])
+	}
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/planner/channels/1/settings", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got channelSettings
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (channelSettings{0, planner.PriorityHigh}); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+// raidsHandler returns the reports of the raids of `:channel` in the
+// [from, to] range, the most recent first. See clickhouse.RaidReports.
+func raidsHandler(repo clickhouse.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		from, to, err := timeRange(c)
+		if err != nil {
+			return err
+		}
+
+		reports, err := repo.RaidReports(c.Params("channel"), from, to)
+		if err != nil {
+			return err
+		}
+		return c.JSON(reports)
+	}
+}
+
+// raidPartnersHandler returns the top `limit` channels raided by `:channel` in
+// the [from, to] range, ranked by the raid viewers that stayed. See
+// clickhouse.RaidPartners.
+func raidPartnersHandler(repo clickhouse.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		from, to, err := timeRange(c)
+		if err != nil {
+			return err
+		}
+		limit := clickhouse.DefaultRaidPartnersLimit
+		if s := c.Query("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid 'limit', expected a positive integer")
+			}
+		}
+
+		partners, err := repo.RaidPartners(c.Params("channel"), from, to, limit)
+		if err != nil {
+			return err
+		}
+		return c.JSON(partners)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+func raidsRepo(t *testing.T) *clickhouse.Memory {
+	repo := clickhouse.NewMemory()
+	for _, evt := range []*clickhouse.RawEvent{
+		{Ts: time.Date(2020, 10, 11, 11, 30, 0, 0, time.UTC), Username: "user1", Channel: "a", EventType: "view"},
+		{Ts: time.Date(2020, 10, 11, 11, 30, 0, 0, time.UTC), Username: "user2", Channel: "a", EventType: "view"},
+		{Ts: time.Date(2020, 10, 11, 12, 30, 0, 0, time.UTC), Username: "user1", Channel: "b", EventType: "view"},
+	} {
+		repo.InsertRawEvent(evt)
+	}
+	if err := repo.InsertRaid(&clickhouse.Raid{
+		ID:      "raid1",
+		Ts:      time.Date(2020, 10, 11, 12, 0, 0, 0, time.UTC),
+		From:    "a",
+		To:      "b",
+		Viewers: 2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestRaidsHandler(t *testing.T) {
+	a := New(&APIOpts{Events: raidsRepo(t)})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/raids/a?from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+
+	var got []*clickhouse.RaidReport
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*clickhouse.RaidReport{
+		{
+			Raid: clickhouse.Raid{
+				ID:      "raid1",
+				Ts:      time.Date(2020, 10, 11, 12, 0, 0, 0, time.UTC),
+				From:    "a",
+				To:      "b",
+				Viewers: 2,
+			},
+			RaidViewers: 2,
+			Arrived:     1,
+			Retention:   []uint64{1, 0, 0, 0, 0, 0},
+			ArrivalRate: 0.5,
+		},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestRaidPartnersHandler(t *testing.T) {
+	a := New(&APIOpts{Events: raidsRepo(t)})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/raids/a/partners?from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z&limit=5", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+
+	var got []*clickhouse.RaidPartner
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*clickhouse.RaidPartner{
+		{Channel: "b", Raids: 1, RaidViewers: 2, Arrived: 1, ArrivalRate: 0.5},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	for _, target := range []string{
+		"/raids/a/partners?from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z&limit=0",
+		"/raids/a/partners?from=2020-10-11T00:00:00Z",
+	} {
+		resp, err := a.app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatalf("%s: expected status code to be 400, got %d", target, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+// recommendationsHandler returns the top `limit` channels similar to
+// `:channel`, with the shared viewers and flows explaining each score. See
+// clickhouse.SimilarChannels.
+func recommendationsHandler(repo clickhouse.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		limit := clickhouse.DefaultSimilarLimit
+		if s := c.Query("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid 'limit', expected a positive integer")
+			}
+		}
+
+		similar, err := repo.SimilarChannels(c.Params("channel"), limit)
+		if err != nil {
+			return err
+		}
+		return c.JSON(similar)
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+func TestRecommendationsHandler(t *testing.T) {
+	repo := clickhouse.NewMemory()
+	for _, evt := range []*clickhouse.RawEvent{
+		{Ts: time.Date(2020, 10, 11, 9, 0, 0, 0, time.UTC), Username: "user1", Channel: "b", EventType: "view"},
+		{Ts: time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC), Username: "user1", Channel: "a", EventType: "view"},
+		{Ts: time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC), Username: "user2", Channel: "a", EventType: "view"},
+		{Ts: time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC), Username: "user2", Channel: "c", EventType: "view"},
+		{Ts: time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC), Username: "user3", Channel: "c", EventType: "view"},
+	} {
+		repo.InsertRawEvent(evt)
+	}
+	if err := repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	to := time.Date(2020, 10, 12, 0, 0, 0, 0, time.UTC)
+	if err := repo.RefreshSimilarChannels([]string{"a"}, to.Add(-24*time.Hour), to, 0); err != nil {
+		t.Fatal(err)
+	}
+	a := New(&APIOpts{Events: repo})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/recommendations/a?limit=1", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+
+	var got []*clickhouse.SimilarChannel
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*clickhouse.SimilarChannel{
+		{
+			Channel: "a", Similar: "b", Score: 1 / math.Sqrt(2), Shared: 1,
+			Viewers: 2, SimilarViewers: 1, FlowIn: 1, ComputedAt: to,
+		},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	resp, err = a.app.Test(httptest.NewRequest("GET", "/recommendations/a?limit=0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Fatalf("expected status code to be 400, got %d", resp.StatusCode)
+	}
+}
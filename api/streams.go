@@ -0,0 +1,23 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+// streamsHandler returns the stream sessions of `:channel` live in the
+// [from, to] range with the stats of their chatters. See clickhouse.Streams.
+func streamsHandler(repo clickhouse.Repository) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		from, to, err := timeRange(c)
+		if err != nil {
+			return err
+		}
+
+		streams, err := repo.Streams(c.Params("channel"), from, to)
+		if err != nil {
+			return err
+		}
+		return c.JSON(streams)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+func TestStreamsHandler(t *testing.T) {
+	repo := clickhouse.NewMemory()
+	ts := time.Date(2020, 10, 11, 20, 0, 0, 0, time.UTC)
+	for _, vw := range []*clickhouse.Viewers{
+		{Ts: ts, Channel: "a", Stream: "s1", Viewers: []string{"user1", "user2"}},
+		{Ts: ts.Add(10 * time.Minute), Channel: "a", Stream: "s1", Viewers: []string{"user1"}},
+	} {
+		if err := repo.InsertViewers(context.Background(), vw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := New(&APIOpts{Events: repo})
+
+	resp, err := a.app.Test(httptest.NewRequest("GET", "/streams/a?from=2020-10-11T00:00:00Z&to=2020-10-12T00:00:00Z", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be 200, got %d", resp.StatusCode)
+	}
+
+	var got []*clickhouse.Stream
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*clickhouse.Stream{
+		{
+			ID:             "s1",
+			Channel:        "a",
+			StartedAt:      ts,
+			EndedAt:        ts.Add(10 * time.Minute),
+			Snapshots:      2,
+			UniqueChatters: 2,
+			PeakChatters:   2,
+			AvgSnapshots:   1.5,
+		},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
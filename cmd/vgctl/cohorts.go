@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+var cohortsCmd = &command{
+	name:  "cohorts",
+	short: "report the new, returning and lapsed viewers of a channel by week",
+	run:   runCohorts,
+}
+
+var churnCmd = &command{
+	name:  "churn",
+	short: "report where the lapsed viewers of a channel went last",
+	run:   runChurn,
+}
+
+func runCohorts(args []string) error {
+	fs := newFlagSet("cohorts")
+	addr := addrFlag(fs)
+	now := time.Now().UTC().Truncate(time.Hour)
+	from := fs.String("from", now.AddDate(0, 0, -28).Format(time.RFC3339), "start of the time range, RFC3339")
+	to := fs.String("to", now.Format(time.RFC3339), "end of the time range, RFC3339")
+	asJSON := fs.Bool("json", false, "print the raw cohorts as JSON")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "usage: vgctl cohorts [flags] <channel>\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected the channel")
+	}
+
+	q := url.Values{}
+	q.Set("from", *from)
+	q.Set("to", *to)
+	var cohorts []*clickhouse.Cohort
+	if err := getJSON(*addr, "/cohorts/"+url.PathEscape(fs.Arg(0))+"?"+q.Encode(), &cohorts); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(cohorts)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "WEEK\tVIEWERS\tNEW\tRETURNING\tLAPSED\t")
+	for _, c := range cohorts {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t\n", c.Week.Format("2006-01-02"), c.Viewers, c.New, c.Returning, c.Lapsed)
+	}
+	return w.Flush()
+}
+
+func runChurn(args []string) error {
+	fs := newFlagSet("churn")
+	addr := addrFlag(fs)
+	week := fs.String("week", time.Now().UTC().AddDate(0, 0, -7).Format(time.RFC3339), "any time of the week the viewers lapsed, RFC3339")
+	limit := fs.Int("limit", clickhouse.DefaultChurnLimit, "maximum number of destinations")
+	asJSON := fs.Bool("json", false, "print the raw destinations as JSON")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "usage: vgctl churn [flags] <channel>\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected the channel")
+	}
+
+	q := url.Values{}
+	q.Set("week", *week)
+	q.Set("limit", strconv.Itoa(*limit))
+	var dsts []*clickhouse.ChurnDestination
+	if err := getJSON(*addr, "/churn/"+url.PathEscape(fs.Arg(0))+"?"+q.Encode(), &dsts); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(dsts)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tUSERS\t")
+	for _, d := range dsts {
+		fmt.Fprintf(w, "%s\t%d\t\n", d.Channel, d.Users)
+	}
+	return w.Flush()
+}
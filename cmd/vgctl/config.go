@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+	cfg "github.com/pmrt/viewergraph/config"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &command{
+	name:  "config",
+	short: "print the effective configuration, with secrets redacted",
+	run:   runConfig,
+}
+
+func runConfig(args []string) error {
+	fs := newFlagSet("config")
+	file := fs.String("file", os.Getenv(cfg.FileEnv), "configuration file, YAML or TOML (env "+cfg.FileEnv+")")
+	format := fs.String("format", "yaml", "output format: yaml, toml or env")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "usage: vgctl config [flags] print\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) != "print" {
+		fs.Usage()
+		return errors.New("expected the print action")
+	}
+
+	// the values are logged at debug level while loading
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	c, err := cfg.Load(*file)
+	if c == nil {
+		return err
+	}
+	// print the config even if it's invalid, so it can be fixed
+	if perr := printConfig(c.Redacted(), *format); perr != nil {
+		return perr
+	}
+	return err
+}
+
+func printConfig(c *cfg.Config, format string) error {
+	switch format {
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		return enc.Encode(c)
+	case "toml":
+		return toml.NewEncoder(os.Stdout).Encode(c)
+	case "env":
+		for _, v := range c.Env() {
+			fmt.Println(v)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmrt/viewergraph/planner"
+)
+
+var executorsCmd = &command{
+	name:  "executors",
+	short: "list the active executors of the planner",
+	run:   runExecutors,
+}
+
+var runCmd = &command{
+	name:  "run",
+	short: "force a run of the worker of a channel",
+	run:   runRun,
+}
+
+var cancelCmd = &command{
+	name:  "cancel",
+	short: "stop the active executor of a channel",
+	run:   runCancel,
+}
+
+var simulateCmd = &command{
+	name:  "simulate",
+	short: "simulate a stream online|offline event for a channel",
+	run:   runSimulate,
+}
+
+var errMissingBid = errors.New("missing broadcaster id")
+
+func runExecutors(args []string) error {
+	fs := newFlagSet("executors")
+	addr := addrFlag(fs)
+	asJSON := fs.Bool("json", false, "print the raw executors as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var es []planner.ExecutorInfo
+	if err := getJSON(*addr, "/planner/executors", &es); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(es)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BID\tLOGIN\tSTARTED AT\tMINUTE\tNEXT RUN\tLAST RUN\tLAST BATCH\tLAST ERROR")
+	for _, e := range es {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%d\t%s\n",
+			e.BroadcasterID, e.Login, fmtTime(e.StartedAt), e.Minute,
+			fmtTime(e.NextRun), fmtTime(e.LastRun), e.LastBatch, e.LastError)
+	}
+	return w.Flush()
+}
+
+func fmtTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func runRun(args []string) error {
+	fs := newFlagSet("run")
+	addr := addrFlag(fs)
+	token := tokenFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errMissingBid
+	}
+	bid := url.PathEscape(fs.Arg(0))
+	return do(*addr, *token, http.MethodPost, "/planner/executors/"+bid+"/run")
+}
+
+func runCancel(args []string) error {
+	fs := newFlagSet("cancel")
+	addr := addrFlag(fs)
+	token := tokenFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errMissingBid
+	}
+	bid := url.PathEscape(fs.Arg(0))
+	return do(*addr, *token, http.MethodDelete, "/planner/executors/"+bid)
+}
+
+func runSimulate(args []string) error {
+	fs := newFlagSet("simulate")
+	addr := addrFlag(fs)
+	token := tokenFlag(fs)
+	login := fs.String("login", "", "login of the broadcaster")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: vgctl simulate [flags] online|offline <bid>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	evt := fs.Arg(0)
+	if evt != "online" && evt != "offline" {
+		return fmt.Errorf("unknown event %q, want online or offline", evt)
+	}
+	bid := url.PathEscape(fs.Arg(1))
+	path := "/planner/channels/" + bid + "/" + evt + "?login=" + url.QueryEscape(*login)
+	return do(*addr, *token, http.MethodPost, path)
+}
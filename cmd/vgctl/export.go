@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmrt/viewergraph/graph"
+)
+
+var exportCmd = &command{
+	name:  "export",
+	short: "export the viewer-flow graph as json, graphml, gexf or dot",
+	run:   runExport,
+}
+
+func runExport(args []string) error {
+	fs := newFlagSet("export")
+	addr := addrFlag(fs)
+	now := time.Now().UTC().Truncate(time.Hour)
+	from := fs.String("from", now.Add(-24*time.Hour).Format(time.RFC3339), "start of the time range, RFC3339")
+	to := fs.String("to", now.Format(time.RFC3339), "end of the time range, RFC3339")
+	minWeight := fs.Uint64("min-weight", 1, "minimum number of users of an edge")
+	format := fs.String("format", "json", "output format: json, graphml, gexf or dot")
+	communities := fs.Bool("communities", false, "include the community and PageRank of the nodes")
+	out := fs.String("o", "", "output file, stdout if empty")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "usage: vgctl export [flags] graph\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) != "graph" {
+		fs.Usage()
+		return errors.New("expected the graph action")
+	}
+	if _, err := graph.ParseFormat(*format); err != nil {
+		return err
+	}
+
+	q := url.Values{}
+	q.Set("from", *from)
+	q.Set("to", *to)
+	q.Set("min_weight", strconv.FormatUint(*minWeight, 10))
+	q.Set("format", *format)
+	q.Set("communities", strconv.FormatBool(*communities))
+	path := "/graph?" + q.Encode()
+	resp, err := client.Get(strings.TrimSuffix(*addr, "/") + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: unexpected status %d: %s", path, resp.StatusCode, b)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/golang-migrate/migrate/v4"
+	cfg "github.com/pmrt/viewergraph/config"
+	"github.com/pmrt/viewergraph/database"
+	"github.com/pmrt/viewergraph/database/clickhouse"
+	"github.com/pmrt/viewergraph/database/postgres"
+)
+
+var migrateCmd = &command{
+	name:  "migrate",
+	short: "show or change the migration version of a database",
+	run:   runMigrate,
+}
+
+const migrateUsage = `usage: vgctl migrate [flags] <action>
+
+actions:
+  status     show the current version and the pending migrations
+  up         apply all the pending migrations
+  down N     roll back N migrations
+  goto V     migrate up or down to the version V
+  force V    set the version V without running migrations, clearing the
+             dirty flag after a failed migration
+
+The database connection is configured with the same environment variables
+as vgserver, but only the <DB>_* ones are required. Migrations are embedded in vgctl unless <DB>_MIG_PATH is set.
+`
+
+func runMigrate(args []string) error {
+	fs := newFlagSet("migrate")
+	db := fs.String("db", "postgres", "database to migrate: postgres or clickhouse")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage+"\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing action")
+	}
+	action := fs.Arg(0)
+
+	// validate the arguments before connecting to the database
+	var n int
+	switch action {
+	case "status", "up":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("%s takes no arguments", action)
+		}
+	case "down", "goto", "force":
+		if fs.NArg() != 2 {
+			return fmt.Errorf("%s takes exactly one argument", action)
+		}
+		var err error
+		if n, err = strconv.Atoi(fs.Arg(1)); err != nil || n < 0 {
+			return fmt.Errorf("invalid argument %q, expected a non-negative integer", fs.Arg(1))
+		}
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown action %q", action)
+	}
+
+	sto, err := storage(*db)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sto.Opts().StorageConnTimeout)
+	defer cancel()
+	if err := sto.Ping(ctx); err != nil {
+		return fmt.Errorf("couldn't connect to %s: %w", *db, err)
+	}
+	mg, err := sto.Migrator()
+	if err != nil {
+		return err
+	}
+	defer mg.Close()
+
+	switch action {
+	case "up":
+		err = mg.Up()
+	case "down":
+		err = mg.Steps(-n)
+	case "goto":
+		err = mg.Migrate(uint(n))
+	case "force":
+		err = mg.Force(n)
+	}
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Println("no changes were made")
+	} else if err != nil {
+		return err
+	}
+	return printMigrationStatus(mg)
+}
+
+func printMigrationStatus(mg *database.Migrator) error {
+	st, err := mg.Status()
+	if err != nil {
+		return err
+	}
+
+	pending := make([]string, 0, len(st.Pending))
+	for _, v := range st.Pending {
+		pending = append(pending, strconv.FormatUint(uint64(v), 10))
+	}
+	if len(pending) == 0 {
+		pending = append(pending, "none")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "VERSION\t%d\n", st.Version)
+	fmt.Fprintf(w, "DIRTY\t%t\n", st.Dirty)
+	fmt.Fprintf(w, "LATEST\t%d\n", st.Latest)
+	fmt.Fprintf(w, "PENDING\t%s\n", strings.Join(pending, ", "))
+	return w.Flush()
+}
+
+// storage returns the storage `db` configured as in vgserver, without
+// connecting to it nor applying migrations. Only the database settings are
+// required, see config.LoadStorage.
+func storage(db string) (database.Storage, error) {
+	c, err := cfg.SetupStorage()
+	if err != nil {
+		return nil, err
+	}
+	switch db {
+	case "postgres":
+		return postgres.New(database.OptionsFromConfig(&c.Postgres)), nil
+	case "clickhouse":
+		return clickhouse.New(database.OptionsFromConfig(&c.Clickhouse)), nil
+	}
+	return nil, fmt.Errorf("unknown database %q", db)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	cfg "github.com/pmrt/viewergraph/config"
+)
+
+// onlyEnv unsets the configuration variables of viewergraph and sets `env`
+// for the duration of the test
+func onlyEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	keys := []string{cfg.FileEnv}
+	for _, kv := range cfg.Default().Env() {
+		key := strings.SplitN(kv, "=", 2)[0]
+		keys = append(keys, key, key+"_FILE")
+	}
+	for _, key := range keys {
+		if v, ok := os.LookupEnv(key); ok {
+			os.Unsetenv(key)
+			t.Cleanup(func() { os.Setenv(key, v) })
+		}
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+}
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	return port
+}
+
+func TestMigrateStatusOnlyNeedsStorageEnv(t *testing.T) {
+	onlyEnv(t, map[string]string{
+		"POSTGRES_HOST":                 "127.0.0.1",
+		"POSTGRES_PORT":                 closedPort(t),
+		"POSTGRES_USER":                 "vg",
+		"POSTGRES_DB_NAME":              "vg",
+		"POSTGRES_CONN_TIMEOUT_SECONDS": "1",
+	})
+
+	// the configuration is accepted without the Twitch credentials nor the
+	// webhook settings, so it fails connecting to the database
+	err := runMigrate([]string{"-db", "postgres", "status"})
+	if err == nil {
+		t.Fatal("expected an error, nothing listens on the port")
+	}
+	if !strings.Contains(err.Error(), "couldn't connect to postgres") {
+		t.Fatalf("expected a connection error, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+)
+
+var raidsCmd = &command{
+	name:  "raids",
+	short: "report how many raid viewers of a channel stuck around, or its best raid partners",
+	run:   runRaids,
+}
+
+func runRaids(args []string) error {
+	fs := newFlagSet("raids")
+	addr := addrFlag(fs)
+	now := time.Now().UTC().Truncate(time.Hour)
+	from := fs.String("from", now.AddDate(0, 0, -7).Format(time.RFC3339), "start of the time range, RFC3339")
+	to := fs.String("to", now.Format(time.RFC3339), "end of the time range, RFC3339")
+	partners := fs.Bool("partners", false, "rank the raided channels instead of reporting each raid")
+	limit := fs.Int("limit", clickhouse.DefaultRaidPartnersLimit, "maximum number of partners")
+	asJSON := fs.Bool("json", false, "print the raw reports as JSON")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "usage: vgctl raids [flags] <channel>\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected the channel")
+	}
+
+	q := url.Values{}
+	q.Set("from", *from)
+	q.Set("to", *to)
+	path := "/raids/" + url.PathEscape(fs.Arg(0))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	if *partners {
+		q.Set("limit", strconv.Itoa(*limit))
+		var ps []*clickhouse.RaidPartner
+		if err := getJSON(*addr, path+"/partners?"+q.Encode(), &ps); err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(ps)
+		}
+		fmt.Fprintln(w, "CHANNEL\tRAIDS\tRAID VIEWERS\tARRIVED\tSTAYED\tRETURNED\tSTAY RATE\t")
+		for _, p := range ps {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%.2f\t\n", p.Channel, p.Raids, p.RaidViewers, p.Arrived, p.Stayed, p.Returned, p.StayRate)
+		}
+		return w.Flush()
+	}
+
+	var reports []*clickhouse.RaidReport
+	if err := getJSON(*addr, path+"?"+q.Encode(), &reports); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(reports)
+	}
+	fmt.Fprintln(w, "TIME\tTO\tRAID VIEWERS\tARRIVED\tSTAYED\tRETURNED\tRETENTION\t")
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%v\t\n", r.Ts.Format(time.RFC3339), r.To, r.RaidViewers, r.Arrived, r.Stayed, r.Returned, r.Retention)
+	}
+	return w.Flush()
+}
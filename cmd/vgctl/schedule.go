@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pmrt/viewergraph/planner"
+)
+
+var scheduleCmd = &command{
+	name:  "schedule",
+	short: "report how evenly channels are distributed across balanced minutes",
+	run:   runSchedule,
+}
+
+func runSchedule(args []string) error {
+	fs := newFlagSet("schedule")
+	addr := addrFlag(fs)
+	asJSON := fs.Bool("json", false, "print the raw report as JSON")
+	all := fs.Bool("all", false, "include minutes with no channels assigned")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var r *planner.ScheduleReport
+	if err := getJSON(*addr, "/planner/schedule", &r); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(r)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "MINUTE\tASSIGNED\tLIVE\tEXPECTED CHATTERS\t")
+	for _, b := range r.Buckets {
+		if b.Assigned == 0 && !*all {
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t\n", b.Minute, b.Assigned, b.Live, b.ExpectedChatters)
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\t%d\t%d\t\n", r.Assigned, r.Live, r.ExpectedChatters)
+	fmt.Fprintf(w, "CV\t%.2f%%\t%.2f%%\t%.2f%%\t\n", r.AssignedCV, r.LiveCV, r.ChattersCV)
+	return w.Flush()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmrt/viewergraph/planner"
+)
+
+var settingsCmd = &command{
+	name:  "settings",
+	short: "show or change the tracking interval and priority tier of a channel",
+	run:   runSettings,
+}
+
+// channelSettings are the scheduling settings of a channel as served by the
+// vgserver API
+type channelSettings struct {
+	IntervalMinutes *int32            `json:"interval_minutes,omitempty"`
+	Priority        *planner.Priority `json:"priority,omitempty"`
+}
+
+var priorities = map[string]planner.Priority{
+	"low":    planner.PriorityLow,
+	"normal": planner.PriorityNormal,
+	"high":   planner.PriorityHigh,
+}
+
+func priorityName(p planner.Priority) string {
+	for name, prio := range priorities {
+		if prio == p {
+			return name
+		}
+	}
+	return fmt.Sprint(int16(p))
+}
+
+func runSettings(args []string) error {
+	fs := newFlagSet("settings")
+	addr := addrFlag(fs)
+	token := tokenFlag(fs)
+	interval := fs.Duration("interval", 0, "time between runs of the worker, in whole minutes. 0 uses the default interval of the planner")
+	priority := fs.String("priority", "", "priority tier of the channel: low, normal or high")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "usage: vgctl settings [flags] <bid>\n\nwithout -interval or -priority the current settings are shown\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errMissingBid
+	}
+	path := "/planner/channels/" + url.PathEscape(fs.Arg(0)) + "/settings"
+
+	var update channelSettings
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "interval":
+			m := int32(*interval / time.Minute)
+			update.IntervalMinutes = &m
+		case "priority":
+			if prio, ok := priorities[*priority]; ok {
+				update.Priority = &prio
+			}
+		}
+	})
+	if *interval < 0 || *interval%time.Minute != 0 {
+		return fmt.Errorf("invalid interval %s, want a non-negative number of minutes", *interval)
+	}
+	if *priority != "" && update.Priority == nil {
+		return fmt.Errorf("unknown priority %q, want low, normal or high", *priority)
+	}
+
+	var got channelSettings
+	if update.IntervalMinutes == nil && update.Priority == nil {
+		if err := getJSON(*addr, path, &got); err != nil {
+			return err
+		}
+	} else if err := doJSON(*addr, *token, http.MethodPatch, path, &update, &got); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INTERVAL\tPRIORITY")
+	ivl := "default"
+	if got.IntervalMinutes != nil && *got.IntervalMinutes > 0 {
+		ivl = (time.Duration(*got.IntervalMinutes) * time.Minute).String()
+	}
+	prio := planner.PriorityNormal
+	if got.Priority != nil {
+		prio = *got.Priority
+	}
+	fmt.Fprintf(w, "%s\t%s\n", ivl, priorityName(prio))
+	return w.Flush()
+}
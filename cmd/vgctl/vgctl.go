@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// vgctl is the command line tool for operating viewergraph. Commands which
+// inspect a running vgserver do it through its HTTP API, while migrate
+// connects to the databases directly and config reads the same configuration
+// as vgserver.
+
+type command struct {
+	name  string
+	short string
+	run   func(args []string) error
+}
+
+var commands = []*command{
+	scheduleCmd,
+	executorsCmd,
+	runCmd,
+	cancelCmd,
+	simulateCmd,
+	settingsCmd,
+	migrateCmd,
+	configCmd,
+	exportCmd,
+	cohortsCmd,
+	churnCmd,
+	raidsCmd,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: vgctl <command> [flags]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun 'vgctl <command> -h' for the flags of a command\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(os.Args[2:]); err != nil {
+			if err == flag.ErrHelp {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "vgctl %s: %s\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "vgctl: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// newFlagSet creates the flag set of a command
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("vgctl "+name, flag.ContinueOnError)
+}
+
+// addrFlag registers the flag with the address of the vgserver API
+func addrFlag(fs *flag.FlagSet) *string {
+	def := os.Getenv("VG_API_ADDR")
+	if def == "" {
+		def = "http://localhost:8080"
+	}
+	return fs.String("addr", def, "address of the vgserver API (env VG_API_ADDR)")
+}
+
+// tokenFlag registers the flag with the admin token of the vgserver API
+func tokenFlag(fs *flag.FlagSet) *string {
+	return fs.String("token", os.Getenv("VG_API_TOKEN"), "admin token of the vgserver API (env VG_API_TOKEN)")
+}
+
+var client = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+// getJSON requests the API endpoint `path` from the vgserver API at `addr`
+// and decodes the JSON response into `v`.
+func getJSON(addr, path string, v any) error {
+	resp, err := client.Get(strings.TrimSuffix(addr, "/") + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: unexpected status %d: %s", path, resp.StatusCode, b)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// printJSON writes `v` as indented JSON to stdout
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// do sends a `method` request without body to the API endpoint `path` of the
+// vgserver API at `addr`, authenticated with the admin `token` if not empty.
+func do(addr, token, method, path string) error {
+	return doJSON(addr, token, method, path, nil, nil)
+}
+
+// doJSON is like do, but sends `body` encoded as JSON if not nil and decodes
+// the JSON response into `v` if not nil.
+func doJSON(addr, token, method, path string, body, v any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(addr, "/")+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: unexpected status %d: %s", path, resp.StatusCode, b)
+	}
+	if v != nil {
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+	return nil
+}
@@ -1,62 +1,247 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/pmrt/viewergraph/analytics"
+	"github.com/pmrt/viewergraph/api"
 	cfg "github.com/pmrt/viewergraph/config"
 	"github.com/pmrt/viewergraph/database"
-	// "github.com/pmrt/viewergraph/database/clickhouse"
+	"github.com/pmrt/viewergraph/database/clickhouse"
 	"github.com/pmrt/viewergraph/database/postgres"
+	"github.com/pmrt/viewergraph/helix"
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/planner"
+	"github.com/pmrt/viewergraph/recommend"
+	chrepo "github.com/pmrt/viewergraph/repo/clickhouse"
+	pgrepo "github.com/pmrt/viewergraph/repo/postgres"
+	"github.com/pmrt/viewergraph/tracing"
+	"github.com/prometheus/client_golang/prometheus"
 	l "github.com/rs/zerolog/log"
 )
 
 func main() {
+	c, err := cfg.Setup()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	l := l.With().
 		Str("context", "app").
 		Logger()
 
 	l.Info().Msg("starting server")
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	l.Info().Msg("setting up tracing")
+	shutdown, err := tracing.Setup(context.Background(), &tracing.TracingOpts{
+		Exporter:     c.Tracing.Exporter,
+		OTLPEndpoint: c.Tracing.OTLPEndpoint,
+		OTLPInsecure: c.Tracing.OTLPInsecure,
+		SampleRatio:  c.Tracing.SampleRatio,
+	})
+	if err != nil {
+		l.Panic().Err(err).Msg("couldn't set up tracing")
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			l.Error().Err(err).Msg("couldn't flush pending spans")
+		}
+	}()
+
 	l.Info().Msg("setting up database connection")
 
-	// l.Info().Msg("=> setting up clickhouse")
-	// database.New(clickhouse.New(
-	// 	&database.StorageOptions{
-	// 		StorageHost:     cfg.ClickhouseHost,
-	// 		StoragePort:     cfg.ClickhousePort,
-	// 		StorageUser:     cfg.ClickhouseUser,
-	// 		StoragePassword: cfg.ClickhousePassword,
-	// 		StorageDbName:   cfg.ClickhouseDBName,
-	//
-	// 		StorageMaxIdleConns:    cfg.ClickhouseMaxIdleConns,
-	// 		StorageMaxOpenConns:    cfg.ClickhouseMaxOpenConns,
-	// 		StorageConnMaxLifetime: time.Duration(cfg.ClickhouseConnMaxLifetimeMinutes) * time.Minute,
-	// 		StorageConnTimeout:     time.Duration(cfg.ClickhouseConnTimeoutSeconds) * time.Second,
-	//
-	// 		MigrationVersion: cfg.ClickhouseMigVersion,
-	// 		MigrationPath:    cfg.ClickhouseMigPath,
-	//
-	// 		DebugMode: cfg.Debug,
-	// 	}))
+	l.Info().Msg("=> setting up clickhouse")
+	chOpts := database.OptionsFromConfig(&c.Clickhouse)
+	chOpts.SkipMigrations = c.SkipMigrations
+	chOpts.DebugMode = c.Debug
+	ch := connect(ctx, "clickhouse", clickhouse.New(chOpts), nil)
+
 	l.Info().Msg("=> setting up postgres")
-	database.New(postgres.New(
-		&database.StorageOptions{
-			StorageHost:     cfg.PostgresHost,
-			StoragePort:     cfg.PostgresPort,
-			StorageUser:     cfg.PostgresUser,
-			StoragePassword: cfg.PostgresPassword,
-			StorageDbName:   cfg.PostgresDBName,
-
-			StorageMaxIdleConns:    cfg.PostgresMaxIdleConns,
-			StorageMaxOpenConns:    cfg.PostgresMaxOpenConns,
-			StorageConnMaxLifetime: time.Duration(cfg.PostgresConnMaxLifetimeMinutes) * time.Minute,
-			StorageConnTimeout:     time.Duration(cfg.PostgresConnTimeoutSeconds) * time.Second,
-
-			MigrationVersion: cfg.PostgresMigVersion,
-			MigrationPath:    cfg.PostgresMigPath,
-		}))
+	pgOpts := database.OptionsFromConfig(&c.Postgres)
+	pgOpts.SkipMigrations = c.SkipMigrations
+	pg := postgres.New(pgOpts)
+	repo := pgrepo.New(pg.Conn())
+
+	l.Info().Msg("setting up planner")
+
+	opts := planner.OptsFromConfig(c)
+	var scheduler *planner.LoadScheduler
+	if c.Planner.Scheduler == "load" {
+		scheduler = planner.NewLoadScheduler(nil)
+		scheduler.OnAssign = repo.SetScheduleMinute
+		scheduler.OnObserve = repo.SetScheduleLoad
+		opts.Scheduler = scheduler
+	}
+	var p *planner.Planner
+	w := &planner.ChattersWorker{
+		Storage:   ch,
+		BatchSize: c.Planner.BatchSize,
+		Login: func(bid string) (string, bool) {
+			return p.Login(bid)
+		},
+	}
+	opts.WorkerFunc = w.Run
+	opts.SaveSettings = repo.SetChannelSettings
+	events := chrepo.New(ch.Conn())
+	opts.RaidFunc = func(ctx context.Context, evt *helix.EventChannelRaid) error {
+		return events.InsertRaid(&chrepo.Raid{
+			ID:      evt.MessageID,
+			Ts:      evt.Ts,
+			From:    evt.From.Login,
+			To:      evt.To.Login,
+			Viewers: uint32(evt.Viewers),
+		})
+	}
+	p = planner.New(opts)
+
+	// the planner is set up before connecting to postgres, so the tracked
+	// channels can be added once it's available, which may be after starting
+	// degraded
+	connect(ctx, "postgres", pg, func() {
+		track(repo, p, scheduler)
+	})
+
+	prometheus.MustRegister(p, metrics.NewReconciliationLag(repo.LastReconciliation))
+
+	if err := p.Start(); err != nil {
+		l.Panic().Err(err).Msg("couldn't start planner")
+	}
+
+	l.Info().Msg("setting up similar channels refresher")
+	rOpts := recommend.OptsFromConfig(c)
+	rOpts.Events = events
+	rOpts.Channels = repo
+	refresher := recommend.New(rOpts)
+	leader := postgres.NewLeader(pg, &postgres.LeaderOpts{
+		Key: postgres.LockKey("similar_channels"),
+	})
+	go leader.Run(ctx, refresher.Run)
+
+	l.Info().Msg("setting up communities refresher")
+	aOpts := analytics.OptsFromConfig(c)
+	aOpts.Events = events
+	communities := analytics.New(aOpts)
+	communitiesLeader := postgres.NewLeader(pg, &postgres.LeaderOpts{
+		Key: postgres.LockKey("channel_communities"),
+	})
+	go communitiesLeader.Run(ctx, communities.Run)
+
+	a := api.New(&api.APIOpts{
+		Port:       c.API.Port,
+		Planner:    p,
+		AdminToken: c.API.AdminToken,
+		Events:     events,
+		Channels:   repo,
+		Health: &api.HealthOpts{
+			Storages: map[string]database.Storage{
+				"clickhouse": ch,
+				"postgres":   pg,
+			},
+			Helix: p.Helix(),
+		},
+	})
+	go func() {
+		if err := a.Listen(); err != nil {
+			l.Error().Err(err).Msg("api server stopped")
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	l.Info().Msg("shutting down")
+	sctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	l.Info().Msg("=> stopping api server")
+	if err := a.Shutdown(); err != nil {
+		l.Error().Err(err).Msg("")
+	}
+	p.Stop()
+
+	l.Info().Msg("=> closing database connections")
+	for name, sto := range map[string]database.Storage{"clickhouse": ch, "postgres": pg} {
+		if err := sto.Close(sctx); err != nil {
+			l.Error().Err(err).Str("db", name).Msg("")
+		}
+	}
+}
+
+// connect sets up the storage `sto` and calls `onConnect`, if not nil, once
+// it's available. If it's unavailable the server starts degraded: /readyz
+// reports the database as unavailable while the connection is retried in
+// background, and `onConnect` is called after the first successful retry.
+func connect(ctx context.Context, name string, sto database.Storage, onConnect func()) database.Storage {
+	if _, err := database.New(sto); err != nil {
+		if !errors.Is(err, database.ErrUnreachable) {
+			l.Error().
+				Str("context", "app").
+				Err(err).
+				Msgf("=> %s couldn't be set up", name)
+			return sto
+		}
+		l.Error().
+			Str("context", "app").
+			Err(err).
+			Msgf("=> %s unavailable, retrying in background", name)
+		go func() {
+			err := database.Retry(ctx, sto, time.Minute)
+			if err != nil {
+				if errors.Is(err, context.Canceled) {
+					return
+				}
+				l.Error().
+					Str("context", "app").
+					Err(err).
+					Msgf("=> %s couldn't be set up", name)
+				return
+			}
+			if onConnect != nil {
+				onConnect()
+			}
+		}()
+		return sto
+	}
+	if onConnect != nil {
+		onConnect()
+	}
+	return sto
 }
 
-func init() {
-	cfg.Setup()
+// track adds the tracked channels stored in `repo` to the planner, restoring
+// their schedule minutes and loads first if `scheduler` is not nil.
+func track(repo pgrepo.Repository, p *planner.Planner, scheduler *planner.LoadScheduler) {
+	l := l.With().
+		Str("context", "app").
+		Logger()
+
+	if scheduler != nil {
+		assigned, err := repo.ScheduleMinutes()
+		if err != nil {
+			l.Error().Err(err).Msg("=> couldn't restore schedule minutes")
+		}
+		loads, err := repo.ScheduleLoads()
+		if err != nil {
+			l.Error().Err(err).Msg("=> couldn't restore schedule loads")
+		}
+		scheduler.Restore(assigned, loads)
+	}
+	tracked, err := repo.Tracked()
+	if err != nil {
+		l.Error().Err(err).Msg("=> couldn't read tracked channels, starting without them")
+		return
+	}
+	l.Info().Int("channels", len(tracked)).Msg("=> tracking channels")
+	p.Track(tracked)
 }
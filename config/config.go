@@ -2,162 +2,194 @@ package config
 
 import (
 	"os"
-	"reflect"
-	"strconv"
+	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
-	l "github.com/rs/zerolog/log"
 )
 
-const LastMigrationVersion = 1
+// FileEnv is the environment variable with the path of the configuration
+// file, in YAML or TOML format.
+const FileEnv = "VG_CONFIG"
 
-func Setup() {
-	LoadVars()
-	setupLogger()
-}
-
-type SupportStringconv interface {
-	~int | ~int8 | ~int64 | ~float32 | ~string | ~bool
-}
-
-func conv(v string, to reflect.Kind) any {
-	var err error
-
-	if to == reflect.String {
-		return v
+// Setup loads the configuration, see Load, with the file in FileEnv and sets
+// up the global logger.
+func Setup() (*Config, error) {
+	c, err := Load(os.Getenv(FileEnv))
+	if c != nil {
+		setupLogger(c.LogLevel)
 	}
+	return c, err
+}
 
-	if to == reflect.Bool {
-		if bool, err := strconv.ParseBool(v); err == nil {
-			return bool
-		}
+// SetupStorage is Setup with LoadStorage.
+func SetupStorage() (*Config, error) {
+	c, err := LoadStorage(os.Getenv(FileEnv))
+	if c != nil {
+		setupLogger(c.LogLevel)
 	}
+	return c, err
+}
 
-	if to == reflect.Int {
-		if int, err := strconv.Atoi(v); err == nil {
-			return int
-		}
-	}
+// Config is the configuration of viewergraph. Each field is read from the
+// environment variable in its `env` tag, prefixed by the `env` tag of the
+// parent structs. Tags:
+//
+//   - required: the field can't be empty.
+//   - secret: the value can also be read from the file in <ENV>_FILE, and is
+//     redacted when printed.
+//   - unit: for durations, the unit of plain numbers, e.g. 60 with unit m is
+//     one hour. Values like 90s are always accepted.
+type Config struct {
+	Clickhouse  StorageConfig     `yaml:"clickhouse" toml:"clickhouse" env:"CLICKHOUSE_"`
+	Postgres    StorageConfig     `yaml:"postgres" toml:"postgres" env:"POSTGRES_"`
+	Helix       HelixConfig       `yaml:"helix" toml:"helix" env:"HELIX_"`
+	API         APIConfig         `yaml:"api" toml:"api" env:"API_"`
+	Planner     PlannerConfig     `yaml:"planner" toml:"planner"`
+	Tracing     TracingConfig     `yaml:"tracing" toml:"tracing"`
+	Similarity  SimilarityConfig  `yaml:"similarity" toml:"similarity" env:"SIMILARITY_"`
+	Communities CommunitiesConfig `yaml:"communities" toml:"communities" env:"COMMUNITIES_"`
+
+	SkipMigrations bool `yaml:"skip_migrations" toml:"skip_migrations" env:"SKIP_MIGRATIONS"`
+	Debug          bool `yaml:"debug" toml:"debug" env:"DEBUG"`
+	LogLevel       int8 `yaml:"log_level" toml:"log_level" env:"LOG_LEVEL"`
+}
 
-	if to == reflect.Int8 {
-		if i64, err := strconv.ParseInt(v, 10, 8); err == nil {
-			return int8(i64)
-		}
-	}
+type StorageConfig struct {
+	Host            string        `yaml:"host" toml:"host" env:"HOST" required:"true"`
+	Port            string        `yaml:"port" toml:"port" env:"PORT" required:"true"`
+	User            string        `yaml:"user" toml:"user" env:"USER" required:"true"`
+	Password        string        `yaml:"password" toml:"password" env:"PASSWORD" secret:"true"`
+	DBName          string        `yaml:"db_name" toml:"db_name" env:"DB_NAME" required:"true"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"MAX_IDLE_CONNS"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"MAX_OPEN_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME_MINUTES" unit:"m"`
+	ConnTimeout     time.Duration `yaml:"conn_timeout" toml:"conn_timeout" env:"CONN_TIMEOUT_SECONDS" unit:"s"`
+	MigVersion      int           `yaml:"mig_version" toml:"mig_version" env:"MIG_VERSION"`
+	// MigPath is the directory with the migrations. If empty, the migrations
+	// embedded in the binary are used.
+	MigPath string `yaml:"mig_path" toml:"mig_path" env:"MIG_PATH"`
+}
 
-	if to == reflect.Int64 {
-		if i64, err := strconv.ParseInt(v, 10, 64); err == nil {
-			return i64
-		}
-	}
+type HelixConfig struct {
+	ClientID string `yaml:"client_id" toml:"client_id" env:"CLIENT_ID" required:"true"`
+	Secret   string `yaml:"secret" toml:"secret" env:"SECRET" required:"true" secret:"true"`
+}
 
-	if to == reflect.Float32 {
-		if f32, err := strconv.ParseFloat(v, 32); err == nil {
-			return f32
-		}
-	}
+type APIConfig struct {
+	Port string `yaml:"port" toml:"port" env:"PORT" required:"true"`
+	// AdminToken protects the endpoints which change the state of the
+	// planner. If empty, they are disabled.
+	AdminToken string `yaml:"admin_token" toml:"admin_token" env:"ADMIN_TOKEN" secret:"true"`
+}
 
-	l.Panic().
-		Err(err).
-		Str("context", "config").
-		Msg("")
-	return nil
+type PlannerConfig struct {
+	// WebhookServerURL is the public URL of the webhook server, without the
+	// endpoint. Twitch requires https.
+	WebhookServerURL string `yaml:"webhook_server_url" toml:"webhook_server_url" env:"WEBHOOK_SERVER_URL" required:"true"`
+	WebhookEndpoint  string `yaml:"webhook_endpoint" toml:"webhook_endpoint" env:"WEBHOOK_ENDPOINT" required:"true"`
+	// WebhookSecret signs the EventSub notifications. Twitch requires 10 to
+	// 100 characters.
+	WebhookSecret string `yaml:"webhook_secret" toml:"webhook_secret" env:"WEBHOOK_SECRET" required:"true" secret:"true"`
+	WebhookPort   string `yaml:"webhook_port" toml:"webhook_port" env:"WEBHOOK_PORT" required:"true"`
+
+	TrackInterval      time.Duration `yaml:"track_interval" toml:"track_interval" env:"TRACK_INTERVAL_MINUTES" unit:"m"`
+	TrackOnlineTimeout time.Duration `yaml:"track_online_timeout" toml:"track_online_timeout" env:"TRACK_ONLINE_TIMEOUT_HOURS" unit:"h"`
+	WorkerTimeout      time.Duration `yaml:"worker_timeout" toml:"worker_timeout" env:"WORKER_TIMEOUT_SECONDS" unit:"s"`
+	MinRunSpacing      time.Duration `yaml:"min_run_spacing" toml:"min_run_spacing" env:"MIN_RUN_SPACING_SECONDS" unit:"s"`
+	// BatchSize is the maximum number of chatters inserted at once.
+	BatchSize uint64 `yaml:"batch_size" toml:"batch_size" env:"BATCH_SIZE"`
+
+	MaxConcurrency        int     `yaml:"max_concurrency" toml:"max_concurrency" env:"WORKER_MAX_CONCURRENCY"`
+	QueueSize             int     `yaml:"queue_size" toml:"queue_size" env:"WORKER_QUEUE_SIZE"`
+	HostRequestsPerSecond float64 `yaml:"host_requests_per_second" toml:"host_requests_per_second" env:"WORKER_HOST_REQUESTS_PER_SECOND"`
+	// Scheduler is hash or load. See planner.LoadScheduler.
+	Scheduler string `yaml:"scheduler" toml:"scheduler" env:"SCHEDULER"`
 }
 
-func Env[T SupportStringconv](key string, def T) T {
-	if v, ok := os.LookupEnv(key); ok {
-		val := conv(v, reflect.TypeOf(def).Kind()).(T)
-		l.Debug().
-			Str("context", "config").
-			Msgf("=> [%s]: %v", key, val)
-		return val
-	}
-	return def
+type TracingConfig struct {
+	// Exporter is one of none, otlp or stdout.
+	Exporter     string  `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER"`
+	SampleRatio  float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint" toml:"otlp_endpoint" env:"OTLP_ENDPOINT"`
+	OTLPInsecure bool    `yaml:"otlp_insecure" toml:"otlp_insecure" env:"OTLP_INSECURE"`
 }
 
-var (
-	ClickhouseHost                   string
-	ClickhousePort                   string
-	ClickhouseUser                   string
-	ClickhousePassword               string
-	ClickhouseDBName                 string
-	ClickhouseMaxIdleConns           int
-	ClickhouseMaxOpenConns           int
-	ClickhouseConnTimeoutSeconds     int
-	ClickhouseConnMaxLifetimeMinutes int
-	ClickhouseMigVersion             int
-	ClickhouseMigPath                string
-
-	PostgresHost                   string
-	PostgresPort                   string
-	PostgresUser                   string
-	PostgresPassword               string
-	PostgresDBName                 string
-	PostgresMaxIdleConns           int
-	PostgresMaxOpenConns           int
-	PostgresConnTimeoutSeconds     int
-	PostgresConnMaxLifetimeMinutes int
-	PostgresMigVersion             int
-	PostgresMigPath                string
-
-	HelixClientID string
-	HelixSecret   string
-
-	SkipMigrations bool
-
-	APIPort string
-
-	TrackIntervalMinutes int
-
-	Debug    bool
-	LogLevel int8
-)
+type SimilarityConfig struct {
+	// RefreshInterval is how often the similar channels are recomputed.
+	RefreshInterval time.Duration `yaml:"refresh_interval" toml:"refresh_interval" env:"REFRESH_INTERVAL_MINUTES" unit:"m"`
+	// Window is how far back the co-viewing data is used.
+	Window time.Duration `yaml:"window" toml:"window" env:"WINDOW_HOURS" unit:"h"`
+	// Limit is the number of similar channels kept per channel.
+	Limit int `yaml:"limit" toml:"limit" env:"LIMIT"`
+}
 
-func LoadVars() {
-	l := l.With().
-		Str("context", "config").
-		Logger()
+type CommunitiesConfig struct {
+	// RefreshInterval is how often the communities are recomputed.
+	RefreshInterval time.Duration `yaml:"refresh_interval" toml:"refresh_interval" env:"REFRESH_INTERVAL_MINUTES" unit:"m"`
+	// Window is the time range of the viewer-flow graph.
+	Window time.Duration `yaml:"window" toml:"window" env:"WINDOW_HOURS" unit:"h"`
+	// MinWeight is the minimum number of users of an edge of the graph.
+	MinWeight uint64 `yaml:"min_weight" toml:"min_weight" env:"MIN_WEIGHT"`
+}
 
-	if err := godotenv.Load(); err != nil {
-		l.Panic().
-			Err(err).
-			Msg("couldn't load .env file")
+// Default returns the configuration used for the values not set in the
+// environment, .env or the configuration file.
+func Default() *Config {
+	return &Config{
+		Clickhouse: StorageConfig{
+			Host:            "127.0.0.1",
+			Port:            "8123",
+			User:            "default",
+			DBName:          "default",
+			MaxIdleConns:    5,
+			MaxOpenConns:    10,
+			ConnMaxLifetime: time.Hour,
+			ConnTimeout:     time.Minute,
+			MigVersion:      8,
+		},
+		Postgres: StorageConfig{
+			Host:            "127.0.0.1",
+			Port:            "5432",
+			User:            "vg",
+			Password:        "unsafepassword",
+			DBName:          "vg",
+			MaxIdleConns:    5,
+			MaxOpenConns:    10,
+			ConnMaxLifetime: time.Hour,
+			ConnTimeout:     time.Minute,
+			MigVersion:      4,
+		},
+		API: APIConfig{
+			Port: "8080",
+		},
+		Planner: PlannerConfig{
+			WebhookEndpoint:    "/webhook",
+			WebhookPort:        "8081",
+			TrackInterval:      time.Hour,
+			TrackOnlineTimeout: 24 * time.Hour,
+			WorkerTimeout:      time.Minute,
+			MinRunSpacing:      time.Minute,
+			BatchSize:          1000,
+			MaxConcurrency:     16,
+			QueueSize:          1024,
+			Scheduler:          "load",
+		},
+		Tracing: TracingConfig{
+			Exporter:     "none",
+			SampleRatio:  1,
+			OTLPEndpoint: "localhost:4318",
+			OTLPInsecure: true,
+		},
+		Similarity: SimilarityConfig{
+			RefreshInterval: 6 * time.Hour,
+			Window:          30 * 24 * time.Hour,
+			Limit:           20,
+		},
+		Communities: CommunitiesConfig{
+			RefreshInterval: time.Hour,
+			Window:          7 * 24 * time.Hour,
+			MinWeight:       1,
+		},
+		LogLevel: int8(zerolog.DebugLevel),
 	}
-
-	l.Info().Msg("reading environment variables")
-
-	ClickhouseHost = Env("CLICKHOUSE_HOST", "127.0.0.1")
-	ClickhousePort = Env("CLICKHOUSE_PORT", "8123")
-	ClickhouseUser = Env("CLICKHOUSE_USER", "default")
-	ClickhousePassword = Env("CLICKHOUSE_PASSWORD", "")
-	ClickhouseDBName = Env("CLICKHOUSE_DB_NAME", "default")
-	ClickhouseMaxIdleConns = Env("CLICKHOUSE_MAX_IDLE_CONNS", 5)
-	ClickhouseMaxOpenConns = Env("CLICKHOUSE_MAX_OPEN_CONNS", 10)
-	ClickhouseConnMaxLifetimeMinutes = Env("CLICKHOUSE_CONN_MAX_LIFETIME_MINUTES", 60)
-	ClickhouseConnTimeoutSeconds = Env("CLICKHOUSE_CONN_TIMEOUT_SECONDS", 60)
-	ClickhouseMigVersion = Env("CLICKHOUSE_MIG_VERSION", 1)
-	ClickhouseMigPath = Env("CLICKHOUSE_MIG_PATH", "database/clickhouse/migrations")
-
-	PostgresHost = Env("POSTGRES_HOST", "127.0.0.1")
-	PostgresPort = Env("POSTGRES_PORT", "5432")
-	PostgresUser = Env("POSTGRES_USER", "vg")
-	PostgresPassword = Env("POSTGRES_PASSWORD", "unsafepassword")
-	PostgresDBName = Env("POSTGRES_DB_NAME", "vg")
-	PostgresMaxIdleConns = Env("POSTGRES_MAX_IDLE_CONNS", 5)
-	PostgresMaxOpenConns = Env("POSTGRES_MAX_OPEN_CONNS", 10)
-	PostgresConnMaxLifetimeMinutes = Env("POSTGRES_CONN_MAX_LIFETIME_MINUTES", 60)
-	PostgresConnTimeoutSeconds = Env("POSTGRES_CONN_TIMEOUT_SECONDS", 60)
-	PostgresMigVersion = Env("POSTGRES_MIG_VERSION", 1)
-	PostgresMigPath = Env("POSTGRES_MIG_VERSION", "database/postgres/migrations")
-
-	HelixClientID = Env("HELIX_CLIENT_ID", "fake_client_id")
-	HelixSecret = Env("HELIX_SECRET", "fake_secret")
-
-	TrackIntervalMinutes = Env("TRACK_INTERVAL_MINUTES", 3600)
-
-	SkipMigrations = Env("SKIP_MIGRATIONS", false)
-
-	Debug = Env("DEBUG", false)
-	LogLevel = Env("LOG_LEVEL", int8(zerolog.DebugLevel))
 }
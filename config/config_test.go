@@ -0,0 +1,357 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func lookupMap(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// requiredEnv are the variables without defaults
+var requiredEnv = map[string]string{
+	"HELIX_CLIENT_ID":    "clientid",
+	"HELIX_SECRET":       "secret",
+	"WEBHOOK_SERVER_URL": "https://vg.example.com",
+	"WEBHOOK_SECRET":     "webhooksecret",
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	t.Parallel()
+
+	files := map[string]string{
+		"vg.yaml": "postgres:\n  host: db\n  conn_timeout: 90s\napi:\n  port: \"9000\"\nplanner:\n  track_interval: 15m\n",
+		"vg.toml": "[postgres]\nhost = \"db\"\nconn_timeout = \"90s\"\n[api]\nport = \"9000\"\n[planner]\ntrack_interval = \"15m\"\n",
+	}
+	for name, content := range files {
+		env := map[string]string{
+			"API_PORT":                  "9090",
+			"CLICKHOUSE_MIG_PATH":       "migrations",
+			"TRACING_SAMPLE_RATIO":      "0.5",
+			"CLICKHOUSE_MAX_IDLE_CONNS": "2",
+		}
+		for k, v := range requiredEnv {
+			env[k] = v
+		}
+		c, err := load(writeFile(t, name, content), lookupMap(env))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := Default()
+		// from the file
+		want.Postgres.Host = "db"
+		want.Postgres.ConnTimeout = 90 * time.Second
+		want.Planner.TrackInterval = 15 * time.Minute
+		// the environment overrides the file
+		want.API.Port = "9090"
+		want.Clickhouse.MigPath = "migrations"
+		want.Clickhouse.MaxIdleConns = 2
+		want.Tracing.SampleRatio = 0.5
+		want.Helix = HelixConfig{ClientID: "clientid", Secret: "secret"}
+		want.Planner.WebhookServerURL = "https://vg.example.com"
+		want.Planner.WebhookSecret = "webhooksecret"
+		if diff := deep.Equal(c, want); diff != nil {
+			t.Fatalf("%s: %v", name, diff)
+		}
+	}
+}
+
+func TestLoadDurations(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		env  string
+		want time.Duration
+	}{
+		// plain numbers are in the unit of the variable
+		{env: "30", want: 30 * time.Minute},
+		{env: "90s", want: 90 * time.Second},
+		{env: "2h", want: 2 * time.Hour},
+	}
+	for _, test := range tests {
+		env := map[string]string{"TRACK_INTERVAL_MINUTES": test.env}
+		for k, v := range requiredEnv {
+			env[k] = v
+		}
+		c, err := load("", lookupMap(env))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Planner.TrackInterval != test.want {
+			t.Fatalf("%s: got %s, want %s", test.env, c.Planner.TrackInterval, test.want)
+		}
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	t.Parallel()
+
+	c, err := load("", lookupMap(map[string]string{
+		"HELIX_CLIENT_ID":        "clientid",
+		"HELIX_SECRET_FILE":      writeFile(t, "helix_secret", "filesecret\n"),
+		"POSTGRES_PASSWORD_FILE": writeFile(t, "pg_password", "pgpassword"),
+		"WEBHOOK_SERVER_URL":     "https://vg.example.com",
+		"WEBHOOK_SECRET_FILE":    writeFile(t, "webhook_secret", "webhooksecret"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Helix.Secret != "filesecret" || c.Postgres.Password != "pgpassword" || c.Planner.WebhookSecret != "webhooksecret" {
+		t.Fatalf("got secrets %q, %q and %q", c.Helix.Secret, c.Postgres.Password, c.Planner.WebhookSecret)
+	}
+
+	// only secrets can be read from files
+	env := map[string]string{"HELIX_CLIENT_ID_FILE": writeFile(t, "client_id", "fileclientid")}
+	for k, v := range requiredEnv {
+		env[k] = v
+	}
+	c, err = load("", lookupMap(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Helix.ClientID != "clientid" {
+		t.Fatalf("got client id %q", c.Helix.ClientID)
+	}
+}
+
+func TestLoadReportsAllErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := load("", lookupMap(map[string]string{
+		"POSTGRES_MAX_OPEN_CONNS":         "ten",
+		"CLICKHOUSE_CONN_TIMEOUT_SECONDS": "a minute",
+		"LOG_LEVEL":                       "300",
+		"DEBUG":                           "sometimes",
+		"HELIX_SECRET":                    "secret",
+		"HELIX_SECRET_FILE":               "/run/secrets/helix",
+	}))
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	want := []string{
+		`CLICKHOUSE_CONN_TIMEOUT_SECONDS: invalid duration "a minute"`,
+		`POSTGRES_MAX_OPEN_CONNS: invalid 64-bit integer "ten"`,
+		`HELIX_SECRET: both HELIX_SECRET and HELIX_SECRET_FILE are set`,
+		`DEBUG: invalid boolean "sometimes"`,
+		`LOG_LEVEL: invalid 8-bit integer "300"`,
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// validation errors are reported along with the loaded config
+	c, err := load("", lookupMap(map[string]string{
+		"POSTGRES_HOST":        "",
+		"TRACING_EXPORTER":     "jaeger",
+		"TRACING_SAMPLE_RATIO": "2",
+	}))
+	if c == nil {
+		t.Fatal("expected the config to be returned on validation errors")
+	}
+	want = []string{
+		"POSTGRES_HOST is required",
+		"HELIX_CLIENT_ID is required",
+		"HELIX_SECRET is required",
+		"WEBHOOK_SERVER_URL is required",
+		"WEBHOOK_SECRET is required",
+		`TRACING_EXPORTER: unknown exporter "jaeger", expected none, otlp or stdout`,
+		"TRACING_SAMPLE_RATIO: must be in the range [0, 1], got 2",
+	}
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	got = nil
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+	if !strings.HasPrefix(err.Error(), "invalid configuration:\n  - POSTGRES_HOST is required\n") {
+		t.Fatalf("unexpected report:\n%s", err)
+	}
+}
+
+func TestLoadValidatesPlanner(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{
+		"HELIX_CLIENT_ID":        "clientid",
+		"HELIX_SECRET":           "secret",
+		"WEBHOOK_SERVER_URL":     "http://vg.example.com",
+		"WEBHOOK_ENDPOINT":       "webhook",
+		"WEBHOOK_SECRET":         "short",
+		"WORKER_TIMEOUT_SECONDS": "0",
+		"BATCH_SIZE":             "0",
+		"SCHEDULER":              "random",
+	}
+	_, err := load("", lookupMap(env))
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	want := []string{
+		`WEBHOOK_SERVER_URL: must be an https URL, got "http://vg.example.com"`,
+		`WEBHOOK_ENDPOINT: must start with /, got "webhook"`,
+		"WEBHOOK_SECRET: must have between 10 and 100 characters, got 5",
+		"WORKER_TIMEOUT_SECONDS: must be positive",
+		"BATCH_SIZE: must be positive",
+		`SCHEDULER: unknown scheduler "random", expected hash or load`,
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	env["WEBHOOK_SECRET"] = strings.Repeat("s", 101)
+	if _, err := load("", lookupMap(env)); !strings.Contains(err.Error(), "got 101") {
+		t.Fatalf("expected secrets longer than 100 characters to be rejected, got %v", err)
+	}
+}
+
+func TestLoadUnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	if _, err := load(writeFile(t, "vg.json", "{}"), lookupMap(requiredEnv)); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	t.Parallel()
+
+	c := Default()
+	c.Helix = HelixConfig{ClientID: "clientid", Secret: "secret"}
+	r := c.Redacted()
+	if r.Helix.Secret != "REDACTED" || r.Postgres.Password != "REDACTED" {
+		t.Fatal("expected secrets to be redacted")
+	}
+	if r.Helix.ClientID != "clientid" || r.Clickhouse.Password != "" {
+		t.Fatal("expected only non-empty secrets to be redacted")
+	}
+	if c.Helix.Secret != "secret" {
+		t.Fatal("expected the original config to be unchanged")
+	}
+}
+
+func TestLoadValidatesSimilarity(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{
+		"SIMILARITY_REFRESH_INTERVAL_MINUTES": "0",
+		"SIMILARITY_WINDOW_HOURS":             "-1",
+		"SIMILARITY_LIMIT":                    "0",
+	}
+	for k, v := range requiredEnv {
+		env[k] = v
+	}
+	_, err := load("", lookupMap(env))
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	want := []string{
+		"SIMILARITY_REFRESH_INTERVAL_MINUTES: must be positive",
+		"SIMILARITY_WINDOW_HOURS: must be positive",
+		"SIMILARITY_LIMIT: must be positive",
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadValidatesCommunities(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{
+		"COMMUNITIES_REFRESH_INTERVAL_MINUTES": "0",
+		"COMMUNITIES_WINDOW_HOURS":             "-1",
+	}
+	for k, v := range requiredEnv {
+		env[k] = v
+	}
+	_, err := load("", lookupMap(env))
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	want := []string{
+		"COMMUNITIES_REFRESH_INTERVAL_MINUTES: must be positive",
+		"COMMUNITIES_WINDOW_HOURS: must be positive",
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadStorageValidatesOnlyStorages(t *testing.T) {
+	t.Parallel()
+
+	// neither the Twitch credentials nor the webhook settings are required
+	c, err := loadWith("", lookupMap(map[string]string{
+		"POSTGRES_HOST": "db",
+		"SCHEDULER":     "random",
+	}), (*Config).validateStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Postgres.Host != "db" {
+		t.Fatalf("got postgres host %q", c.Postgres.Host)
+	}
+
+	_, err = loadWith("", lookupMap(map[string]string{
+		"POSTGRES_HOST":      "",
+		"CLICKHOUSE_DB_NAME": "",
+	}), (*Config).validateStorage)
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	want := []string{
+		"CLICKHOUSE_DB_NAME is required",
+		"POSTGRES_HOST is required",
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,379 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	l "github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUnknownFormat = errors.New("unknown configuration file format, expected .yaml, .yml or .toml")
+
+// Errors are all the problems found while loading the configuration, so they
+// can be fixed at once.
+type Errors []error
+
+func (e Errors) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, err := range e {
+		b.WriteString("\n  - ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Load reads the configuration with the following precedence, from highest to
+// lowest: environment variables, the .env file of the working directory, the
+// YAML or TOML `file` and Default. Both the .env file and `file` are
+// optional.
+//
+// Parse and validation problems are reported together as Errors. If only
+// validation failed, the returned config is still filled so it can be
+// inspected.
+func Load(file string) (*Config, error) {
+	dotenv, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+	return load(file, envLookup(dotenv))
+}
+
+// LoadStorage is Load, but only the Clickhouse and Postgres sections are
+// validated. It is meant for the commands which only connect to the
+// databases, e.g. migrations run from CI, which don't have the Twitch
+// credentials nor the webhook settings.
+func LoadStorage(file string) (*Config, error) {
+	dotenv, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+	return loadWith(file, envLookup(dotenv), (*Config).validateStorage)
+}
+
+// envLookup looks up the environment variables, then the `dotenv` file
+func envLookup(dotenv map[string]string) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := dotenv[key]
+		return v, ok
+	}
+}
+
+func load(file string, lookup func(key string) (string, bool)) (*Config, error) {
+	return loadWith(file, lookup, (*Config).validate)
+}
+
+// loadWith is load with `validate` instead of the validation of the whole
+// config
+func loadWith(file string, lookup func(key string) (string, bool), validate func(c *Config) Errors) (*Config, error) {
+	c := Default()
+	if file != "" {
+		if err := decodeFile(file, c); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs Errors
+	walk(reflect.ValueOf(c).Elem(), "", func(f reflect.StructField, v reflect.Value, key string) {
+		raw, ok, err := lookupValue(f, key, lookup)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if !ok {
+			return
+		}
+		if err := set(v, raw, f.Tag.Get("unit")); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			return
+		}
+		l.Debug().
+			Str("context", "config").
+			Msgf("=> [%s]: %s", key, redact(f, raw))
+	})
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	if errs = validate(c); len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+func decodeFile(file string, c *Config) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	switch filepath.Ext(file) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, c)
+	case ".toml":
+		err = toml.Unmarshal(b, c)
+	default:
+		return ErrUnknownFormat
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// walk calls `fn` for every field of the struct `v` with an `env` tag, with
+// the name of its environment variable.
+func walk(v reflect.Value, prefix string, fn func(f reflect.StructField, v reflect.Value, key string)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := prefix + f.Tag.Get("env")
+		if f.Type.Kind() == reflect.Struct {
+			walk(v.Field(i), key, fn)
+			continue
+		}
+		if f.Tag.Get("env") == "" {
+			continue
+		}
+		fn(f, v.Field(i), key)
+	}
+}
+
+// lookupValue returns the value of the environment variable `key`. Secrets can
+// also be read from the file in <key>_FILE, e.g. docker secrets.
+func lookupValue(f reflect.StructField, key string, lookup func(string) (string, bool)) (string, bool, error) {
+	v, ok := lookup(key)
+	if f.Tag.Get("secret") != "true" {
+		return v, ok, nil
+	}
+	path, fok := lookup(key + "_FILE")
+	if !fok {
+		return v, ok, nil
+	}
+	if ok {
+		return "", false, fmt.Errorf("%s: both %s and %s_FILE are set", key, key, key)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", key, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), true, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses `raw` into `v` according to its type
+func set(v reflect.Value, raw, unit string) error {
+	if v.Type() == durationType {
+		d, err := parseDuration(raw, unit)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid %d-bit integer %q", v.Type().Bits(), raw)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid %d-bit unsigned integer %q", v.Type().Bits(), raw)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// parseDuration parses `raw` as a time.Duration. Plain numbers are in `unit`.
+func parseDuration(raw, unit string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(raw, 10, 64); err == nil && unit != "" {
+		u, err := time.ParseDuration("1" + unit)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * u, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", raw)
+	}
+	return d, nil
+}
+
+// required reports the empty required fields of the struct `v`, whose
+// environment variables are prefixed by `prefix`
+func required(v reflect.Value, prefix string) Errors {
+	var errs Errors
+	walk(v, prefix, func(f reflect.StructField, v reflect.Value, key string) {
+		if f.Tag.Get("required") == "true" && v.IsZero() {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	})
+	return errs
+}
+
+func (c *Config) validate() Errors {
+	errs := required(reflect.ValueOf(c).Elem(), "")
+
+	switch c.Tracing.Exporter {
+	case "", "none", "otlp", "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER: unknown exporter %q, expected none, otlp or stdout", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("TRACING_SAMPLE_RATIO: must be in the range [0, 1], got %v", c.Tracing.SampleRatio))
+	}
+	errs = append(errs, c.Planner.validate()...)
+	errs = append(errs, c.Similarity.validate()...)
+	errs = append(errs, c.Communities.validate()...)
+	return errs
+}
+
+// validateStorage validates only the Clickhouse and Postgres sections
+func (c *Config) validateStorage() Errors {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	var errs Errors
+	for _, name := range []string{"Clickhouse", "Postgres"} {
+		f, _ := t.FieldByName(name)
+		errs = append(errs, required(v.FieldByName(name), f.Tag.Get("env"))...)
+	}
+	return errs
+}
+
+func (c *PlannerConfig) validate() Errors {
+	var errs Errors
+	if c.WebhookServerURL != "" {
+		u, err := url.Parse(c.WebhookServerURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("WEBHOOK_SERVER_URL: must be an https URL, got %q", c.WebhookServerURL))
+		}
+	}
+	if c.WebhookEndpoint != "" && !strings.HasPrefix(c.WebhookEndpoint, "/") {
+		errs = append(errs, fmt.Errorf("WEBHOOK_ENDPOINT: must start with /, got %q", c.WebhookEndpoint))
+	}
+	if n := len(c.WebhookSecret); n != 0 && (n < 10 || n > 100) {
+		errs = append(errs, fmt.Errorf("WEBHOOK_SECRET: must have between 10 and 100 characters, got %d", n))
+	}
+	for _, d := range []struct {
+		key string
+		d   time.Duration
+	}{
+		{"TRACK_INTERVAL_MINUTES", c.TrackInterval},
+		{"TRACK_ONLINE_TIMEOUT_HOURS", c.TrackOnlineTimeout},
+		{"WORKER_TIMEOUT_SECONDS", c.WorkerTimeout},
+	} {
+		if d.d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be positive", d.key))
+		}
+	}
+	if c.MinRunSpacing < 0 {
+		errs = append(errs, errors.New("MIN_RUN_SPACING_SECONDS: can't be negative"))
+	}
+	if c.BatchSize == 0 {
+		errs = append(errs, errors.New("BATCH_SIZE: must be positive"))
+	}
+	if c.MaxConcurrency <= 0 {
+		errs = append(errs, errors.New("WORKER_MAX_CONCURRENCY: must be positive"))
+	}
+	switch c.Scheduler {
+	case "hash", "load":
+	default:
+		errs = append(errs, fmt.Errorf("SCHEDULER: unknown scheduler %q, expected hash or load", c.Scheduler))
+	}
+	return errs
+}
+
+func (c *SimilarityConfig) validate() Errors {
+	var errs Errors
+	if c.RefreshInterval <= 0 {
+		errs = append(errs, errors.New("SIMILARITY_REFRESH_INTERVAL_MINUTES: must be positive"))
+	}
+	if c.Window <= 0 {
+		errs = append(errs, errors.New("SIMILARITY_WINDOW_HOURS: must be positive"))
+	}
+	if c.Limit <= 0 {
+		errs = append(errs, errors.New("SIMILARITY_LIMIT: must be positive"))
+	}
+	return errs
+}
+
+func (c *CommunitiesConfig) validate() Errors {
+	var errs Errors
+	if c.RefreshInterval <= 0 {
+		errs = append(errs, errors.New("COMMUNITIES_REFRESH_INTERVAL_MINUTES: must be positive"))
+	}
+	if c.Window <= 0 {
+		errs = append(errs, errors.New("COMMUNITIES_WINDOW_HOURS: must be positive"))
+	}
+	return errs
+}
+
+const redacted = "REDACTED"
+
+func redact(f reflect.StructField, raw string) string {
+	if f.Tag.Get("secret") == "true" && raw != "" {
+		return redacted
+	}
+	return raw
+}
+
+// Redacted returns a copy of the config with the secrets redacted, e.g. for
+// printing it.
+func (c *Config) Redacted() *Config {
+	cp := *c
+	walk(reflect.ValueOf(&cp).Elem(), "", func(f reflect.StructField, v reflect.Value, key string) {
+		if f.Tag.Get("secret") == "true" && !v.IsZero() {
+			v.SetString(redacted)
+		}
+	})
+	return &cp
+}
+
+// Env returns the config as environment variables, in the format of a .env
+// file.
+func (c *Config) Env() []string {
+	var vars []string
+	walk(reflect.ValueOf(c).Elem(), "", func(f reflect.StructField, v reflect.Value, key string) {
+		val := fmt.Sprint(v.Interface())
+		if v.Type() == durationType {
+			val = v.Interface().(time.Duration).String()
+		}
+		vars = append(vars, key+"="+val)
+	})
+	return vars
+}
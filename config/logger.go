@@ -5,9 +5,9 @@ import (
 	"github.com/rs/zerolog/pkgerrors"
 )
 
-func setupLogger() {
+func setupLogger(level int8) {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
 
-	zerolog.SetGlobalLevel(zerolog.Level(LogLevel))
+	zerolog.SetGlobalLevel(zerolog.Level(level))
 }
@@ -3,50 +3,60 @@ package clickhouse
 import (
 	"context"
 	"database/sql"
+	"embed"
+	"io/fs"
 	"time"
 
 	ch "github.com/ClickHouse/clickhouse-go/v2"
-	m "github.com/golang-migrate/migrate/v4"
 	mch "github.com/golang-migrate/migrate/v4/database/clickhouse"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/pmrt/viewergraph/database"
 )
 
+//go:embed migrations/*.sql
+var migrations embed.FS
+
 type Clickhouse struct {
 	db   *sql.DB
 	opts *database.StorageOptions
 }
 
+// Ping checks the connection, retrying every second until it succeeds or
+// `ctx` is done.
 func (s *Clickhouse) Ping(ctx context.Context) (err error) {
 	timer := time.NewTicker(time.Second)
+	defer timer.Stop()
 	for {
+		if err = s.db.PingContext(ctx); err == nil {
+			return nil
+		}
 		select {
 		case <-timer.C:
-			if err = s.db.Ping(); err == nil {
-				return
-			}
 		case <-ctx.Done():
-			return
+			return err
 		}
 	}
 }
 
 func (s *Clickhouse) Migrate() error {
+	mg, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	return mg.Migrate(uint(s.opts.MigrationVersion))
+}
+
+func (s *Clickhouse) Migrator() (*database.Migrator, error) {
 	d, err := mch.WithInstance(s.db, &mch.Config{
 		MultiStatementEnabled: true,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	mg, err := m.NewWithDatabaseInstance(
-		"file://"+s.opts.MigrationPath, "clickhouse", d,
-	)
+	fsys, err := fs.Sub(migrations, "migrations")
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return mg.Migrate(uint(s.opts.MigrationVersion))
+	return database.NewMigrator("clickhouse", d, fsys, s.opts)
 }
 
 func (s *Clickhouse) Conn() *sql.DB {
@@ -57,6 +67,18 @@ func (s *Clickhouse) Opts() *database.StorageOptions {
 	return s.opts
 }
 
+func (s *Clickhouse) Close(ctx context.Context) error {
+	return database.Close(ctx, s.db)
+}
+
+func (s *Clickhouse) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+func (s *Clickhouse) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return database.WithTx(ctx, s.db, fn)
+}
+
 func New(opts *database.StorageOptions) database.Storage {
 	db := ch.OpenDB(&ch.Options{
 		Addr: []string{opts.StorageHost + ":" + opts.StoragePort},
@@ -0,0 +1,2 @@
+DROP VIEW IF EXISTS aggregated_audience_mv;
+DROP TABLE IF EXISTS aggregated_audience;
@@ -0,0 +1,29 @@
+-- Unique viewers of each channel by hour, for the audience overlap between
+-- channels. The shared viewers of two channels are |A| + |B| - |A ∪ B|, where
+-- the union is the merge of both states.
+CREATE TABLE IF NOT EXISTS aggregated_audience (
+  ts Datetime,
+  channel LowCardinality(String),
+  viewers AggregateFunction(uniq, String)
+) ENGINE = AggregatingMergeTree()
+PARTITION BY toYYYYMM(ts)
+ORDER BY (channel, ts);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS aggregated_audience_mv
+TO aggregated_audience
+AS
+  SELECT
+    ts, channel,
+    uniqState(username) as viewers
+  FROM raw_events
+  WHERE event_type = 'view'
+  GROUP BY channel, ts;
+
+-- Backfill the raw events inserted before the rollup
+INSERT INTO aggregated_audience
+  SELECT
+    ts, channel,
+    uniqState(username) as viewers
+  FROM raw_events
+  WHERE event_type = 'view'
+  GROUP BY channel, ts;
@@ -0,0 +1,5 @@
+DROP VIEW IF EXISTS aggregated_flows_by_src_daily_mv;
+DROP TABLE IF EXISTS aggregated_flows_by_src_daily;
+
+DROP VIEW IF EXISTS aggregated_flows_by_dst_daily_mv;
+DROP TABLE IF EXISTS aggregated_flows_by_dst_daily;
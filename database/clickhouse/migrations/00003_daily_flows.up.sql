@@ -0,0 +1,64 @@
+-- Daily rollups of the hourly flows, for the flow queries over long ranges
+-- with day, week or month granularity. Days start at 00:00 UTC.
+CREATE TABLE IF NOT EXISTS aggregated_flows_by_dst_daily (
+  ts Datetime,
+  channel LowCardinality(String),
+  referrer LowCardinality(String),
+  total_users AggregateFunction(uniq, String)
+) ENGINE = AggregatingMergeTree()
+PARTITION BY toYYYYMM(ts)
+ORDER BY (channel, ts, referrer);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS aggregated_flows_by_dst_daily_mv
+TO aggregated_flows_by_dst_daily
+AS
+  SELECT
+    day as ts, channel, referrer,
+    uniqMergeState(total_users) as total_users
+  FROM (
+    SELECT toStartOfDay(ts, 'UTC') as day, channel, referrer, total_users
+    FROM aggregated_flows_by_dst
+  )
+  GROUP BY channel, day, referrer;
+
+CREATE TABLE IF NOT EXISTS aggregated_flows_by_src_daily (
+  ts Datetime,
+  channel LowCardinality(String),
+  referrer LowCardinality(String),
+  total_users AggregateFunction(uniq, String)
+) ENGINE = AggregatingMergeTree()
+PARTITION BY toYYYYMM(ts)
+ORDER BY (referrer, ts, channel);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS aggregated_flows_by_src_daily_mv
+TO aggregated_flows_by_src_daily
+AS
+  SELECT
+    day as ts, channel, referrer,
+    uniqMergeState(total_users) as total_users
+  FROM (
+    SELECT toStartOfDay(ts, 'UTC') as day, channel, referrer, total_users
+    FROM aggregated_flows_by_src
+  )
+  GROUP BY referrer, day, channel;
+
+-- Backfill the flows aggregated before the rollups
+INSERT INTO aggregated_flows_by_dst_daily
+  SELECT
+    day as ts, channel, referrer,
+    uniqMergeState(total_users) as total_users
+  FROM (
+    SELECT toStartOfDay(ts, 'UTC') as day, channel, referrer, total_users
+    FROM aggregated_flows_by_dst
+  )
+  GROUP BY channel, day, referrer;
+
+INSERT INTO aggregated_flows_by_src_daily
+  SELECT
+    day as ts, channel, referrer,
+    uniqMergeState(total_users) as total_users
+  FROM (
+    SELECT toStartOfDay(ts, 'UTC') as day, channel, referrer, total_users
+    FROM aggregated_flows_by_src
+  )
+  GROUP BY referrer, day, channel;
@@ -0,0 +1,6 @@
+DROP VIEW IF EXISTS stream_snapshots_mv;
+DROP TABLE IF EXISTS stream_snapshots;
+
+ALTER TABLE raw_events
+  DROP COLUMN IF EXISTS snapshot,
+  DROP COLUMN IF EXISTS stream;
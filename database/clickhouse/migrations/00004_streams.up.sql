@@ -0,0 +1,28 @@
+-- The stream session and the exact time of the snapshot (the run of the
+-- worker) of each raw event. `ts` is rounded to the hour, so the snapshots of
+-- the same hour can't be told apart without `snapshot`. Raw events inserted
+-- before this migration have no stream.
+ALTER TABLE raw_events
+  ADD COLUMN IF NOT EXISTS stream String DEFAULT '',
+  ADD COLUMN IF NOT EXISTS snapshot Datetime DEFAULT ts;
+
+-- Unique chatters of each snapshot of each stream session. The chatters of a
+-- stream are the merge of the states of all its snapshots.
+CREATE TABLE IF NOT EXISTS stream_snapshots (
+  channel LowCardinality(String),
+  stream String,
+  snapshot Datetime,
+  chatters AggregateFunction(uniq, String)
+) ENGINE = AggregatingMergeTree()
+PARTITION BY toYYYYMM(snapshot)
+ORDER BY (channel, stream, snapshot);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS stream_snapshots_mv
+TO stream_snapshots
+AS
+  SELECT
+    channel, stream, snapshot,
+    uniqState(username) as chatters
+  FROM raw_events
+  WHERE event_type = 'view' AND stream != ''
+  GROUP BY channel, stream, snapshot;
@@ -0,0 +1,5 @@
+DROP VIEW IF EXISTS weekly_viewers_mv;
+DROP TABLE IF EXISTS weekly_viewers;
+
+DROP VIEW IF EXISTS first_seen_mv;
+DROP TABLE IF EXISTS first_seen;
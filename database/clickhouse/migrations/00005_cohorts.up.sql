@@ -0,0 +1,53 @@
+-- When each user was first seen in each channel, for the new viewers of the
+-- cohorts.
+CREATE TABLE IF NOT EXISTS first_seen (
+  channel LowCardinality(String),
+  username String,
+  ts SimpleAggregateFunction(min, Datetime)
+) ENGINE = AggregatingMergeTree()
+ORDER BY (channel, username);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS first_seen_mv
+TO first_seen
+AS
+  SELECT
+    channel, username,
+    min(ts) as ts
+  FROM raw_events
+  WHERE event_type = 'view'
+  GROUP BY channel, username;
+
+-- The viewers of each channel by week, starting on monday. A viewer active in
+-- the previous week and not in the current one has lapsed.
+CREATE TABLE IF NOT EXISTS weekly_viewers (
+  week Date,
+  channel LowCardinality(String),
+  username String
+) ENGINE = ReplacingMergeTree()
+PARTITION BY toYYYYMM(week)
+ORDER BY (channel, week, username);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS weekly_viewers_mv
+TO weekly_viewers
+AS
+  SELECT DISTINCT
+    toMonday(ts, 'UTC') as week,
+    channel, username
+  FROM raw_events
+  WHERE event_type = 'view';
+
+-- Backfill the raw events inserted before the rollups
+INSERT INTO first_seen
+  SELECT
+    channel, username,
+    min(ts) as ts
+  FROM raw_events
+  WHERE event_type = 'view'
+  GROUP BY channel, username;
+
+INSERT INTO weekly_viewers
+  SELECT DISTINCT
+    toMonday(ts, 'UTC') as week,
+    channel, username
+  FROM raw_events
+  WHERE event_type = 'view';
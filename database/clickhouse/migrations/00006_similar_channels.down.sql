@@ -0,0 +1 @@
+DROP TABLE IF EXISTS similar_channels;
@@ -0,0 +1,17 @@
+-- The channels most similar to each tracked channel, by the cosine similarity
+-- of their audiences. Each refresh inserts a new ranking with its own
+-- computed_at, only the latest one of each channel is served.
+CREATE TABLE IF NOT EXISTS similar_channels (
+  computed_at Datetime,
+  channel LowCardinality(String),
+  similar LowCardinality(String),
+  score Float64,
+  shared UInt64,
+  viewers UInt64,
+  similar_viewers UInt64,
+  flow_in UInt64,
+  flow_out UInt64
+) ENGINE = MergeTree()
+PARTITION BY toYYYYMM(computed_at)
+ORDER BY (channel, computed_at, similar)
+TTL computed_at + INTERVAL 30 DAY;
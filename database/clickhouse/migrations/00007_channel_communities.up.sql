@@ -0,0 +1,14 @@
+-- The community and weighted PageRank of each channel of the viewer-flow
+-- graph of a time window. Each computation inserts the whole graph with its
+-- own computed_at.
+CREATE TABLE IF NOT EXISTS channel_communities (
+  window_start Datetime,
+  window_end Datetime,
+  computed_at Datetime,
+  channel LowCardinality(String),
+  community UInt32,
+  pagerank Float64
+) ENGINE = MergeTree()
+PARTITION BY toYYYYMM(computed_at)
+ORDER BY (window_start, window_end, computed_at, channel)
+TTL computed_at + INTERVAL 90 DAY;
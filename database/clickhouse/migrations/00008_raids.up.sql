@@ -0,0 +1,16 @@
+-- The raids of the tracked channels, from their channel.raid notifications.
+-- Twitch may deliver the same notification more than once, with the same
+-- message id but a new timestamp, so duplicates are removed by message id on
+-- merges and with FINAL. The version is higher for earlier timestamps, so the
+-- first delivery is kept and retries don't move the raid. The table is small
+-- and not partitioned, so duplicates are removed even if their timestamps
+-- fall in different months.
+CREATE TABLE IF NOT EXISTS raids (
+  message_id String,
+  ts Datetime,
+  from_channel LowCardinality(String),
+  to_channel LowCardinality(String),
+  viewers UInt32,
+  ver UInt32 DEFAULT 4294967295 - toUInt32(ts)
+) ENGINE = ReplacingMergeTree(ver)
+ORDER BY (from_channel, message_id);
@@ -4,18 +4,30 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"os"
 	"time"
 
+	"github.com/golang-migrate/migrate/v4"
 	"github.com/pmrt/viewergraph/config"
 	l "github.com/rs/zerolog/log"
 )
 
 type Storage interface {
 	Ping(ctx context.Context) error
+	// Migrate migrates the database to MigrationVersion.
 	Migrate() error
+	// Migrator returns the migrator of the database, for manual migrations.
+	Migrator() (*Migrator, error)
 	Conn() *sql.DB
 	Opts() *StorageOptions
+	// Close closes the connection pool, waiting for the running queries to
+	// finish until `ctx` is done.
+	Close(ctx context.Context) error
+	// Stats returns the statistics of the connection pool.
+	Stats() sql.DBStats
+	// WithTx runs `fn` in a transaction. See WithTx.
+	WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error
 }
 
 type StorageOptions struct {
@@ -31,11 +43,76 @@ type StorageOptions struct {
 	StorageConnTimeout     time.Duration
 
 	MigrationVersion int
-	MigrationPath    string
-	DebugMode        bool
+	// MigrationPath is the directory with the migrations. If empty, the
+	// migrations embedded in the binary are used.
+	MigrationPath  string
+	SkipMigrations bool
+	DebugMode      bool
 }
 
-func New(sto Storage) Storage {
+// OptionsFromConfig returns the options of the storage configured by `c`.
+func OptionsFromConfig(c *config.StorageConfig) *StorageOptions {
+	return &StorageOptions{
+		StorageHost:     c.Host,
+		StoragePort:     c.Port,
+		StorageUser:     c.User,
+		StoragePassword: c.Password,
+		StorageDbName:   c.DBName,
+
+		StorageMaxIdleConns:    c.MaxIdleConns,
+		StorageMaxOpenConns:    c.MaxOpenConns,
+		StorageConnMaxLifetime: c.ConnMaxLifetime,
+		StorageConnTimeout:     c.ConnTimeout,
+
+		MigrationVersion: c.MigVersion,
+		MigrationPath:    c.MigPath,
+	}
+}
+
+// WithTx runs `fn` in a transaction of `db`, committing it if `fn` returns
+// nil and rolling it back otherwise, including when `fn` panics.
+func WithTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close closes `db`, returning ctx.Err() if `ctx` is done before the running
+// queries finish. The pool is closed anyway once they finish.
+func Close(ctx context.Context, db *sql.DB) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Close()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var ErrUnreachable = errors.New("database is unreachable")
+
+// New pings the storage, retrying until StorageConnTimeout, and applies the
+// migrations. Errors are returned instead of panicking so the caller can
+// start degraded, see Retry.
+func New(sto Storage) (Storage, error) {
 	l := l.With().
 		Str("context", "database").
 		Logger()
@@ -53,13 +130,13 @@ func New(sto Storage) Storage {
 	)
 	defer cancel()
 	if err := sto.Ping(ctx); err != nil {
-		l.Panic().Err(err).Msg("")
+		return sto, fmt.Errorf("%w: %v", ErrUnreachable, err)
 	}
 	l.Info().Msg("=> => connection successful")
 
-	if config.SkipMigrations {
+	if opts.SkipMigrations {
 		l.Info().Msg("=> => skipping migrations")
-		return sto
+		return sto, nil
 	}
 
 	l.Info().
@@ -67,13 +144,41 @@ func New(sto Storage) Storage {
 		Str("mig_path", opts.MigrationPath).
 		Msg("=> => attempting to apply migrations")
 	if err := sto.Migrate(); err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			l.Panic().Err(err).Msg("")
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, migrate.ErrNoChange) {
+			return sto, err
 		}
 		l.Info().Msg("=> => => no changes were made")
 	} else {
 		l.Info().Msg("=> => => migration success")
 	}
 
-	return sto
+	return sto, nil
+}
+
+// Retry calls New until it succeeds or `ctx` is done, waiting `every`
+// between attempts. Only ErrUnreachable is retried, any other error (e.g. a
+// failed migration) is returned as is.
+func Retry(ctx context.Context, sto Storage, every time.Duration) error {
+	l := l.With().
+		Str("context", "database").
+		Str("db", sto.Opts().StorageDbName).
+		Logger()
+
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		_, err := New(sto)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrUnreachable) {
+			return err
+		}
+		l.Warn().Err(err).Msgf("=> => retrying in %s", every)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+type fakeStorage struct {
+	pingErrs   []error
+	migrateErr error
+	migrated   int
+}
+
+func (s *fakeStorage) Ping(ctx context.Context) error {
+	if len(s.pingErrs) == 0 {
+		return nil
+	}
+	err := s.pingErrs[0]
+	s.pingErrs = s.pingErrs[1:]
+	return err
+}
+
+func (s *fakeStorage) Migrate() error {
+	s.migrated++
+	return s.migrateErr
+}
+
+func (s *fakeStorage) Migrator() (*Migrator, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeStorage) Conn() *sql.DB {
+	return nil
+}
+
+func (s *fakeStorage) Opts() *StorageOptions {
+	return &StorageOptions{StorageConnTimeout: time.Second}
+}
+
+func (s *fakeStorage) Close(ctx context.Context) error {
+	return nil
+}
+
+func (s *fakeStorage) Stats() sql.DBStats {
+	return sql.DBStats{}
+}
+
+func (s *fakeStorage) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return nil
+}
+
+func TestNewReturnsErrors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New(&fakeStorage{pingErrs: []error{sql.ErrConnDone}}); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("expected ErrUnreachable, got %v", err)
+	}
+
+	migErr := errors.New("dirty database")
+	if _, err := New(&fakeStorage{migrateErr: migErr}); !errors.Is(err, migErr) {
+		t.Fatalf("expected migration error, got %v", err)
+	}
+
+	// no changes is not an error
+	if _, err := New(&fakeStorage{migrateErr: os.ErrNotExist}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	sto := &fakeStorage{pingErrs: []error{sql.ErrConnDone, sql.ErrConnDone}}
+	if err := Retry(context.Background(), sto, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if sto.migrated != 1 {
+		t.Fatalf("expected migrations to be applied once the database is reachable, got %d", sto.migrated)
+	}
+
+	migErr := errors.New("dirty database")
+	sto = &fakeStorage{migrateErr: migErr}
+	if err := Retry(context.Background(), sto, time.Millisecond); !errors.Is(err, migErr) {
+		t.Fatalf("expected migration error, got %v", err)
+	}
+	if sto.migrated != 1 {
+		t.Fatalf("expected migration errors not to be retried, got %d attempts", sto.migrated)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sto = &fakeStorage{pingErrs: []error{sql.ErrConnDone}}
+	if err := Retry(ctx, sto, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+// txDriver is a database/sql driver which only records the transactions
+type txDriver struct {
+	log []string
+}
+
+func (d *txDriver) Open(name string) (driver.Conn, error) { return d, nil }
+func (d *txDriver) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+func (d *txDriver) Close() error              { return nil }
+func (d *txDriver) Begin() (driver.Tx, error) { d.log = append(d.log, "begin"); return d, nil }
+func (d *txDriver) Commit() error             { d.log = append(d.log, "commit"); return nil }
+func (d *txDriver) Rollback() error           { d.log = append(d.log, "rollback"); return nil }
+
+func TestWithTx(t *testing.T) {
+	t.Parallel()
+
+	d := &txDriver{}
+	sql.Register("txdriver", d)
+	db, err := sql.Open("txdriver", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WithTx(context.Background(), db, func(tx *sql.Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	fnErr := errors.New("boom")
+	if err := WithTx(context.Background(), db, func(tx *sql.Tx) error { return fnErr }); !errors.Is(err, fnErr) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected the panic to be propagated")
+			}
+		}()
+		WithTx(context.Background(), db, func(tx *sql.Tx) error { panic("boom") })
+	}()
+
+	want := []string{"begin", "commit", "begin", "rollback", "begin", "rollback"}
+	if diff := deep.Equal(d.log, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	if err := Close(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err == nil {
+		t.Fatal("expected the pool to be closed")
+	}
+}
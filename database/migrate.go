@@ -0,0 +1,132 @@
+package database
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/golang-migrate/migrate/v4"
+	mdb "github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// Migrator applies the migrations of a storage.
+type Migrator struct {
+	m    *migrate.Migrate
+	fsys fs.FS
+}
+
+type MigrationStatus struct {
+	// Version is the current version of the database, 0 if no migration was
+	// applied.
+	Version uint `json:"version"`
+	// Dirty means the last migration failed and the database must be fixed
+	// manually and forced to a version.
+	Dirty bool `json:"dirty"`
+	// Latest is the version of the last available migration.
+	Latest uint `json:"latest"`
+	// Pending are the versions of the available migrations not applied yet.
+	Pending []uint `json:"pending"`
+}
+
+// NewMigrator returns the migrator of the database driver `d` named `name`.
+// Migrations are read from MigrationPath in `opts` if set, otherwise from the
+// `embedded` file system, so binaries don't depend on the working directory.
+func NewMigrator(name string, d mdb.Driver, embedded fs.FS, opts *StorageOptions) (*Migrator, error) {
+	fsys := embedded
+	if opts.MigrationPath != "" {
+		fsys = os.DirFS(opts.MigrationPath)
+	}
+	src, err := iofs.New(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	mg, err := migrate.NewWithInstance("iofs", src, name, d)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{m: mg, fsys: fsys}, nil
+}
+
+// Up applies all the pending migrations.
+func (m *Migrator) Up() error {
+	return m.m.Up()
+}
+
+// Steps applies `n` migrations up, or down if `n` is negative.
+func (m *Migrator) Steps(n int) error {
+	return m.m.Steps(n)
+}
+
+// Migrate migrates up or down to the `version`.
+func (m *Migrator) Migrate(version uint) error {
+	return m.m.Migrate(version)
+}
+
+// Force sets the `version` of the database without running any migration and
+// clears the dirty flag. Used to recover from failed migrations.
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+// Close closes the migrator and the connection to the database.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	if srcErr != nil {
+		return srcErr
+	}
+	return dbErr
+}
+
+// Versions returns the versions of the available migrations, in order.
+func (m *Migrator) Versions() ([]uint, error) {
+	src, err := iofs.New(m.fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	v, err := src.First()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	versions := []uint{v}
+	for {
+		v, err = src.Next(v)
+		if errors.Is(err, fs.ErrNotExist) {
+			return versions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+}
+
+// Status reports the current version of the database and the pending
+// migrations.
+func (m *Migrator) Status() (*MigrationStatus, error) {
+	st := &MigrationStatus{}
+	v, dirty, err := m.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, err
+	}
+	st.Version, st.Dirty = v, dirty
+
+	versions, err := m.Versions()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if v > st.Version {
+			st.Pending = append(st.Pending, v)
+		}
+	}
+	if len(versions) > 0 {
+		st.Latest = versions[len(versions)-1]
+	}
+	return st, nil
+}
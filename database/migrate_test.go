@@ -0,0 +1,59 @@
+package database
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-test/deep"
+	"github.com/golang-migrate/migrate/v4/database/stub"
+)
+
+func TestMigrator(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"00001_scaffolding.up.sql":   {Data: []byte("CREATE TABLE a;")},
+		"00001_scaffolding.down.sql": {Data: []byte("DROP TABLE a;")},
+		"00002_column.up.sql":        {Data: []byte("ALTER TABLE a;")},
+		"00002_column.down.sql":      {Data: []byte("ALTER TABLE a;")},
+		"00003_settings.up.sql":      {Data: []byte("ALTER TABLE b;")},
+		"00003_settings.down.sql":    {Data: []byte("ALTER TABLE b;")},
+	}
+	d, err := stub.WithInstance(nil, &stub.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mg, err := NewMigrator("stub", d, fsys, &StorageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := func(want *MigrationStatus) {
+		t.Helper()
+		got, err := mg.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(got, want); diff != nil {
+			t.Fatal(diff)
+		}
+	}
+
+	status(&MigrationStatus{Latest: 3, Pending: []uint{1, 2, 3}})
+	if err := mg.Up(); err != nil {
+		t.Fatal(err)
+	}
+	status(&MigrationStatus{Version: 3, Latest: 3})
+	if err := mg.Steps(-2); err != nil {
+		t.Fatal(err)
+	}
+	status(&MigrationStatus{Version: 1, Latest: 3, Pending: []uint{2, 3}})
+	if err := mg.Migrate(2); err != nil {
+		t.Fatal(err)
+	}
+	status(&MigrationStatus{Version: 2, Latest: 3, Pending: []uint{3}})
+	if err := mg.Force(3); err != nil {
+		t.Fatal(err)
+	}
+	status(&MigrationStatus{Version: 3, Latest: 3})
+}
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	l "github.com/rs/zerolog/log"
+)
+
+// PeriodicJob calls its refresh function every Interval. It is meant for
+// singleton jobs: only one instance should run it at a time, wrap its Run
+// with Leader.Run.
+type PeriodicJob struct {
+	// Interval between refreshes
+	Interval time.Duration
+	name     string
+	refresh  func() error
+	l        zerolog.Logger
+}
+
+// Run refreshes right away and then every Interval, until `ctx` is done. A
+// failed refresh is logged and retried on the next tick.
+func (j *PeriodicJob) Run(ctx context.Context) {
+	t := time.NewTicker(j.Interval)
+	defer t.Stop()
+
+	for {
+		if err := j.refresh(); err != nil {
+			j.l.Error().Err(err).Msgf("error while refreshing %s", j.name)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// NewPeriodicJob returns a PeriodicJob calling `refresh` every `interval`.
+// `name` is what is refreshed, used in the logs.
+func NewPeriodicJob(name string, interval time.Duration, refresh func() error) *PeriodicJob {
+	return &PeriodicJob{
+		Interval: interval,
+		name:     name,
+		refresh:  refresh,
+		l: l.With().
+			Str("context", "job").
+			Str("job", name).
+			Logger(),
+	}
+}
@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPeriodicJobRetriesFailedRefreshes(t *testing.T) {
+	t.Parallel()
+
+	refreshed := make(chan struct{}, 3)
+	j := NewPeriodicJob("test", time.Millisecond, func() error {
+		refreshed <- struct{}{}
+		return errors.New("unavailable")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		j.Run(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-refreshed:
+		case <-time.After(time.Second):
+			t.Fatalf("expected refresh %d after a failed one", i+1)
+		}
+	}
+	cancel()
+	for {
+		select {
+		case <-refreshed:
+			continue
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected Run to return once the context is done")
+		}
+		break
+	}
+}
@@ -0,0 +1,265 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pmrt/viewergraph/database"
+	"github.com/rs/zerolog"
+	l "github.com/rs/zerolog/log"
+)
+
+var ErrLeaseLost = errors.New("leader lease lost")
+
+// Advisory locks are bound to the session which acquired them, so every query
+// concerning the lock must be made through the same dedicated connection.
+const (
+	queryTryLock = "SELECT pg_try_advisory_lock($1)"
+	queryUnlock  = "SELECT pg_advisory_unlock($1)"
+	// A bigint advisory lock key is stored in pg_locks split in two halves:
+	// classid has the high 32 bits and objid the low 32 bits. objsubid is 1 for
+	// single bigint keys.
+	queryHoldsLock = `
+    SELECT EXISTS (
+      SELECT 1 FROM pg_locks
+      WHERE
+        locktype = 'advisory' AND
+        granted AND
+        pid = pg_backend_pid() AND
+        objsubid = 1 AND
+        ((classid::bigint << 32) | objid::bigint) = $1
+    )
+  `
+)
+
+type LeaderOpts struct {
+	// Key of the advisory lock. All the instances running the same singleton
+	// job must use the same key. See LockKey().
+	Key int64
+	// RetryInterval is how often a follower tries to acquire the lock.
+	RetryInterval time.Duration
+	// KeepaliveInterval is how often the leader checks that its session is
+	// alive and still holds the lock. If the check fails the lease is
+	// considered lost.
+	KeepaliveInterval time.Duration
+}
+
+// Leader implements leader election on top of Postgres session-level advisory
+// locks (pg_try_advisory_lock).
+//
+// Only one session can hold the lock for a given key at the same time, so
+// jobs like event reconciliation or subscription sync can be wrapped with
+// Run() to ensure they are executed by exactly one instance. If the session
+// dies (e.g.: the connection is dropped or the backend is terminated)
+// Postgres releases the lock, allowing another instance to take over.
+//
+// Leader is safe for concurrent use, but Run() must not be called
+// concurrently on the same Leader.
+type Leader struct {
+	mu   sync.Mutex
+	db   *sql.DB
+	conn *sql.Conn
+	opts *LeaderOpts
+	l    zerolog.Logger
+}
+
+// IsLeader reports whether this instance currently holds the lock. It only
+// reflects the last known state, use Check() to ask the database.
+func (le *Leader) IsLeader() bool {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	return le.conn != nil
+}
+
+// TryAcquire tries to acquire the lock once, without blocking. It returns true
+// if the lock is held by this instance after the call.
+func (le *Leader) TryAcquire(ctx context.Context) (bool, error) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	if le.conn != nil {
+		return true, nil
+	}
+
+	conn, err := le.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var ok bool
+	if err := conn.QueryRowContext(ctx, queryTryLock, le.opts.Key).Scan(&ok); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !ok {
+		// Return the connection to the pool, we will retry later
+		conn.Close()
+		return false, nil
+	}
+
+	le.conn = conn
+	return true, nil
+}
+
+// Check asks the database whether the session of this instance is alive and
+// still holds the lock. It returns ErrLeaseLost otherwise, releasing the
+// dedicated connection.
+func (le *Leader) Check(ctx context.Context) error {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	if le.conn == nil {
+		return ErrLeaseLost
+	}
+
+	var ok bool
+	if err := le.conn.QueryRowContext(ctx, queryHoldsLock, le.opts.Key).Scan(&ok); err != nil || !ok {
+		le.drop()
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Release releases the lock if held. It is safe to call Release() when the
+// lock is not held.
+func (le *Leader) Release(ctx context.Context) error {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	if le.conn == nil {
+		return nil
+	}
+
+	var ok bool
+	if err := le.conn.QueryRowContext(ctx, queryUnlock, le.opts.Key).Scan(&ok); err != nil {
+		// We don't know if the lock was released, discard the session so it is
+		// released for sure
+		le.drop()
+		return err
+	}
+	le.conn.Close()
+	le.conn = nil
+	return nil
+}
+
+// drop discards the dedicated connection and its session. Must be called with
+// le.mu held.
+func (le *Leader) drop() {
+	// Closing a sql.Conn only returns it to the pool, so the underlying
+	// session could keep the lock. Raw() with driver.ErrBadConn forces the
+	// pool to discard it, which closes the session and releases any advisory
+	// lock still held by it.
+	_ = le.conn.Raw(func(any) error { return driver.ErrBadConn })
+	le.conn.Close()
+	le.conn = nil
+}
+
+// Run campaigns for leadership until `ctx` is done. Every time this instance
+// becomes the leader, `fn` is called with a context which is cancelled as
+// soon as the lease is lost or `ctx` is done. After `fn` returns the lock is
+// released and the instance goes back to campaigning.
+//
+// `fn` must return promptly once its context is cancelled, otherwise two
+// instances could be running the job at the same time.
+func (le *Leader) Run(ctx context.Context, fn func(ctx context.Context)) error {
+	retry := time.NewTicker(le.opts.RetryInterval)
+	defer retry.Stop()
+
+	for {
+		ok, err := le.TryAcquire(ctx)
+		if err != nil {
+			le.l.Error().Err(err).Msg("error while trying to acquire lock")
+		}
+		if ok {
+			le.l.Info().Msg("-> acquired leadership")
+			le.lead(ctx, fn)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-retry.C:
+		}
+	}
+}
+
+// lead runs `fn` while the lease is held, checking it every
+// KeepaliveInterval.
+func (le *Leader) lead(ctx context.Context, fn func(ctx context.Context)) {
+	leaseCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(leaseCtx)
+	}()
+
+	keepalive := time.NewTicker(le.opts.KeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-done:
+			le.l.Info().Msg("-> job finished, releasing leadership")
+			le.release()
+			return
+		case <-ctx.Done():
+			cancel()
+			<-done
+			le.l.Info().Msg("-> context done, releasing leadership")
+			le.release()
+			return
+		case <-keepalive.C:
+			if err := le.Check(leaseCtx); err != nil {
+				le.l.Warn().Err(err).Msg("-> lease lost, stopping job")
+				cancel()
+				<-done
+				return
+			}
+		}
+	}
+}
+
+func (le *Leader) release() {
+	// Use a fresh context, the parent one may be done already
+	ctx, cancel := context.WithTimeout(context.Background(), le.opts.KeepaliveInterval)
+	defer cancel()
+	if err := le.Release(ctx); err != nil {
+		le.l.Error().Err(err).Msg("error while releasing lock")
+	}
+}
+
+// LockKey derives an advisory lock key from a job name, so jobs can be
+// identified by a human readable name instead of a magic number.
+func LockKey(name string) int64 {
+	// FNV-1a 64
+	var hash uint64 = 14695981039346656037
+	const prime64 uint64 = 1099511628211
+	for i := 0; i < len(name); i++ {
+		hash ^= uint64(name[i])
+		hash *= prime64
+	}
+	return int64(hash)
+}
+
+func NewLeader(sto database.Storage, opts *LeaderOpts) *Leader {
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = 10 * time.Second
+	}
+	if opts.KeepaliveInterval == 0 {
+		opts.KeepaliveInterval = 5 * time.Second
+	}
+
+	return &Leader{
+		db:   sto.Conn(),
+		opts: opts,
+		l: l.With().
+			Str("context", "leader").
+			Int64("key", opts.Key).
+			Logger(),
+	}
+}
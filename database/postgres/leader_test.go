@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func terminateSession(t *testing.T, le *Leader) {
+	t.Helper()
+
+	var pid int
+	le.mu.Lock()
+	err := le.conn.QueryRowContext(context.Background(), "SELECT pg_backend_pid()").Scan(&pid)
+	le.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sto.Conn().Exec("SELECT pg_terminate_backend($1)", pid); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeaderMutualExclusion(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	key := LockKey("test_mutual_exclusion")
+	a := NewLeader(sto, &LeaderOpts{Key: key})
+	b := NewLeader(sto, &LeaderOpts{Key: key})
+
+	if ok, err := a.TryAcquire(ctx); err != nil || !ok {
+		t.Fatalf("expected a to acquire the lock, got ok=%t err=%v", ok, err)
+	}
+	if ok, err := b.TryAcquire(ctx); err != nil || ok {
+		t.Fatalf("expected b to not acquire the lock, got ok=%t err=%v", ok, err)
+	}
+	if err := a.Check(ctx); err != nil {
+		t.Fatalf("expected a to hold the lock, got %v", err)
+	}
+
+	if err := a.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if a.IsLeader() {
+		t.Fatal("expected a to not be the leader after release")
+	}
+
+	if ok, err := b.TryAcquire(ctx); err != nil || !ok {
+		t.Fatalf("expected b to acquire the lock after release, got ok=%t err=%v", ok, err)
+	}
+	if err := b.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeaderCheckDetectsLeaseLoss(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	key := LockKey("test_lease_loss")
+	a := NewLeader(sto, &LeaderOpts{Key: key})
+	b := NewLeader(sto, &LeaderOpts{Key: key})
+
+	if ok, err := a.TryAcquire(ctx); err != nil || !ok {
+		t.Fatalf("expected a to acquire the lock, got ok=%t err=%v", ok, err)
+	}
+	terminateSession(t, a)
+
+	if err := a.Check(ctx); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost, got %v", err)
+	}
+	if a.IsLeader() {
+		t.Fatal("expected a to not be the leader after losing the lease")
+	}
+
+	if ok, err := b.TryAcquire(ctx); err != nil || !ok {
+		t.Fatalf("expected b to take over the lock, got ok=%t err=%v", ok, err)
+	}
+	if err := b.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeaderRunStopsJobOnLeaseLoss(t *testing.T) {
+	requireDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := func() *LeaderOpts {
+		return &LeaderOpts{
+			Key:               LockKey("test_run"),
+			RetryInterval:     50 * time.Millisecond,
+			KeepaliveInterval: 50 * time.Millisecond,
+		}
+	}
+	a, b := NewLeader(sto, opts()), NewLeader(sto, opts())
+
+	// a stops campaigning once its job is cancelled, so b is the only one
+	// able to take over
+	ctxA, cancelA := context.WithCancel(ctx)
+	startedA, stoppedA := make(chan struct{}), make(chan struct{})
+	go a.Run(ctxA, func(ctx context.Context) {
+		close(startedA)
+		<-ctx.Done()
+		cancelA()
+		close(stoppedA)
+	})
+
+	select {
+	case <-startedA:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a to become the leader")
+	}
+
+	startedB := make(chan struct{}, 1)
+	go b.Run(ctx, func(ctx context.Context) {
+		startedB <- struct{}{}
+		<-ctx.Done()
+	})
+
+	select {
+	case <-startedB:
+		t.Fatal("b must not run the job while a is the leader")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	terminateSession(t, a)
+
+	select {
+	case <-stoppedA:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the job of a to be cancelled after losing the lease")
+	}
+	select {
+	case <-startedB:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected b to take over after a lost the lease")
+	}
+}
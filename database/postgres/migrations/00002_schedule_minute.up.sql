@@ -0,0 +1,10 @@
+BEGIN;
+
+-- Minute of the hour assigned to the channel by the load-aware scheduler.
+-- Persisted so assignments are stable across restarts. NULL means the channel
+-- has no assignment yet and the minute is derived by hashing its id.
+ALTER TABLE tracked_channels
+  ADD COLUMN IF NOT EXISTS schedule_minute smallint
+  CHECK (schedule_minute >= 0 AND schedule_minute < 60);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE tracked_channels DROP COLUMN IF EXISTS priority;
+ALTER TABLE tracked_channels DROP COLUMN IF EXISTS track_interval_minutes;
+
+COMMIT;
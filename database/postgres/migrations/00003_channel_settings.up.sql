@@ -0,0 +1,13 @@
+BEGIN;
+
+-- Per-channel scheduling settings. A NULL interval means the channel uses the
+-- default interval of the planner (TRACK_INTERVAL_MINUTES). Channels with a
+-- higher priority are run first by the worker pool under load.
+ALTER TABLE tracked_channels
+  ADD COLUMN IF NOT EXISTS track_interval_minutes integer
+  CHECK (track_interval_minutes > 0);
+
+ALTER TABLE tracked_channels
+  ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 0;
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE tracked_channels DROP COLUMN IF EXISTS schedule_load;
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+-- Last observed load (average chatters per batch) of the channel. Persisted so
+-- the load-aware scheduler can place channels before the planner has history
+-- for them after a restart. NULL means the load is unknown.
+ALTER TABLE tracked_channels
+  ADD COLUMN IF NOT EXISTS schedule_load bigint
+  CHECK (schedule_load >= 0);
+
+COMMIT;
@@ -3,51 +3,84 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"embed"
 	"fmt"
+	"io/fs"
 	"time"
 
-	m "github.com/golang-migrate/migrate/v4"
+	mdb "github.com/golang-migrate/migrate/v4/database"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/lib/pq"
 	"github.com/pmrt/viewergraph/database"
 )
 
+//go:embed migrations/*.sql
+var migrations embed.FS
+
 type Postgres struct {
 	db   *sql.DB
 	opts *database.StorageOptions
 }
 
+// Ping checks the connection, retrying every second until it succeeds or
+// `ctx` is done.
 func (s *Postgres) Ping(ctx context.Context) (err error) {
 	timer := time.NewTicker(time.Second)
+	defer timer.Stop()
 	for {
+		if err = s.db.PingContext(ctx); err == nil {
+			return nil
+		}
 		select {
 		case <-timer.C:
-			if err = s.db.Ping(); err == nil {
-				return
-			}
 		case <-ctx.Done():
-			return
+			return err
 		}
 	}
 }
 
+// Migrate applies the migrations up to MigrationVersion.
+//
+// The driver holds a dedicated connection while it's alive and its Close
+// would also close the shared pool, so the migrations run on a connection
+// which is released here once they are done.
 func (s *Postgres) Migrate() error {
-	d, err := postgres.WithInstance(s.db, &postgres.Config{})
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 
-	mg, err := m.NewWithDatabaseInstance(
-		"file://"+s.opts.MigrationPath, "postgres", d,
-	)
+	d, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		return err
+	}
+	mg, err := s.migrator(d)
 	if err != nil {
 		return err
 	}
-
 	return mg.Migrate(uint(s.opts.MigrationVersion))
 }
 
+// Migrator returns a migrator which owns the connection pool, closing the
+// migrator closes the storage too.
+func (s *Postgres) Migrator() (*database.Migrator, error) {
+	d, err := postgres.WithInstance(s.db, &postgres.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return s.migrator(d)
+}
+
+func (s *Postgres) migrator(d mdb.Driver) (*database.Migrator, error) {
+	fsys, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return database.NewMigrator("postgres", d, fsys, s.opts)
+}
+
 func (s *Postgres) Conn() *sql.DB {
 	return s.db
 }
@@ -56,6 +89,18 @@ func (s *Postgres) Opts() *database.StorageOptions {
 	return s.opts
 }
 
+func (s *Postgres) Close(ctx context.Context) error {
+	return database.Close(ctx, s.db)
+}
+
+func (s *Postgres) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+func (s *Postgres) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return database.WithTx(ctx, s.db, fn)
+}
+
 func New(opts *database.StorageOptions) database.Storage {
 	db, err := sql.Open("postgres", fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
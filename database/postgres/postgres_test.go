@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/pmrt/viewergraph/database"
+)
+
+// sto is nil when docker is unavailable, see requireDB
+var sto database.Storage
+
+// requireDB skips the test when there is no database to run it against
+func requireDB(t *testing.T) {
+	t.Helper()
+	if sto == nil {
+		t.Skip("docker is unavailable, skipping database test")
+	}
+}
+
+func TestMain(m *testing.M) {
+	// Run a docker with a database for testing. Without docker the tests
+	// which need it are skipped.
+	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
+	if err != nil {
+		log.Printf("docker is unavailable: %v", err)
+		os.Exit(m.Run())
+	}
+	res, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "postgres",
+		Tag:        "14.3-alpine3.16",
+		Env: []string{
+			"POSTGRES_PASSWORD=test",
+			"POSTGRES_USER=user",
+			"POSTGRES_DB=name",
+			"listen_addresses = '*'",
+		},
+	}, func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		panic(err)
+	}
+	res.Expire(120)
+
+	// Prepare a connection to the db in the docker
+	sto, err = database.New(
+		New(&database.StorageOptions{
+			StorageHost:            res.GetBoundIP("5432/tcp"),
+			StoragePort:            res.GetPort("5432/tcp"),
+			StorageUser:            "user",
+			StoragePassword:        "test",
+			StorageDbName:          "name",
+			StorageMaxIdleConns:    5,
+			StorageMaxOpenConns:    10,
+			StorageConnMaxLifetime: time.Hour,
+			StorageConnTimeout:     60 * time.Second,
+			DebugMode:              true,
+
+			MigrationVersion: 3,
+		}))
+	if err != nil {
+		panic(err)
+	}
+
+	// Run tests
+	code := m.Run()
+
+	if err := sto.Close(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	if err := pool.Purge(res); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(code)
+}
@@ -19,4 +19,8 @@ type TrackedChannels struct {
 	ProfileImageURL        *string
 	OfflineImageURL        *string
 	TrackedSince           time.Time
+	ScheduleMinute         *int16
+	ScheduleLoad           *int64
+	TrackIntervalMinutes   *int32
+	Priority               int16
 }
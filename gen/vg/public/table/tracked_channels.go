@@ -24,6 +24,10 @@ type trackedChannelsTable struct {
 	ProfileImageURL        postgres.ColumnString
 	OfflineImageURL        postgres.ColumnString
 	TrackedSince           postgres.ColumnTimestamp
+	ScheduleMinute         postgres.ColumnInteger
+	ScheduleLoad           postgres.ColumnInteger
+	TrackIntervalMinutes   postgres.ColumnInteger
+	Priority               postgres.ColumnInteger
 
 	AllColumns     postgres.ColumnList
 	MutableColumns postgres.ColumnList
@@ -71,8 +75,12 @@ func newTrackedChannelsTableImpl(schemaName, tableName, alias string) trackedCha
 		ProfileImageURLColumn        = postgres.StringColumn("profile_image_url")
 		OfflineImageURLColumn        = postgres.StringColumn("offline_image_url")
 		TrackedSinceColumn           = postgres.TimestampColumn("tracked_since")
-		allColumns                   = postgres.ColumnList{BroadcasterIDColumn, BroadcasterDisplayNameColumn, BroadcasterUsernameColumn, BroadcasterTypeColumn, ProfileImageURLColumn, OfflineImageURLColumn, TrackedSinceColumn}
-		mutableColumns               = postgres.ColumnList{BroadcasterDisplayNameColumn, BroadcasterUsernameColumn, BroadcasterTypeColumn, ProfileImageURLColumn, OfflineImageURLColumn, TrackedSinceColumn}
+		ScheduleMinuteColumn         = postgres.IntegerColumn("schedule_minute")
+		ScheduleLoadColumn           = postgres.IntegerColumn("schedule_load")
+		TrackIntervalMinutesColumn   = postgres.IntegerColumn("track_interval_minutes")
+		PriorityColumn               = postgres.IntegerColumn("priority")
+		allColumns                   = postgres.ColumnList{BroadcasterIDColumn, BroadcasterDisplayNameColumn, BroadcasterUsernameColumn, BroadcasterTypeColumn, ProfileImageURLColumn, OfflineImageURLColumn, TrackedSinceColumn, ScheduleMinuteColumn, ScheduleLoadColumn, TrackIntervalMinutesColumn, PriorityColumn}
+		mutableColumns               = postgres.ColumnList{BroadcasterDisplayNameColumn, BroadcasterUsernameColumn, BroadcasterTypeColumn, ProfileImageURLColumn, OfflineImageURLColumn, TrackedSinceColumn, ScheduleMinuteColumn, ScheduleLoadColumn, TrackIntervalMinutesColumn, PriorityColumn}
 	)
 
 	return trackedChannelsTable{
@@ -86,6 +94,10 @@ func newTrackedChannelsTableImpl(schemaName, tableName, alias string) trackedCha
 		ProfileImageURL:        ProfileImageURLColumn,
 		OfflineImageURL:        OfflineImageURLColumn,
 		TrackedSince:           TrackedSinceColumn,
+		ScheduleMinute:         ScheduleMinuteColumn,
+		ScheduleLoad:           ScheduleLoadColumn,
+		TrackIntervalMinutes:   TrackIntervalMinutesColumn,
+		Priority:               PriorityColumn,
 
 		AllColumns:     allColumns,
 		MutableColumns: mutableColumns,
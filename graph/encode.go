@@ -0,0 +1,310 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type Format string
+
+const (
+	// JSON is the node-link format of d3-force
+	JSON Format = "json"
+	// GraphML is the XML format supported by most graph tools
+	GraphML Format = "graphml"
+	// GEXF is the XML format of Gephi
+	GEXF Format = "gexf"
+	// DOT is the language of Graphviz
+	DOT Format = "dot"
+)
+
+var ErrUnknownFormat = errors.New("unknown graph format, expected json, graphml, gexf or dot")
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case JSON, GraphML, GEXF, DOT:
+		return f, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case JSON:
+		return "application/json"
+	case GraphML:
+		return "application/graphml+xml"
+	case GEXF:
+		return "application/gexf+xml"
+	case DOT:
+		return "text/vnd.graphviz"
+	}
+	return "application/octet-stream"
+}
+
+// Encode writes the graph `g` to `w` in the format `f`
+func Encode(w io.Writer, g *Graph, f Format) error {
+	switch f {
+	case JSON:
+		return json.NewEncoder(w).Encode(g)
+	case GraphML:
+		return encodeXML(w, graphML(g))
+	case GEXF:
+		return encodeXML(w, gexf(g))
+	case DOT:
+		return encodeDOT(w, g)
+	}
+	return ErrUnknownFormat
+}
+
+func encodeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type graphMLDoc struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+func graphML(g *Graph) *graphMLDoc {
+	doc := &graphMLDoc{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "label", For: "node", Name: "label", Type: "string"},
+			{ID: "tracked", For: "node", Name: "tracked", Type: "boolean"},
+			{ID: "broadcaster_id", For: "node", Name: "broadcaster_id", Type: "string"},
+			{ID: "broadcaster_type", For: "node", Name: "broadcaster_type", Type: "string"},
+			{ID: "profile_image_url", For: "node", Name: "profile_image_url", Type: "string"},
+			{ID: "weight", For: "edge", Name: "weight", Type: "long"},
+		},
+		Graph: graphMLGraph{
+			ID:          "viewergraph",
+			EdgeDefault: "directed",
+			Nodes:       make([]graphMLNode, 0, len(g.Nodes)),
+			Edges:       make([]graphMLEdge, 0, len(g.Edges)),
+		},
+	}
+	if g.hasCommunities() {
+		doc.Keys = append(doc.Keys,
+			graphMLKey{ID: "community", For: "node", Name: "community", Type: "int"},
+			graphMLKey{ID: "pagerank", For: "node", Name: "pagerank", Type: "double"},
+		)
+	}
+	for _, n := range g.Nodes {
+		data := []graphMLData{
+			{Key: "label", Value: n.Label},
+			{Key: "tracked", Value: strconv.FormatBool(n.Tracked)},
+		}
+		for _, d := range []graphMLData{
+			{Key: "broadcaster_id", Value: n.BroadcasterID},
+			{Key: "broadcaster_type", Value: n.BroadcasterType},
+			{Key: "profile_image_url", Value: n.ProfileImageURL},
+		} {
+			if d.Value != "" {
+				data = append(data, d)
+			}
+		}
+		if n.Community != nil {
+			data = append(data,
+				graphMLData{Key: "community", Value: formatCommunity(n)},
+				graphMLData{Key: "pagerank", Value: formatPageRank(n)},
+			)
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: n.ID, Data: data})
+	}
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     "e" + strconv.Itoa(i),
+			Source: e.Source,
+			Target: e.Target,
+			Data:   []graphMLData{{Key: "weight", Value: strconv.FormatUint(e.Weight, 10)}},
+		})
+	}
+	return doc
+}
+
+type gexfDoc struct {
+	XMLName xml.Name  `xml:"gexf"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Meta    gexfMeta  `xml:"meta"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfMeta struct {
+	Creator string `xml:"creator"`
+}
+
+type gexfGraph struct {
+	Mode            string         `xml:"mode,attr"`
+	DefaultEdgeType string         `xml:"defaultedgetype,attr"`
+	Attributes      gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode     `xml:"nodes>node"`
+	Edges           []gexfEdge     `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+	Weight uint64 `xml:"weight,attr"`
+}
+
+func gexf(g *Graph) *gexfDoc {
+	doc := &gexfDoc{
+		XMLNS:   "http://gexf.net/1.3",
+		Version: "1.3",
+		Meta:    gexfMeta{Creator: "viewergraph"},
+		Graph: gexfGraph{
+			Mode:            "static",
+			DefaultEdgeType: "directed",
+			Attributes: gexfAttributes{
+				Class: "node",
+				Attributes: []gexfAttribute{
+					{ID: "tracked", Title: "tracked", Type: "boolean"},
+					{ID: "broadcaster_id", Title: "broadcaster_id", Type: "string"},
+					{ID: "broadcaster_type", Title: "broadcaster_type", Type: "string"},
+					{ID: "profile_image_url", Title: "profile_image_url", Type: "string"},
+				},
+			},
+			Nodes: make([]gexfNode, 0, len(g.Nodes)),
+			Edges: make([]gexfEdge, 0, len(g.Edges)),
+		},
+	}
+	if g.hasCommunities() {
+		doc.Graph.Attributes.Attributes = append(doc.Graph.Attributes.Attributes,
+			gexfAttribute{ID: "community", Title: "community", Type: "integer"},
+			gexfAttribute{ID: "pagerank", Title: "pagerank", Type: "double"},
+		)
+	}
+	for _, n := range g.Nodes {
+		values := []gexfAttValue{{For: "tracked", Value: strconv.FormatBool(n.Tracked)}}
+		for _, v := range []gexfAttValue{
+			{For: "broadcaster_id", Value: n.BroadcasterID},
+			{For: "broadcaster_type", Value: n.BroadcasterType},
+			{For: "profile_image_url", Value: n.ProfileImageURL},
+		} {
+			if v.Value != "" {
+				values = append(values, v)
+			}
+		}
+		if n.Community != nil {
+			values = append(values,
+				gexfAttValue{For: "community", Value: formatCommunity(n)},
+				gexfAttValue{For: "pagerank", Value: formatPageRank(n)},
+			)
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{ID: n.ID, Label: n.Label, AttValues: values})
+	}
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID:     strconv.Itoa(i),
+			Source: e.Source,
+			Target: e.Target,
+			Weight: e.Weight,
+		})
+	}
+	return doc
+}
+
+func encodeDOT(w io.Writer, g *Graph) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph viewergraph {")
+	for _, n := range g.Nodes {
+		if n.Community != nil {
+			fmt.Fprintf(bw, "  %s [label=%s, tracked=%t, community=%s, pagerank=%s];\n",
+				dotID(n.ID), dotID(n.Label), n.Tracked, formatCommunity(n), formatPageRank(n))
+			continue
+		}
+		fmt.Fprintf(bw, "  %s [label=%s, tracked=%t];\n", dotID(n.ID), dotID(n.Label), n.Tracked)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(bw, "  %s -> %s [weight=%d, label=%d];\n", dotID(e.Source), dotID(e.Target), e.Weight, e.Weight)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+func formatCommunity(n *Node) string {
+	return strconv.FormatUint(uint64(*n.Community), 10)
+}
+
+func formatPageRank(n *Node) string {
+	return strconv.FormatFloat(*n.PageRank, 'g', -1, 64)
+}
+
+// dotID quotes `s` as a DOT identifier
+func dotID(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
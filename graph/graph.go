@@ -0,0 +1,177 @@
+// Package graph builds the viewer-flow graph of the tracked channels and
+// encodes it in the formats of the usual graph tools.
+package graph
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pmrt/viewergraph/analytics"
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+	"github.com/pmrt/viewergraph/repo/postgres"
+)
+
+// Graph is a directed weighted graph of channels. An edge from A to B is the
+// number of unique users that went from A to B.
+type Graph struct {
+	Nodes []*Node `json:"nodes"`
+	// Edges are named links as expected by d3-force
+	Edges []*Edge `json:"links"`
+	// CommunitiesSource is where the communities of the nodes come from,
+	// empty if the graph was exported without them
+	CommunitiesSource CommunitiesSource `json:"communities_source,omitempty"`
+}
+
+// CommunitiesSource is where the communities of an exported graph come from
+type CommunitiesSource string
+
+const (
+	// CommunitiesStored are the communities of a stored computation, see
+	// analytics.Refresher
+	CommunitiesStored CommunitiesSource = "stored"
+	// CommunitiesComputed are the communities computed from the exported
+	// edges, when there is no stored computation for the time range
+	CommunitiesComputed CommunitiesSource = "computed"
+)
+
+// Node is a channel, identified by its login. The metadata is only known for
+// tracked channels.
+type Node struct {
+	ID              string `json:"id"`
+	Label           string `json:"label"`
+	Tracked         bool   `json:"tracked"`
+	BroadcasterID   string `json:"broadcaster_id,omitempty"`
+	BroadcasterType string `json:"broadcaster_type,omitempty"`
+	ProfileImageURL string `json:"profile_image_url,omitempty"`
+	// In and Out are the weighted in and out degrees
+	In  uint64 `json:"in"`
+	Out uint64 `json:"out"`
+	// Community and PageRank are only set when the graph is exported with its
+	// communities. See analytics.Analyze.
+	Community *uint32  `json:"community,omitempty"`
+	PageRank  *float64 `json:"pagerank,omitempty"`
+}
+
+type Edge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Weight uint64 `json:"value"`
+}
+
+// New builds the graph of the flow `edges`, with the metadata of the tracked
+// `channels`. Nodes are sorted by id and edges keep their order.
+func New(edges []*clickhouse.FlowEdge, channels []*model.TrackedChannels) *Graph {
+	byLogin := make(map[string]*model.TrackedChannels, len(channels))
+	for _, ch := range channels {
+		byLogin[ch.BroadcasterUsername] = ch
+	}
+
+	g := &Graph{
+		Edges: make([]*Edge, 0, len(edges)),
+	}
+	nodes := make(map[string]*Node)
+	node := func(id string) *Node {
+		n, ok := nodes[id]
+		if !ok {
+			n = &Node{ID: id, Label: id}
+			if ch, ok := byLogin[id]; ok {
+				n.Tracked = true
+				n.BroadcasterID = ch.BroadcasterID
+				n.BroadcasterType = string(ch.BroadcasterType)
+				if ch.BroadcasterDisplayName != "" {
+					n.Label = ch.BroadcasterDisplayName
+				}
+				if ch.ProfileImageURL != nil {
+					n.ProfileImageURL = *ch.ProfileImageURL
+				}
+			}
+			nodes[id] = n
+			g.Nodes = append(g.Nodes, n)
+		}
+		return n
+	}
+	for _, e := range edges {
+		node(e.Referrer).Out += e.Total
+		node(e.Channel).In += e.Total
+		g.Edges = append(g.Edges, &Edge{
+			Source: e.Referrer,
+			Target: e.Channel,
+			Weight: e.Total,
+		})
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].ID < g.Nodes[j].ID
+	})
+	if g.Nodes == nil {
+		g.Nodes = []*Node{}
+	}
+	return g
+}
+
+type ExportOpts struct {
+	From time.Time
+	To   time.Time
+	// MinWeight is the minimum number of users of an edge
+	MinWeight uint64
+	// Communities sets the community and PageRank of the nodes
+	Communities bool
+}
+
+// Export builds the graph of the flows in the time range of `opts`. The
+// metadata of the nodes is read from `channels`, which is optional.
+//
+// With opts.Communities, the communities of the latest computation whose
+// window overlaps the time range are used. If there is none, they are
+// computed from the exported edges. See Graph.CommunitiesSource.
+func Export(events clickhouse.Repository, channels postgres.Repository, opts *ExportOpts) (*Graph, error) {
+	edges, err := events.FlowEdges(opts.From, opts.To, opts.MinWeight)
+	if err != nil {
+		return nil, err
+	}
+	var tracked []*model.TrackedChannels
+	if channels != nil {
+		if tracked, err = channels.Channels(); err != nil {
+			return nil, err
+		}
+	}
+	g := New(edges, tracked)
+	if opts.Communities {
+		cs, err := events.Communities(opts.From, opts.To)
+		if err != nil {
+			return nil, err
+		}
+		g.CommunitiesSource = CommunitiesStored
+		if len(cs) == 0 {
+			cs = analytics.Analyze(edges)
+			g.CommunitiesSource = CommunitiesComputed
+		}
+		g.SetCommunities(cs)
+	}
+	return g, nil
+}
+
+// SetCommunities sets the community and PageRank of the nodes in `cs`
+func (g *Graph) SetCommunities(cs []*clickhouse.ChannelCommunity) {
+	byChannel := make(map[string]*clickhouse.ChannelCommunity, len(cs))
+	for _, c := range cs {
+		byChannel[c.Channel] = c
+	}
+	for _, n := range g.Nodes {
+		if c, ok := byChannel[n.ID]; ok {
+			community, pr := c.Community, c.PageRank
+			n.Community = &community
+			n.PageRank = &pr
+		}
+	}
+}
+
+// hasCommunities reports whether any node has a community
+func (g *Graph) hasCommunities() bool {
+	for _, n := range g.Nodes {
+		if n.Community != nil {
+			return true
+		}
+	}
+	return false
+}
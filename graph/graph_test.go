@@ -0,0 +1,314 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+	"github.com/pmrt/viewergraph/repo/postgres"
+	"github.com/pmrt/viewergraph/utils"
+)
+
+func testGraph() *Graph {
+	return New([]*clickhouse.FlowEdge{
+		{Referrer: "b", Channel: "a", Total: 3},
+		{Referrer: "a", Channel: "c", Total: 1},
+	}, []*model.TrackedChannels{
+		{
+			BroadcasterID:          "1",
+			BroadcasterUsername:    "a",
+			BroadcasterDisplayName: "A",
+			BroadcasterType:        model.Broadcastertype_Partner,
+			ProfileImageURL:        utils.StrPtr("https://example.com/a.png"),
+		},
+		// without flows
+		{BroadcasterID: "4", BroadcasterUsername: "d"},
+	})
+}
+
+func TestNew(t *testing.T) {
+	want := &Graph{
+		Nodes: []*Node{
+			{
+				ID:              "a",
+				Label:           "A",
+				Tracked:         true,
+				BroadcasterID:   "1",
+				BroadcasterType: "partner",
+				ProfileImageURL: "https://example.com/a.png",
+				In:              3,
+				Out:             1,
+			},
+			{ID: "b", Label: "b", Out: 3},
+			{ID: "c", Label: "c", In: 1},
+		},
+		Edges: []*Edge{
+			{Source: "b", Target: "a", Weight: 3},
+			{Source: "a", Target: "c", Weight: 1},
+		},
+	}
+	if diff := deep.Equal(testGraph(), want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestExport(t *testing.T) {
+	events := clickhouse.NewMemory()
+	ts := time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC)
+	for _, evt := range []*clickhouse.RawEvent{
+		{Ts: ts, Username: "user1", Channel: "b"},
+		{Ts: ts.Add(time.Hour), Username: "user1", Channel: "a"},
+		{Ts: ts, Username: "user2", Channel: "c"},
+		{Ts: ts.Add(time.Hour), Username: "user2", Channel: "a"},
+	} {
+		evt.EventType = "view"
+		events.InsertRawEvent(evt)
+	}
+	if err := events.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	channels := postgres.NewMemory()
+	if err := channels.Track(&model.TrackedChannels{BroadcasterID: "1", BroadcasterUsername: "a", BroadcasterDisplayName: "A"}); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := Export(events, channels, &ExportOpts{From: ts, To: ts.Add(time.Hour), MinWeight: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Nodes) != 3 || len(g.Edges) != 2 {
+		t.Fatalf("got %d nodes and %d edges, want 3 and 2", len(g.Nodes), len(g.Edges))
+	}
+	if !g.Nodes[0].Tracked || g.Nodes[0].Label != "A" {
+		t.Fatalf("expected the metadata of the tracked channel, got %+v", g.Nodes[0])
+	}
+
+	// without channels the nodes have no metadata
+	g, err = Export(events, nil, &ExportOpts{From: ts, To: ts.Add(time.Hour), MinWeight: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Nodes[0].Tracked {
+		t.Fatal("expected nodes without metadata")
+	}
+}
+
+func TestExportCommunities(t *testing.T) {
+	events := clickhouse.NewMemory()
+	ts := time.Date(2020, 10, 11, 10, 0, 0, 0, time.UTC)
+	events.InsertRawEvent(&clickhouse.RawEvent{Ts: ts, Username: "user1", Channel: "b", EventType: "view"})
+	events.InsertRawEvent(&clickhouse.RawEvent{Ts: ts.Add(time.Hour), Username: "user1", Channel: "a", EventType: "view"})
+	if err := events.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	opts := &ExportOpts{From: ts, To: ts.Add(time.Hour), MinWeight: 1, Communities: true}
+
+	// without stored communities they are computed from the edges
+	g, err := Export(events, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.CommunitiesSource != CommunitiesComputed {
+		t.Fatalf("got source %q, want computed", g.CommunitiesSource)
+	}
+	for _, n := range g.Nodes {
+		if n.Community == nil || *n.Community != 0 || n.PageRank == nil {
+			t.Fatalf("expected %s in the community 0 with a PageRank, got %+v", n.ID, n)
+		}
+	}
+
+	if err := events.InsertCommunities([]*clickhouse.ChannelCommunity{
+		{WindowStart: opts.From, WindowEnd: opts.To, ComputedAt: opts.To, Channel: "a", Community: 3, PageRank: 0.5},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	g, err = Export(events, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.CommunitiesSource != CommunitiesStored {
+		t.Fatalf("got source %q, want stored", g.CommunitiesSource)
+	}
+	if a := g.Nodes[0]; *a.Community != 3 || *a.PageRank != 0.5 {
+		t.Fatalf("expected the stored community of a, got %d and %v", *a.Community, *a.PageRank)
+	}
+	if b := g.Nodes[1]; b.Community != nil || b.PageRank != nil {
+		t.Fatalf("expected no community for b, got %+v", b)
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := Encode(&b, testGraph(), JSON); err != nil {
+		t.Fatal(err)
+	}
+
+	// d3-force expects nodes with an id and links with source and target
+	var got struct {
+		Nodes []struct {
+			ID string `json:"id"`
+		} `json:"nodes"`
+		Links []struct {
+			Source string `json:"source"`
+			Target string `json:"target"`
+			Value  uint64 `json:"value"`
+		} `json:"links"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Nodes) != 3 || got.Links[0].Source != "b" || got.Links[0].Target != "a" || got.Links[0].Value != 3 {
+		t.Fatalf("unexpected graph: %s", b.String())
+	}
+}
+
+func TestEncodeGraphML(t *testing.T) {
+	var b bytes.Buffer
+	if err := Encode(&b, testGraph(), GraphML); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(b.String(), xml.Header) {
+		t.Fatal("expected the XML header")
+	}
+
+	var got graphMLDoc
+	if err := xml.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(&got.Graph, &graphML(testGraph()).Graph); diff != nil {
+		t.Fatal(diff)
+	}
+	if got.Graph.EdgeDefault != "directed" || len(got.Graph.Nodes) != 3 {
+		t.Fatalf("unexpected graph: %s", b.String())
+	}
+	wantEdge := graphMLEdge{
+		ID:     "e0",
+		Source: "b",
+		Target: "a",
+		Data:   []graphMLData{{Key: "weight", Value: "3"}},
+	}
+	if diff := deep.Equal(got.Graph.Edges[0], wantEdge); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestEncodeGEXF(t *testing.T) {
+	var b bytes.Buffer
+	if err := Encode(&b, testGraph(), GEXF); err != nil {
+		t.Fatal(err)
+	}
+
+	var got gexfDoc
+	if err := xml.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "1.3" || got.Graph.DefaultEdgeType != "directed" {
+		t.Fatalf("unexpected graph: %s", b.String())
+	}
+	wantNode := gexfNode{
+		ID:    "a",
+		Label: "A",
+		AttValues: []gexfAttValue{
+			{For: "tracked", Value: "true"},
+			{For: "broadcaster_id", Value: "1"},
+			{For: "broadcaster_type", Value: "partner"},
+			{For: "profile_image_url", Value: "https://example.com/a.png"},
+		},
+	}
+	if diff := deep.Equal(got.Graph.Nodes[0], wantNode); diff != nil {
+		t.Fatal(diff)
+	}
+	if diff := deep.Equal(got.Graph.Edges[0], gexfEdge{ID: "0", Source: "b", Target: "a", Weight: 3}); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestEncodeDOT(t *testing.T) {
+	var b bytes.Buffer
+	g := testGraph()
+	g.Nodes[1].Label = `say "hi"`
+	if err := Encode(&b, g, DOT); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `digraph viewergraph {
+  "a" [label="A", tracked=true];
+  "b" [label="say \"hi\"", tracked=false];
+  "c" [label="c", tracked=false];
+  "b" -> "a" [weight=3, label=3];
+  "a" -> "c" [weight=1, label=1];
+}
+`
+	if got := b.String(); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEncodeCommunities(t *testing.T) {
+	g := testGraph()
+	g.SetCommunities([]*clickhouse.ChannelCommunity{
+		{Channel: "a", Community: 0, PageRank: 0.5},
+		{Channel: "b", Community: 1, PageRank: 0.25},
+	})
+
+	var b bytes.Buffer
+	if err := Encode(&b, g, DOT); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph viewergraph {
+  "a" [label="A", tracked=true, community=0, pagerank=0.5];
+  "b" [label="b", tracked=false, community=1, pagerank=0.25];
+  "c" [label="c", tracked=false];
+  "b" -> "a" [weight=3, label=3];
+  "a" -> "c" [weight=1, label=1];
+}
+`
+	if got := b.String(); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	doc := graphML(g)
+	if n := len(doc.Keys); doc.Keys[n-2].ID != "community" || doc.Keys[n-1].ID != "pagerank" {
+		t.Fatalf("expected the community and pagerank keys, got %+v", doc.Keys)
+	}
+	wantData := []graphMLData{
+		{Key: "label", Value: "b"},
+		{Key: "tracked", Value: "false"},
+		{Key: "community", Value: "1"},
+		{Key: "pagerank", Value: "0.25"},
+	}
+	if diff := deep.Equal(doc.Graph.Nodes[1].Data, wantData); diff != nil {
+		t.Fatal(diff)
+	}
+
+	values := gexf(g).Graph.Nodes[1].AttValues
+	wantValues := []gexfAttValue{
+		{For: "tracked", Value: "false"},
+		{For: "community", Value: "1"},
+		{For: "pagerank", Value: "0.25"},
+	}
+	if diff := deep.Equal(values, wantValues); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// without communities the keys are omitted
+	if n := len(graphML(testGraph()).Keys); n != 6 {
+		t.Fatalf("expected 6 keys, got %d", n)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("GEXF"); err != nil || f != GEXF {
+		t.Fatalf("got %q, %v", f, err)
+	}
+	if _, err := ParseFormat("csv"); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package helix
 
 import (
+	"context"
 	"time"
 )
 
@@ -22,10 +23,71 @@ type EventStreamOnline struct {
 	Type      string    `json:"type"`
 	StartedAt time.Time `json:"stated_at"`
 	*Broadcaster
+
+	ctx context.Context
+}
+
+// Context returns the context of the event, which carries the span of the
+// webhook request that received it. Defaults to context.Background().
+func (evt *EventStreamOnline) Context() context.Context {
+	if evt.ctx == nil {
+		return context.Background()
+	}
+	return evt.ctx
+}
+
+// WithContext returns a shallow copy of the event with its context changed to
+// `ctx`.
+func (evt *EventStreamOnline) WithContext(ctx context.Context) *EventStreamOnline {
+	evt2 := *evt
+	evt2.ctx = ctx
+	return &evt2
 }
 
 type EventStreamOffline struct {
 	*Broadcaster
+
+	ctx context.Context
+}
+
+// Context returns the context of the event. See EventStreamOnline.Context().
+func (evt *EventStreamOffline) Context() context.Context {
+	if evt.ctx == nil {
+		return context.Background()
+	}
+	return evt.ctx
+}
+
+// WithContext returns a shallow copy of the event with its context changed to
+// `ctx`.
+func (evt *EventStreamOffline) WithContext(ctx context.Context) *EventStreamOffline {
+	evt2 := *evt
+	evt2.ctx = ctx
+	return &evt2
+}
+
+// EventChannelRaid is a raid of the channel From to the channel To
+type EventChannelRaid struct {
+	// MessageID is the id of the notification message, the same when Twitch
+	// delivers the notification again
+	MessageID string
+	From      *Broadcaster
+	To        *Broadcaster
+	// Viewers is the number of viewers in the raid, as reported by Twitch
+	Viewers int
+	// Ts is the time of the raid, the time of its notification. It changes
+	// when the notification is delivered again, see MessageID.
+	Ts time.Time
+
+	ctx context.Context
+}
+
+// Context returns the context of the event. See EventStreamOnline.Context().
+func (evt *EventChannelRaid) Context() context.Context {
+	if evt.ctx == nil {
+		return context.Background()
+	}
+	return evt.ctx
 }
 
 type Broadcaster struct {
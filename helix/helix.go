@@ -7,8 +7,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/gofiber/fiber/v2"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 	"golang.org/x/oauth2/twitch"
 )
@@ -25,15 +27,25 @@ type Helix struct {
 	creds                    ClientCreds
 	clientID, secret         string
 	APIUrl, EventSubEndpoint string
+	// ValidateURL is the endpoint used to validate the app access token. See
+	// ValidateToken.
+	ValidateURL string
 
-	c *http.Client
+	c  *http.Client
+	ts oauth2.TokenSource
 
 	handleStreamOnline  func(evt *EventStreamOnline)
 	handleStreamOffline func(evt *EventStreamOffline)
+	handleChannelRaid   func(evt *EventChannelRaid)
 
 	handleRevocation func(evt *WebhookRevokePayload)
 }
 
+var (
+	ErrNoTokenSource = errors.New("credentials were not exchanged for a token source")
+	ErrInvalidToken  = errors.New("invalid app access token")
+)
+
 const EstimatedSubscriptionJSONSize = 350
 
 func (hx *Helix) CreateEventSubSubscription(sub *Subscription) error {
@@ -75,6 +87,86 @@ func (hx *Helix) CreateEventSubSubscription(sub *Subscription) error {
 	return nil
 }
 
+// ValidateToken checks that the app access token, refreshed if needed, is
+// accepted by Twitch. It returns ErrNoTokenSource if Exchange was not called.
+//
+// https://dev.twitch.tv/docs/authentication/validate-tokens
+func (hx *Helix) ValidateToken(ctx context.Context) error {
+	if hx.ts == nil {
+		return ErrNoTokenSource
+	}
+	tok, err := hx.ts.Token()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", hx.ValidateURL, nil)
+	if err != nil {
+		return err
+	}
+	// the validate endpoint expects the OAuth scheme instead of Bearer, so
+	// the plain transport is used
+	req.Header.Set("Authorization", "OAuth "+tok.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: got %d", ErrInvalidToken, resp.StatusCode)
+	}
+	return nil
+}
+
+// Subscriptions lists all the EventSub subscriptions of the app, following
+// the pagination cursor.
+//
+// https://dev.twitch.tv/docs/api/reference#get-eventsub-subscriptions
+func (hx *Helix) Subscriptions(ctx context.Context) ([]*Subscription, error) {
+	var (
+		subs   []*Subscription
+		cursor string
+	)
+	for {
+		endpoint := hx.APIUrl + hx.EventSubEndpoint + "/subscriptions"
+		if cursor != "" {
+			endpoint += "?after=" + url.QueryEscape(cursor)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Client-Id", hx.creds.ClientID)
+
+		resp, err := hx.c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Data       []*Subscription `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.New("Expected 200 response, got" + fmt.Sprint(resp.StatusCode))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		subs = append(subs, page.Data...)
+		if page.Pagination.Cursor == "" {
+			return subs, nil
+		}
+		cursor = page.Pagination.Cursor
+	}
+}
+
 // OnStreamOnline sets the StreamOnline handler. The same event may be triggered
 // more than once.
 //
@@ -91,6 +183,14 @@ func (hx *Helix) OnStreamOffline(cb func(evt *EventStreamOffline)) {
 	hx.handleStreamOffline = cb
 }
 
+// OnChannelRaid sets the ChannelRaid handler. If not set, raid notifications
+// are acknowledged and ignored.
+//
+// https://dev.twitch.tv/docs/eventsub/eventsub-reference/#channel-raid-event
+func (hx *Helix) OnChannelRaid(cb func(evt *EventChannelRaid)) {
+	hx.handleChannelRaid = cb
+}
+
 func (hx *Helix) OnRevocation(cb func(evt *WebhookRevokePayload)) {
 	hx.handleRevocation = cb
 }
@@ -116,7 +216,10 @@ func (hx *Helix) Exchange() {
 		ClientSecret: hx.creds.ClientSecret,
 		TokenURL:     twitch.Endpoint.TokenURL,
 	}
-	hx.c = o2.Client(hx.ctx)
+	hx.ts = o2.TokenSource(hx.ctx)
+	c := oauth2.NewClient(hx.ctx, hx.ts)
+	c.Transport = &instrumentedTransport{next: c.Transport}
+	hx.c = c
 }
 
 // NewWithoutExchange instantiates a new Helix client but without exchanging
@@ -126,10 +229,11 @@ func (hx *Helix) Exchange() {
 func NewWithoutExchange(creds ClientCreds) *Helix {
 	return &Helix{
 		creds:            creds,
-		c:                http.DefaultClient,
+		c:                &http.Client{Transport: &instrumentedTransport{next: http.DefaultTransport}},
 		ctx:              context.Background(),
 		APIUrl:           "https://api.twitch.tv/helix",
 		EventSubEndpoint: "/eventsub",
+		ValidateURL:      "https://id.twitch.tv/oauth2/validate",
 	}
 }
 
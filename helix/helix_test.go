@@ -1,7 +1,9 @@
 package helix
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,7 +12,7 @@ import (
 	"testing"
 
 	"github.com/go-test/deep"
-	"github.com/pmrt/viewergraph/config"
+	"golang.org/x/oauth2"
 )
 
 func TestHelixCredentials(t *testing.T) {
@@ -96,8 +98,8 @@ func TestHelixCreateEventSubSubscription(t *testing.T) {
 	defer sv.Close()
 	hx := &Helix{
 		creds: ClientCreds{
-			ClientID:     config.HelixClientID,
-			ClientSecret: config.HelixSecret,
+			ClientID:     "fake_client_id",
+			ClientSecret: "fake_secret",
 		},
 		c:                sv.Client(),
 		APIUrl:           sv.URL,
@@ -124,3 +126,59 @@ func TestHelixCreateEventSubSubscription(t *testing.T) {
 		t.Fatalf("got:\n\n%s (%d)\nwant:\n\n%s (%d)", got, len(got), want, len(want))
 	}
 }
+
+func TestHelixValidateToken(t *testing.T) {
+	sv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "OAuth validtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer sv.Close()
+
+	hx := NewWithoutExchange(ClientCreds{})
+	hx.ValidateURL = sv.URL
+	if err := hx.ValidateToken(context.Background()); !errors.Is(err, ErrNoTokenSource) {
+		t.Fatalf("expected ErrNoTokenSource, got %v", err)
+	}
+
+	hx.ts = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "validtoken"})
+	if err := hx.ValidateToken(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	hx.ts = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "expiredtoken"})
+	if err := hx.ValidateToken(context.Background()); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestHelixSubscriptions(t *testing.T) {
+	pages := map[string]string{
+		"":     `{"data":[{"id":"a","status":"enabled","type":"stream.online"},{"id":"b","status":"enabled","type":"stream.offline"}],"pagination":{"cursor":"next"}}`,
+		"next": `{"data":[{"id":"c","status":"webhook_callback_verification_failed","type":"stream.online"}],"pagination":{}}`,
+	}
+	sv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eventsub/subscriptions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Client-Id") != "clientid" {
+			t.Error("expected Client-Id header")
+		}
+		io.WriteString(w, pages[r.URL.Query().Get("after")])
+	}))
+	defer sv.Close()
+
+	hx := NewWithoutExchange(ClientCreds{ClientID: "clientid"})
+	hx.APIUrl = sv.URL
+	subs, err := hx.Subscriptions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Subscription{
+		{ID: "a", Status: "enabled", Type: SubStreamOnline},
+		{ID: "b", Status: "enabled", Type: SubStreamOffline},
+		{ID: "c", Status: "webhook_callback_verification_failed", Type: SubStreamOnline},
+	}
+	if diff := deep.Equal(subs, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,36 @@
+package helix
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pmrt/viewergraph/metrics"
+)
+
+// HeaderRateLimitRemaining is the number of points left in the Helix API
+// rate limit bucket.
+// https://dev.twitch.tv/docs/api/guide#twitch-rate-limits
+const HeaderRateLimitRemaining = "Ratelimit-Remaining"
+
+// instrumentedTransport records the latency of the requests to the Helix API
+// and the remaining rate limit points reported by its responses.
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+		if rem, err := strconv.Atoi(resp.Header.Get(HeaderRateLimitRemaining)); err == nil {
+			metrics.HelixRateLimitRemaining.Set(float64(rem))
+		}
+	}
+	metrics.HelixRequestDuration.
+		WithLabelValues(req.URL.Path, code).
+		Observe(time.Since(start).Seconds())
+	return resp, err
+}
@@ -0,0 +1,62 @@
+package helix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentedTransport(t *testing.T) {
+	sv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderRateLimitRemaining, "799")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sv.Close()
+
+	c := &http.Client{Transport: &instrumentedTransport{next: sv.Client().Transport}}
+	resp, err := c.Get(sv.URL + "/eventsub/subscriptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := testutil.ToFloat64(metrics.HelixRateLimitRemaining); got != 799 {
+		t.Fatalf("rate limit remaining: got %f, want 799", got)
+	}
+	if n := testutil.CollectAndCount(metrics.HelixRequestDuration); n == 0 {
+		t.Fatalf("expected request latency to be recorded")
+	}
+}
+
+func TestWebhookMetrics(t *testing.T) {
+	app := fiber.New()
+	hx := NewWithoutExchange(ClientCreds{})
+	app.Post("/webhook", hx.WebhookHandler([]byte("secret")))
+
+	requests := metrics.WebhookRequests.WithLabelValues("unknown")
+	beforeReqs := testutil.ToFloat64(requests)
+	beforeFails := testutil.ToFloat64(metrics.WebhookSignatureFailures)
+
+	req := httptest.NewRequest("POST", "/webhook", nil)
+	req.Header.Set(WebhookHeaderType, "unexpected")
+	req.Header.Set(WebhookHeaderSignature, "sha256=invalid")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("expected status code to be 401, got %d", resp.StatusCode)
+	}
+
+	if got := testutil.ToFloat64(requests) - beforeReqs; got != 1 {
+		t.Fatalf("webhook requests: got %f, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.WebhookSignatureFailures) - beforeFails; got != 1 {
+		t.Fatalf("signature failures: got %f, want 1", got)
+	}
+}
@@ -7,7 +7,12 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/tracing"
 	"github.com/pmrt/viewergraph/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -24,6 +29,7 @@ const (
 
 	SubStreamOnline  string = "stream.online"
 	SubStreamOffline string = "stream.offline"
+	SubChannelRaid   string = "channel.raid"
 )
 
 // Twitch webhook headers
@@ -73,6 +79,14 @@ type WebhookNotificationPayload struct {
 		BroadcasterUserID    string    `json:"broadcaster_user_id"`
 		BroadcasterUserLogin string    `json:"broadcaster_user_login"`
 		BroadcasterUserName  string    `json:"broadcaster_user_name"`
+
+		FromBroadcasterUserID    string `json:"from_broadcaster_user_id"`
+		FromBroadcasterUserLogin string `json:"from_broadcaster_user_login"`
+		FromBroadcasterUserName  string `json:"from_broadcaster_user_name"`
+		ToBroadcasterUserID      string `json:"to_broadcaster_user_id"`
+		ToBroadcasterUserLogin   string `json:"to_broadcaster_user_login"`
+		ToBroadcasterUserName    string `json:"to_broadcaster_user_name"`
+		Viewers                  int    `json:"viewers"`
 	} `json:"event"`
 }
 
@@ -96,8 +110,13 @@ type Subscription struct {
 	CreatedAt time.Time  `json:"created_at"`
 }
 
+// Condition of a subscription. stream.online and stream.offline use
+// BroadcasterUserID, channel.raid either FromBroadcasterUserID or
+// ToBroadcasterUserID.
 type Condition struct {
-	BroadcasterUserID string `json:"broadcaster_user_id"`
+	BroadcasterUserID     string `json:"broadcaster_user_id,omitempty"`
+	FromBroadcasterUserID string `json:"from_broadcaster_user_id,omitempty"`
+	ToBroadcasterUserID   string `json:"to_broadcaster_user_id,omitempty"`
 }
 
 type Transport struct {
@@ -106,12 +125,25 @@ type Transport struct {
 	Secret   string `json:"secret"`
 }
 
+// messageType returns the webhook message type `typ` as a metric label,
+// "unknown" for unexpected values so the number of labels is bounded.
+func messageType(typ string) string {
+	switch typ {
+	case WebhookEventNotification, WebhookEventVerification, WebhookEventRevocation:
+		return typ
+	}
+	return "unknown"
+}
+
 type WebhookHandler struct {
 	secret []byte
 	hx     *Helix
 }
 
 func (h *WebhookHandler) handler(c *fiber.Ctx) error {
+	ctx, span := tracing.Start(c.UserContext(), "helix.webhook", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	headers := &WebhookHeaders{
 		ID:        c.Get(WebhookHeaderID),
 		Timestamp: c.Get(WebhookHeaderTimestamp),
@@ -119,7 +151,11 @@ func (h *WebhookHandler) handler(c *fiber.Ctx) error {
 		Type:      c.Get(WebhookHeaderType),
 		Body:      c.Body(),
 	}
+	span.SetAttributes(attribute.String("message_type", headers.Type))
+	metrics.WebhookRequests.WithLabelValues(messageType(headers.Type)).Inc()
 	if !headers.Valid(h.secret) {
+		metrics.WebhookSignatureFailures.Inc()
+		span.SetStatus(codes.Error, "invalid signature")
 		return fiber.NewError(fiber.StatusUnauthorized, "Invalid signature")
 	}
 
@@ -133,10 +169,17 @@ func (h *WebhookHandler) handler(c *fiber.Ctx) error {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid notification body")
 		}
 
+		span.SetAttributes(
+			attribute.String("subscription_type", resp.Subscription.Type),
+			attribute.String("bid", resp.Event.BroadcasterUserID),
+		)
 		switch resp.Subscription.Type {
 		// handlers may involve long-running tasks, so we separate them from this
 		// goroutine, otherwise the request would block until they finish and the
 		// twitch server would eventually revoke the subscriptions.
+		//
+		// The events carry the span of this request, so the spans of the
+		// handlers are part of the same trace.
 		case SubStreamOnline:
 			go h.hx.handleStreamOnline(&EventStreamOnline{
 				ID:        resp.Event.ID,
@@ -147,14 +190,41 @@ func (h *WebhookHandler) handler(c *fiber.Ctx) error {
 					Login:    resp.Event.BroadcasterUserLogin,
 					Username: resp.Event.BroadcasterUserName,
 				},
+				ctx: ctx,
 			})
 		case SubStreamOffline:
 			go h.hx.handleStreamOffline(&EventStreamOffline{
-				&Broadcaster{
+				Broadcaster: &Broadcaster{
 					ID:       resp.Event.BroadcasterUserID,
 					Login:    resp.Event.BroadcasterUserLogin,
 					Username: resp.Event.BroadcasterUserName,
 				},
+				ctx: ctx,
+			})
+		case SubChannelRaid:
+			if h.hx.handleChannelRaid == nil {
+				break
+			}
+			// the event has no time, the raid is as recent as the message
+			ts, err := time.Parse(time.RFC3339Nano, headers.Timestamp)
+			if err != nil {
+				ts = time.Now()
+			}
+			go h.hx.handleChannelRaid(&EventChannelRaid{
+				MessageID: headers.ID,
+				From: &Broadcaster{
+					ID:       resp.Event.FromBroadcasterUserID,
+					Login:    resp.Event.FromBroadcasterUserLogin,
+					Username: resp.Event.FromBroadcasterUserName,
+				},
+				To: &Broadcaster{
+					ID:       resp.Event.ToBroadcasterUserID,
+					Login:    resp.Event.ToBroadcasterUserLogin,
+					Username: resp.Event.ToBroadcasterUserName,
+				},
+				Viewers: resp.Event.Viewers,
+				Ts:      ts.UTC(),
+				ctx:     ctx,
 			})
 		default:
 			return fiber.NewError(fiber.StatusBadRequest, "Unknown notification subscription type")
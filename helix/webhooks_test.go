@@ -9,7 +9,6 @@ import (
 
 	"github.com/go-test/deep"
 	"github.com/gofiber/fiber/v2"
-	"github.com/pmrt/viewergraph/config"
 )
 
 func TestWebhookHeadersValidation(t *testing.T) {
@@ -108,8 +107,8 @@ func TestWebhookStreamOnline(t *testing.T) {
   }`)
 
 	hx := NewWithoutExchange(ClientCreds{
-		ClientID:     config.HelixClientID,
-		ClientSecret: config.HelixSecret,
+		ClientID:     "fake_client_id",
+		ClientSecret: "fake_secret",
 	})
 	wait := make(chan struct{}, 1)
 	hx.OnStreamOnline(func(evt *EventStreamOnline) {
@@ -193,8 +192,8 @@ func TestWebhookStreamOffline(t *testing.T) {
   }`)
 
 	hx := NewWithoutExchange(ClientCreds{
-		ClientID:     config.HelixClientID,
-		ClientSecret: config.HelixSecret,
+		ClientID:     "fake_client_id",
+		ClientSecret: "fake_secret",
 	})
 	wait := make(chan struct{}, 1)
 	hx.OnStreamOffline(func(evt *EventStreamOffline) {
@@ -238,6 +237,92 @@ func TestWebhookStreamOffline(t *testing.T) {
 	}
 }
 
+func TestWebhookChannelRaid(t *testing.T) {
+	t.Parallel()
+
+	var raidEvt *EventChannelRaid
+
+	var body = []byte(`{
+    "subscription": {
+        "id": "f1c2a387-161a-49f9-a165-0f21d7a4e1c5",
+        "type": "channel.raid",
+        "version": "1",
+        "status": "enabled",
+        "cost": 0,
+        "condition": {
+            "from_broadcaster_user_id": "1337"
+        },
+        "created_at": "2019-11-16T10:11:12.123Z",
+        "transport": {
+            "method": "webhook",
+            "callback": "https://example.com/webhooks/callback"
+        }
+    },
+    "event": {
+        "from_broadcaster_user_id": "1337",
+        "from_broadcaster_user_login": "cool_user",
+        "from_broadcaster_user_name": "Cool_User",
+        "to_broadcaster_user_id": "1338",
+        "to_broadcaster_user_login": "cooler_user",
+        "to_broadcaster_user_name": "Cooler_User",
+        "viewers": 9001
+    }
+  }`)
+
+	hx := NewWithoutExchange(ClientCreds{
+		ClientID:     "fake_client_id",
+		ClientSecret: "fake_secret",
+	})
+	wait := make(chan struct{}, 1)
+	hx.OnChannelRaid(func(evt *EventChannelRaid) {
+		raidEvt = evt
+		wait <- struct{}{}
+	})
+
+	app := fiber.New()
+	app.Post("/webhook", hx.WebhookHandler(secret))
+
+	req := httptest.NewRequest("POST", "http://localhost:7123/webhook", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderID, "f1c2a387-161a-49f9-a165-0f21d7a4e1c5")
+	req.Header.Set(WebhookHeaderTimestamp, "2019-11-16T10:11:12.123Z")
+	req.Header.Set(WebhookHeaderSignature, "sha256=3a8fe206c8e415c7a06f4180a1df8c56b002c120385688073ef7f5d8717dc922")
+	req.Header.Set(WebhookHeaderType, WebhookEventNotification)
+
+	resp, _ := app.Test(req)
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("\nexpected status code to be 200, got %d\nbody: %s", resp.StatusCode, b)
+	}
+
+	<-wait
+	got := raidEvt
+	want := &EventChannelRaid{
+		MessageID: "f1c2a387-161a-49f9-a165-0f21d7a4e1c5",
+		From: &Broadcaster{
+			ID:       "1337",
+			Username: "Cool_User",
+			Login:    "cool_user",
+		},
+		To: &Broadcaster{
+			ID:       "1338",
+			Username: "Cooler_User",
+			Login:    "cooler_user",
+		},
+		Viewers: 9001,
+		Ts:      time.Date(2019, 11, 16, 10, 11, 12, 123000000, time.UTC),
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
 func TestWebhookVerification(t *testing.T) {
 	var body = []byte(`{
     "challenge": "pogchamp-kappa-360noscope-vohiyo",
@@ -259,8 +344,8 @@ func TestWebhookVerification(t *testing.T) {
   }`)
 
 	hx := NewWithoutExchange(ClientCreds{
-		ClientID:     config.HelixClientID,
-		ClientSecret: config.HelixSecret,
+		ClientID:     "fake_client_id",
+		ClientSecret: "fake_secret",
 	})
 
 	app := fiber.New()
@@ -315,8 +400,8 @@ func TestWebhookRevocation(t *testing.T) {
 
 	var revokedEvt *WebhookRevokePayload
 	hx := NewWithoutExchange(ClientCreds{
-		ClientID:     config.HelixClientID,
-		ClientSecret: config.HelixSecret,
+		ClientID:     "fake_client_id",
+		ClientSecret: "fake_secret",
 	})
 	hx.OnRevocation(func(evt *WebhookRevokePayload) {
 		revokedEvt = evt
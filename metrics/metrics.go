@@ -0,0 +1,101 @@
+// Package metrics defines the Prometheus metrics of viewergraph. Metrics are
+// registered in the default registry and exposed by the API at /metrics.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "viewergraph"
+
+// Planner
+var (
+	WorkerRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "planner",
+		Name:      "worker_runs_total",
+		Help:      "Number of finished worker runs by result (success or failure).",
+	}, []string{"result"})
+	WorkerDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "planner",
+		Name:      "worker_duration_seconds",
+		Help:      "Duration of the worker runs.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	})
+)
+
+// Batcher
+var (
+	BatcherFlushes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "batcher",
+		Name:      "flushes_total",
+		Help:      "Number of batches flushed per channel, including failed ones.",
+	}, []string{"channel"})
+	BatcherFlushErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "batcher",
+		Name:      "flush_errors_total",
+		Help:      "Number of failed batch flushes per channel.",
+	}, []string{"channel"})
+	BatcherRowsInserted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "batcher",
+		Name:      "rows_inserted_total",
+		Help:      "Number of rows inserted per channel.",
+	}, []string{"channel"})
+)
+
+// Helix
+var (
+	WebhookRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "helix",
+		Name:      "webhook_requests_total",
+		Help:      "Number of webhook requests by message type.",
+	}, []string{"type"})
+	WebhookSignatureFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "helix",
+		Name:      "webhook_signature_failures_total",
+		Help:      "Number of webhook requests with an invalid signature.",
+	})
+	HelixRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "helix",
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of the requests to the Helix API by path and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"path", "code"})
+	HelixRateLimitRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "helix",
+		Name:      "api_ratelimit_remaining",
+		Help:      "Remaining points of the Helix API rate limit bucket, as of the last response.",
+	})
+)
+
+// Repositories
+var QueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Subsystem: "repo",
+	Name:      "query_duration_seconds",
+	Help:      "Duration of the queries by database and query name.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"db", "query"})
+
+// ObserveQuery starts timing the query `query` against the database `db`
+// (clickhouse or postgres). Call the returned function when the query
+// finishes, e.g.:
+//
+//	defer metrics.ObserveQuery("postgres", "Tracked")()
+func ObserveQuery(db, query string) func() {
+	start := time.Now()
+	return func() {
+		QueryDuration.WithLabelValues(db, query).Observe(time.Since(start).Seconds())
+	}
+}
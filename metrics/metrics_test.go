@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestReconciliationLag(t *testing.T) {
+	t0 := time.Date(2022, 6, 22, 15, 0, 0, 0, time.UTC)
+	c := NewReconciliationLag(func() (time.Time, error) {
+		return t0, nil
+	}).(*reconciliationLag)
+	c.now = func() time.Time { return t0.Add(90 * time.Second) }
+
+	want := `
+# HELP viewergraph_reconciliation_lag_seconds Seconds since the last event reconciliation.
+# TYPE viewergraph_reconciliation_lag_seconds gauge
+viewergraph_reconciliation_lag_seconds 90
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconciliationLagOmitted(t *testing.T) {
+	tests := []func() (time.Time, error){
+		func() (time.Time, error) { return time.Time{}, nil },
+		func() (time.Time, error) { return time.Time{}, errors.New("boom") },
+	}
+	for _, last := range tests {
+		if n := testutil.CollectAndCount(NewReconciliationLag(last)); n != 0 {
+			t.Fatalf("expected metric to be omitted, got %d metrics", n)
+		}
+	}
+}
+
+func TestObserveQuery(t *testing.T) {
+	ObserveQuery("postgres", "TestObserveQuery")()
+
+	m := &dto.Metric{}
+	h := QueryDuration.WithLabelValues("postgres", "TestObserveQuery").(prometheus.Metric)
+	if err := h.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.GetHistogram().GetSampleCount(); n != 1 {
+		t.Fatalf("expected 1 observation, got %d", n)
+	}
+}
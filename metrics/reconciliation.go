@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	l "github.com/rs/zerolog/log"
+)
+
+type reconciliationLag struct {
+	desc *prometheus.Desc
+	last func() (time.Time, error)
+	now  func() time.Time
+}
+
+func (c *reconciliationLag) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect reads the time of the last reconciliation at scrape time. If it
+// fails or there was no reconciliation yet the metric is omitted, so the rest
+// of the metrics can still be scraped.
+func (c *reconciliationLag) Collect(ch chan<- prometheus.Metric) {
+	last, err := c.last()
+	if err != nil {
+		l.Error().
+			Err(err).
+			Str("context", "metrics").
+			Msg("error while reading last reconciliation")
+		return
+	}
+	if last.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.desc, prometheus.GaugeValue, c.now().Sub(last).Seconds(),
+	)
+}
+
+// NewReconciliationLag returns a collector with the seconds since the last
+// event reconciliation. `last` returns the time of the last reconciliation,
+// e.g.: vg_options.last_reconciliation_at, the zero time if there was none.
+func NewReconciliationLag(last func() (time.Time, error)) prometheus.Collector {
+	return &reconciliationLag{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "reconciliation", "lag_seconds"),
+			"Seconds since the last event reconciliation.",
+			nil, nil,
+		),
+		last: last,
+		now:  time.Now,
+	}
+}
@@ -0,0 +1,99 @@
+package planner
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/pmrt/viewergraph/helix"
+	"github.com/pmrt/viewergraph/tracing"
+	l "github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrExecutorNotFound = errors.New("no active executor for the channel")
+
+// Executors returns a snapshot of the active executors, sorted by broadcaster
+// ID.
+func (p *Planner) Executors() []ExecutorInfo {
+	infos := make([]ExecutorInfo, 0, p.active.Count())
+	for bid, e := range p.active.Items() {
+		infos = append(infos, p.executorInfo(bid, e))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].BroadcasterID < infos[j].BroadcasterID
+	})
+	return infos
+}
+
+// Executor returns a snapshot of the active executor of the channel `bid`.
+func (p *Planner) Executor(bid string) (ExecutorInfo, bool) {
+	e, ok := p.active.Get(bid)
+	if !ok {
+		return ExecutorInfo{}, false
+	}
+	return p.executorInfo(bid, e), true
+}
+
+func (p *Planner) executorInfo(bid string, e *executor) ExecutorInfo {
+	i := e.info()
+	i.BroadcasterID = bid
+	i.Minute = p.minute(bid)
+	return i
+}
+
+// RunNow forces a run of the worker of the channel `bid`, ignoring
+// MinRunSpacing. Runs of the same channel still never overlap. The channel
+// does not need to have an active executor.
+func (p *Planner) RunNow(bid string) {
+	l := l.With().
+		Str("context", "planner_admin").
+		Str("bid", bid).
+		Logger()
+
+	l.Info().Msg("forced worker run")
+	ctx, span := tracing.Start(l.WithContext(p.ctx), "planner.run_now", trace.WithAttributes(
+		attribute.String("bid", bid),
+	))
+	defer span.End()
+	p.submitRun(ctx, bid, 0)
+}
+
+// Cancel stops the active executor of the channel `bid` without running the
+// worker one last time, unlike stream.offline events. It returns
+// ErrExecutorNotFound if the channel has no active executor.
+func (p *Planner) Cancel(bid string) error {
+	e, ok := p.active.Pop(bid)
+	if !ok {
+		return ErrExecutorNotFound
+	}
+	l.Info().
+		Str("context", "planner_admin").
+		Str("bid", bid).
+		Msg("cancelled executor")
+	close(e.end)
+	return nil
+}
+
+// SimulateOnline starts an executor for the channel `bid` as if a
+// stream.online event was received. It does not block.
+func (p *Planner) SimulateOnline(bid, login string) {
+	go p.OnStreamOnline(&helix.EventStreamOnline{
+		Type:      "live",
+		StartedAt: p.opts.Clock.Now(),
+		Broadcaster: &helix.Broadcaster{
+			ID:    bid,
+			Login: login,
+		},
+	})
+}
+
+// SimulateOffline handles a stream.offline event for the channel `bid`.
+func (p *Planner) SimulateOffline(bid, login string) {
+	p.OnStreamOffline(&helix.EventStreamOffline{
+		Broadcaster: &helix.Broadcaster{
+			ID:    bid,
+			Login: login,
+		},
+	})
+}
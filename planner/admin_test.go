@@ -0,0 +1,140 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func TestPlannerExecutors(t *testing.T) {
+	const bid = "36138196" // balanced minute: 10
+	clock := NewFakeClock(parseTime("2022-06-22T15:00:00Z"))
+	results := make(chan error)
+	ran := make(chan struct{})
+	p := New(&PlannerOpts{
+		TrackInterval:      time.Hour,
+		TrackOnlineTimeout: 24 * time.Hour,
+		WorkerTimeout:      time.Minute,
+		Clock:              clock,
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			err := <-results
+			defer func() { ran <- struct{}{} }()
+			return 42, err
+		},
+	})
+	defer p.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		p.OnStreamOnline(createEventStreamOnline(bid, "alexelcapo"))
+		close(done)
+	}()
+	// online timeout and align phase
+	clock.BlockUntil(2)
+
+	got, ok := p.Executor(bid)
+	if !ok {
+		t.Fatal("expected executor to be active")
+	}
+	want := ExecutorInfo{
+		BroadcasterID: bid,
+		Login:         "alexelcapo",
+		StartedAt:     parseTime("2022-06-22T15:00:00Z"),
+		Minute:        10,
+		NextRun:       parseTime("2022-06-22T15:10:00Z"),
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	clock.Advance(10 * time.Minute)
+	results <- nil
+	<-ran
+	clock.BlockUntil(3)
+	clock.Advance(time.Hour)
+	results <- errors.New("boom")
+	<-ran
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got = p.Executors()[0]
+		if got.LastError != "" && !got.InFlight && got.NextRun.Equal(parseTime("2022-06-22T16:10:00Z").Add(time.Hour)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected executor state %+v", got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// failed runs keep the last batch size
+	if got.LastBatch != 42 || got.LastError != "boom" || !got.LastRun.Equal(parseTime("2022-06-22T16:10:00Z")) {
+		t.Fatalf("got last_batch=%d last_error=%q last_run=%s", got.LastBatch, got.LastError, got.LastRun)
+	}
+
+	if err := p.Cancel(bid); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if err := p.Cancel(bid); !errors.Is(err, ErrExecutorNotFound) {
+		t.Fatalf("expected ErrExecutorNotFound, got %v", err)
+	}
+	if len(p.Executors()) != 0 {
+		t.Fatal("expected no active executors")
+	}
+}
+
+func TestPlannerRunNowIgnoresSpacing(t *testing.T) {
+	t.Parallel()
+
+	ran := make(chan struct{})
+	p := New(&PlannerOpts{
+		WorkerTimeout: 10 * time.Second,
+		MinRunSpacing: time.Hour,
+		Clock:         NewFakeClock(parseTime("2022-06-22T15:00:00Z")),
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			ran <- struct{}{}
+			return 0, nil
+		},
+	})
+	defer p.Stop()
+	p.active.Set("234783", newExecutor())
+
+	p.RunNow("234783")
+	<-ran
+	p.RunNow("234783")
+	<-ran
+}
+
+func TestPlannerSimulateEvents(t *testing.T) {
+	t.Parallel()
+
+	ran := make(chan string, 2)
+	p := New(&PlannerOpts{
+		TrackInterval:      time.Hour,
+		TrackOnlineTimeout: time.Hour,
+		WorkerTimeout:      10 * time.Second,
+		SkipAlign:          true,
+		Clock:              NewFakeClock(parseTime("2022-06-22T15:00:00Z")),
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			ran <- bid
+			return 0, nil
+		},
+	})
+	defer p.Stop()
+
+	p.SimulateOnline("234783", "ibai")
+	<-ran
+	if e, ok := p.Executor("234783"); !ok || e.Login != "ibai" {
+		t.Fatal("expected simulated stream.online to start an executor")
+	}
+
+	// stream.offline runs the worker one last time
+	p.SimulateOffline("234783", "ibai")
+	<-ran
+	if _, ok := p.Executor("234783"); ok {
+		t.Fatal("expected simulated stream.offline to stop the executor")
+	}
+}
@@ -1,6 +1,7 @@
 package planner
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,7 +9,12 @@ import (
 	"time"
 
 	"github.com/pmrt/viewergraph/database"
+	"github.com/pmrt/viewergraph/metrics"
 	"github.com/pmrt/viewergraph/repo/clickhouse"
+	"github.com/pmrt/viewergraph/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var ErrUnexpectedProp = errors.New("unexpected property")
@@ -43,12 +49,29 @@ type StreamBatcher struct {
 	ChatterSize uint64
 	flushCount  uint64
 	size        uint64
+	// err is the first flush error, see Err
+	err error
 
-	FlushFunc func(sto database.Storage, queue []string, channel string) error
+	FlushFunc func(ctx context.Context, sto database.Storage, queue []string, channel string) error
 
 	MaxQueueSize uint64
 	Channel      string
 	sto          database.Storage
+	ctx          context.Context
+}
+
+// WithContext sets the context passed to the flushes, e.g. the context of the
+// worker run with its logger and span.
+func (b *StreamBatcher) WithContext(ctx context.Context) *StreamBatcher {
+	b.ctx = ctx
+	return b
+}
+
+func (b *StreamBatcher) context() context.Context {
+	if b.ctx == nil {
+		return context.Background()
+	}
+	return b.ctx
 }
 
 // Enqueue the given `usr` item.
@@ -77,18 +100,42 @@ func (b *StreamBatcher) Enqueue(usr string) {
 	}
 }
 
-// Flush the queue. Flush is an idempotent operation.
+// Err returns the first error returned by FlushFunc, if any. Batching goes on
+// after a failed flush, so the rest of the items are still inserted.
+func (b *StreamBatcher) Err() error {
+	return b.err
+}
+
+// Flush the queue. Flush is an idempotent operation. See Err.
 func (b *StreamBatcher) Flush() {
 	if b.queueCount == 0 {
 		return
 	}
-	b.FlushFunc(b.sto, b.queue, b.Channel)
+	ctx, span := tracing.Start(b.context(), "batcher.flush", trace.WithAttributes(
+		attribute.String("channel", b.Channel),
+		attribute.Int64("rows", int64(b.queueCount)),
+	))
+	defer span.End()
+
+	metrics.BatcherFlushes.WithLabelValues(b.Channel).Inc()
+	if err := b.FlushFunc(ctx, b.sto, b.queue, b.Channel); err != nil {
+		metrics.BatcherFlushErrors.WithLabelValues(b.Channel).Inc()
+		if b.err == nil {
+			b.err = err
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		metrics.BatcherRowsInserted.WithLabelValues(b.Channel).Add(float64(b.queueCount))
+	}
 
 	b.queue = nil
 	b.queueCount = 0
 	b.flushCount++
 }
 
+// Batch parses, batches and flushes the items read from `r`. It returns the
+// first error while parsing or flushing.
 func (b *StreamBatcher) Batch(r io.Reader) error {
 	dec := json.NewDecoder(r)
 	tk, err := dec.Token()
@@ -173,14 +220,38 @@ func (b *StreamBatcher) Batch(r io.Reader) error {
 	// If ChatterSize wasn't provided we need an extra flush to ensure we don't
 	// leave any items unflushed
 	b.Flush()
-	return nil
+	return b.err
+}
+
+// snapshot is a run of the worker of a stream. The chatters flushed during the
+// same run are stored with the same stream and snapshot time.
+type snapshot struct {
+	stream string
+	ts     time.Time
+}
+
+type snapshotKey struct{}
+
+func withSnapshot(ctx context.Context, s *snapshot) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, s)
+}
+
+// snapshotFrom returns the snapshot carried by `ctx`. Defaults to a snapshot
+// of an unknown stream taken now.
+func snapshotFrom(ctx context.Context) *snapshot {
+	if s, ok := ctx.Value(snapshotKey{}).(*snapshot); ok {
+		return s
+	}
+	return &snapshot{ts: time.Now()}
 }
 
-func flusher(sto database.Storage, queue []string, channel string) error {
-	return clickhouse.InsertViewers(sto.Conn(), &clickhouse.Viewers{
-		Ts:      time.Now(),
+func flusher(ctx context.Context, sto database.Storage, queue []string, channel string) error {
+	s := snapshotFrom(ctx)
+	return clickhouse.InsertViewersContext(ctx, sto.Conn(), &clickhouse.Viewers{
+		Ts:      s.ts,
 		Viewers: queue,
 		Channel: channel,
+		Stream:  s.stream,
 	})
 }
 
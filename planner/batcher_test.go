@@ -2,7 +2,9 @@ package planner
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"strings"
 	"testing"
@@ -23,7 +25,7 @@ func TestStreamBatcherChatterSizeLowerThanMax(t *testing.T) {
 	const chatterCount = 3
 	b := &StreamBatcher{
 		MaxQueueSize: 10,
-		FlushFunc:    func(sto database.Storage, queue []string, channel string) error { return nil },
+		FlushFunc:    func(ctx context.Context, sto database.Storage, queue []string, channel string) error { return nil },
 	}
 	b.ChatterSize = chatterCount
 
@@ -66,7 +68,7 @@ func TestStreamBatcherChatterSizeZero(t *testing.T) {
 	const max = 5
 	b := &StreamBatcher{
 		MaxQueueSize: max,
-		FlushFunc:    func(sto database.Storage, queue []string, channel string) error { return nil },
+		FlushFunc:    func(ctx context.Context, sto database.Storage, queue []string, channel string) error { return nil },
 	}
 
 	if b.queue != nil {
@@ -107,7 +109,7 @@ func TestStreamBatcherChatterSizeZeroMoreValuesThanMax(t *testing.T) {
 	const max = 3
 	b := &StreamBatcher{
 		MaxQueueSize: max,
-		FlushFunc:    func(sto database.Storage, queue []string, channel string) error { return nil },
+		FlushFunc:    func(ctx context.Context, sto database.Storage, queue []string, channel string) error { return nil },
 	}
 
 	if b.queue != nil {
@@ -161,7 +163,7 @@ func TestStreamBatcherChatterSizeSameAsMax(t *testing.T) {
 	const max = 3
 	b := &StreamBatcher{
 		MaxQueueSize: max,
-		FlushFunc:    func(sto database.Storage, queue []string, channel string) error { return nil },
+		FlushFunc:    func(ctx context.Context, sto database.Storage, queue []string, channel string) error { return nil },
 	}
 	b.ChatterSize = chatterCount
 
@@ -205,7 +207,7 @@ func TestStreamBatcherChatterSizeGreaterThanMax(t *testing.T) {
 	const max = 3
 	b := &StreamBatcher{
 		MaxQueueSize: max,
-		FlushFunc:    func(sto database.Storage, queue []string, channel string) error { return nil },
+		FlushFunc:    func(ctx context.Context, sto database.Storage, queue []string, channel string) error { return nil },
 	}
 	b.ChatterSize = chatterCount
 
@@ -282,7 +284,7 @@ func TestStreamBatcherChatterSizeWrong(t *testing.T) {
 	const max = 2
 	b := &StreamBatcher{
 		MaxQueueSize: max,
-		FlushFunc:    func(sto database.Storage, queue []string, channel string) error { return nil },
+		FlushFunc:    func(ctx context.Context, sto database.Storage, queue []string, channel string) error { return nil },
 	}
 	b.ChatterSize = chatterCount
 
@@ -346,7 +348,7 @@ func TestStreamBatcherJSONParse(t *testing.T) {
 	var got []string
 	b := &StreamBatcher{
 		MaxQueueSize: 100000,
-		FlushFunc: func(sto database.Storage, queue []string, channel string) error {
+		FlushFunc: func(ctx context.Context, sto database.Storage, queue []string, channel string) error {
 			got = queue
 			return nil
 		},
@@ -379,7 +381,7 @@ func TestStreamBatcherJSONQueue(t *testing.T) {
 	var flushCount uint64
 	b := &StreamBatcher{
 		MaxQueueSize: 100,
-		FlushFunc: func(sto database.Storage, _ []string, _ string) error {
+		FlushFunc: func(ctx context.Context, sto database.Storage, _ []string, _ string) error {
 			flushCount++
 			return nil
 		},
@@ -404,3 +406,36 @@ func TestStreamBatcherJSONQueue(t *testing.T) {
 		t.Fatalf("ChatterSize - got: %d want: %d", got, want)
 	}
 }
+
+func TestStreamBatcherFlushErrors(t *testing.T) {
+	t.Parallel()
+
+	obj := strings.NewReader(`{"_links":{},"chatter_count":5,"chatters":{"broadcaster":["a"],"vips":["b","c"],"moderators":[],"staff":[],"admins":[],"global_mods":[],"viewers":["d","e","f"]}}`)
+
+	errFirst, errSecond := errors.New("first"), errors.New("second")
+	var flushed []string
+	calls := 0
+	b := &StreamBatcher{
+		MaxQueueSize: 2,
+		FlushFunc: func(ctx context.Context, sto database.Storage, queue []string, _ string) error {
+			calls++
+			switch calls {
+			case 1:
+				return errFirst
+			case 2:
+				return errSecond
+			}
+			flushed = append(flushed, queue...)
+			return nil
+		},
+	}
+
+	// the first flush error is returned and the rest of the items are still
+	// flushed
+	if err := b.Batch(obj); !errors.Is(err, errFirst) {
+		t.Fatalf("expected the first flush error, got %v", err)
+	}
+	if diff := deep.Equal(flushed, []string{"f"}); diff != nil {
+		t.Fatal(diff)
+	}
+}
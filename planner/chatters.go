@@ -0,0 +1,74 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pmrt/viewergraph/database"
+)
+
+// ChattersURL is the unofficial endpoint with the chatters of a channel,
+// formatted with the login of the broadcaster.
+const ChattersURL = "https://tmi.twitch.tv/group/user/%s/chatters"
+
+var ErrUnknownLogin = errors.New("unknown login for the channel")
+
+// ChattersWorker tracks the chatters of a channel, streaming them from the
+// chatters endpoint to the storage in batches. See StreamBatcher. Its Run
+// method is meant to be used as PlannerOpts.WorkerFunc.
+type ChattersWorker struct {
+	Storage   database.Storage
+	BatchSize uint64
+	// Login returns the login of the broadcaster `bid`, e.g. Planner.Login.
+	Login func(bid string) (string, bool)
+	// URL is the chatters endpoint, formatted with the login. Defaults to
+	// ChattersURL.
+	URL    string
+	Client *http.Client
+	// FlushFunc replaces the FlushFunc of the batchers. Intended just for
+	// testing.
+	FlushFunc func(ctx context.Context, sto database.Storage, queue []string, channel string) error
+}
+
+// Run tracks the chatters of the channel `bid`. It returns the number of
+// chatters reported by the endpoint, or the first error while reading or
+// storing them.
+func (w *ChattersWorker) Run(ctx context.Context, bid string) (uint64, error) {
+	login, ok := w.Login(bid)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownLogin, bid)
+	}
+	endpoint := w.URL
+	if endpoint == "" {
+		endpoint = ChattersURL
+	}
+	c := w.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf(endpoint, url.PathEscape(login)), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("chatters of %s: unexpected status %d", login, resp.StatusCode)
+	}
+
+	b := NewStreamBatcher(w.Storage, login, w.BatchSize).WithContext(ctx)
+	if w.FlushFunc != nil {
+		b.FlushFunc = w.FlushFunc
+	}
+	if err := b.Batch(resp.Body); err != nil {
+		return 0, err
+	}
+	return b.ChatterSize, nil
+}
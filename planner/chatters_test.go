@@ -0,0 +1,74 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/database"
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+)
+
+func TestChattersWorker(t *testing.T) {
+	t.Parallel()
+
+	sv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/group/user/alexelcapo/chatters" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, `{"_links":{},"chatter_count":3,"chatters":{"broadcaster":["alexelcapo"],"vips":["a"],"moderators":["b"],"staff":[],"admins":[],"global_mods":[],"viewers":["c"]}}`)
+	}))
+	defer sv.Close()
+
+	p := FromChannels(&PlannerOpts{}, []*model.TrackedChannels{
+		{BroadcasterID: "36138196", BroadcasterUsername: "alexelcapo"},
+		{BroadcasterID: "1", BroadcasterUsername: "offline"},
+	})
+	defer p.Stop()
+
+	var flushed []string
+	w := &ChattersWorker{
+		BatchSize: 10,
+		Login:     p.Login,
+		URL:       sv.URL + "/group/user/%s/chatters",
+		FlushFunc: func(ctx context.Context, sto database.Storage, queue []string, channel string) error {
+			if channel != "alexelcapo" {
+				t.Errorf("got channel %s, want alexelcapo", channel)
+			}
+			flushed = append(flushed, queue...)
+			return nil
+		},
+	}
+
+	n, err := w.Run(context.Background(), "36138196")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("got %d chatters, want 3", n)
+	}
+	if diff := deep.Equal(flushed, []string{"a", "b", "c"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	if _, err := w.Run(context.Background(), "1"); err == nil {
+		t.Fatal("expected error on unexpected status")
+	}
+	if _, err := w.Run(context.Background(), "2"); !errors.Is(err, ErrUnknownLogin) {
+		t.Fatalf("expected ErrUnknownLogin, got %v", err)
+	}
+
+	// flush errors are reported
+	errFlush := errors.New("clickhouse is down")
+	w.FlushFunc = func(ctx context.Context, sto database.Storage, queue []string, channel string) error {
+		return errFlush
+	}
+	if _, err := w.Run(context.Background(), "36138196"); !errors.Is(err, errFlush) {
+		t.Fatalf("expected flush error, got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package planner
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock abstracts the time source of the planner so scheduling (alignment,
+// ticking and timeouts) can be tested deterministically. See FakeClock.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker is the Clock counterpart of time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{time.NewTicker(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t *realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// withTimeout is the Clock counterpart of context.WithTimeout.
+func withTimeout(ctx context.Context, c Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(realClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if d <= 0 {
+		cancel()
+		return ctx, cancel
+	}
+	after := c.After(d)
+	go func() {
+		select {
+		case <-after:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// FakeClock is a Clock whose time only moves when Advance() is called.
+// Intended for testing.
+//
+// Sleepers, After() channels and tickers fire when the clock is advanced past
+// their deadline. Use BlockUntil() to wait for the code under test to be
+// waiting on the clock before advancing it.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+	tickers []*fakeTicker
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+type fakeTicker struct {
+	clock  *FakeClock
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	fc := t.clock
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for i, tk := range fc.tickers {
+		if tk == t {
+			fc.tickers = append(fc.tickers[:i], fc.tickers[i+1:]...)
+			fc.cond.Broadcast()
+			return
+		}
+	}
+}
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for fakeTicker.Reset")
+	}
+	fc := t.clock
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	t.period = d
+	t.next = fc.now.Add(d)
+}
+
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+func (fc *FakeClock) Sleep(d time.Duration) {
+	<-fc.After(d)
+}
+
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- fc.now
+		return c
+	}
+	fc.waiters = append(fc.waiters, &fakeWaiter{
+		at: fc.now.Add(d),
+		c:  c,
+	})
+	fc.cond.Broadcast()
+	return c
+}
+
+func (fc *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	t := &fakeTicker{
+		clock:  fc,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   fc.now.Add(d),
+	}
+	fc.tickers = append(fc.tickers, t)
+	fc.cond.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward by `d`, firing every sleeper, After()
+// channel and ticker whose deadline is reached. Like time.Ticker, tickers drop
+// ticks if the previous one has not been received yet.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.now = fc.now.Add(d)
+	waiters := fc.waiters[:0]
+	for _, w := range fc.waiters {
+		if w.at.After(fc.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.c <- fc.now
+	}
+	fc.waiters = waiters
+
+	for _, t := range fc.tickers {
+		for !t.next.After(fc.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+	fc.cond.Broadcast()
+}
+
+// BlockUntil blocks until at least `n` sleepers, After() channels or tickers
+// are waiting on the clock.
+func (fc *FakeClock) BlockUntil(n int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for len(fc.waiters)+len(fc.tickers) < n {
+		fc.cond.Wait()
+	}
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	fc := &FakeClock{now: now}
+	fc.cond = sync.NewCond(&fc.mu)
+	return fc
+}
@@ -0,0 +1,62 @@
+package planner
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFakeClockAfterAndTicker(t *testing.T) {
+	t.Parallel()
+
+	t0 := parseTime("2022-06-22T15:00:00Z")
+	clock := NewFakeClock(t0)
+	after := clock.After(time.Minute)
+	ticker := clock.NewTicker(20 * time.Second)
+	defer ticker.Stop()
+
+	clock.Advance(30 * time.Second)
+	select {
+	case <-after:
+		t.Fatal("After() fired before its deadline")
+	default:
+	}
+	if got, want := <-ticker.C(), t0.Add(20*time.Second); !got.Equal(want) {
+		t.Fatalf("tick: got %s, want %s", got, want)
+	}
+
+	// ticks not received are dropped, like time.Ticker
+	clock.Advance(time.Minute)
+	if got, want := <-after, t0.Add(90*time.Second); !got.Equal(want) {
+		t.Fatalf("after: got %s, want %s", got, want)
+	}
+	if got, want := <-ticker.C(), t0.Add(40*time.Second); !got.Equal(want) {
+		t.Fatalf("tick: got %s, want %s", got, want)
+	}
+	select {
+	case <-ticker.C():
+		t.Fatal("expected ticks to be dropped")
+	default:
+	}
+}
+
+func TestWithTimeoutFakeClock(t *testing.T) {
+	t.Parallel()
+
+	clock := NewFakeClock(parseTime("2022-06-22T15:00:00Z"))
+	ctx, cancel := withTimeout(context.Background(), clock, time.Hour)
+	defer cancel()
+
+	clock.Advance(59 * time.Minute)
+	if ctx.Err() != nil {
+		t.Fatal("context cancelled before the timeout")
+	}
+	clock.Advance(time.Minute)
+	<-ctx.Done()
+
+	ctx, cancel = withTimeout(context.Background(), clock, 0)
+	defer cancel()
+	if ctx.Err() == nil {
+		t.Fatal("expected context with no timeout to be cancelled right away")
+	}
+}
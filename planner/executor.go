@@ -0,0 +1,142 @@
+package planner
+
+import (
+	"sync"
+	"time"
+)
+
+type endSig chan struct{}
+
+// executor is the state of an active channel, stored in the active map of the
+// planner by broadcaster ID.
+type executor struct {
+	// end is closed upon stream.offline events
+	end endSig
+	// interval receives the new interval of the cycle when it is changed at
+	// runtime. Only the latest change is kept
+	interval chan time.Duration
+
+	// login of the broadcaster and when the executor was started
+	login     string
+	startedAt time.Time
+	// stream is the id of the stream of the stream.online event
+	stream string
+
+	mu sync.Mutex
+	// lastStart is when the last run of the worker was started
+	lastStart time.Time
+	// inFlight is the number of runs submitted which have not finished yet
+	inFlight int
+	// nextRun is when the cycle will run the worker next
+	nextRun time.Time
+	// lastBatch and lastErr are the result of the last finished run
+	lastBatch uint64
+	lastErr   error
+}
+
+// claim decides whether a new run can be started at `now`, registering it as
+// in flight if so. Runs are skipped if the previous run started less than
+// `spacing` ago.
+//
+// Every successful claim must be followed by a call to release() once the run
+// finishes or is discarded.
+func (e *executor) claim(now time.Time, spacing time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.lastStart.IsZero() && now.Sub(e.lastStart) < spacing {
+		return false
+	}
+	e.lastStart = now
+	e.inFlight++
+	return true
+}
+
+func (e *executor) release() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.inFlight--
+}
+
+// InFlight reports whether a run of the worker is queued or running.
+func (e *executor) InFlight() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.inFlight > 0
+}
+
+// LastStart returns when the last run of the worker was started.
+func (e *executor) LastStart() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastStart
+}
+
+func (e *executor) setNextRun(t time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextRun = t
+}
+
+// observe records the result of a finished run.
+func (e *executor) observe(chatters uint64, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.lastErr = err
+		return
+	}
+	e.lastBatch = chatters
+	e.lastErr = nil
+}
+
+// setInterval notifies the cycle of the executor about a new interval,
+// replacing any change not yet applied.
+func (e *executor) setInterval(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	select {
+	case <-e.interval:
+	default:
+	}
+	e.interval <- d
+}
+
+// ExecutorInfo is a snapshot of the state of an active executor.
+type ExecutorInfo struct {
+	BroadcasterID string    `json:"bid"`
+	Login         string    `json:"login"`
+	StartedAt     time.Time `json:"started_at"`
+	// Minute is the balanced minute of the channel
+	Minute  uint32    `json:"minute"`
+	NextRun time.Time `json:"next_run"`
+	// LastRun is when the last run of the worker was started
+	LastRun   time.Time `json:"last_run"`
+	InFlight  bool      `json:"in_flight"`
+	LastBatch uint64    `json:"last_batch"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+func (e *executor) info() ExecutorInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	i := ExecutorInfo{
+		Login:     e.login,
+		StartedAt: e.startedAt,
+		NextRun:   e.nextRun,
+		LastRun:   e.lastStart,
+		InFlight:  e.inFlight > 0,
+		LastBatch: e.lastBatch,
+	}
+	if e.lastErr != nil {
+		i.LastError = e.lastErr.Error()
+	}
+	return i
+}
+
+func newExecutor() *executor {
+	return &executor{
+		end:      make(endSig, 1),
+		interval: make(chan time.Duration, 1),
+	}
+}
@@ -0,0 +1,138 @@
+package planner
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecutorClaim(t *testing.T) {
+	t.Parallel()
+
+	e := newExecutor()
+	t0 := time.Date(2022, 6, 22, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		now  time.Time
+		want bool
+	}{
+		{now: t0, want: true},
+		{now: t0.Add(30 * time.Second), want: false},
+		{now: t0.Add(59 * time.Second), want: false},
+		{now: t0.Add(time.Minute), want: true},
+		{now: t0.Add(90 * time.Second), want: false},
+		{now: t0.Add(2 * time.Minute), want: true},
+	}
+	for _, test := range tests {
+		if got := e.claim(test.now, time.Minute); got != test.want {
+			t.Fatalf("claim at %s: got %t, want %t", test.now, got, test.want)
+		}
+	}
+
+	if got, want := e.LastStart(), t0.Add(2*time.Minute); !got.Equal(want) {
+		t.Fatalf("last start: got %s, want %s", got, want)
+	}
+	if !e.InFlight() {
+		t.Fatal("expected executor to have runs in flight")
+	}
+	for i := 0; i < 3; i++ {
+		e.release()
+	}
+	if e.InFlight() {
+		t.Fatal("expected executor to have no runs in flight")
+	}
+}
+
+func TestPlannerSkipsRunsWithinSpacing(t *testing.T) {
+	t.Parallel()
+
+	var count uint32
+	ran := make(chan struct{})
+	clock := NewFakeClock(time.Date(2022, 6, 22, 15, 0, 0, 0, time.UTC))
+	p := New(&PlannerOpts{
+		WorkerTimeout: 10 * time.Second,
+		MinRunSpacing: time.Minute,
+		Clock:         clock,
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			atomic.AddUint32(&count, 1)
+			ran <- struct{}{}
+			return 0, nil
+		},
+	})
+	defer p.Stop()
+	p.active.Set("234783", newExecutor())
+
+	p.runWorker(p.ctx, "234783")
+	<-ran
+	clock.Advance(59 * time.Second)
+	p.runWorker(p.ctx, "234783")
+	clock.Advance(time.Second)
+	p.runWorker(p.ctx, "234783")
+	<-ran
+
+	if got := atomic.LoadUint32(&count); got != 2 {
+		t.Fatalf("count: got %d, want 2", got)
+	}
+	if got := p.PoolStats().Submitted; got != 2 {
+		t.Fatalf("expected the run within the spacing to never be submitted, got %d submissions", got)
+	}
+}
+
+func TestPlannerRunsNeverOverlap(t *testing.T) {
+	t.Parallel()
+
+	var running, peak, count int32
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	var wg sync.WaitGroup
+	p := New(&PlannerOpts{
+		WorkerTimeout: 10 * time.Second,
+		Clock:         NewFakeClock(time.Time{}),
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			defer wg.Done()
+			if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&peak) {
+				atomic.StoreInt32(&peak, n)
+			}
+			atomic.AddInt32(&count, 1)
+			started <- struct{}{}
+			<-release
+			atomic.AddInt32(&running, -1)
+			return 0, nil
+		},
+	})
+	defer p.Stop()
+	e := newExecutor()
+	p.active.Set("234783", e)
+
+	wg.Add(2)
+	p.runWorker(p.ctx, "234783")
+	<-started
+	// While the first run is in flight, new runs are coalesced into one, which
+	// runs after the first one finishes. stream.offline behaves the same.
+	p.runWorker(p.ctx, "234783")
+	p.runWorker(p.ctx, "234783")
+	p.OnStreamOffline(createEventStreamOffline("234783", "user"))
+	if !e.InFlight() {
+		t.Fatal("expected executor to have runs in flight")
+	}
+
+	close(release)
+	wg.Wait()
+
+	if peak != 1 {
+		t.Fatalf("expected runs to never overlap, got %d concurrent runs", peak)
+	}
+	if count != 2 {
+		t.Fatalf("count: got %d, want 2", count)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for e.InFlight() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected executor to have no runs in flight")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
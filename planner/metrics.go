@@ -0,0 +1,62 @@
+package planner
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	activeExecutorsDesc = prometheus.NewDesc(
+		"viewergraph_planner_active_executors",
+		"Number of active executors, i.e. channels being tracked.",
+		nil, nil,
+	)
+	poolQueueDepthDesc = prometheus.NewDesc(
+		"viewergraph_planner_pool_queue_depth",
+		"Number of worker runs waiting for a free worker.",
+		nil, nil,
+	)
+	poolRunningDesc = prometheus.NewDesc(
+		"viewergraph_planner_pool_running",
+		"Number of workers currently running.",
+		nil, nil,
+	)
+	poolPendingDesc = prometheus.NewDesc(
+		"viewergraph_planner_pool_pending",
+		"Number of worker runs waiting for the previous run of the same channel.",
+		nil, nil,
+	)
+	poolRunsDesc = prometheus.NewDesc(
+		"viewergraph_planner_pool_runs_total",
+		"Number of worker runs by outcome in the pool.",
+		[]string{"outcome"}, nil,
+	)
+)
+
+// Describe implements prometheus.Collector. The planner reports the metrics
+// derived from its state at scrape time. Register it with
+// prometheus.MustRegister(p).
+func (p *Planner) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeExecutorsDesc
+	ch <- poolQueueDepthDesc
+	ch <- poolRunningDesc
+	ch <- poolPendingDesc
+	ch <- poolRunsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (p *Planner) Collect(ch chan<- prometheus.Metric) {
+	st := p.PoolStats()
+	ch <- prometheus.MustNewConstMetric(activeExecutorsDesc, prometheus.GaugeValue, float64(p.active.Count()))
+	ch <- prometheus.MustNewConstMetric(poolQueueDepthDesc, prometheus.GaugeValue, float64(st.QueueDepth))
+	ch <- prometheus.MustNewConstMetric(poolRunningDesc, prometheus.GaugeValue, float64(st.Running))
+	ch <- prometheus.MustNewConstMetric(poolPendingDesc, prometheus.GaugeValue, float64(st.Pending))
+	for outcome, n := range map[string]uint64{
+		"submitted": st.Submitted,
+		"completed": st.Completed,
+		"skipped":   st.Skipped,
+		"dropped":   st.Dropped,
+		"expired":   st.Expired,
+	} {
+		ch <- prometheus.MustNewConstMetric(poolRunsDesc, prometheus.CounterValue, float64(n), outcome)
+	}
+}
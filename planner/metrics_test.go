@@ -0,0 +1,68 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pmrt/viewergraph/database"
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPlannerCollector(t *testing.T) {
+	t.Parallel()
+
+	p := New(&PlannerOpts{})
+	defer p.Stop()
+	p.active.Set("1", newExecutor())
+	p.active.Set("2", newExecutor())
+
+	want := `
+# HELP viewergraph_planner_active_executors Number of active executors, i.e. channels being tracked.
+# TYPE viewergraph_planner_active_executors gauge
+viewergraph_planner_active_executors 2
+`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(want), "viewergraph_planner_active_executors"); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(p); n != 9 {
+		t.Fatalf("expected 9 metrics, got %d", n)
+	}
+}
+
+func TestStreamBatcherMetrics(t *testing.T) {
+	t.Parallel()
+
+	fail := false
+	b := &StreamBatcher{
+		MaxQueueSize: 2,
+		Channel:      "TestStreamBatcherMetrics",
+		FlushFunc: func(ctx context.Context, sto database.Storage, queue []string, channel string) error {
+			if fail {
+				return errors.New("boom")
+			}
+			return nil
+		},
+	}
+	b.Enqueue("a")
+	b.Enqueue("b")
+	b.Enqueue("c")
+	fail = true
+	b.Flush()
+
+	tests := []struct {
+		name      string
+		got, want float64
+	}{
+		{"flushes", testutil.ToFloat64(metrics.BatcherFlushes.WithLabelValues(b.Channel)), 2},
+		{"flush errors", testutil.ToFloat64(metrics.BatcherFlushErrors.WithLabelValues(b.Channel)), 1},
+		{"rows inserted", testutil.ToFloat64(metrics.BatcherRowsInserted.WithLabelValues(b.Channel)), 2},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Fatalf("%s: got %f, want %f", test.name, test.got, test.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package planner
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -9,8 +10,13 @@ import (
 	"github.com/pmrt/viewergraph/config"
 	"github.com/pmrt/viewergraph/gen/vg/public/model"
 	"github.com/pmrt/viewergraph/helix"
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/tracing"
 	"github.com/rs/zerolog"
 	l "github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PlannerOpts struct {
@@ -21,22 +27,49 @@ type PlannerOpts struct {
 	WebhookSecret    string
 	WebhookPort      string
 
+	// TrackInterval is the default time between runs of the worker of a
+	// channel. See ChannelSettings.Interval.
 	TrackInterval      time.Duration
 	TrackOnlineTimeout time.Duration
 	WorkerTimeout      time.Duration
+	// MinRunSpacing is the minimum time between the start of two runs of the
+	// worker for the same channel. Runs within that time are skipped. 0
+	// disables the check.
+	MinRunSpacing time.Duration
+
+	// WorkerHost is the host the workers make requests to, i.e.: the host of
+	// the chatters endpoint. Used for per-host rate limiting.
+	WorkerHost string
+	// Pool configures the concurrency and rate limits of the workers.
+	Pool PoolOpts
+
+	// Scheduler decides the minute of the hour each channel's worker runs at.
+	// Defaults to HashScheduler. See LoadScheduler.
+	Scheduler Scheduler
+
+	// Clock is the time source of the planner, used for alignment, ticking
+	// and timeouts. Defaults to the system clock.
+	Clock Clock
 
 	// If this flag is true the executor will not sleep to align the cycle to the
-	// corresponding balanced minute. Useful for testing
+	// corresponding balanced minute. Useful for testing, although a FakeClock
+	// allows testing the alignment without sleeping
 	SkipAlign bool
 
 	// Hook to be called right before worker with the same parameters. Intended
 	// just for testing. It will be removed by compiler in release builds.
 	beforeWorkerTest func(ctx context.Context, bid string)
-	WorkerFunc       func(ctx context.Context, bid string)
+	// WorkerFunc tracks the channel `bid`. It returns the number of chatters
+	// found, which the planner keeps as the recent batch sizes of the channel.
+	WorkerFunc func(ctx context.Context, bid string) (chatters uint64, err error)
+	// RaidFunc stores the raids of the tracked channels to other channels.
+	// Optional.
+	RaidFunc func(ctx context.Context, evt *helix.EventChannelRaid) error
+	// SaveSettings persists the scheduling settings of a channel changed with
+	// UpdateSettings. A nil `intervalMinutes` means TrackInterval. Optional.
+	SaveSettings func(bid string, intervalMinutes *int32, priority int16) error
 }
 
-type endSig chan struct{}
-
 type Planner struct {
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -44,10 +77,23 @@ type Planner struct {
 	hx     *helix.Helix
 	sv     *fiber.App
 
-	// queue of channels to be tracked
+	// mu guards queue and started
+	mu sync.Mutex
+	// queue of channels to be subscribed to on Start
 	queue []*model.TrackedChannels
-	// active workers
-	active cmap.ConcurrentMap[endSig]
+	// started reports whether Start was called, so new channels are
+	// subscribed to right away
+	started bool
+	// tracked channels, whether they are live or not
+	channels cmap.ConcurrentMap[*model.TrackedChannels]
+	// active executors
+	active cmap.ConcurrentMap[*executor]
+	// recent batch sizes of the channels
+	history cmap.ConcurrentMap[*batchHistory]
+	// scheduling settings of the channels
+	settings cmap.ConcurrentMap[ChannelSettings]
+	// pool runs the workers of all the active executors
+	pool *workerPool
 }
 
 func (p *Planner) Start() error {
@@ -63,6 +109,7 @@ func (p *Planner) Start() error {
 
 	l.Debug().Msg("-> setting up webhook handlers")
 	p.hx.OnStreamOnline(p.OnStreamOnline)
+	p.hx.OnChannelRaid(p.OnChannelRaid)
 	// p.hx.OnStreamOffline()
 
 	p.sv.Post(
@@ -91,14 +138,16 @@ func (p *Planner) Start() error {
 //
 // Ensures only one online event per streamer is being run at the same time by
 // using the BroadcasterID as key in a concurrent sharded hash map which
-// contains a end channel to be closed upon stream.offline events.
+// contains the executor of the channel: a end channel to be closed upon
+// stream.offline events and the state of its worker runs.
 //
 // It aligns the cycle to a specific minute, so the worker is always run at
-// roughly the same minute. The minute depends on the broadcasterID, the
-// broadcasterID is hashed and balanced, distributed evenly across the minute
-// range [0,59]. Depending on the hash, the same broadcasterID will run the task
-// always at the same balanced minute. As a result, the workers will run at
-// balanced minutes throughtout the 60 possible minutes.
+// roughly the same minute. The minute is decided by the Scheduler. By default
+// the broadcasterID is hashed and balanced, distributed evenly across the
+// minute range [0,59]. Depending on the hash, the same broadcasterID will run
+// the task always at the same balanced minute. As a result, the workers will
+// run at balanced minutes throughtout the 60 possible minutes. LoadScheduler
+// also takes the size of the channels into account.
 //
 // Once the cycle started there is three ways to stop this goroutine: (1) By a
 // close signal in the general planner context which will stop all the active
@@ -106,34 +155,52 @@ func (p *Planner) Start() error {
 // goroutine receives a stream.offline event with the same BroadcasterID. (3) By
 // a timeout, this timeout is meant to close the channel if the stream has not
 // received a stream.offline event after an abnormally long time.
+//
+// The logger of the executor is carried by the context passed to the runs of
+// the worker. The span of the executor (planner.stream_online) is a child of
+// the span carried by the event and ends once the cycle starts, the runs
+// started by the ticker are new traces linked to it.
 func (p *Planner) OnStreamOnline(evt *helix.EventStreamOnline) {
-	ctx, cancel := context.WithTimeout(p.ctx, p.opts.TrackOnlineTimeout)
+	ctx, cancel := withTimeout(p.ctx, p.opts.Clock, p.opts.TrackOnlineTimeout)
 	defer cancel()
 
-	end := make(endSig, 1)
+	e := newExecutor()
 	bid, usr := evt.Broadcaster.ID, evt.Broadcaster.Login
+	e.login = usr
+	e.startedAt = p.opts.Clock.Now()
+	e.stream = evt.ID
 	l := l.With().
 		Str("context", "planner_executor").
 		Str("bid", bid).
 		Str("login", usr).
 		Logger()
+	ctx = l.WithContext(ctx)
+
+	ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(evt.Context()))
+	ctx, span := tracing.Start(ctx, "planner.stream_online", trace.WithAttributes(
+		attribute.String("bid", bid),
+		attribute.String("login", usr),
+	))
+	// ending a span more than once is a no-op
+	defer span.End()
 
 	l.Debug().Msg("started executor upon stream.online event")
 
-	if !p.active.SetIfAbsent(bid, end) {
+	if !p.active.SetIfAbsent(bid, e) {
 		l.Trace().Msg("-> duplicated worker found. Aborted executor")
 		return
 	}
 
-	// generate a uniform minute based on broadcaster ID
-	min := balancedKey(bid, 60)
+	// assign the minute of the channel, see PlannerOpts.Scheduler
+	min := p.opts.Scheduler.Assign(bid, p.load)
 	l.Trace().Msgf("-> balanced minute is %d", min)
 	if !p.opts.SkipAlign {
 		// waits for the next corresponding balanced minute so it aligns the cycle to
 		// the specific minute
-		d := untilMinute(int(min))
+		d := untilMinute(p.opts.Clock, int(min))
+		e.setNextRun(p.opts.Clock.Now().Add(d))
 		l.Debug().Msgf("-> align phase. Sleeping for %d", d)
-		time.Sleep(d)
+		p.opts.Clock.Sleep(d)
 	}
 
 	// If close signals were sent (ie. channels were closed) during our arbitrary
@@ -146,10 +213,8 @@ func (p *Planner) OnStreamOnline(evt *helix.EventStreamOnline) {
 	// again before starting the cycle where we would detect that the channel is
 	// closed.
 	//
-	// Note that there is still a possibility of duplicated workers if the
-	// stream.offline event arrives at the same time we are running the worker. If
-	// this becomes a problem in the future we could store the ts when the last
-	// worker started in the concurrent hashmap
+	// If the stream.offline event arrives at the same time we are running the
+	// worker, the executor ensures the runs do not overlap. See runWorker().
 	select {
 	case <-ctx.Done():
 		defer p.active.Remove(bid)
@@ -158,7 +223,7 @@ func (p *Planner) OnStreamOnline(evt *helix.EventStreamOnline) {
 		})
 		l.Debug().Msg("-> premature cancellation")
 		return
-	case <-end:
+	case <-e.end:
 		l.UpdateContext(func(c zerolog.Context) zerolog.Context {
 			return c.Str("reason", "end_signal")
 		})
@@ -168,10 +233,14 @@ func (p *Planner) OnStreamOnline(evt *helix.EventStreamOnline) {
 	}
 
 	l.Debug().Msg("-> started cycle")
-	ticker := time.NewTicker(p.opts.TrackInterval)
+	interval := p.interval(bid)
+	ticker := p.opts.Clock.NewTicker(interval)
 	defer ticker.Stop()
 
-	p.runWorker(ctx, l, bid)
+	p.runWorker(ctx, bid)
+	e.setNextRun(p.opts.Clock.Now().Add(interval))
+	span.End()
+	link := trace.Link{SpanContext: span.SpanContext()}
 	for {
 		select {
 		case <-ctx.Done():
@@ -181,14 +250,26 @@ func (p *Planner) OnStreamOnline(evt *helix.EventStreamOnline) {
 			})
 			l.Debug().Msg("-> ended cycle")
 			return
-		case <-end:
+		case <-e.end:
 			l.UpdateContext(func(c zerolog.Context) zerolog.Context {
 				return c.Str("reason", "end_signal")
 			})
 			l.Debug().Msg("-> ended cycle")
 			return
-		case <-ticker.C:
-			p.runWorker(ctx, l, bid)
+		case <-ticker.C():
+			tctx, tspan := tracing.Start(ctx, "planner.tick",
+				trace.WithNewRoot(),
+				trace.WithLinks(link),
+				trace.WithAttributes(attribute.String("bid", bid)),
+			)
+			p.runWorker(tctx, bid)
+			tspan.End()
+			e.setNextRun(p.opts.Clock.Now().Add(interval))
+		case interval = <-e.interval:
+			// interval changed at runtime, see SetSettings()
+			l.Debug().Msgf("-> interval changed to %s", interval)
+			ticker.Reset(interval)
+			e.setNextRun(p.opts.Clock.Now().Add(interval))
 		}
 	}
 }
@@ -199,28 +280,156 @@ func (p *Planner) OnStreamOffline(evt *helix.EventStreamOffline) {
 		Str("bid", evt.Broadcaster.ID).
 		Str("login", evt.Broadcaster.Login).
 		Logger()
+	ctx := l.WithContext(p.ctx)
+
+	ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(evt.Context()))
+	ctx, span := tracing.Start(ctx, "planner.stream_offline", trace.WithAttributes(
+		attribute.String("bid", evt.Broadcaster.ID),
+		attribute.String("login", evt.Broadcaster.Login),
+	))
+	defer span.End()
 
 	// Run worker one more time before closing
-	p.runWorker(p.ctx, l, evt.Broadcaster.ID)
-	if end, ok := p.active.Pop(evt.Broadcaster.ID); ok {
-		close(end)
+	p.runWorker(ctx, evt.Broadcaster.ID)
+	if e, ok := p.active.Pop(evt.Broadcaster.ID); ok {
+		close(e.end)
 	}
 }
 
-func (p *Planner) runWorker(ctx context.Context, l zerolog.Logger, bid string) {
-	// TODO - logger can be injected into context by parent contexts
+// OnChannelRaid is invoked by the channel.raid events of the tracked channels.
+// The raid is handed to the RaidFunc, if any.
+func (p *Planner) OnChannelRaid(evt *helix.EventChannelRaid) {
+	if p.opts.RaidFunc == nil {
+		return
+	}
+	l := l.With().
+		Str("context", "planner_raid_evt").
+		Str("from", evt.From.Login).
+		Str("to", evt.To.Login).
+		Logger()
+	if err := p.opts.RaidFunc(evt.Context(), evt); err != nil {
+		l.Error().Err(err).Msg("error while storing raid")
+	}
+}
+
+// runWorker submits a run of the worker for `bid` to the pool.
+//
+// Runs of the same channel never overlap: if the executor of the channel
+// started a run less than MinRunSpacing ago the new run is skipped, otherwise
+// if the previous run is still in flight the pool coalesces them, running the
+// new one after the previous finishes. See PoolOpts.Overlap.
+//
+// The logger and the span carried by `ctx` are passed to the worker, see
+// tracing.Logger().
+func (p *Planner) runWorker(ctx context.Context, bid string) {
+	p.submitRun(ctx, bid, p.opts.MinRunSpacing)
+}
+
+// submitRun is runWorker with an explicit minimum spacing between runs.
+func (p *Planner) submitRun(ctx context.Context, bid string, spacing time.Duration) {
+	l := tracing.Logger(ctx)
 	l.Trace().Msg("-> run worker")
-	ctx, cancel := context.WithTimeout(ctx, p.opts.WorkerTimeout)
-	if !config.IsProd {
-		if p.opts.beforeWorkerTest != nil {
-			p.opts.beforeWorkerTest(ctx, bid)
+
+	var done func()
+	e, ok := p.active.Get(bid)
+	if ok {
+		if !e.claim(p.opts.Clock.Now(), spacing) {
+			l.Debug().Msg("-> previous run started too recently. Skipped")
+			return
 		}
+		done = e.release
 	}
-	// The workers are run in a different goroutine so they don't delay the cycle
-	go func() {
+
+	// The workers are run by the pool in a different goroutine so they don't
+	// delay the cycle. The timeout starts when the worker starts, not while
+	// waiting in the queue.
+	if err := p.pool.Submit(ctx, bid, p.opts.WorkerHost, p.priority(bid), func(ctx context.Context) {
+		ctx, cancel := withTimeout(ctx, p.opts.Clock, p.opts.WorkerTimeout)
 		defer cancel()
-		p.opts.WorkerFunc(ctx, bid)
-	}()
+		ctx, span := tracing.Start(ctx, "planner.worker", trace.WithAttributes(
+			attribute.String("bid", bid),
+		))
+		defer span.End()
+		l := tracing.Logger(ctx)
+		if e != nil {
+			ctx = withSnapshot(ctx, &snapshot{stream: e.stream, ts: p.opts.Clock.Now()})
+		}
+
+		if !config.IsProd {
+			if p.opts.beforeWorkerTest != nil {
+				p.opts.beforeWorkerTest(ctx, bid)
+			}
+		}
+		start := time.Now()
+		n, err := p.opts.WorkerFunc(ctx, bid)
+		metrics.WorkerDuration.Observe(time.Since(start).Seconds())
+		if e != nil {
+			e.observe(n, err)
+		}
+		if err != nil {
+			metrics.WorkerRuns.WithLabelValues("failure").Inc()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			l.Error().Err(err).Msg("-> worker failed")
+			return
+		}
+		metrics.WorkerRuns.WithLabelValues("success").Inc()
+		span.SetAttributes(attribute.Int64("chatters", int64(n)))
+		p.observeBatch(bid, n)
+	}, done); err != nil {
+		l.Warn().Err(err).Msg("-> worker run not scheduled")
+	}
+}
+
+// minute returns the balanced minute of the channel `bid`, i.e. the minute
+// of the hour its worker runs at.
+func (p *Planner) minute(bid string) uint32 {
+	return p.opts.Scheduler.Minute(bid)
+}
+
+// load is the LoadFunc of the planner, the average of the recent batch sizes
+// of the channel.
+func (p *Planner) load(bid string) (uint64, bool) {
+	h, ok := p.history.Get(bid)
+	if !ok {
+		return 0, false
+	}
+	return h.Avg(), true
+}
+
+func (p *Planner) observeBatch(bid string, chatters uint64) {
+	var avg uint64
+	p.history.Upsert(bid, nil, func(exists bool, h, _ *batchHistory) *batchHistory {
+		if !exists {
+			h = &batchHistory{}
+		}
+		h.add(chatters)
+		avg = h.Avg()
+		return h
+	})
+	p.opts.Scheduler.Observe(bid, avg)
+}
+
+// Login returns the login of the broadcaster `bid`, from its active executor
+// or the tracked channels.
+func (p *Planner) Login(bid string) (string, bool) {
+	if e, ok := p.active.Get(bid); ok && e.login != "" {
+		return e.login, true
+	}
+	if ch, ok := p.channels.Get(bid); ok && ch.BroadcasterUsername != "" {
+		return ch.BroadcasterUsername, true
+	}
+	return "", false
+}
+
+// Helix returns the helix client of the planner, nil before Start.
+func (p *Planner) Helix() *helix.Helix {
+	return p.hx
+}
+
+// PoolStats returns a snapshot of the worker pool metrics.
+func (p *Planner) PoolStats() PoolStats {
+	return p.pool.Stats()
 }
 
 func (p *Planner) Stop() {
@@ -232,8 +441,15 @@ func (p *Planner) Stop() {
 	p.cancel()
 }
 
+// flush subscribes to the events of the queued channels. Channels tracked
+// afterwards are subscribed to by Track.
 func (p *Planner) flush() {
-	if p.queue == nil {
+	p.mu.Lock()
+	queue := p.queue
+	p.queue = nil
+	p.started = true
+	p.mu.Unlock()
+	if queue == nil {
 		return
 	}
 
@@ -241,63 +457,120 @@ func (p *Planner) flush() {
 		Str("context", "planner").
 		Logger()
 
-	l.Info().Msgf("flushing channel queue (%d)", len(p.queue))
-	for _, ch := range p.queue {
-		l.Debug().Msgf("-> req. subscription: %s (stream.online)", ch.BroadcasterID)
-		if err := p.hx.CreateEventSubSubscription(&helix.Subscription{
-			Type:    helix.SubStreamOnline,
-			Version: "1",
-			Condition: &helix.Condition{
-				BroadcasterUserID: ch.BroadcasterID,
-			},
-			Transport: &helix.Transport{
-				Method:   "webhook",
-				Callback: p.opts.WebhookServerURL + p.opts.WebhookEndpoint,
-				Secret:   p.opts.WebhookSecret,
-			},
-		}); err != nil {
-			l.Error().
-				Err(err).
-				Str("bid", ch.BroadcasterID).
-				Msg("error while subscribing to stream.online")
-		}
+	l.Info().Msgf("flushing channel queue (%d)", len(queue))
+	p.subscribe(queue)
+}
 
-		l.Debug().Msgf("-> req. subscription: %s (stream.offline)", ch.BroadcasterID)
-		if err := p.hx.CreateEventSubSubscription(&helix.Subscription{
-			Type:    helix.SubStreamOffline,
-			Version: "1",
-			Condition: &helix.Condition{
-				BroadcasterUserID: ch.BroadcasterID,
-			},
-			Transport: &helix.Transport{
-				Method:   "webhook",
-				Callback: p.opts.WebhookServerURL + p.opts.WebhookEndpoint,
-				Secret:   p.opts.WebhookSecret,
-			},
-		}); err != nil {
-			l.Error().
-				Err(err).
-				Str("bid", ch.BroadcasterID).
-				Msg("error while subscribing to stream.offline")
+// subscribe subscribes to the EventSub events of the channels `chs`
+func (p *Planner) subscribe(chs []*model.TrackedChannels) {
+	l := l.With().
+		Str("context", "planner").
+		Logger()
+
+	for _, ch := range chs {
+		for _, sub := range []struct {
+			typ  string
+			cond *helix.Condition
+		}{
+			{helix.SubStreamOnline, &helix.Condition{BroadcasterUserID: ch.BroadcasterID}},
+			{helix.SubStreamOffline, &helix.Condition{BroadcasterUserID: ch.BroadcasterID}},
+			{helix.SubChannelRaid, &helix.Condition{FromBroadcasterUserID: ch.BroadcasterID}},
+		} {
+			l.Debug().Msgf("-> req. subscription: %s (%s)", ch.BroadcasterID, sub.typ)
+			if err := p.hx.CreateEventSubSubscription(&helix.Subscription{
+				Type:      sub.typ,
+				Version:   "1",
+				Condition: sub.cond,
+				Transport: &helix.Transport{
+					Method:   "webhook",
+					Callback: p.opts.WebhookServerURL + p.opts.WebhookEndpoint,
+					Secret:   p.opts.WebhookSecret,
+				},
+			}); err != nil {
+				l.Error().
+					Err(err).
+					Str("bid", ch.BroadcasterID).
+					Msgf("error while subscribing to %s", sub.typ)
+			}
 		}
 	}
+}
 
-	p.queue = nil
+// Track adds the channels `tracked` to the planner, with their scheduling
+// settings. If the planner was started their events are subscribed to right
+// away, otherwise on Start. Channels already tracked are updated.
+func (p *Planner) Track(tracked []*model.TrackedChannels) {
+	for _, ch := range tracked {
+		p.channels.Set(ch.BroadcasterID, ch)
+		p.SetSettings(ch.BroadcasterID, SettingsFromModel(ch))
+	}
+
+	p.mu.Lock()
+	if !p.started {
+		p.queue = append(p.queue, tracked...)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	p.subscribe(tracked)
+}
+
+// OptsFromConfig returns the options of the planner configured by `c`. The
+// WorkerFunc and, for the load scheduler, the Scheduler must be set by the
+// caller since they depend on the storages.
+func OptsFromConfig(c *config.Config) *PlannerOpts {
+	opts := &PlannerOpts{
+		Creds: helix.ClientCreds{
+			ClientID:     c.Helix.ClientID,
+			ClientSecret: c.Helix.Secret,
+		},
+		WebhookServerURL:   c.Planner.WebhookServerURL,
+		WebhookEndpoint:    c.Planner.WebhookEndpoint,
+		WebhookSecret:      c.Planner.WebhookSecret,
+		WebhookPort:        c.Planner.WebhookPort,
+		TrackInterval:      c.Planner.TrackInterval,
+		TrackOnlineTimeout: c.Planner.TrackOnlineTimeout,
+		WorkerTimeout:      c.Planner.WorkerTimeout,
+		MinRunSpacing:      c.Planner.MinRunSpacing,
+		Pool: PoolOpts{
+			MaxConcurrency:        c.Planner.MaxConcurrency,
+			QueueSize:             c.Planner.QueueSize,
+			HostRequestsPerSecond: c.Planner.HostRequestsPerSecond,
+		},
+	}
+	if c.Planner.Scheduler == "hash" {
+		opts.Scheduler = HashScheduler{}
+	}
+	return opts
 }
 
 func New(opts *PlannerOpts) *Planner {
+	if opts.WorkerHost == "" {
+		opts.WorkerHost = "tmi.twitch.tv"
+	}
+	if opts.Scheduler == nil {
+		opts.Scheduler = HashScheduler{}
+	}
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Planner{
-		opts:   opts,
-		ctx:    ctx,
-		cancel: cancel,
-		sv:     fiber.New(),
-		active: cmap.NewWithConcurrencyLevel[endSig](32),
+		opts:     opts,
+		ctx:      ctx,
+		cancel:   cancel,
+		sv:       fiber.New(),
+		channels: cmap.NewWithConcurrencyLevel[*model.TrackedChannels](32),
+		active:   cmap.NewWithConcurrencyLevel[*executor](32),
+		history:  cmap.NewWithConcurrencyLevel[*batchHistory](32),
+		settings: cmap.NewWithConcurrencyLevel[ChannelSettings](32),
+		pool:     newWorkerPool(ctx, &opts.Pool),
 	}
 }
 
 func FromChannels(opts *PlannerOpts, tracked []*model.TrackedChannels) *Planner {
 	p := New(opts)
-	p.queue = tracked
+	p.Track(tracked)
 	return p
 }
@@ -20,7 +20,7 @@ func TestPlannerFlush(t *testing.T) {
 	tracked := []*model.TrackedChannels{
 		{BroadcasterID: "1"},
 	}
-	got := make([]*helix.Subscription, 0, len(tracked)*2)
+	got := make([]*helix.Subscription, 0, len(tracked)*3)
 	fakeTwitchEventSubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		b, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -78,6 +78,18 @@ func TestPlannerFlush(t *testing.T) {
 				Secret:   "fake-webhook-secret",
 			},
 		},
+		{
+			Type:    helix.SubChannelRaid,
+			Version: "1",
+			Condition: &helix.Condition{
+				FromBroadcasterUserID: "1",
+			},
+			Transport: &helix.Transport{
+				Method:   "webhook",
+				Callback: "http://localhost/webhook",
+				Secret:   "fake-webhook-secret",
+			},
+		},
 	}
 	if diff := deep.Equal(want, got); diff != nil {
 		t.Fatal(diff)
@@ -86,6 +98,24 @@ func TestPlannerFlush(t *testing.T) {
 	if p.queue != nil {
 		t.Fatal("expected channel queue to be empty")
 	}
+
+	// channels tracked after the flush are subscribed to right away
+	interval := int32(5)
+	p.Track([]*model.TrackedChannels{
+		{BroadcasterID: "2", BroadcasterUsername: "two", TrackIntervalMinutes: &interval},
+	})
+	if len(got) != 6 || got[3].Condition.BroadcasterUserID != "2" || got[5].Condition.FromBroadcasterUserID != "2" {
+		t.Fatalf("expected the subscriptions of the new channel, got %d", len(got))
+	}
+	if p.queue != nil {
+		t.Fatal("expected channel queue to be empty")
+	}
+	if login, ok := p.Login("2"); !ok || login != "two" {
+		t.Fatalf("expected the new channel to be tracked, got %q", login)
+	}
+	if s := p.Settings("2"); s.Interval != 5*time.Minute {
+		t.Fatalf("expected the settings of the new channel, got %+v", s)
+	}
 }
 
 func createEventStreamOnline(bid, login string) *helix.EventStreamOnline {
@@ -108,13 +138,21 @@ func createEventStreamOffline(bid, login string) *helix.EventStreamOffline {
 	}
 }
 
+func parseTime(timestr string) time.Time {
+	ts, err := time.Parse(time.RFC3339, timestr)
+	if err != nil {
+		panic(err)
+	}
+	return ts
+}
+
 // List of string numbers that hashed with FNV32 and mod 60 will be equal to 0
 // 234783 558010 293612 262375 92286 445402 544330 796494 31267
 func TestPlannerStopsWithTimeout(t *testing.T) {
 	before := func(ctx context.Context, bid string) {
 		t.Fatal("worker should never be executed")
 	}
-	worker := func(ctx context.Context, bid string) {
+	worker := func(ctx context.Context, bid string) (uint64, error) {
 		panic("worker should never be executed")
 	}
 
@@ -139,11 +177,12 @@ func TestPlannerStopsWithEnd(t *testing.T) {
 
 	var p *Planner
 	var count1 uint32
-	worker := func(ctx context.Context, bid string) {
+	worker := func(ctx context.Context, bid string) (uint64, error) {
 		defer wg.Done()
 		if bid == "234783" {
 			atomic.AddUint32(&count1, 1)
 		}
+		return 0, nil
 	}
 
 	p = New(&PlannerOpts{
@@ -184,12 +223,17 @@ func TestPlannerStopsWithCtxPlanner(t *testing.T) {
 
 	var p *Planner
 	var count1, count2 uint32
-	worker := func(ctx context.Context, bid string) {
+	// closed once the first worker has run, so the planner is not stopped by
+	// the second one before
+	ran := make(chan struct{})
+	worker := func(ctx context.Context, bid string) (uint64, error) {
 		defer wg.Done()
 		if bid == "234783" {
 			atomic.AddUint32(&count1, 1)
+			close(ran)
 		}
 		if bid == "558010" {
+			<-ran
 			if p != nil {
 				p.Stop()
 			}
@@ -199,6 +243,7 @@ func TestPlannerStopsWithCtxPlanner(t *testing.T) {
 				atomic.AddUint32(&count2, 1)
 			}
 		}
+		return 0, nil
 	}
 
 	p = New(&PlannerOpts{
@@ -227,7 +272,7 @@ func TestPlannerPreventDuplicates(t *testing.T) {
 	wg.Add(2)
 
 	var count1, count2 uint32
-	worker := func(ctx context.Context, bid string) {
+	worker := func(ctx context.Context, bid string) (uint64, error) {
 		if bid == "234783" {
 			atomic.AddUint32(&count1, 1)
 		}
@@ -235,6 +280,7 @@ func TestPlannerPreventDuplicates(t *testing.T) {
 			atomic.AddUint32(&count2, 1)
 		}
 		wg.Done()
+		return 0, nil
 	}
 
 	p := New(&PlannerOpts{
@@ -264,6 +310,125 @@ func TestPlannerPreventDuplicates(t *testing.T) {
 
 }
 
+func TestPlannerAlignsToBalancedMinute(t *testing.T) {
+	const bid = "36138196"
+	min := balancedKey(bid, 60)
+	if min == 0 {
+		t.Fatal("expected a bid with a balanced minute other than 0")
+	}
+
+	clock := NewFakeClock(parseTime("2022-06-22T15:00:00Z"))
+	ran := make(chan time.Time, 1)
+	p := New(&PlannerOpts{
+		TrackInterval:      time.Hour,
+		TrackOnlineTimeout: 24 * time.Hour,
+		WorkerTimeout:      time.Minute,
+		Clock:              clock,
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			ran <- clock.Now()
+			return 0, nil
+		},
+	})
+	defer p.Stop()
+
+	go p.OnStreamOnline(createEventStreamOnline(bid, "user"))
+	// online timeout and align phase
+	clock.BlockUntil(2)
+
+	clock.Advance(time.Duration(min-1) * time.Minute)
+	select {
+	case <-ran:
+		t.Fatal("worker must not run before the balanced minute")
+	default:
+	}
+
+	clock.Advance(time.Minute)
+	for i := 0; i < 3; i++ {
+		if got := (<-ran).Minute(); got != int(min) {
+			t.Fatalf("run %d: expected worker to run at minute %d, got %d", i, min, got)
+		}
+		clock.Advance(time.Hour)
+	}
+}
+
+func TestPlannerStopsWithTimeoutFakeClock(t *testing.T) {
+	clock := NewFakeClock(parseTime("2022-06-22T15:00:00Z"))
+	var count uint32
+	ran := make(chan struct{}, 1)
+	p := New(&PlannerOpts{
+		TrackInterval:      time.Hour,
+		TrackOnlineTimeout: 90 * time.Minute,
+		WorkerTimeout:      time.Minute,
+		Clock:              clock,
+		SkipAlign:          true,
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			atomic.AddUint32(&count, 1)
+			ran <- struct{}{}
+			return 0, nil
+		},
+	})
+	defer p.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		p.OnStreamOnline(createEventStreamOnline("234783", "user"))
+		close(done)
+	}()
+
+	<-ran
+	clock.Advance(time.Hour)
+	<-ran
+	clock.Advance(30 * time.Minute)
+	<-done
+
+	if count != 2 {
+		t.Fatalf("count: got %d, want 2", count)
+	}
+	if _, ok := p.active.Get("234783"); ok {
+		t.Fatal("expected executor to have no active cycle for bid 234783")
+	}
+}
+
+func TestPlannerEndSignalDuringAlign(t *testing.T) {
+	const bid = "36138196"
+	clock := NewFakeClock(parseTime("2022-06-22T15:00:00Z"))
+	var count uint32
+	ran := make(chan struct{}, 1)
+	p := New(&PlannerOpts{
+		TrackInterval:      time.Hour,
+		TrackOnlineTimeout: 24 * time.Hour,
+		WorkerTimeout:      time.Minute,
+		Clock:              clock,
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			atomic.AddUint32(&count, 1)
+			ran <- struct{}{}
+			return 0, nil
+		},
+	})
+	defer p.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		p.OnStreamOnline(createEventStreamOnline(bid, "user"))
+		close(done)
+	}()
+	// online timeout and align phase
+	clock.BlockUntil(2)
+
+	// stream.offline runs the worker one last time
+	p.OnStreamOffline(createEventStreamOffline(bid, "user"))
+	<-ran
+
+	// the executor wakes up at the balanced minute and aborts without running
+	// the worker
+	clock.Advance(time.Hour)
+	<-done
+
+	if count != 1 {
+		t.Fatalf("count: got %d, want 1", count)
+	}
+}
+
 func TestPlanner(t *testing.T) {
 	sv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -278,3 +443,60 @@ func TestPlanner(t *testing.T) {
 	// p := FromChannels(tracked)
 
 }
+
+func TestPlannerRunsCarryTheSnapshot(t *testing.T) {
+	clock := NewFakeClock(parseTime("2022-06-22T15:00:00Z"))
+	snapshots := make(chan *snapshot, 1)
+	p := New(&PlannerOpts{
+		TrackInterval:      time.Hour,
+		TrackOnlineTimeout: 90 * time.Minute,
+		WorkerTimeout:      time.Minute,
+		Clock:              clock,
+		SkipAlign:          true,
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			snapshots <- snapshotFrom(ctx)
+			return 0, nil
+		},
+	})
+	defer p.Stop()
+
+	evt := createEventStreamOnline("234783", "user")
+	evt.ID = "stream1"
+	go p.OnStreamOnline(evt)
+
+	want := []*snapshot{
+		{stream: "stream1", ts: parseTime("2022-06-22T15:00:00Z")},
+		{stream: "stream1", ts: parseTime("2022-06-22T16:00:00Z")},
+	}
+	for i, w := range want {
+		got := <-snapshots
+		if got.stream != w.stream || !got.ts.Equal(w.ts) {
+			t.Fatalf("run %d: got snapshot %+v, want %+v", i, got, w)
+		}
+		clock.Advance(time.Hour)
+	}
+}
+
+func TestPlannerOnChannelRaid(t *testing.T) {
+	evt := &helix.EventChannelRaid{
+		From:    &helix.Broadcaster{ID: "1", Login: "a"},
+		To:      &helix.Broadcaster{ID: "2", Login: "b"},
+		Viewers: 10,
+		Ts:      parseTime("2020-10-11T12:00:00Z"),
+	}
+
+	// without RaidFunc raids are ignored
+	New(&PlannerOpts{}).OnChannelRaid(evt)
+
+	var got *helix.EventChannelRaid
+	p := New(&PlannerOpts{
+		RaidFunc: func(ctx context.Context, evt *helix.EventChannelRaid) error {
+			got = evt
+			return nil
+		},
+	})
+	p.OnChannelRaid(evt)
+	if got != evt {
+		t.Fatalf("expected the raid to be handed to RaidFunc, got %+v", got)
+	}
+}
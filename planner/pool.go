@@ -0,0 +1,409 @@
+package planner
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrPoolQueueFull = errors.New("worker pool queue is full")
+	ErrRunInFlight   = errors.New("previous run is still in flight")
+)
+
+// OverlapPolicy decides what to do with a new run for a channel whose previous
+// run is still in flight (queued or running).
+type OverlapPolicy int
+
+const (
+	// OverlapQueue keeps the new run pending until the previous one finishes.
+	// Only one run can be pending per channel, newer runs replace (coalesce
+	// with) the pending one.
+	OverlapQueue OverlapPolicy = iota
+	// OverlapSkip drops the new run.
+	OverlapSkip
+)
+
+// Priority is the priority tier of a channel. Under load, the worker pool runs
+// the queued runs of higher priority first and drops the ones of lower
+// priority when the queue is full.
+type Priority int16
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+type PoolOpts struct {
+	// MaxConcurrency is the maximum number of workers running at the same time
+	// across all the channels.
+	MaxConcurrency int
+	// QueueSize is the maximum number of runs waiting for a free worker. When
+	// the queue is full, runs are dropped unless they have a higher priority
+	// than the lowest priority queued run, which is dropped instead.
+	QueueSize int
+	// HostRequestsPerSecond limits how many runs per second can be started
+	// against the same host. 0 means no limit.
+	HostRequestsPerSecond float64
+	// Overlap is the policy applied when a channel's previous run is still in
+	// flight.
+	Overlap OverlapPolicy
+}
+
+// PoolStats is a snapshot of the worker pool metrics.
+type PoolStats struct {
+	// QueueDepth is the number of runs waiting for a free worker.
+	QueueDepth int
+	// Running is the number of workers currently running.
+	Running int64
+	// Pending is the number of runs waiting for the previous run of the same
+	// channel to finish.
+	Pending int64
+
+	Submitted uint64
+	Completed uint64
+	Skipped   uint64
+	Dropped   uint64
+	Expired   uint64
+
+	// TotalWait is the accumulated time runs waited since they were submitted
+	// until they started, including the time waiting for the host rate limiter.
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// AvgWait returns the average time runs waited before starting.
+func (s PoolStats) AvgWait() time.Duration {
+	started := s.Completed + s.Expired
+	if started == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(started)
+}
+
+type job struct {
+	ctx         context.Context
+	bid, host   string
+	priority    Priority
+	fn          func(ctx context.Context)
+	done        func()
+	submittedAt time.Time
+	// seq keeps the order of submission among runs of the same priority
+	seq uint64
+}
+
+// finish calls the done callback of the job, if any.
+func (j *job) finish() {
+	if j.done != nil {
+		j.done()
+	}
+}
+
+// jobQueue is a priority queue of jobs, see container/heap. Jobs of higher
+// priority are popped first, FIFO within the same priority.
+type jobQueue []*job
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q jobQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *jobQueue) Push(x any) { *q = append(*q, x.(*job)) }
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	n := len(old)
+	j := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return j
+}
+
+// lowest returns the index of the job to be popped last.
+func (q jobQueue) lowest() int {
+	min := 0
+	for i := 1; i < len(q); i++ {
+		if q.Less(min, i) {
+			min = i
+		}
+	}
+	return min
+}
+
+// channelRuns tracks the in-flight run of a single channel.
+type channelRuns struct {
+	pending *job
+}
+
+// workerPool runs worker jobs with a global concurrency cap and a per-host
+// rate limit, ensuring there is at most one run in flight per channel.
+//
+// Jobs are queued in a bounded priority queue consumed by MaxConcurrency
+// goroutines, so no matter how many channels are aligned to the same minute
+// we never run more than MaxConcurrency workers at the same time.
+type workerPool struct {
+	opts *PoolOpts
+	// ready receives a token for every job pushed to the queue
+	ready chan struct{}
+
+	mu    sync.Mutex
+	queue jobQueue
+	seq   uint64
+	// channels in flight, keyed by broadcaster id
+	inflight map[string]*channelRuns
+	// rate limiters, keyed by host
+	limiters map[string]*rateLimiter
+
+	running, pending                                int64
+	submitted, completed, skipped, dropped, expired uint64
+	totalWait, maxWait                              int64
+}
+
+// Submit queues a run of `fn` for the channel `bid` against `host` with the
+// given `priority`. `fn` will receive `ctx`, runs whose `ctx` is done before
+// they start are discarded.
+//
+// `done` is optional. If provided it is called exactly once, when the run
+// finishes or when it is discarded for any reason, including when Submit
+// returns an error.
+//
+// It returns ErrRunInFlight if the run was skipped because of the overlap
+// policy and ErrPoolQueueFull if it was dropped because the queue is full.
+func (wp *workerPool) Submit(ctx context.Context, bid, host string, priority Priority, fn func(ctx context.Context), done func()) error {
+	atomic.AddUint64(&wp.submitted, 1)
+	j := &job{
+		ctx:         ctx,
+		bid:         bid,
+		host:        host,
+		priority:    priority,
+		fn:          fn,
+		done:        done,
+		submittedAt: time.Now(),
+	}
+
+	wp.mu.Lock()
+	if runs, ok := wp.inflight[bid]; ok {
+		if wp.opts.Overlap == OverlapSkip {
+			wp.mu.Unlock()
+			atomic.AddUint64(&wp.skipped, 1)
+			j.finish()
+			return ErrRunInFlight
+		}
+		prev := runs.pending
+		runs.pending = j
+		wp.mu.Unlock()
+
+		if prev == nil {
+			atomic.AddInt64(&wp.pending, 1)
+		} else {
+			// coalesced with the pending run
+			atomic.AddUint64(&wp.skipped, 1)
+			prev.finish()
+		}
+		return nil
+	}
+	wp.inflight[bid] = &channelRuns{}
+	wp.mu.Unlock()
+
+	return wp.enqueue(j)
+}
+
+func (wp *workerPool) enqueue(j *job) error {
+	wp.mu.Lock()
+	wp.seq++
+	j.seq = wp.seq
+	if wp.queue.Len() < wp.opts.QueueSize {
+		heap.Push(&wp.queue, j)
+		wp.mu.Unlock()
+		wp.ready <- struct{}{}
+		return nil
+	}
+
+	// The queue is full. Under load, a run of higher priority replaces the
+	// lowest priority queued run, otherwise the new run is dropped.
+	dropped := j
+	if i := wp.queue.lowest(); wp.queue[i].priority < j.priority {
+		dropped = heap.Remove(&wp.queue, i).(*job)
+		heap.Push(&wp.queue, j)
+	}
+	wp.mu.Unlock()
+
+	atomic.AddUint64(&wp.dropped, 1)
+	dropped.finish()
+	wp.done(dropped.bid)
+	if dropped == j {
+		return ErrPoolQueueFull
+	}
+	return nil
+}
+
+// done marks the run of `bid` as finished, enqueueing the pending run if any.
+func (wp *workerPool) done(bid string) {
+	wp.mu.Lock()
+	runs, ok := wp.inflight[bid]
+	if !ok {
+		wp.mu.Unlock()
+		return
+	}
+	next := runs.pending
+	if next == nil {
+		delete(wp.inflight, bid)
+		wp.mu.Unlock()
+		return
+	}
+	runs.pending = nil
+	wp.mu.Unlock()
+
+	atomic.AddInt64(&wp.pending, -1)
+	wp.enqueue(next)
+}
+
+func (wp *workerPool) limiter(host string) *rateLimiter {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	lim, ok := wp.limiters[host]
+	if !ok {
+		lim = newRateLimiter(wp.opts.HostRequestsPerSecond)
+		wp.limiters[host] = lim
+	}
+	return lim
+}
+
+func (wp *workerPool) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wp.ready:
+			wp.mu.Lock()
+			j := heap.Pop(&wp.queue).(*job)
+			wp.mu.Unlock()
+			wp.run(j)
+		}
+	}
+}
+
+func (wp *workerPool) run(j *job) {
+	defer wp.done(j.bid)
+	defer j.finish()
+
+	if err := wp.limiter(j.host).Wait(j.ctx); err != nil {
+		atomic.AddUint64(&wp.expired, 1)
+		wp.observeWait(time.Since(j.submittedAt))
+		return
+	}
+	wp.observeWait(time.Since(j.submittedAt))
+	if j.ctx.Err() != nil {
+		atomic.AddUint64(&wp.expired, 1)
+		return
+	}
+
+	atomic.AddInt64(&wp.running, 1)
+	j.fn(j.ctx)
+	atomic.AddInt64(&wp.running, -1)
+	atomic.AddUint64(&wp.completed, 1)
+}
+
+func (wp *workerPool) observeWait(d time.Duration) {
+	atomic.AddInt64(&wp.totalWait, int64(d))
+	for {
+		max := atomic.LoadInt64(&wp.maxWait)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&wp.maxWait, max, int64(d)) {
+			return
+		}
+	}
+}
+
+func (wp *workerPool) Stats() PoolStats {
+	wp.mu.Lock()
+	depth := wp.queue.Len()
+	wp.mu.Unlock()
+	return PoolStats{
+		QueueDepth: depth,
+		Running:    atomic.LoadInt64(&wp.running),
+		Pending:    atomic.LoadInt64(&wp.pending),
+		Submitted:  atomic.LoadUint64(&wp.submitted),
+		Completed:  atomic.LoadUint64(&wp.completed),
+		Skipped:    atomic.LoadUint64(&wp.skipped),
+		Dropped:    atomic.LoadUint64(&wp.dropped),
+		Expired:    atomic.LoadUint64(&wp.expired),
+		TotalWait:  time.Duration(atomic.LoadInt64(&wp.totalWait)),
+		MaxWait:    time.Duration(atomic.LoadInt64(&wp.maxWait)),
+	}
+}
+
+// newWorkerPool creates a worker pool and starts its workers. The workers stop
+// when `ctx` is done.
+func newWorkerPool(ctx context.Context, opts *PoolOpts) *workerPool {
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = 32
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+
+	wp := &workerPool{
+		opts:     opts,
+		ready:    make(chan struct{}, opts.QueueSize),
+		inflight: make(map[string]*channelRuns),
+		limiters: make(map[string]*rateLimiter),
+	}
+	for i := 0; i < opts.MaxConcurrency; i++ {
+		go wp.work(ctx)
+	}
+	return wp
+}
+
+// rateLimiter spaces events evenly so no more than `rps` events per second
+// are allowed. It does not allow bursts.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// Wait blocks until the next event is allowed or `ctx` is done.
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	if r.interval == 0 {
+		return ctx.Err()
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	d := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mu.Unlock()
+
+	if d == 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func newRateLimiter(rps float64) *rateLimiter {
+	r := &rateLimiter{}
+	if rps > 0 {
+		r.interval = time.Duration(float64(time.Second) / rps)
+	}
+	return r
+}
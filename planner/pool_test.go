@@ -0,0 +1,314 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func TestPoolConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+
+	const (
+		max      = 3
+		channels = 20
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp := newWorkerPool(ctx, &PoolOpts{MaxConcurrency: max})
+
+	var wg sync.WaitGroup
+	var running, peak int64
+	wg.Add(channels)
+	for i := 0; i < channels; i++ {
+		err := wp.Submit(ctx, strconv.Itoa(i), "host", PriorityNormal, func(ctx context.Context) {
+			defer wg.Done()
+			n := atomic.AddInt64(&running, 1)
+			for {
+				p := atomic.LoadInt64(&peak)
+				if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt64(&running, -1)
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	if peak > max {
+		t.Fatalf("expected at most %d concurrent workers, got %d", max, peak)
+	}
+	if got := wp.Stats().Completed; got != channels {
+		t.Fatalf("completed: got %d, want %d", got, channels)
+	}
+}
+
+func TestPoolOverlapSkip(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp := newWorkerPool(ctx, &PoolOpts{Overlap: OverlapSkip})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := wp.Submit(ctx, "1", "host", PriorityNormal, func(ctx context.Context) {
+		close(started)
+		<-release
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	err := wp.Submit(ctx, "1", "host", PriorityNormal, func(ctx context.Context) {
+		t.Error("skipped run should never be executed")
+	}, nil)
+	if !errors.Is(err, ErrRunInFlight) {
+		t.Fatalf("expected ErrRunInFlight, got %v", err)
+	}
+	close(release)
+
+	if got := wp.Stats().Skipped; got != 1 {
+		t.Fatalf("skipped: got %d, want 1", got)
+	}
+}
+
+func TestPoolOverlapQueueCoalesces(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp := newWorkerPool(ctx, &PoolOpts{Overlap: OverlapQueue})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := wp.Submit(ctx, "1", "host", PriorityNormal, func(ctx context.Context) {
+		close(started)
+		<-release
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	var runs []int
+	var mu sync.Mutex
+	done := make(chan struct{})
+	for i := 1; i <= 3; i++ {
+		i := i
+		if err := wp.Submit(ctx, "1", "host", PriorityNormal, func(ctx context.Context) {
+			mu.Lock()
+			runs = append(runs, i)
+			mu.Unlock()
+			close(done)
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := wp.Stats().Pending; got != 1 {
+		t.Fatalf("pending: got %d, want 1", got)
+	}
+	close(release)
+	<-done
+
+	// only the last pending run is executed, after the first one finished
+	mu.Lock()
+	defer mu.Unlock()
+	if len(runs) != 1 || runs[0] != 3 {
+		t.Fatalf("expected only the last run to be executed, got %v", runs)
+	}
+}
+
+func TestPoolQueueFull(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp := newWorkerPool(ctx, &PoolOpts{MaxConcurrency: 1, QueueSize: 1})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := wp.Submit(ctx, "1", "host", PriorityNormal, func(ctx context.Context) {
+		close(started)
+		<-release
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	defer close(release)
+
+	// fills the queue
+	if err := wp.Submit(ctx, "2", "host", PriorityNormal, func(ctx context.Context) {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	err := wp.Submit(ctx, "3", "host", PriorityNormal, func(ctx context.Context) {
+		t.Error("dropped run should never be executed")
+	}, nil)
+	if !errors.Is(err, ErrPoolQueueFull) {
+		t.Fatalf("expected ErrPoolQueueFull, got %v", err)
+	}
+
+	st := wp.Stats()
+	if st.Dropped != 1 || st.QueueDepth != 1 {
+		t.Fatalf("got dropped=%d queue_depth=%d, want dropped=1 queue_depth=1", st.Dropped, st.QueueDepth)
+	}
+}
+
+func TestPoolHostRateLimit(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rps  = 20
+		runs = 5
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp := newWorkerPool(ctx, &PoolOpts{HostRequestsPerSecond: rps})
+
+	var wg sync.WaitGroup
+	wg.Add(runs)
+	start := time.Now()
+	for i := 0; i < runs; i++ {
+		if err := wp.Submit(ctx, strconv.Itoa(i), "host", PriorityNormal, func(ctx context.Context) {
+			wg.Done()
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	// the first run is allowed immediately, the rest are spaced 1/rps
+	want := time.Duration(runs-1) * time.Second / rps
+	if got := time.Since(start); got < want {
+		t.Fatalf("expected runs to take at least %s, took %s", want, got)
+	}
+	if st := wp.Stats(); st.MaxWait < want-time.Second/rps {
+		t.Fatalf("expected max wait to reflect the rate limit, got %s", st.MaxWait)
+	}
+}
+
+func TestPoolDiscardsExpiredRuns(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp := newWorkerPool(ctx, &PoolOpts{MaxConcurrency: 1})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := wp.Submit(ctx, "1", "host", PriorityNormal, func(ctx context.Context) {
+		close(started)
+		<-release
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	jctx, jcancel := context.WithCancel(ctx)
+	if err := wp.Submit(jctx, "2", "host", PriorityNormal, func(ctx context.Context) {
+		t.Error("expired run should never be executed")
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	jcancel()
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for wp.Stats().Expired != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected run to expire")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolRunsHigherPriorityFirst(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp := newWorkerPool(ctx, &PoolOpts{MaxConcurrency: 1})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := wp.Submit(ctx, "0", "host", PriorityNormal, func(ctx context.Context) {
+		close(started)
+		<-release
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	submit := func(bid string, prio Priority) {
+		wg.Add(1)
+		if err := wp.Submit(ctx, bid, "host", prio, func(ctx context.Context) {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, bid)
+			mu.Unlock()
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	submit("low", PriorityLow)
+	submit("normal1", PriorityNormal)
+	submit("high", PriorityHigh)
+	submit("normal2", PriorityNormal)
+	close(release)
+	wg.Wait()
+
+	want := []string{"high", "normal1", "normal2", "low"}
+	if diff := deep.Equal(order, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestPoolQueueFullEvictsLowerPriority(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp := newWorkerPool(ctx, &PoolOpts{MaxConcurrency: 1, QueueSize: 1})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := wp.Submit(ctx, "1", "host", PriorityNormal, func(ctx context.Context) {
+		close(started)
+		<-release
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	evicted := make(chan struct{})
+	if err := wp.Submit(ctx, "2", "host", PriorityLow, func(ctx context.Context) {
+		t.Error("evicted run should never be executed")
+	}, func() { close(evicted) }); err != nil {
+		t.Fatal(err)
+	}
+	ran := make(chan struct{})
+	if err := wp.Submit(ctx, "3", "host", PriorityHigh, func(ctx context.Context) {
+		close(ran)
+	}, nil); err != nil {
+		t.Fatalf("expected the high priority run to be queued, got %v", err)
+	}
+	<-evicted
+	close(release)
+	<-ran
+
+	if got := wp.Stats().Dropped; got != 1 {
+		t.Fatalf("dropped: got %d, want 1", got)
+	}
+}
@@ -0,0 +1,141 @@
+package planner
+
+import (
+	"sync"
+
+	"github.com/pmrt/viewergraph/utils"
+)
+
+// BatchHistorySize is the number of recent batch sizes kept per channel.
+const BatchHistorySize = 8
+
+// batchHistory is a ring buffer with the most recent batch sizes (number of
+// chatters) of a channel.
+type batchHistory struct {
+	mu    sync.Mutex
+	sizes [BatchHistorySize]uint64
+	n     int
+	next  int
+}
+
+func (h *batchHistory) add(size uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sizes[h.next] = size
+	h.next = (h.next + 1) % BatchHistorySize
+	if h.n < BatchHistorySize {
+		h.n++
+	}
+}
+
+// Avg returns the average of the recent batch sizes.
+func (h *batchHistory) Avg() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.n == 0 {
+		return 0
+	}
+	var t uint64
+	for i := 0; i < h.n; i++ {
+		t += h.sizes[i]
+	}
+	return t / uint64(h.n)
+}
+
+// Last returns the most recent batch size.
+func (h *batchHistory) Last() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.n == 0 {
+		return 0
+	}
+	return h.sizes[(h.next+BatchHistorySize-1)%BatchHistorySize]
+}
+
+type MinuteBucket struct {
+	Minute int `json:"minute"`
+	// Assigned is the number of channels whose balanced minute is Minute
+	Assigned int `json:"assigned"`
+	// Live is the number of assigned channels with an active executor
+	Live int `json:"live"`
+	// ExpectedChatters is the sum of the average recent batch sizes of the live
+	// channels, i.e. the chatters we expect to fetch and insert at Minute
+	ExpectedChatters uint64 `json:"expected_chatters"`
+}
+
+// ScheduleReport describes how evenly the workers are distributed across the
+// minutes of the hour. CVs are coefficients of variation in %, the lower the
+// more even the distribution is.
+type ScheduleReport struct {
+	Buckets []MinuteBucket `json:"buckets"`
+
+	Assigned         int    `json:"assigned"`
+	Live             int    `json:"live"`
+	ExpectedChatters uint64 `json:"expected_chatters"`
+
+	AssignedCV float64 `json:"assigned_cv"`
+	LiveCV     float64 `json:"live_cv"`
+	ChattersCV float64 `json:"chatters_cv"`
+}
+
+// ScheduleReport reports, per minute bucket, how many channels are assigned
+// and live and the expected chatter volume based on the recent batch sizes.
+func (p *Planner) ScheduleReport() *ScheduleReport {
+	r := &ScheduleReport{
+		Buckets: make([]MinuteBucket, 60),
+	}
+	for i := range r.Buckets {
+		r.Buckets[i].Minute = i
+	}
+
+	// Channels can be active without being tracked (e.g.: events received for
+	// channels added after the planner started), so assigned channels are the
+	// union of both
+	assigned := make(map[string]struct{}, p.channels.Count())
+	for _, bid := range p.channels.Keys() {
+		assigned[bid] = struct{}{}
+	}
+	for _, bid := range p.active.Keys() {
+		assigned[bid] = struct{}{}
+	}
+
+	for bid := range assigned {
+		b := &r.Buckets[p.minute(bid)]
+		b.Assigned++
+		r.Assigned++
+		if !p.active.Has(bid) {
+			continue
+		}
+		b.Live++
+		r.Live++
+		if h, ok := p.history.Get(bid); ok {
+			avg := h.Avg()
+			b.ExpectedChatters += avg
+			r.ExpectedChatters += avg
+		}
+	}
+
+	assignedSet := make([]int, 60)
+	liveSet := make([]int, 60)
+	chattersSet := make([]int, 60)
+	for i, b := range r.Buckets {
+		assignedSet[i] = b.Assigned
+		liveSet[i] = b.Live
+		chattersSet[i] = int(b.ExpectedChatters)
+	}
+	r.AssignedCV = cv(assignedSet)
+	r.LiveCV = cv(liveSet)
+	r.ChattersCV = cv(chattersSet)
+	return r
+}
+
+// cv is utils.CV for the entire population but returns 0 for empty sets
+// instead of NaN, so the result can be encoded as JSON.
+func cv(s []int) float64 {
+	for _, n := range s {
+		if n != 0 {
+			return utils.CV(s, false)
+		}
+	}
+	return 0
+}
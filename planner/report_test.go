@@ -0,0 +1,94 @@
+package planner
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+)
+
+func TestBatchHistory(t *testing.T) {
+	t.Parallel()
+
+	h := &batchHistory{}
+	if h.Avg() != 0 || h.Last() != 0 {
+		t.Fatal("expected empty history to have avg=0 and last=0")
+	}
+
+	h.add(10)
+	h.add(20)
+	if got, want := h.Avg(), uint64(15); got != want {
+		t.Fatalf("avg: got %d, want %d", got, want)
+	}
+
+	// overflow the ring buffer, only the most recent sizes are kept
+	for i := 0; i < BatchHistorySize; i++ {
+		h.add(100)
+	}
+	h.add(200)
+	if got, want := h.Last(), uint64(200); got != want {
+		t.Fatalf("last: got %d, want %d", got, want)
+	}
+	if got, want := h.Avg(), uint64((100*(BatchHistorySize-1)+200)/BatchHistorySize); got != want {
+		t.Fatalf("avg: got %d, want %d", got, want)
+	}
+}
+
+func TestScheduleReport(t *testing.T) {
+	t.Parallel()
+
+	// balanced minutes: "1" => 6, "2" => 5, "36138196" => 10, "234783" => 0
+	p := FromChannels(&PlannerOpts{}, []*model.TrackedChannels{
+		{BroadcasterID: "1"},
+		{BroadcasterID: "2"},
+		{BroadcasterID: "36138196"},
+	})
+	defer p.Stop()
+
+	p.active.Set("1", newExecutor())
+	p.active.Set("234783", newExecutor())
+	p.observeBatch("1", 100)
+	p.observeBatch("1", 300)
+	// not live, must not count towards the expected chatters
+	p.observeBatch("2", 1000)
+
+	r := p.ScheduleReport()
+
+	got := make(map[int]MinuteBucket)
+	for _, b := range r.Buckets {
+		if b.Assigned != 0 {
+			got[b.Minute] = b
+		}
+	}
+	want := map[int]MinuteBucket{
+		0:  {Minute: 0, Assigned: 1, Live: 1},
+		5:  {Minute: 5, Assigned: 1},
+		6:  {Minute: 6, Assigned: 1, Live: 1, ExpectedChatters: 200},
+		10: {Minute: 10, Assigned: 1},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	if r.Assigned != 4 || r.Live != 2 || r.ExpectedChatters != 200 {
+		t.Fatalf("got assigned=%d live=%d expected_chatters=%d, want 4, 2, 200", r.Assigned, r.Live, r.ExpectedChatters)
+	}
+	if r.AssignedCV <= 0 || r.LiveCV <= 0 || r.ChattersCV <= 0 {
+		t.Fatal("expected uneven distributions to have CV > 0")
+	}
+}
+
+func TestScheduleReportEmpty(t *testing.T) {
+	t.Parallel()
+
+	p := New(&PlannerOpts{})
+	defer p.Stop()
+
+	r := p.ScheduleReport()
+	if len(r.Buckets) != 60 {
+		t.Fatalf("expected 60 buckets, got %d", len(r.Buckets))
+	}
+	if r.AssignedCV != 0 || r.LiveCV != 0 || r.ChattersCV != 0 {
+		t.Fatal("expected empty distributions to have CV = 0")
+	}
+}
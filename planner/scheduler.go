@@ -0,0 +1,182 @@
+package planner
+
+import (
+	"sync"
+
+	l "github.com/rs/zerolog/log"
+)
+
+// LoadFunc returns the expected load of the channel `bid`, i.e. the recent
+// average of chatters per batch. ok is false if the channel has no history.
+type LoadFunc func(bid string) (load uint64, ok bool)
+
+// Scheduler decides the minute of the hour at which the worker of each
+// channel runs.
+type Scheduler interface {
+	// Assign returns the minute of `bid`, assigning one if the channel has
+	// none yet. `load` reports the expected load of any channel.
+	Assign(bid string, load LoadFunc) uint32
+	// Minute returns the minute of `bid` without assigning a new one.
+	Minute(bid string) uint32
+	// Observe reports the current expected load of `bid`, i.e. after each
+	// batch.
+	Observe(bid string, load uint64)
+}
+
+// HashScheduler distributes the channels across the minutes of the hour by
+// hashing their broadcaster ID. It ignores the size of the channels.
+type HashScheduler struct{}
+
+func (HashScheduler) Assign(bid string, _ LoadFunc) uint32 {
+	return balancedKey(bid, 60)
+}
+
+func (HashScheduler) Minute(bid string) uint32 {
+	return balancedKey(bid, 60)
+}
+
+func (HashScheduler) Observe(string, uint64) {}
+
+// LoadScheduler assigns the minutes based on the recent chatter counts of the
+// channels, minimising the peak per-minute load: each channel with a known
+// load is assigned to the least loaded minute, preferring its hashed minute on
+// ties. The load of a minute counts every channel running at it, the assigned
+// ones and the ones still at their hashed minute.
+//
+// Assignments are stable, once a channel has a minute it keeps it. Pass the
+// persisted assignments and loads to Restore and persist new ones with
+// OnAssign and OnObserve so they survive restarts. The persisted load of a
+// channel is used until the planner has history for it. Channels without a
+// known load fall back to their hashed minute and are assigned once their load
+// is known.
+type LoadScheduler struct {
+	mu       sync.Mutex
+	assigned map[string]uint32
+	// estimates are the last observed loads, see Observe
+	estimates map[string]uint64
+	// hashed are the channels running at their hashed minute, i.e. the ones
+	// without a known load when they were scheduled
+	hashed map[string]struct{}
+	// OnAssign is called with every new assignment, e.g. to persist it
+	OnAssign func(bid string, minute uint32) error
+	// OnObserve is called with every observed load, e.g. to persist it
+	OnObserve func(bid string, load uint64) error
+}
+
+func (s *LoadScheduler) Assign(bid string, load LoadFunc) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if min, ok := s.assigned[bid]; ok {
+		return min
+	}
+	hashed := balancedKey(bid, 60)
+	if _, ok := s.load(bid, load); !ok {
+		s.hashed[bid] = struct{}{}
+		return hashed
+	}
+	delete(s.hashed, bid)
+
+	var loads [60]uint64
+	for b, m := range s.assigned {
+		n, _ := s.load(b, load)
+		loads[m] += n
+	}
+	for b := range s.hashed {
+		n, _ := s.load(b, load)
+		loads[balancedKey(b, 60)] += n
+	}
+	min := hashed
+	for m := uint32(0); m < 60; m++ {
+		if loads[m] < loads[min] {
+			min = m
+		}
+	}
+	s.assigned[bid] = min
+
+	if s.OnAssign != nil {
+		if err := s.OnAssign(bid, min); err != nil {
+			l.Error().
+				Err(err).
+				Str("context", "planner_scheduler").
+				Str("bid", bid).
+				Msg("error while persisting schedule minute")
+		}
+	}
+	return min
+}
+
+// load returns the expected load of `bid` from `load` or, if the channel has
+// no history, its last observed load. Must be called with the lock held.
+func (s *LoadScheduler) load(bid string, load LoadFunc) (uint64, bool) {
+	if load != nil {
+		if n, ok := load(bid); ok {
+			return n, true
+		}
+	}
+	n, ok := s.estimates[bid]
+	return n, ok
+}
+
+func (s *LoadScheduler) Minute(bid string) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if min, ok := s.assigned[bid]; ok {
+		return min
+	}
+	return balancedKey(bid, 60)
+}
+
+func (s *LoadScheduler) Observe(bid string, load uint64) {
+	s.mu.Lock()
+	s.estimates[bid] = load
+	s.mu.Unlock()
+
+	if s.OnObserve != nil {
+		if err := s.OnObserve(bid, load); err != nil {
+			l.Error().
+				Err(err).
+				Str("context", "planner_scheduler").
+				Str("bid", bid).
+				Msg("error while persisting schedule load")
+		}
+	}
+}
+
+// Assignments returns a copy of the current assignments.
+func (s *LoadScheduler) Assignments() map[string]uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[string]uint32, len(s.assigned))
+	for bid, min := range s.assigned {
+		m[bid] = min
+	}
+	return m
+}
+
+// Restore sets the `assigned` minutes and the last observed `loads`, e.g. the
+// ones persisted in tracked_channels.schedule_minute and schedule_load. They
+// replace the current ones of the same channels.
+func (s *LoadScheduler) Restore(assigned map[string]uint32, loads map[string]uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for bid, min := range assigned {
+		s.assigned[bid] = min % 60
+		delete(s.hashed, bid)
+	}
+	for bid, n := range loads {
+		s.estimates[bid] = n
+	}
+}
+
+// NewLoadScheduler returns a LoadScheduler restoring the `assigned` minutes,
+// see Restore.
+func NewLoadScheduler(assigned map[string]uint32) *LoadScheduler {
+	s := &LoadScheduler{
+		assigned:  make(map[string]uint32, len(assigned)),
+		estimates: make(map[string]uint64),
+		hashed:    make(map[string]struct{}),
+	}
+	s.Restore(assigned, nil)
+	return s
+}
@@ -0,0 +1,161 @@
+package planner
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestLoadSchedulerFallsBackToHash(t *testing.T) {
+	t.Parallel()
+
+	var persisted int
+	s := NewLoadScheduler(nil)
+	s.OnAssign = func(bid string, minute uint32) error {
+		persisted++
+		return nil
+	}
+	noHistory := func(bid string) (uint64, bool) { return 0, false }
+
+	// balanced minutes: "1" => 6, "36138196" => 10
+	if got := s.Assign("1", noHistory); got != 6 {
+		t.Fatalf("got minute %d, want 6", got)
+	}
+	if got := s.Assign("36138196", nil); got != 10 {
+		t.Fatalf("got minute %d, want 10", got)
+	}
+	if persisted != 0 || len(s.Assignments()) != 0 {
+		t.Fatal("expected channels without history to not be assigned")
+	}
+}
+
+func TestLoadSchedulerAvoidsCollisions(t *testing.T) {
+	t.Parallel()
+
+	loads := map[string]uint64{
+		"1": 100000,
+		"2": 100000,
+	}
+	load := func(bid string) (uint64, bool) {
+		n, ok := loads[bid]
+		return n, ok
+	}
+	// "1" and the channel with the forced minute collide with the same
+	// hashed minute
+	s := NewLoadScheduler(map[string]uint32{"2": 6})
+	persisted := make(map[string]uint32)
+	s.OnAssign = func(bid string, minute uint32) error {
+		persisted[bid] = minute
+		return nil
+	}
+
+	min := s.Assign("1", load)
+	if min == 6 {
+		t.Fatal("expected the channel to be moved away from the loaded minute")
+	}
+	if diff := deep.Equal(persisted, map[string]uint32{"1": min}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// assignments are stable even if the load changes
+	loads["2"] = 0
+	if got := s.Assign("1", load); got != min {
+		t.Fatalf("got minute %d, want stable minute %d", got, min)
+	}
+	if got := s.Minute("2"); got != 6 {
+		t.Fatalf("got restored minute %d, want 6", got)
+	}
+
+	// assignments restored later replace the current ones
+	s.Restore(map[string]uint32{"1": 70, "3": 12}, nil)
+	if diff := deep.Equal(s.Assignments(), map[string]uint32{"1": 10, "2": 6, "3": 12}); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadSchedulerPrefersHashOnTies(t *testing.T) {
+	t.Parallel()
+
+	s := NewLoadScheduler(nil)
+	load := func(bid string) (uint64, bool) { return 10, true }
+	if got := s.Assign("36138196", load); got != 10 {
+		t.Fatalf("got minute %d, want hashed minute 10", got)
+	}
+}
+
+func TestLoadSchedulerReducesPeak(t *testing.T) {
+	t.Parallel()
+
+	const channels = 600
+	rnd := rand.New(rand.NewSource(1))
+	loads := make(map[string]uint64, channels)
+	for i := 0; i < channels; i++ {
+		// a few big channels and a long tail of small ones
+		n := uint64(rnd.ExpFloat64() * 1000)
+		if i%50 == 0 {
+			n = 100000
+		}
+		loads[strconv.Itoa(i)] = n
+	}
+	load := func(bid string) (uint64, bool) {
+		n, ok := loads[bid]
+		return n, ok
+	}
+
+	var hashed, balanced [60]uint64
+	s := NewLoadScheduler(nil)
+	for i := 0; i < channels; i++ {
+		bid := strconv.Itoa(i)
+		hashed[HashScheduler{}.Assign(bid, load)] += loads[bid]
+		balanced[s.Assign(bid, load)] += loads[bid]
+	}
+
+	peak := func(b [60]uint64) (max uint64) {
+		for _, n := range b {
+			if n > max {
+				max = n
+			}
+		}
+		return max
+	}
+	if peak(balanced) >= peak(hashed) {
+		t.Fatalf("expected a lower peak than hashing, got %d, hashing %d", peak(balanced), peak(hashed))
+	}
+}
+
+func TestLoadSchedulerAfterRestore(t *testing.T) {
+	t.Parallel()
+
+	// after a restart the planner has no history, the scheduler only knows
+	// the persisted minutes and loads.
+	// balanced minutes: "1" => 6, "188" => 6, "2" => 5, "36138196" => 10
+	s := NewLoadScheduler(nil)
+	s.Restore(
+		map[string]uint32{"2": 0},
+		map[string]uint64{"2": 100000, "36138196": 50000, "188": 1000},
+	)
+	noHistory := func(bid string) (uint64, bool) { return 0, false }
+
+	// "36138196" has no minute yet but a persisted load, it's assigned
+	if got := s.Assign("36138196", noHistory); got != 10 {
+		t.Fatalf("got minute %d, want 10", got)
+	}
+	// "1" is unknown, it runs at its hashed minute and counts towards it once
+	// its load is observed
+	if got := s.Assign("1", noHistory); got != 6 {
+		t.Fatalf("got minute %d, want 6", got)
+	}
+	s.Observe("1", 70000)
+
+	// minutes 0, 6 and 10 are loaded, "188" must avoid its hashed minute
+	min := s.Assign("188", noHistory)
+	if min == 0 || min == 6 || min == 10 {
+		t.Fatalf("got loaded minute %d", min)
+	}
+	want := map[string]uint32{"2": 0, "36138196": 10, "188": min}
+	if diff := deep.Equal(s.Assignments(), want); diff != nil {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,120 @@
+package planner
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+)
+
+var (
+	ErrChannelNotTracked = errors.New("the channel is not tracked")
+	ErrInvalidSettings   = errors.New("the interval must be a non-negative number of minutes and the priority one of -1, 0 or 1")
+)
+
+// ChannelSettings are the scheduling settings of a channel.
+type ChannelSettings struct {
+	// Interval is the time between runs of the worker of the channel. 0 uses
+	// PlannerOpts.TrackInterval.
+	Interval time.Duration
+	// Priority is the priority tier of the runs of the channel in the worker
+	// pool.
+	Priority Priority
+}
+
+// SettingsFromModel returns the settings stored in the tracked channel `ch`.
+func SettingsFromModel(ch *model.TrackedChannels) ChannelSettings {
+	s := ChannelSettings{
+		Priority: Priority(ch.Priority),
+	}
+	if ch.TrackIntervalMinutes != nil {
+		s.Interval = time.Duration(*ch.TrackIntervalMinutes) * time.Minute
+	}
+	return s
+}
+
+// Validate reports whether the settings can be stored: the interval is
+// persisted in whole minutes.
+func (s ChannelSettings) Validate() error {
+	if s.Interval < 0 || s.Interval%time.Minute != 0 {
+		return ErrInvalidSettings
+	}
+	if s.Priority < PriorityLow || s.Priority > PriorityHigh {
+		return ErrInvalidSettings
+	}
+	return nil
+}
+
+// Settings returns the scheduling settings of the channel `bid`.
+func (p *Planner) Settings(bid string) ChannelSettings {
+	s, _ := p.settings.Get(bid)
+	return s
+}
+
+// SetSettings changes the scheduling settings of the channel `bid`. If the
+// channel is live the new interval is applied to its executor right away,
+// without restarting it; the next run happens one interval after the change.
+func (p *Planner) SetSettings(bid string, s ChannelSettings) {
+	prev := p.interval(bid)
+	p.settings.Set(bid, s)
+	d := p.interval(bid)
+	if d == prev {
+		return
+	}
+	if e, ok := p.active.Get(bid); ok {
+		e.setInterval(d)
+	}
+}
+
+// UpdateSettings persists the scheduling settings of the tracked channel
+// `bid` with PlannerOpts.SaveSettings and applies them, see SetSettings. The
+// settings are not applied if they can't be persisted, so they survive
+// restarts.
+func (p *Planner) UpdateSettings(bid string, s ChannelSettings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if !p.channels.Has(bid) {
+		return ErrChannelNotTracked
+	}
+	if p.opts.SaveSettings != nil {
+		var minutes *int32
+		if s.Interval > 0 {
+			m := int32(s.Interval / time.Minute)
+			minutes = &m
+		}
+		if err := p.opts.SaveSettings(bid, minutes, int16(s.Priority)); err != nil {
+			return err
+		}
+	}
+	p.SetSettings(bid, s)
+	return nil
+}
+
+// SetInterval changes the interval of the channel `bid`, see SetSettings.
+func (p *Planner) SetInterval(bid string, d time.Duration) {
+	s := p.Settings(bid)
+	s.Interval = d
+	p.SetSettings(bid, s)
+}
+
+// SetPriority changes the priority tier of the channel `bid`. It applies to
+// the runs submitted after the change.
+func (p *Planner) SetPriority(bid string, prio Priority) {
+	s := p.Settings(bid)
+	s.Priority = prio
+	p.SetSettings(bid, s)
+}
+
+// interval returns the time between runs of the worker of the channel `bid`.
+func (p *Planner) interval(bid string) time.Duration {
+	if s, ok := p.settings.Get(bid); ok && s.Interval > 0 {
+		return s.Interval
+	}
+	return p.opts.TrackInterval
+}
+
+func (p *Planner) priority(bid string) Priority {
+	s, _ := p.settings.Get(bid)
+	return s.Priority
+}
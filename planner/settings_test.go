@@ -0,0 +1,167 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+)
+
+func TestSettingsFromModel(t *testing.T) {
+	t.Parallel()
+
+	interval := int32(15)
+	tests := []struct {
+		in   *model.TrackedChannels
+		want ChannelSettings
+	}{
+		{
+			in:   &model.TrackedChannels{BroadcasterID: "1"},
+			want: ChannelSettings{},
+		},
+		{
+			in: &model.TrackedChannels{
+				BroadcasterID:        "2",
+				TrackIntervalMinutes: &interval,
+				Priority:             1,
+			},
+			want: ChannelSettings{Interval: 15 * time.Minute, Priority: PriorityHigh},
+		},
+	}
+	for _, test := range tests {
+		if diff := deep.Equal(SettingsFromModel(test.in), test.want); diff != nil {
+			t.Fatal(diff)
+		}
+	}
+}
+
+// waitTickerPeriod waits until the only ticker of the clock has the period `d`
+func waitTickerPeriod(t *testing.T, clock *FakeClock, d time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		clock.mu.Lock()
+		ok := len(clock.tickers) == 1 && clock.tickers[0].period == d
+		clock.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected ticker period to be %s", d)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPlannerChangesIntervalAtRuntime(t *testing.T) {
+	const bid = "234783"
+	interval := int32(15)
+	clock := NewFakeClock(parseTime("2022-06-22T15:00:00Z"))
+	ran := make(chan time.Time, 1)
+	p := FromChannels(&PlannerOpts{
+		TrackInterval:      time.Hour,
+		TrackOnlineTimeout: 24 * time.Hour,
+		WorkerTimeout:      time.Minute,
+		Clock:              clock,
+		SkipAlign:          true,
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			ran <- clock.Now()
+			return 0, nil
+		},
+	}, []*model.TrackedChannels{
+		{BroadcasterID: bid, TrackIntervalMinutes: &interval},
+	})
+	defer p.Stop()
+
+	go p.OnStreamOnline(createEventStreamOnline(bid, "user"))
+	<-ran
+	// the stored interval of the channel is used instead of TrackInterval
+	waitTickerPeriod(t, clock, 15*time.Minute)
+	clock.Advance(15 * time.Minute)
+	if got, want := <-ran, parseTime("2022-06-22T15:15:00Z"); !got.Equal(want) {
+		t.Fatalf("got run at %s, want %s", got, want)
+	}
+
+	// the executor keeps running with the new interval
+	p.SetInterval(bid, 5*time.Minute)
+	waitTickerPeriod(t, clock, 5*time.Minute)
+	for _, want := range []string{"2022-06-22T15:20:00Z", "2022-06-22T15:25:00Z"} {
+		clock.Advance(5 * time.Minute)
+		if got := <-ran; !got.Equal(parseTime(want)) {
+			t.Fatalf("got run at %s, want %s", got, want)
+		}
+	}
+
+	// changing the priority does not reset the cycle
+	p.SetPriority(bid, PriorityHigh)
+	if got := p.Settings(bid); got.Priority != PriorityHigh || got.Interval != 5*time.Minute {
+		t.Fatalf("got settings %+v", got)
+	}
+	if got := p.interval("unknown"); got != time.Hour {
+		t.Fatalf("expected channels without settings to use TrackInterval, got %s", got)
+	}
+}
+
+func TestPlannerUpdateSettings(t *testing.T) {
+	t.Parallel()
+
+	type saved struct {
+		bid      string
+		minutes  *int32
+		priority int16
+	}
+	var got []saved
+	fail := false
+	p := FromChannels(&PlannerOpts{
+		TrackInterval: time.Hour,
+		SaveSettings: func(bid string, intervalMinutes *int32, priority int16) error {
+			if fail {
+				return errors.New("db down")
+			}
+			got = append(got, saved{bid, intervalMinutes, priority})
+			return nil
+		},
+	}, []*model.TrackedChannels{{BroadcasterID: "1"}})
+	defer p.Stop()
+
+	if err := p.UpdateSettings("1", ChannelSettings{Interval: 5 * time.Minute, Priority: PriorityHigh}); err != nil {
+		t.Fatal(err)
+	}
+	// the default interval is persisted as nil
+	if err := p.UpdateSettings("1", ChannelSettings{Priority: PriorityLow}); err != nil {
+		t.Fatal(err)
+	}
+	five := int32(5)
+	want := []saved{{"1", &five, 1}, {"1", nil, -1}}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+	if s := p.Settings("1"); s.Interval != 0 || s.Priority != PriorityLow {
+		t.Fatalf("got settings %+v", s)
+	}
+
+	// settings which can't be persisted are not applied
+	fail = true
+	if err := p.UpdateSettings("1", ChannelSettings{Interval: 10 * time.Minute}); err == nil {
+		t.Fatal("expected the error of SaveSettings")
+	}
+	if s := p.Settings("1"); s.Priority != PriorityLow {
+		t.Fatalf("expected the settings to be unchanged, got %+v", s)
+	}
+
+	if err := p.UpdateSettings("2", ChannelSettings{}); err != ErrChannelNotTracked {
+		t.Fatalf("expected ErrChannelNotTracked, got %v", err)
+	}
+	for _, s := range []ChannelSettings{
+		{Interval: -time.Minute},
+		{Interval: 90 * time.Second},
+		{Priority: 2},
+	} {
+		if err := p.UpdateSettings("1", s); err != ErrInvalidSettings {
+			t.Fatalf("%+v: expected ErrInvalidSettings, got %v", s, err)
+		}
+	}
+}
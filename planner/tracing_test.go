@@ -0,0 +1,107 @@
+package planner
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pmrt/viewergraph/database"
+	"github.com/pmrt/viewergraph/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPlannerPropagatesSpans(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	defer otel.SetTracerProvider(prev)
+
+	const bid = "TestPlannerPropagatesSpans"
+	clock := NewFakeClock(parseTime("2022-06-22T15:00:00Z"))
+	ran := make(chan struct{})
+	p := New(&PlannerOpts{
+		TrackInterval:      time.Hour,
+		TrackOnlineTimeout: 24 * time.Hour,
+		WorkerTimeout:      time.Minute,
+		SkipAlign:          true,
+		Clock:              clock,
+		WorkerFunc: func(ctx context.Context, bid string) (uint64, error) {
+			defer func() { ran <- struct{}{} }()
+			b := &StreamBatcher{
+				MaxQueueSize: 10,
+				Channel:      bid,
+				FlushFunc: func(ctx context.Context, sto database.Storage, queue []string, channel string) error {
+					_, span := tracing.Start(ctx, "test.insert")
+					span.End()
+					return nil
+				},
+			}
+			err := b.WithContext(ctx).Batch(strings.NewReader(`{"_links":{},"chatter_count":1,"chatters":{"broadcaster":[],"vips":[],"moderators":[],"staff":[],"admins":[],"global_mods":[],"viewers":["a"]}}`))
+			return 1, err
+		},
+	})
+	defer p.Stop()
+
+	ctx, webhook := tracing.Start(context.Background(), "helix.webhook")
+	go p.OnStreamOnline(createEventStreamOnline(bid, "user").WithContext(ctx))
+	<-ran
+	webhook.End()
+	clock.BlockUntil(3)
+	clock.Advance(time.Hour)
+	<-ran
+
+	// spans end right after the worker returns
+	deadline := time.Now().Add(5 * time.Second)
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for len(spans["test.insert"]) < 2 || len(spans["planner.worker"]) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected spans to be recorded")
+		}
+		time.Sleep(time.Millisecond)
+		spans = make(map[string][]sdktrace.ReadOnlySpan)
+		for _, s := range sr.Ended() {
+			spans[s.Name()] = append(spans[s.Name()], s)
+		}
+	}
+
+	parent := func(s sdktrace.ReadOnlySpan) trace.SpanID {
+		return s.Parent().SpanID()
+	}
+	online := spans["planner.stream_online"][0]
+	tick := spans["planner.tick"][0]
+	workers := spans["planner.worker"]
+	flushes := spans["batcher.flush"]
+	inserts := spans["test.insert"]
+
+	// webhook -> stream_online -> worker -> flush -> insert
+	if parent(online) != webhook.SpanContext().SpanID() {
+		t.Fatal("expected planner.stream_online to be a child of the webhook span")
+	}
+	if parent(workers[0]) != online.SpanContext().SpanID() {
+		t.Fatal("expected the first planner.worker to be a child of planner.stream_online")
+	}
+	if parent(flushes[0]) != workers[0].SpanContext().SpanID() {
+		t.Fatal("expected batcher.flush to be a child of planner.worker")
+	}
+	if parent(inserts[0]) != flushes[0].SpanContext().SpanID() {
+		t.Fatal("expected the insert to be a child of batcher.flush")
+	}
+	if inserts[0].SpanContext().TraceID() != webhook.SpanContext().TraceID() {
+		t.Fatal("expected the first run to be part of the webhook trace")
+	}
+
+	// runs started by the ticker are new traces linked to stream_online
+	if tick.SpanContext().TraceID() == online.SpanContext().TraceID() {
+		t.Fatal("expected planner.tick to start a new trace")
+	}
+	if links := tick.Links(); len(links) != 1 || links[0].SpanContext.SpanID() != online.SpanContext().SpanID() {
+		t.Fatal("expected planner.tick to be linked to planner.stream_online")
+	}
+	if parent(workers[1]) != tick.SpanContext().SpanID() {
+		t.Fatal("expected the second planner.worker to be a child of planner.tick")
+	}
+}
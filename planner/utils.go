@@ -21,8 +21,8 @@ func untilMinuteWithTime(t time.Time, min int) time.Duration {
 	return time.Duration(d) * time.Minute
 }
 
-func untilMinute(min int) time.Duration {
-	return untilMinuteWithTime(time.Now(), min)
+func untilMinute(c Clock, min int) time.Duration {
+	return untilMinuteWithTime(c.Now(), min)
 }
 
 func fnv32(key string) uint32 {
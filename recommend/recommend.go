@@ -0,0 +1,86 @@
+// Package recommend keeps the similar channels of the tracked channels up to
+// date, see clickhouse.RefreshSimilarChannels.
+package recommend
+
+import (
+	"time"
+
+	"github.com/pmrt/viewergraph/config"
+	pg "github.com/pmrt/viewergraph/database/postgres"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+	"github.com/pmrt/viewergraph/repo/postgres"
+	"github.com/pmrt/viewergraph/utils"
+	"github.com/rs/zerolog"
+)
+
+type RefresherOpts struct {
+	Events   clickhouse.Repository
+	Channels postgres.Repository
+	// Interval is how often the similar channels are recomputed
+	Interval time.Duration
+	// Window is how far back the co-viewing data is used
+	Window time.Duration
+	// Limit is the number of similar channels kept per channel
+	Limit int
+	// Now returns the current time. time.Now if nil.
+	Now func() time.Time
+}
+
+// OptsFromConfig returns the options of the refresher configured by `c`. The
+// repositories must be set by the caller.
+func OptsFromConfig(c *config.Config) *RefresherOpts {
+	return &RefresherOpts{
+		Interval: c.Similarity.RefreshInterval,
+		Window:   c.Similarity.Window,
+		Limit:    c.Similarity.Limit,
+	}
+}
+
+// Refresher recomputes the similar channels of the tracked channels every
+// Interval, see pg.PeriodicJob.
+type Refresher struct {
+	*pg.PeriodicJob
+	opts *RefresherOpts
+	l    zerolog.Logger
+}
+
+// Refresh recomputes the similar channels of the tracked channels with the
+// co-viewing data of the last Window.
+func (r *Refresher) Refresh() error {
+	channels, err := r.opts.Channels.Channels()
+	if err != nil {
+		return err
+	}
+	logins := make([]string, 0, len(channels))
+	for _, ch := range channels {
+		logins = append(logins, ch.BroadcasterUsername)
+	}
+
+	now := r.opts.Now().UTC().Truncate(time.Second)
+	if err := r.opts.Events.RefreshSimilarChannels(logins, now.Add(-r.opts.Window), now, r.opts.Limit); err != nil {
+		return err
+	}
+	r.l.Info().Int("channels", len(logins)).Msg("refreshed similar channels")
+	return nil
+}
+
+func New(opts *RefresherOpts) *Refresher {
+	if opts.Interval == 0 {
+		opts.Interval = 6 * time.Hour
+	}
+	if opts.Window == 0 {
+		opts.Window = 30 * 24 * time.Hour
+	}
+	if opts.Limit == 0 {
+		opts.Limit = clickhouse.DefaultSimilarLimit
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	r := &Refresher{
+		opts: opts,
+		l:    utils.Logger("recommend"),
+	}
+	r.PeriodicJob = pg.NewPeriodicJob("similar channels", opts.Interval, r.Refresh)
+	return r
+}
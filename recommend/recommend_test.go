@@ -0,0 +1,111 @@
+package recommend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+	"github.com/pmrt/viewergraph/repo/clickhouse"
+	"github.com/pmrt/viewergraph/repo/postgres"
+)
+
+func TestRefresh(t *testing.T) {
+	now := time.Date(2020, 10, 12, 0, 0, 0, 0, time.UTC)
+	events := clickhouse.NewMemory()
+	for _, evt := range []struct {
+		ts       time.Time
+		username string
+		channel  string
+	}{
+		{now.Add(-2 * time.Hour), "user1", "b"},
+		{now.Add(-time.Hour), "user1", "a"},
+		{now.Add(-time.Hour), "user2", "a"},
+		{now.Add(-time.Hour), "user2", "c"},
+		// out of the window
+		{now.Add(-48 * time.Hour), "user3", "a"},
+		{now.Add(-48 * time.Hour), "user3", "d"},
+	} {
+		events.InsertRawEvent(&clickhouse.RawEvent{
+			Ts:        evt.ts,
+			Username:  evt.username,
+			Channel:   evt.channel,
+			EventType: "view",
+		})
+	}
+	if err := events.ReconcileEvents(time.Time{}, 3*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	channels := postgres.NewMemory()
+	for _, ch := range []*model.TrackedChannels{
+		{BroadcasterID: "1", BroadcasterUsername: "a"},
+		{BroadcasterID: "2", BroadcasterUsername: "b"},
+	} {
+		if err := channels.Track(ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := New(&RefresherOpts{
+		Events:   events,
+		Channels: channels,
+		Window:   24 * time.Hour,
+		Limit:    1,
+		Now:      func() time.Time { return now },
+	})
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	for ch, want := range map[string]string{"a": "b", "b": "a", "c": ""} {
+		got, err := events.SimilarChannels(ch, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == "" {
+			if len(got) != 0 {
+				t.Fatalf("%s: untracked channels must not be refreshed, got %d similar channels", ch, len(got))
+			}
+			continue
+		}
+		if len(got) != 1 || got[0].Similar != want || !got[0].ComputedAt.Equal(now) {
+			t.Fatalf("%s: expected %s computed at %s, got %+v", ch, want, now, got)
+		}
+	}
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	refreshed := make(chan struct{}, 1)
+	r := New(&RefresherOpts{
+		Events:   clickhouse.NewMemory(),
+		Channels: postgres.NewMemory(),
+		Interval: time.Hour,
+		Now: func() time.Time {
+			select {
+			case refreshed <- struct{}{}:
+			default:
+			}
+			return time.Now()
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to refresh right away")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to return once the context is done")
+	}
+}
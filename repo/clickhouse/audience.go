@@ -0,0 +1,168 @@
+package clickhouse
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/utils"
+)
+
+// MaxOverlapChannels is the maximum number of channels of an overlap matrix
+const MaxOverlapChannels = 50
+
+var ErrTooManyChannels = errors.New("too many channels")
+
+// Overlap is the co-viewership of two channels: the unique viewers they share
+// and how similar their audiences are.
+type Overlap struct {
+	ChannelA string `json:"channel_a"`
+	ChannelB string `json:"channel_b"`
+	ViewersA uint64 `json:"viewers_a"`
+	ViewersB uint64 `json:"viewers_b"`
+	Shared   uint64 `json:"shared"`
+	// Jaccard is |A ∩ B| / |A ∪ B|
+	Jaccard float64 `json:"jaccard"`
+	// OverlapCoefficient is |A ∩ B| / min(|A|, |B|), 1 when the audience of a
+	// channel is contained in the other one
+	OverlapCoefficient float64 `json:"overlap_coefficient"`
+}
+
+// newOverlap returns the overlap of the channels `a` and `b`, with `va` and
+// `vb` unique viewers and `shared` viewers in common
+func newOverlap(a, b string, va, vb, shared uint64) *Overlap {
+	o := &Overlap{
+		ChannelA: a,
+		ChannelB: b,
+		ViewersA: va,
+		ViewersB: vb,
+		Shared:   shared,
+	}
+	if union := va + vb - shared; union > 0 {
+		o.Jaccard = float64(shared) / float64(union)
+	}
+	smallest := va
+	if vb < smallest {
+		smallest = vb
+	}
+	if smallest > 0 {
+		o.OverlapCoefficient = float64(shared) / float64(smallest)
+	}
+	return o
+}
+
+// overlapChannels returns the sorted and deduplicated `channels`
+func overlapChannels(channels []string) ([]string, error) {
+	set := make(map[string]struct{}, len(channels))
+	uniq := make([]string, 0, len(channels))
+	for _, ch := range channels {
+		if _, ok := set[ch]; ok || ch == "" {
+			continue
+		}
+		set[ch] = struct{}{}
+		uniq = append(uniq, ch)
+	}
+	if len(uniq) > MaxOverlapChannels {
+		return nil, ErrTooManyChannels
+	}
+	sort.Strings(uniq)
+	return uniq, nil
+}
+
+// overlapMatrix returns the overlap of every pair of `channels`, sorted, with
+// the `viewers` of each channel and the `shared` viewers of each pair. Missing
+// channels and pairs have no viewers.
+func overlapMatrix(channels []string, viewers map[string]uint64, shared map[[2]string]uint64) []*Overlap {
+	m := make([]*Overlap, 0, len(channels)*(len(channels)-1)/2)
+	for i, a := range channels {
+		for _, b := range channels[i+1:] {
+			m = append(m, newOverlap(a, b, viewers[a], viewers[b], shared[[2]string{a, b}]))
+		}
+	}
+	return m
+}
+
+// audienceByChannel selects the unique viewers of the given channels in the
+// time range as a uniq state
+const audienceByChannel = `
+    SELECT
+      channel,
+      uniqMergeState(viewers) AS state,
+      uniqMerge(viewers) AS total
+    FROM aggregated_audience
+    WHERE
+      has(@Channels, channel) AND
+      ts >= @From AND
+      ts <= @To
+    GROUP BY channel
+`
+
+// AudienceOverlap returns the overlap matrix of `channels` in the time range,
+// one Overlap for each pair of channels sorted by name, with ChannelA <
+// ChannelB. At most MaxOverlapChannels channels are allowed.
+//
+// The shared viewers are |A| + |B| - |A ∪ B|, where the union is the merge of
+// the uniq states of both channels.
+func AudienceOverlap(db *sql.DB, channels []string, from, to time.Time) ([]*Overlap, error) {
+	defer metrics.ObserveQuery("clickhouse", "AudienceOverlap")()
+	l := utils.Logger("query", "q", "AudienceOverlap")
+
+	channels, err := overlapChannels(channels)
+	if err != nil {
+		return nil, err
+	}
+	if len(channels) < 2 {
+		return []*Overlap{}, nil
+	}
+
+	rows, err := db.Query(`
+    SELECT
+      a.channel, b.channel,
+      a.total, b.total,
+      greatest(
+        toInt64(a.total) + toInt64(b.total) -
+        toInt64(arrayReduce('uniqMerge', [a.state, b.state])),
+        0
+      ) AS shared
+    FROM (`+audienceByChannel+`) AS a
+    CROSS JOIN (`+audienceByChannel+`) AS b
+    WHERE a.channel <= b.channel
+  `,
+		sql.Named("Channels", channels),
+		sql.Named("From", from),
+		sql.Named("To", to),
+	)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	viewers := make(map[string]uint64, len(channels))
+	shared := make(map[[2]string]uint64)
+	for rows.Next() {
+		var (
+			a, b   string
+			va, vb uint64
+			s      int64
+		)
+		if err := rows.Scan(&a, &b, &va, &vb, &s); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		// the pair of a channel with itself is included so channels without
+		// viewers in common with the others still report their viewers
+		if a == b {
+			viewers[a] = va
+			continue
+		}
+		shared[[2]string{a, b}] = uint64(s)
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+	return overlapMatrix(channels, viewers, shared), nil
+}
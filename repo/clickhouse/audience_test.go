@@ -0,0 +1,63 @@
+package clickhouse
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func testAudienceOverlap(t *testing.T, h *harness) {
+	for _, usr := range []string{"user1", "user2", "user3", "user4"} {
+		h.insertRawEvent("2020-10-11T10:00:00Z", usr, "a", "view")
+	}
+	for _, usr := range []string{"user3", "user4", "user5"} {
+		h.insertRawEvent("2020-10-11T10:00:00Z", usr, "b", "view")
+	}
+	h.insertRawEvent("2020-10-11T11:00:00Z", "user1", "c", "view")
+	// out of the time range
+	h.insertRawEvent("2020-10-11T07:00:00Z", "user5", "a", "view")
+	h.insertRawEvent("2020-10-11T13:00:00Z", "user1", "b", "view")
+	// not a view
+	h.insertRawEvent("2020-10-11T10:00:00Z", "user9", "c", "ban")
+
+	// channels are sorted and deduplicated. Channels without viewers are
+	// included
+	got, err := h.repo.AudienceOverlap(
+		[]string{"b", "a", "c", "d", "a"},
+		parseTime("2020-10-11T08:00:00Z"),
+		parseTime("2020-10-11T12:00:00Z"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Overlap{
+		{ChannelA: "a", ChannelB: "b", ViewersA: 4, ViewersB: 3, Shared: 2, Jaccard: 2.0 / 5, OverlapCoefficient: 2.0 / 3},
+		{ChannelA: "a", ChannelB: "c", ViewersA: 4, ViewersB: 1, Shared: 1, Jaccard: 1.0 / 4, OverlapCoefficient: 1},
+		{ChannelA: "a", ChannelB: "d", ViewersA: 4},
+		{ChannelA: "b", ChannelB: "c", ViewersA: 3, ViewersB: 1},
+		{ChannelA: "b", ChannelB: "d", ViewersA: 3},
+		{ChannelA: "c", ChannelB: "d", ViewersA: 1},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	got, err = h.repo.AudienceOverlap([]string{"a"}, parseTime("2020-10-11T08:00:00Z"), parseTime("2020-10-11T12:00:00Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no pairs for a single channel, got %d", len(got))
+	}
+
+	channels := make([]string, MaxOverlapChannels+1)
+	for i := range channels {
+		channels[i] = strconv.Itoa(i)
+	}
+	if _, err := h.repo.AudienceOverlap(channels, parseTime("2020-10-11T08:00:00Z"), parseTime("2020-10-11T12:00:00Z")); !errors.Is(err, ErrTooManyChannels) {
+		t.Fatalf("expected ErrTooManyChannels, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package clickhouse
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"os"
@@ -13,13 +14,28 @@ import (
 	ch "github.com/pmrt/viewergraph/database/clickhouse"
 )
 
+// db is nil when docker is unavailable, see requireDB
 var db *sql.DB
 
+// requireDB skips the test when there is no database to run it against
+func requireDB(t *testing.T) {
+	t.Helper()
+	if db == nil {
+		t.Skip("docker is unavailable, skipping database test")
+	}
+}
+
 func TestMain(m *testing.M) {
-	// Run a docker with a database for testing
+	// Run a docker with a database for testing. Without docker only the tests
+	// which don't need a database are run, e.g. the conformance suite against
+	// the in-memory repository.
 	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
 	if err != nil {
-		panic(err)
+		log.Printf("docker is unavailable: %v", err)
+		os.Exit(m.Run())
 	}
 	res, err := pool.RunWithOptions(&dockertest.RunOptions{
 		Repository: "clickhouse/clickhouse-server",
@@ -39,7 +55,7 @@ func TestMain(m *testing.M) {
 	res.Expire(120)
 
 	// Prepare a connection to the db in the docker
-	sto := database.New(
+	sto, err := database.New(
 		ch.New(&database.StorageOptions{
 			StorageHost:            res.GetBoundIP("9000/tcp"),
 			StoragePort:            res.GetPort("9000/tcp"),
@@ -52,14 +68,19 @@ func TestMain(m *testing.M) {
 			StorageConnTimeout:     60 * time.Second,
 			DebugMode:              true,
 
-			MigrationVersion: 1,
-			MigrationPath:    "../../database/clickhouse/migrations",
+			MigrationVersion: 8,
 		}))
+	if err != nil {
+		panic(err)
+	}
 	db = sto.Conn()
 
 	// Run tests
 	code := m.Run()
 
+	if err := sto.Close(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 	if err := pool.Purge(res); err != nil {
 		log.Fatal(err)
 	}
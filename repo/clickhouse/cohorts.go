@@ -0,0 +1,188 @@
+package clickhouse
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/utils"
+)
+
+// DefaultChurnLimit is the default number of destinations of the lapsed viewers
+const DefaultChurnLimit = 20
+
+// Cohort are the viewers of a channel in a week, starting on monday at 00:00
+// UTC, by whether they were seen for the first time.
+type Cohort struct {
+	Week    time.Time `json:"week"`
+	Viewers uint64    `json:"viewers"`
+	// New viewers were seen in the channel for the first time this week
+	New uint64 `json:"new"`
+	// Returning viewers were already seen in the channel before this week
+	Returning uint64 `json:"returning"`
+	// Lapsed viewers were seen in the channel the previous week but not this
+	// week
+	Lapsed uint64 `json:"lapsed"`
+}
+
+// ChurnDestination is a channel the lapsed viewers of another channel went to
+type ChurnDestination struct {
+	Channel string `json:"channel"`
+	Users   uint64 `json:"users"`
+}
+
+// startOfWeek returns the monday of the week of `t`, at 00:00 UTC
+func startOfWeek(t time.Time) time.Time {
+	return Week.bucket(t, t)
+}
+
+// Cohorts returns the weekly cohorts of `channel` of the weeks in the time
+// range, sorted by week. Weeks without viewers nor lapsed viewers are
+// omitted.
+func Cohorts(db *sql.DB, channel string, from, to time.Time) ([]*Cohort, error) {
+	defer metrics.ObserveQuery("clickhouse", "Cohorts")()
+	l := utils.Logger("query", "q", "Cohorts")
+
+	// the viewers of each week are joined with the viewers of the previous
+	// week, shifted one week forward
+	rows, err := db.Query(`
+    SELECT
+      w,
+      countIf(cur) AS viewers,
+      countIf(cur AND first_week = w) AS new,
+      countIf(cur AND first_week < w) AS returning,
+      countIf(prev AND NOT cur) AS lapsed
+    FROM (
+      SELECT
+        w, username,
+        max(is_cur) AS cur,
+        max(is_prev) AS prev
+      FROM (
+        SELECT week AS w, username, 1 AS is_cur, 0 AS is_prev
+        FROM weekly_viewers
+        WHERE
+          channel = @Channel AND
+          week >= toDate(@Since, 'UTC') AND
+          week <= toDate(@To, 'UTC')
+        UNION ALL
+        SELECT addWeeks(week, 1) AS w, username, 0 AS is_cur, 1 AS is_prev
+        FROM weekly_viewers
+        WHERE
+          channel = @Channel AND
+          week >= subtractWeeks(toDate(@Since, 'UTC'), 1) AND
+          week <= subtractWeeks(toDate(@To, 'UTC'), 1)
+      )
+      GROUP BY w, username
+    ) AS u
+    LEFT JOIN (
+      SELECT
+        username,
+        toMonday(min(ts), 'UTC') AS first_week
+      FROM first_seen
+      WHERE channel = @Channel
+      GROUP BY username
+    ) AS f USING (username)
+    GROUP BY w
+    ORDER BY w ASC
+  `,
+		sql.Named("Channel", channel),
+		sql.Named("Since", startOfWeek(from)),
+		sql.Named("To", to),
+	)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := make([]*Cohort, 0)
+	for rows.Next() {
+		c := new(Cohort)
+		if err := rows.Scan(
+			&c.Week,
+			&c.Viewers,
+			&c.New,
+			&c.Returning,
+			&c.Lapsed,
+		); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		c.Week = c.Week.UTC()
+		r = append(r, c)
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+	return r, nil
+}
+
+// Churn returns where the viewers of `channel` lapsed in the week of `week`
+// went: the last channel each of them went to from `channel` in the previous
+// week, sorted by unique users. At most `limit` destinations are returned,
+// DefaultChurnLimit if 0.
+func Churn(db *sql.DB, channel string, week time.Time, limit int) ([]*ChurnDestination, error) {
+	defer metrics.ObserveQuery("clickhouse", "Churn")()
+	l := utils.Logger("query", "q", "Churn")
+
+	if limit <= 0 {
+		limit = DefaultChurnLimit
+	}
+	week = startOfWeek(week)
+	// ties between destinations at the same time are broken by channel
+	rows, err := db.Query(fmt.Sprintf(`
+    SELECT
+      destination,
+      count() AS users
+    FROM (
+      SELECT
+        username,
+        argMax(channel, (ts, channel)) AS destination
+      FROM events
+      WHERE
+        referrer = @Channel AND
+        ts >= @Prev AND
+        ts < @Week AND
+        username IN (
+          SELECT username
+          FROM weekly_viewers
+          WHERE channel = @Channel AND week = toDate(@Prev, 'UTC')
+        ) AND
+        username NOT IN (
+          SELECT username
+          FROM weekly_viewers
+          WHERE channel = @Channel AND week = toDate(@Week, 'UTC')
+        )
+      GROUP BY username
+    )
+    GROUP BY destination
+    ORDER BY users DESC, destination ASC
+    LIMIT %d
+  `, limit),
+		sql.Named("Channel", channel),
+		sql.Named("Prev", week.AddDate(0, 0, -7)),
+		sql.Named("Week", week),
+	)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := make([]*ChurnDestination, 0, limit)
+	for rows.Next() {
+		d := new(ChurnDestination)
+		if err := rows.Scan(&d.Channel, &d.Users); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		r = append(r, d)
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+	return r, nil
+}
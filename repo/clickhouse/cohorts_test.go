@@ -0,0 +1,74 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func testCohorts(t *testing.T, h *harness) {
+	// 2020-10-05, 2020-10-12 and 2020-10-19 are mondays
+	for _, evt := range [][2]string{
+		{"2020-09-30T10:00:00Z", "user1"},
+		{"2020-10-05T10:00:00Z", "user1"},
+		{"2020-10-06T10:00:00Z", "user2"},
+		{"2020-10-07T10:00:00Z", "user3"},
+		{"2020-10-12T10:00:00Z", "user1"},
+		{"2020-10-13T10:00:00Z", "user4"},
+	} {
+		h.insertRawEvent(evt[0], evt[1], "a", "view")
+	}
+	h.insertRawEvent("2020-10-12T10:00:00Z", "user2", "b", "view")
+
+	got, err := h.repo.Cohorts("a", parseTime("2020-10-07T00:00:00Z"), parseTime("2020-10-25T00:00:00Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Cohort{
+		{Week: parseTime("2020-10-05T00:00:00Z"), Viewers: 3, New: 2, Returning: 1},
+		{Week: parseTime("2020-10-12T00:00:00Z"), Viewers: 2, New: 1, Returning: 1, Lapsed: 2},
+		{Week: parseTime("2020-10-19T00:00:00Z"), Lapsed: 2},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func testChurn(t *testing.T, h *harness) {
+	// user1 stays, user2, user3 and user4 lapse in the week of 2020-10-12
+	for _, usr := range []string{"user1", "user2", "user3", "user4"} {
+		h.insertRawEvent("2020-10-06T10:00:00Z", usr, "a", "view")
+	}
+	h.insertRawEvent("2020-10-06T11:00:00Z", "user1", "b", "view")
+	h.insertRawEvent("2020-10-06T11:00:00Z", "user2", "b", "view")
+	h.insertRawEvent("2020-10-06T11:00:00Z", "user4", "c", "view")
+	// user3 went to b and then back to a, only their last destination counts
+	h.insertRawEvent("2020-10-06T11:00:00Z", "user3", "b", "view")
+	h.insertRawEvent("2020-10-06T12:00:00Z", "user3", "a", "view")
+	h.insertRawEvent("2020-10-06T13:00:00Z", "user3", "c", "view")
+	h.insertRawEvent("2020-10-13T10:00:00Z", "user1", "a", "view")
+	if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := h.repo.Churn("a", parseTime("2020-10-14T00:00:00Z"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*ChurnDestination{
+		{Channel: "c", Users: 2},
+		{Channel: "b", Users: 1},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	got, err = h.repo.Churn("a", parseTime("2020-10-14T00:00:00Z"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, want[:1]); diff != nil {
+		t.Fatal(diff)
+	}
+}
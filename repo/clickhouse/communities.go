@@ -0,0 +1,115 @@
+package clickhouse
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/utils"
+)
+
+// ChannelCommunity is the community and the weighted PageRank of a channel in
+// the viewer-flow graph of the [WindowStart, WindowEnd] time window.
+type ChannelCommunity struct {
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	ComputedAt  time.Time `json:"computed_at"`
+	Channel     string    `json:"channel"`
+	// Community is the label of the community of the channel. Labels are only
+	// comparable within the same computation.
+	Community uint32  `json:"community"`
+	PageRank  float64 `json:"pagerank"`
+}
+
+// InsertCommunities stores the communities `cs` of a computation.
+func InsertCommunities(db *sql.DB, cs []*ChannelCommunity) error {
+	defer metrics.ObserveQuery("clickhouse", "InsertCommunities")()
+	l := utils.Logger("query", "q", "InsertCommunities")
+
+	if len(cs) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		l.Error().Err(err).Msg("error while opening transaction")
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO channel_communities (window_start, window_end, computed_at, channel, community, pagerank)")
+	if err != nil {
+		l.Error().Err(err).Msg("error while preparing statement")
+		return err
+	}
+
+	for _, c := range cs {
+		if _, err := stmt.Exec(c.WindowStart, c.WindowEnd, c.ComputedAt, c.Channel, c.Community, c.PageRank); err != nil {
+			l.Error().Err(err).Msg("error while adding values to the batch")
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		l.Error().Err(err).Msg("error while committing transaction")
+		return err
+	}
+	return nil
+}
+
+// Communities returns the communities of the latest computation whose time
+// window overlaps or contains [from, to], sorted by community, PageRank and
+// channel. Only the rows of that computation are returned, even if others
+// share its computed_at. Empty if there is none.
+func Communities(db *sql.DB, from, to time.Time) ([]*ChannelCommunity, error) {
+	defer metrics.ObserveQuery("clickhouse", "Communities")()
+	l := utils.Logger("query", "q", "Communities")
+
+	rows, err := db.Query(`
+    SELECT
+      window_start, window_end, computed_at,
+      channel, community, pagerank
+    FROM channel_communities
+    WHERE (window_start, window_end, computed_at) IN (
+      SELECT window_start, window_end, computed_at
+      FROM channel_communities
+      WHERE
+        (window_start < @To AND window_end > @From) OR
+        (window_start <= @From AND window_end >= @To)
+      ORDER BY computed_at DESC, window_end DESC, window_start DESC
+      LIMIT 1
+    )
+    ORDER BY community ASC, pagerank DESC, channel ASC
+  `,
+		sql.Named("From", from),
+		sql.Named("To", to),
+	)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := make([]*ChannelCommunity, 0)
+	for rows.Next() {
+		c := new(ChannelCommunity)
+		if err := rows.Scan(
+			&c.WindowStart,
+			&c.WindowEnd,
+			&c.ComputedAt,
+			&c.Channel,
+			&c.Community,
+			&c.PageRank,
+		); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		c.WindowStart = c.WindowStart.UTC()
+		c.WindowEnd = c.WindowEnd.UTC()
+		c.ComputedAt = c.ComputedAt.UTC()
+		r = append(r, c)
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,66 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func testCommunities(t *testing.T, h *harness) {
+	day1, day2 := parseTime("2020-10-11T00:00:00Z"), parseTime("2020-10-12T00:00:00Z")
+	day3 := parseTime("2020-10-13T00:00:00Z")
+	old := []*ChannelCommunity{
+		{WindowStart: day1, WindowEnd: day2, ComputedAt: day2, Channel: "a", Community: 0, PageRank: 1},
+	}
+	latest := []*ChannelCommunity{
+		{WindowStart: day1, WindowEnd: day2, ComputedAt: day3, Channel: "c", Community: 1, PageRank: 0.2},
+		{WindowStart: day1, WindowEnd: day2, ComputedAt: day3, Channel: "a", Community: 0, PageRank: 0.3},
+		{WindowStart: day1, WindowEnd: day2, ComputedAt: day3, Channel: "b", Community: 0, PageRank: 0.5},
+	}
+	// computed at the same time as latest, for the next window
+	other := []*ChannelCommunity{
+		{WindowStart: day2, WindowEnd: day3, ComputedAt: day3, Channel: "a", Community: 0, PageRank: 1},
+	}
+	for _, cs := range [][]*ChannelCommunity{old, latest, other} {
+		if err := h.repo.InsertCommunities(cs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// windows which only share an endpoint with the range don't overlap it
+	got, err := h.repo.Communities(day1, day2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*ChannelCommunity{latest[2], latest[1], latest[0]}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// a window containing the range
+	got, err = h.repo.Communities(day1.Add(6*time.Hour), day1.Add(18*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// both computations overlap the range, only the latest window is returned
+	got, err = h.repo.Communities(day1.Add(12*time.Hour), day2.Add(12*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, other); diff != nil {
+		t.Fatal(diff)
+	}
+
+	got, err = h.repo.Communities(day3, day3.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no communities, got %d", len(got))
+	}
+}
@@ -1,17 +1,26 @@
 package clickhouse
 
 import (
+	"context"
 	"database/sql"
 	"io"
 	"time"
 
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/tracing"
 	"github.com/pmrt/viewergraph/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Viewers struct {
+	// Ts is the time of the snapshot. Raw events are rounded to the hour.
 	Ts      time.Time
 	Viewers []string
 	Channel string
+	// Stream is the id of the stream session of the snapshot, if known
+	Stream string
 }
 
 type RawEvent struct {
@@ -28,38 +37,87 @@ type Event struct {
 	Referrer string
 }
 
-type UserFlowDst struct {
-	Ts       time.Time
+// FlowEdge is the number of unique users that went from Referrer to Channel
+type FlowEdge struct {
 	Referrer string
+	Channel  string
 	Total    uint64
 }
 
+// startOfHour rounds `t` to the start of its hour for aggregation purposes.
+// Hour is the smallest unit we will be storing in the database.
+func startOfHour(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+// reconcileSince returns the time of the oldest raw events taken into account
+// by a reconciliation after `lastAt`.
+//
+// The window is the max. time an event can have relation with future events
+// (or future events with previous ones), covering all the events than can
+// have events related. Then we round it to the start of the our since events
+// are also rounded and we add an extra margin just in case.
+//
+// So that should select all the elements that can have elements related to
+// them and that are not already processed (plus others that are already
+// processed). Duplicates are handled by the database with a
+// ReplacingMergeTree.
+func reconcileSince(lastAt time.Time, window time.Duration) time.Time {
+	const margin = 15 * time.Minute
+	return startOfHour(lastAt.Add(-window)).Add(-margin)
+}
+
+type UserFlowDst struct {
+	Ts       time.Time `json:"ts"`
+	Referrer string    `json:"referrer"`
+	Total    uint64    `json:"total"`
+}
+
 type UserFlowSrc struct {
-	Ts      time.Time
-	Channel string
-	Total   uint64
+	Ts      time.Time `json:"ts"`
+	Channel string    `json:"channel"`
+	Total   uint64    `json:"total"`
 }
 
+// InsertViewers is InsertViewersContext with context.Background()
 func InsertViewers(db *sql.DB, vw *Viewers) error {
+	return InsertViewersContext(context.Background(), db, vw)
+}
+
+// InsertViewersContext inserts the viewers `vw` as view raw events in a single
+// batch. The insert is traced as a child of the span carried by `ctx`
+func InsertViewersContext(ctx context.Context, db *sql.DB, vw *Viewers) (err error) {
+	defer metrics.ObserveQuery("clickhouse", "InsertViewers")()
+	ctx, span := tracing.Start(ctx, "clickhouse.InsertViewers", trace.WithAttributes(
+		attribute.String("db.system", "clickhouse"),
+		attribute.String("channel", vw.Channel),
+		attribute.Int("rows", len(vw.Viewers)),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
 	l := utils.Logger("query")
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		l.Error().Err(err).Msg("error while opening transaction")
 		return err
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO raw_events (ts, username, channel, event_type)")
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO raw_events (ts, username, channel, event_type, stream, snapshot)")
 	if err != nil {
 		l.Error().Err(err).Msg("error while preparing statement")
 		return err
 	}
 
-	// we round time to start of hour for aggregation purposes. Hour is the
-	// smallest unit we will be storing in the database.
-	t := time.Date(vw.Ts.Year(), vw.Ts.Month(), vw.Ts.Day(), vw.Ts.Hour(), 0, 0, 0, vw.Ts.Location())
+	t := startOfHour(vw.Ts)
+	snapshot := vw.Ts.Truncate(time.Second)
 	for _, usr := range vw.Viewers {
-		if _, err := stmt.Exec(t, usr, vw.Channel, "view"); err != nil {
+		if _, err := stmt.ExecContext(ctx, t, usr, vw.Channel, "view", vw.Stream, snapshot); err != nil {
 			l.Error().Err(err).Msg("error while adding values to the batch")
 			return err
 		}
@@ -73,21 +131,10 @@ func InsertViewers(db *sql.DB, vw *Viewers) error {
 }
 
 func ReconcileEvents(db *sql.DB, lastAt time.Time, window time.Duration) error {
+	defer metrics.ObserveQuery("clickhouse", "ReconcileEvents")()
 	l := utils.Logger("query")
 
-	const margin = 15 * time.Minute
-	// The window is the max. time an event can have relation with future events
-	// (or future events with previous ones), covering all the events than can
-	// have events related. Then we round it to the start of the our since events
-	// are also rounded and we add an extra margin just in case.
-	//
-	// So that should select all the elements that can have elements related to
-	// them and that are not already processed (plus others that are already
-	// processed). Duplicates are handled by the database with a
-	// ReplacingMergeTree.
-	t := lastAt.Add(-window)
-	t2 := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
-	since := t2.Add(-margin)
+	since := reconcileSince(lastAt, window)
 
 	l.Info().Msgf("event reconciliation since: %s", since)
 
@@ -125,86 +172,63 @@ func ReconcileEvents(db *sql.DB, lastAt time.Time, window time.Duration) error {
 	return nil
 }
 
+// UserFlowsByDstHourly returns the top flows to `channel` of each hour. See
+// UserFlowsByDst.
 func UserFlowsByDstHourly(db *sql.DB, channel string, from, to time.Time) ([]*UserFlowDst, error) {
-	l := utils.Logger("query", "q", "UserFlowsByDstHourly")
+	return UserFlowsByDst(db, channel, from, to, &FlowOpts{Granularity: Hour})
+}
+
+// UserFlowsBySrcHourly returns the top flows from `referrer` of each hour.
+// See UserFlowsBySrc.
+func UserFlowsBySrcHourly(db *sql.DB, referrer string, from, to time.Time) ([]*UserFlowSrc, error) {
+	return UserFlowsBySrc(db, referrer, from, to, &FlowOpts{Granularity: Hour})
+}
+
+// FlowEdges returns the unique users that went from a channel to another in
+// the time range, for each pair of channels with at least `minWeight` users.
+// Edges are sorted by total, descending.
+func FlowEdges(db *sql.DB, from, to time.Time, minWeight uint64) ([]*FlowEdge, error) {
+	defer metrics.ObserveQuery("clickhouse", "FlowEdges")()
+	l := utils.Logger("query", "q", "FlowEdges")
 
-	const max = 20
 	rows, err := db.Query(`
     SELECT
-      ts, referrer,
+      referrer, channel,
       uniqMerge(total_users) as total
     FROM aggregated_flows_by_dst
     WHERE
-      channel = @Channel AND
       ts >= @From AND
       ts <= @To
-    GROUP BY channel, ts, referrer
-    ORDER BY ts ASC, total DESC
-    LIMIT @Max
+    GROUP BY referrer, channel
+    HAVING total >= @MinWeight
+    ORDER BY total DESC, referrer ASC, channel ASC
   `,
-		sql.Named("Channel", channel),
 		sql.Named("From", from),
 		sql.Named("To", to),
-		sql.Named("Max", max),
+		sql.Named("MinWeight", minWeight),
 	)
 	if err != nil {
 		l.Error().Err(err).Msg("error while executing query")
 		return nil, err
 	}
+	defer rows.Close()
 
-	r := make([]*UserFlowDst, 0, max)
+	r := make([]*FlowEdge, 0)
 	for rows.Next() {
-		flow := new(UserFlowDst)
+		e := new(FlowEdge)
 		if err := rows.Scan(
-			&flow.Ts,
-			&flow.Referrer,
-			&flow.Total,
+			&e.Referrer,
+			&e.Channel,
+			&e.Total,
 		); err != nil {
 			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
 		}
-		r = append(r, flow)
+		r = append(r, e)
 	}
-	return r, nil
-}
-
-func UserFlowsBySrcHourly(db *sql.DB, referrer string, from, to time.Time) ([]*UserFlowSrc, error) {
-	l := utils.Logger("query", "q", "UserFlowsBySrcHourly")
-
-	const max = 20
-	rows, err := db.Query(`
-	   SELECT
-	     ts, channel,
-	     uniqMerge(total_users) as total
-	   FROM aggregated_flows_by_src
-	   WHERE
-	     referrer = @Referrer AND
-	     ts >= @From AND
-	     ts <= @To
-	   GROUP BY referrer, ts, channel
-	   ORDER BY ts ASC, total DESC
-	   LIMIT @Max
-	 `,
-		sql.Named("Referrer", referrer),
-		sql.Named("From", from),
-		sql.Named("To", to),
-		sql.Named("Max", max),
-	)
-	if err != nil {
-		l.Error().Err(err).Msg("error while executing query")
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
 		return nil, err
 	}
-
-	r := make([]*UserFlowSrc, 0, max)
-	for rows.Next() {
-		flow := new(UserFlowSrc)
-		if err := rows.Scan(
-			&flow.Ts,
-			&flow.Channel,
-			&flow.Total,
-		); err != nil {
-			l.Error().Err(err).Msg("error while scanning")
-		}
-		r = append(r, flow)
-	}
 	return r, nil
 }
@@ -1,6 +1,7 @@
 package clickhouse
 
 import (
+	"context"
 	"database/sql"
 	"io"
 	"testing"
@@ -15,6 +16,22 @@ func cleanTable(table string) {
 	_ = db.QueryRow("TRUNCATE TABLE " + table)
 }
 
+func cleanTables() {
+	cleanTable("raw_events")
+	cleanTable("events")
+	cleanTable("aggregated_flows_by_dst")
+	cleanTable("aggregated_flows_by_src")
+	cleanTable("aggregated_flows_by_dst_daily")
+	cleanTable("aggregated_flows_by_src_daily")
+	cleanTable("aggregated_audience")
+	cleanTable("stream_snapshots")
+	cleanTable("first_seen")
+	cleanTable("weekly_viewers")
+	cleanTable("similar_channels")
+	cleanTable("channel_communities")
+	cleanTable("raids")
+}
+
 func parseTime(timestr string) time.Time {
 	ts, err := time.Parse(time.RFC3339, timestr)
 	if err != nil {
@@ -23,28 +40,107 @@ func parseTime(timestr string) time.Time {
 	return ts
 }
 
-func TestInsertViewers(t *testing.T) {
-	t.Cleanup(func() {
-		cleanTable("raw_events")
-	})
+// harness is a Repository under test along with the helpers to seed and
+// inspect its tables. Raw events and events are returned in the order of their
+// tables.
+type harness struct {
+	repo           Repository
+	insertRawEvent func(ts, username, channel, evttype string)
+	rawEvents      func(t *testing.T) []*RawEvent
+	events         func(t *testing.T) []*Event
+}
 
-	ts := parseTime("2020-10-11T10:30:20.123Z")
+// conformance are the tests every Repository must pass
+var conformance = []struct {
+	name string
+	test func(t *testing.T, h *harness)
+}{
+	{"InsertViewers", testInsertViewers},
+	{"ReconcileTimings", testReconcileTimings},
+	{"ReconcileSameTime", testReconcileSameTime},
+	{"ReconcileMultipleUsers", testReconcileMultipleUsers},
+	{"FlowsDstHourly", testFlowsDstHourly},
+	{"FlowsSrcHourly", testFlowsSrcHourly},
+	{"FlowsUniqueUsers", testFlowsUniqueUsers},
+	{"FlowsGranularity", testFlowsGranularity},
+	{"FlowsOptions", testFlowsOptions},
+	{"FlowEdges", testFlowEdges},
+	{"AudienceOverlap", testAudienceOverlap},
+	{"Streams", testStreams},
+	{"Cohorts", testCohorts},
+	{"Churn", testChurn},
+	{"SimilarChannels", testSimilarChannels},
+	{"Communities", testCommunities},
+	{"Raids", testRaids},
+}
 
-	vw := &Viewers{
-		Ts:      ts,
-		Viewers: []string{"user1", "user2", "user3", "user4", "user5"},
-		Channel: "streamer1",
+func runConformance(t *testing.T, newHarness func(t *testing.T) *harness) {
+	for _, c := range conformance {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.test(t, newHarness(t))
+		})
 	}
+}
+
+func TestConformanceMemory(t *testing.T) {
+	runConformance(t, func(t *testing.T) *harness {
+		m := NewMemory()
+		return &harness{
+			repo: m,
+			insertRawEvent: func(ts, username, channel, evttype string) {
+				m.InsertRawEvent(&RawEvent{
+					Ts:        parseTime(ts),
+					Username:  username,
+					Channel:   channel,
+					EventType: evttype,
+				})
+			},
+			rawEvents: func(t *testing.T) []*RawEvent { return m.RawEvents() },
+			events:    func(t *testing.T) []*Event { return m.Events() },
+		}
+	})
+}
 
-	if err := InsertViewers(db, vw); err != nil {
+func TestConformanceDB(t *testing.T) {
+	requireDB(t)
+	runConformance(t, func(t *testing.T) *harness {
+		cleanTables()
+		t.Cleanup(cleanTables)
+		return &harness{
+			repo: New(db),
+			insertRawEvent: func(ts, username, channel, evttype string) {
+				insertRawEvent(t, ts, username, channel, evttype)
+			},
+			rawEvents: queryRawEvents,
+			events:    queryEvents,
+		}
+	})
+}
+
+func insertRawEvent(t *testing.T, ts, username, channel, evttype string) {
+	row := db.QueryRow(
+		"INSERT INTO raw_events (ts, username, channel, event_type) VALUES (@Ts, @Username, @Channel, @EvtType)",
+		sql.Named("Ts", parseTime(ts)),
+		sql.Named("Username", username),
+		sql.Named("Channel", channel),
+		sql.Named("EvtType", evttype),
+	)
+	if err := row.Err(); err != nil {
 		t.Fatal(err)
 	}
+}
 
-	rows, err := db.Query("SELECT toTimeZone(ts, 'UTC'), username, channel, event_type FROM raw_events")
+func queryRawEvents(t *testing.T) []*RawEvent {
+	rows, err := db.Query(`
+    SELECT toTimeZone(ts, 'UTC'), username, channel, event_type
+    FROM raw_events
+    ORDER BY username, ts, channel
+  `)
 	if err != nil {
 		t.Fatal(err)
 	}
-	got := make([]*RawEvent, 0, len(vw.Viewers))
+	got := make([]*RawEvent, 0)
 	for rows.Next() {
 		evt := new(RawEvent)
 		if err := rows.Scan(
@@ -57,6 +153,53 @@ func TestInsertViewers(t *testing.T) {
 		}
 		got = append(got, evt)
 	}
+	return got
+}
+
+func queryEvents(t *testing.T) []*Event {
+	row := db.QueryRow("OPTIMIZE TABLE events FINAL")
+	if err := row.Err(); err != nil {
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := db.Query(`
+    SELECT toTimeZone(ts, 'UTC'), username, channel, referrer
+    FROM events
+    ORDER BY channel, ts, referrer, username
+  `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]*Event, 0)
+	for rows.Next() {
+		evt := new(Event)
+		if err := rows.Scan(
+			&evt.Ts,
+			&evt.Username,
+			&evt.Channel,
+			&evt.Referrer,
+		); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, evt)
+	}
+	return got
+}
+
+func testInsertViewers(t *testing.T, h *harness) {
+	ts := parseTime("2020-10-11T10:30:20.123Z")
+
+	vw := &Viewers{
+		Ts:      ts,
+		Viewers: []string{"user1", "user2", "user3", "user4", "user5"},
+		Channel: "streamer1",
+	}
+
+	if err := h.repo.InsertViewers(context.Background(), vw); err != nil {
+		t.Fatal(err)
+	}
 
 	wantTs := parseTime("2020-10-11T10:00:00Z")
 	want := []*RawEvent{
@@ -66,333 +209,222 @@ func TestInsertViewers(t *testing.T) {
 		{Ts: wantTs, Username: "user4", Channel: "streamer1", EventType: "view"},
 		{Ts: wantTs, Username: "user5", Channel: "streamer1", EventType: "view"},
 	}
-	if diff := deep.Equal(got, want); diff != nil {
+	if diff := deep.Equal(h.rawEvents(t), want); diff != nil {
 		t.Fatal(diff)
 	}
 }
 
-func insertRawEvent(ts, username, channel, evttype string) {
-	_ = db.QueryRow(
-		"INSERT INTO raw_events VALUES (@Ts, @Username, @Channel, @EvtType)",
-		sql.Named("Ts", parseTime(ts)),
-		sql.Named("Username", username),
-		sql.Named("Channel", channel),
-		sql.Named("EvtType", evttype),
-	)
-}
-
-func TestReconcileTimings(t *testing.T) {
-	t.Cleanup(func() {
-		cleanTable("raw_events")
-		cleanTable("events")
-		cleanTable("aggregated_flows_by_dst")
-		cleanTable("aggregated_flows_by_src")
-	})
-
+func testReconcileTimings(t *testing.T, h *harness) {
 	/*
 	            10          11          12          13          15
 	  events:   |                       |           |           |
 	  recon.:                 |                       |                |
 	*/
 
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T10:00:00Z",
 		"user1",
 		"alexelcapo",
 		"view",
 	)
-	if err := ReconcileEvents(db, time.Time{}, 2*time.Hour); err != nil {
+	if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
 		t.Fatal(err)
 	}
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T12:00:00Z",
 		"user1",
 		"jujalag",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T13:00:00Z",
 		"user1",
 		"chuso",
 		"view",
 	)
-	if err := ReconcileEvents(db, parseTime("2020-10-11T11:05:00Z"), 2*time.Hour); err != nil {
+	if err := h.repo.ReconcileEvents(parseTime("2020-10-11T11:05:00Z"), 2*time.Hour); err != nil {
 		t.Fatal(err)
 	}
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T15:00:00Z",
 		"user1",
 		"yuste",
 		"view",
 	)
-	if err := ReconcileEvents(db, parseTime("2020-10-11T13:05:00Z"), 2*time.Hour); err != nil {
-		t.Fatal(err)
-	}
-	row := db.QueryRow("OPTIMIZE TABLE events")
-	if err := row.Err(); err != nil {
-		if err != io.EOF {
-			t.Fatal(err)
-		}
-	}
-
-	rows, err := db.Query("SELECT toTimeZone(ts, 'UTC'), username, channel, referrer FROM events ORDER BY ts")
-	if err != nil {
+	if err := h.repo.ReconcileEvents(parseTime("2020-10-11T13:05:00Z"), 2*time.Hour); err != nil {
 		t.Fatal(err)
 	}
-	got := make([]*Event, 0, 3)
-	for rows.Next() {
-		evt := new(Event)
-		if err := rows.Scan(
-			&evt.Ts,
-			&evt.Username,
-			&evt.Channel,
-			&evt.Referrer,
-		); err != nil {
-			t.Fatal(err)
-		}
-		got = append(got, evt)
-	}
 
 	want := []*Event{
-		{Ts: parseTime("2020-10-11T12:00:00Z"), Username: "user1", Channel: "jujalag", Referrer: "alexelcapo"},
 		{Ts: parseTime("2020-10-11T13:00:00Z"), Username: "user1", Channel: "chuso", Referrer: "jujalag"},
+		{Ts: parseTime("2020-10-11T12:00:00Z"), Username: "user1", Channel: "jujalag", Referrer: "alexelcapo"},
 		{Ts: parseTime("2020-10-11T15:00:00Z"), Username: "user1", Channel: "yuste", Referrer: "chuso"},
 	}
-	if diff := deep.Equal(got, want); diff != nil {
+	if diff := deep.Equal(h.events(t), want); diff != nil {
 		t.Fatal(diff)
 	}
 }
 
-func TestReconcileSameTime(t *testing.T) {
-	t.Cleanup(func() {
-		cleanTable("raw_events")
-		cleanTable("events")
-		cleanTable("aggregated_flows_by_dst")
-		cleanTable("aggregated_flows_by_src")
-	})
-
-	insertRawEvent(
+func testReconcileSameTime(t *testing.T, h *harness) {
+	h.insertRawEvent(
 		"2020-10-11T10:00:00Z",
 		"user1",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T10:00:00Z",
 		"user1",
 		"jujalag",
 		"view",
 	)
-	if err := ReconcileEvents(db, time.Time{}, 2*time.Hour); err != nil {
+	if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
 		t.Fatal(err)
 	}
-	row := db.QueryRow("OPTIMIZE TABLE events")
-	if err := row.Err(); err != nil {
-		if err != io.EOF {
-			t.Fatal(err)
-		}
-	}
-
-	rows, err := db.Query("SELECT toTimeZone(ts, 'UTC'), username, channel, referrer FROM events ORDER BY ts")
-	if err != nil {
-		t.Fatal(err)
-	}
-	got := make([]*Event, 0, 3)
-	for rows.Next() {
-		evt := new(Event)
-		if err := rows.Scan(
-			&evt.Ts,
-			&evt.Username,
-			&evt.Channel,
-			&evt.Referrer,
-		); err != nil {
-			t.Fatal(err)
-		}
-		got = append(got, evt)
-	}
 
 	want := []*Event{
 		{Ts: parseTime("2020-10-11T10:00:00Z"), Username: "user1", Channel: "alexelcapo", Referrer: "jujalag"},
 		{Ts: parseTime("2020-10-11T10:00:00Z"), Username: "user1", Channel: "jujalag", Referrer: "alexelcapo"},
 	}
-	if diff := deep.Equal(got, want); diff != nil {
+	if diff := deep.Equal(h.events(t), want); diff != nil {
 		t.Fatal(diff)
 	}
 }
 
-func TestReconcileMultipleUsers(t *testing.T) {
-	t.Cleanup(func() {
-		cleanTable("raw_events")
-		cleanTable("events")
-		cleanTable("aggregated_flows_by_dst")
-		cleanTable("aggregated_flows_by_src")
-	})
-
-	insertRawEvent(
+func testReconcileMultipleUsers(t *testing.T, h *harness) {
+	h.insertRawEvent(
 		"2020-10-11T07:00:00Z",
 		"user1",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T07:00:00Z",
 		"user2",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T07:00:00Z",
 		"user3",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T08:00:00Z",
 		"user1",
 		"jujalag",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T08:00:00Z",
 		"user2",
 		"jujalag",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T08:00:00Z",
 		"user3",
 		"chuso",
 		"view",
 	)
 
-	if err := ReconcileEvents(db, time.Time{}, 2*time.Hour); err != nil {
-		t.Fatal(err)
-	}
-	row := db.QueryRow("OPTIMIZE TABLE events")
-	if err := row.Err(); err != nil {
-		if err != io.EOF {
-			t.Fatal(err)
-		}
-	}
-
-	rows, err := db.Query("SELECT toTimeZone(ts, 'UTC'), username, channel, referrer FROM events ORDER BY username")
-	if err != nil {
+	if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
 		t.Fatal(err)
 	}
-	got := make([]*Event, 0, 3)
-	for rows.Next() {
-		evt := new(Event)
-		if err := rows.Scan(
-			&evt.Ts,
-			&evt.Username,
-			&evt.Channel,
-			&evt.Referrer,
-		); err != nil {
-			t.Fatal(err)
-		}
-		got = append(got, evt)
-	}
 
 	want := []*Event{
+		{Ts: parseTime("2020-10-11T08:00:00Z"), Username: "user3", Channel: "chuso", Referrer: "alexelcapo"},
 		{Ts: parseTime("2020-10-11T08:00:00Z"), Username: "user1", Channel: "jujalag", Referrer: "alexelcapo"},
 		{Ts: parseTime("2020-10-11T08:00:00Z"), Username: "user2", Channel: "jujalag", Referrer: "alexelcapo"},
-		{Ts: parseTime("2020-10-11T08:00:00Z"), Username: "user3", Channel: "chuso", Referrer: "alexelcapo"},
 	}
-	if diff := deep.Equal(got, want); diff != nil {
+	if diff := deep.Equal(h.events(t), want); diff != nil {
 		t.Fatal(diff)
 	}
-
 }
 
-func TestFlowsDstHourly(t *testing.T) {
-	t.Cleanup(func() {
-		cleanTable("raw_events")
-		cleanTable("events")
-		cleanTable("aggregated_flows_by_dst")
-		cleanTable("aggregated_flows_by_src")
-	})
-
-	insertRawEvent(
+func testFlowsDstHourly(t *testing.T, h *harness) {
+	h.insertRawEvent(
 		"2020-10-11T08:00:00Z",
 		"user1",
 		"jujalag",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T08:00:00Z",
 		"user2",
 		"jujalag",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T10:00:00Z",
 		"user3",
 		"felipez",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T08:00:00Z",
 		"user4",
 		"jujalag",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T09:00:00Z",
 		"user5",
 		"felipez",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T10:00:00Z",
 		"user1",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T10:00:00Z",
 		"user2",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T10:00:00Z",
 		"user3",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T10:00:00Z",
 		"user4",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T10:00:00Z",
 		"user5",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T10:00:00Z",
 		"user6",
 		"yuste",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T12:00:00Z",
 		"user6",
 		"alexelcapo",
 		"view",
 	)
-	if err := ReconcileEvents(db, time.Time{}, 2*time.Hour); err != nil {
+	if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
 		t.Fatal(err)
 	}
 
-	got, err := UserFlowsByDstHourly(
-		db,
+	got, err := h.repo.UserFlowsByDst(
 		"alexelcapo",
 		parseTime("2020-10-11T08:00:00Z"),
 		parseTime("2020-10-11T12:00:00Z"),
+		nil,
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -408,95 +440,88 @@ func TestFlowsDstHourly(t *testing.T) {
 	}
 }
 
-func TestFlowsSrcHourly(t *testing.T) {
-	t.Cleanup(func() {
-		cleanTable("raw_events")
-		cleanTable("events")
-		cleanTable("aggregated_flows_by_dst")
-		cleanTable("aggregated_flows_by_src")
-	})
-
-	insertRawEvent(
+func testFlowsSrcHourly(t *testing.T, h *harness) {
+	h.insertRawEvent(
 		"2020-10-11T07:00:00Z",
 		"user1",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T07:00:00Z",
 		"user2",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T07:00:00Z",
 		"user3",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T07:00:00Z",
 		"user4",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T07:00:00Z",
 		"user5",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T08:00:00Z",
 		"user1",
 		"jujalag",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T08:00:00Z",
 		"user2",
 		"jujalag",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T08:00:00Z",
 		"user3",
 		"felipez",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T08:00:00Z",
 		"user4",
 		"jujalag",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T09:00:00Z",
 		"user5",
 		"felipez",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T09:00:00Z",
 		"user6",
 		"alexelcapo",
 		"view",
 	)
-	insertRawEvent(
+	h.insertRawEvent(
 		"2020-10-11T11:00:00Z",
 		"user6",
 		"yuste",
 		"view",
 	)
-	if err := ReconcileEvents(db, time.Time{}, 2*time.Hour); err != nil {
+	if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
 		t.Fatal(err)
 	}
 
-	got, err := UserFlowsBySrcHourly(
-		db,
+	got, err := h.repo.UserFlowsBySrc(
 		"alexelcapo",
 		parseTime("2020-10-11T08:00:00Z"),
 		parseTime("2020-10-11T12:00:00Z"),
+		nil,
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -504,11 +529,107 @@ func TestFlowsSrcHourly(t *testing.T) {
 
 	want := []*UserFlowSrc{
 		{Ts: parseTime("2020-10-11T08:00:00Z"), Channel: "jujalag", Total: 3},
-		{Ts: parseTime("2020-10-11T8:00:00Z"), Channel: "felipez", Total: 1},
-		{Ts: parseTime("2020-10-11T9:00:00Z"), Channel: "felipez", Total: 1},
+		{Ts: parseTime("2020-10-11T08:00:00Z"), Channel: "felipez", Total: 1},
+		{Ts: parseTime("2020-10-11T09:00:00Z"), Channel: "felipez", Total: 1},
 		{Ts: parseTime("2020-10-11T11:00:00Z"), Channel: "yuste", Total: 1},
 	}
 	if diff := deep.Equal(got, want); diff != nil {
 		t.Fatal(diff)
 	}
 }
+
+func testFlowsUniqueUsers(t *testing.T, h *harness) {
+	// user1 comes twice from alexelcapo within the window and the events are
+	// reconciled twice, but it is counted once
+	h.insertRawEvent(
+		"2020-10-11T08:00:00Z",
+		"user1",
+		"alexelcapo",
+		"view",
+	)
+	h.insertRawEvent(
+		"2020-10-11T09:00:00Z",
+		"user1",
+		"alexelcapo",
+		"view",
+	)
+	h.insertRawEvent(
+		"2020-10-11T10:00:00Z",
+		"user1",
+		"jujalag",
+		"view",
+	)
+	h.insertRawEvent(
+		"2020-10-11T10:00:00Z",
+		"user2",
+		"jujalag",
+		"view",
+	)
+	for i := 0; i < 2; i++ {
+		if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := h.repo.UserFlowsByDst(
+		"jujalag",
+		parseTime("2020-10-11T08:00:00Z"),
+		parseTime("2020-10-11T12:00:00Z"),
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*UserFlowDst{
+		{Ts: parseTime("2020-10-11T10:00:00Z"), Referrer: "alexelcapo", Total: 1},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func testFlowEdges(t *testing.T, h *harness) {
+	for _, evt := range [][3]string{
+		{"2020-10-11T08:00:00Z", "user1", "a"},
+		{"2020-10-11T09:00:00Z", "user1", "b"},
+		{"2020-10-11T10:00:00Z", "user1", "c"},
+		{"2020-10-11T08:00:00Z", "user2", "a"},
+		{"2020-10-11T09:00:00Z", "user2", "b"},
+		{"2020-10-11T09:00:00Z", "user3", "a"},
+		{"2020-10-11T10:00:00Z", "user3", "b"},
+		{"2020-10-11T09:00:00Z", "user4", "b"},
+		{"2020-10-11T10:00:00Z", "user4", "a"},
+		// out of the time range
+		{"2020-10-11T13:00:00Z", "user5", "a"},
+		{"2020-10-11T14:00:00Z", "user5", "b"},
+	} {
+		h.insertRawEvent(evt[0], evt[1], evt[2], "view")
+	}
+	if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	from, to := parseTime("2020-10-11T08:00:00Z"), parseTime("2020-10-11T12:00:00Z")
+	got, err := h.repo.FlowEdges(from, to, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*FlowEdge{
+		{Referrer: "a", Channel: "b", Total: 3},
+		{Referrer: "a", Channel: "c", Total: 1},
+		{Referrer: "b", Channel: "a", Total: 1},
+		{Referrer: "b", Channel: "c", Total: 1},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	got, err = h.repo.FlowEdges(from, to, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, want[:1]); diff != nil {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,311 @@
+package clickhouse
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/utils"
+)
+
+// Granularity is the size of the time buckets of the flow queries
+type Granularity string
+
+const (
+	Hour  Granularity = "hour"
+	Day   Granularity = "day"
+	Week  Granularity = "week"
+	Month Granularity = "month"
+	// Total is a single bucket with the whole period, starting at `from`
+	Total Granularity = "total"
+)
+
+var ErrUnknownGranularity = errors.New("unknown granularity, expected hour, day, week, month or total")
+
+func ParseGranularity(s string) (Granularity, error) {
+	switch g := Granularity(s); g {
+	case Hour, Day, Week, Month, Total:
+		return g, nil
+	}
+	return "", ErrUnknownGranularity
+}
+
+// DefaultFlowLimit is the default number of flows of each bucket
+const DefaultFlowLimit = 20
+
+// MaxFlowLimit is the maximum number of flows of each bucket
+const MaxFlowLimit = 1000
+
+// FlowOrder is the order of the flows within a bucket. Buckets are always in
+// ascending order.
+type FlowOrder string
+
+const (
+	// OrderTotal sorts the flows by total, descending, and then by channel
+	OrderTotal FlowOrder = "total"
+	// OrderChannel sorts the flows by channel, ascending
+	OrderChannel FlowOrder = "channel"
+)
+
+var (
+	ErrUnknownFlowOrder = errors.New("unknown flow order, expected total or channel")
+	ErrInvalidLimit     = fmt.Errorf("the flow limit must be in the range [0, %d]", MaxFlowLimit)
+	ErrInvalidOffset    = errors.New("the flow offset can't be negative")
+)
+
+type FlowOpts struct {
+	// Granularity is the size of the buckets, Hour by default.
+	//
+	// Day, week and month buckets are read from the daily rollups, so the
+	// range is extended to the start of the day of `from`. Days, weeks and
+	// months start at 00:00 UTC, weeks on monday.
+	Granularity Granularity
+	// Limit is the maximum number of flows of each bucket, at most
+	// MaxFlowLimit. DefaultFlowLimit by default.
+	Limit int
+	// Offset is the number of flows of each bucket skipped, for pagination.
+	Offset int
+	// MinTotal is the minimum number of users of a flow
+	MinTotal uint64
+	// Include and Exclude filter the other channel of the flows: the referrer
+	// of the flows to a channel and the channel of the flows from a referrer.
+	// An empty Include means every channel.
+	Include []string
+	Exclude []string
+	// Order is the order of the flows within a bucket, OrderTotal by default.
+	Order FlowOrder
+}
+
+func (o *FlowOpts) withDefaults() (*FlowOpts, error) {
+	r := FlowOpts{Granularity: Hour, Limit: DefaultFlowLimit, Order: OrderTotal}
+	if o != nil {
+		if o.Limit < 0 || o.Limit > MaxFlowLimit {
+			return nil, ErrInvalidLimit
+		}
+		if o.Offset < 0 {
+			return nil, ErrInvalidOffset
+		}
+		r.Offset = o.Offset
+		r.MinTotal = o.MinTotal
+		r.Include = o.Include
+		r.Exclude = o.Exclude
+		if o.Granularity != "" {
+			r.Granularity = o.Granularity
+		}
+		if o.Limit > 0 {
+			r.Limit = o.Limit
+		}
+		if o.Order != "" {
+			r.Order = o.Order
+		}
+	}
+	if _, err := ParseGranularity(string(r.Granularity)); err != nil {
+		return nil, err
+	}
+	if _, err := ParseFlowOrder(string(r.Order)); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func ParseFlowOrder(s string) (FlowOrder, error) {
+	switch o := FlowOrder(s); o {
+	case OrderTotal, OrderChannel:
+		return o, nil
+	}
+	return "", ErrUnknownFlowOrder
+}
+
+// filter reports whether the flow with the other channel `ch` and `total`
+// users is selected by the options
+func (o *FlowOpts) filter(ch string, total uint64) bool {
+	if total < o.MinTotal {
+		return false
+	}
+	if len(o.Include) > 0 && !contains(o.Include, ch) {
+		return false
+	}
+	return !contains(o.Exclude, ch)
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket returns the start of the bucket of `ts` for the granularity `g`,
+// where `from` is the start of the period
+func (g Granularity) bucket(ts, from time.Time) time.Time {
+	ts = ts.UTC()
+	switch g {
+	case Day:
+		return startOfDay(ts)
+	case Week:
+		d := startOfDay(ts)
+		// time.Sunday is 0, weeks start on monday
+		return d.AddDate(0, 0, -(int(d.Weekday())+6)%7)
+	case Month:
+		return time.Date(ts.Year(), ts.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case Total:
+		return from
+	}
+	return ts
+}
+
+// daily reports whether the buckets are read from the daily rollups
+func (g Granularity) daily() bool {
+	return g == Day || g == Week || g == Month
+}
+
+// since returns the start of the range of the buckets beginning at `from`
+func (g Granularity) since(from time.Time) time.Time {
+	if g.daily() {
+		return startOfDay(from.UTC())
+	}
+	return from
+}
+
+// bucketExpr is the ClickHouse expression of the bucket of the `ts` column
+func (g Granularity) bucketExpr() string {
+	switch g {
+	case Day:
+		return "ts"
+	case Week:
+		return "toDateTime(toMonday(ts, 'UTC'), 'UTC')"
+	case Month:
+		return "toDateTime(toStartOfMonth(ts, 'UTC'), 'UTC')"
+	case Total:
+		return "@From"
+	}
+	return "ts"
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// flowsQuery returns the query of the flows of the aggregated `table` where
+// `key` is `value`, grouped by bucket and the `group` column, and its
+// arguments. The flows of each bucket are filtered, sorted and paginated by
+// `opts`.
+func flowsQuery(table, key, value, group string, from, to time.Time, opts *FlowOpts) (string, []any) {
+	if opts.Granularity.daily() {
+		table += "_daily"
+	}
+	args := []any{
+		sql.Named("Key", value),
+		sql.Named("From", from),
+		sql.Named("Since", opts.Granularity.since(from)),
+		sql.Named("To", to),
+		sql.Named("MinTotal", opts.MinTotal),
+	}
+	var filters string
+	if len(opts.Include) > 0 {
+		filters += fmt.Sprintf(" AND\n      has(@Include, %s)", group)
+		args = append(args, sql.Named("Include", opts.Include))
+	}
+	if len(opts.Exclude) > 0 {
+		filters += fmt.Sprintf(" AND\n      NOT has(@Exclude, %s)", group)
+		args = append(args, sql.Named("Exclude", opts.Exclude))
+	}
+	order := "total DESC, " + group + " ASC"
+	if opts.Order == OrderChannel {
+		order = group + " ASC"
+	}
+	return fmt.Sprintf(`
+    SELECT
+      %[4]s AS bucket, %[3]s,
+      uniqMerge(total_users) as total
+    FROM %[1]s
+    WHERE
+      %[2]s = @Key AND
+      ts >= @Since AND
+      ts <= @To%[5]s
+    GROUP BY bucket, %[3]s
+    HAVING total >= @MinTotal
+    ORDER BY bucket ASC, %[6]s
+    LIMIT %[7]d, %[8]d BY bucket
+  `, table, key, group, opts.Granularity.bucketExpr(), filters, order, opts.Offset, opts.Limit), args
+}
+
+// UserFlowsByDst returns the unique users that came to `channel` from other
+// channels in the time range, by bucket and referrer. See FlowOpts.
+func UserFlowsByDst(db *sql.DB, channel string, from, to time.Time, opts *FlowOpts) ([]*UserFlowDst, error) {
+	defer metrics.ObserveQuery("clickhouse", "UserFlowsByDst")()
+	l := utils.Logger("query", "q", "UserFlowsByDst")
+
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	query, args := flowsQuery("aggregated_flows_by_dst", "channel", channel, "referrer", from, to, opts)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := make([]*UserFlowDst, 0, opts.Limit)
+	for rows.Next() {
+		flow := new(UserFlowDst)
+		if err := rows.Scan(
+			&flow.Ts,
+			&flow.Referrer,
+			&flow.Total,
+		); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		r = append(r, flow)
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+	return r, nil
+}
+
+// UserFlowsBySrc returns the unique users that went from `referrer` to other
+// channels in the time range, by bucket and channel. See FlowOpts.
+func UserFlowsBySrc(db *sql.DB, referrer string, from, to time.Time, opts *FlowOpts) ([]*UserFlowSrc, error) {
+	defer metrics.ObserveQuery("clickhouse", "UserFlowsBySrc")()
+	l := utils.Logger("query", "q", "UserFlowsBySrc")
+
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	query, args := flowsQuery("aggregated_flows_by_src", "referrer", referrer, "channel", from, to, opts)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := make([]*UserFlowSrc, 0, opts.Limit)
+	for rows.Next() {
+		flow := new(UserFlowSrc)
+		if err := rows.Scan(
+			&flow.Ts,
+			&flow.Channel,
+			&flow.Total,
+		); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		r = append(r, flow)
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+	return r, nil
+}
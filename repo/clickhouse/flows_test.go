@@ -0,0 +1,199 @@
+package clickhouse
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+// insertFlows inserts views of `referrer` at 09:00 and of x at 10:00 of the
+// given days, for each user
+func insertFlows(h *harness, days []string, referrer string, users ...string) {
+	for _, day := range days {
+		for _, usr := range users {
+			h.insertRawEvent(day+"T09:00:00Z", usr, referrer, "view")
+			h.insertRawEvent(day+"T10:00:00Z", usr, "x", "view")
+		}
+	}
+}
+
+func testFlowsGranularity(t *testing.T, h *harness) {
+	// 2020-10-05 and 2020-10-12 are mondays
+	insertFlows(h, []string{"2020-10-05"}, "a", "user1", "user2")
+	insertFlows(h, []string{"2020-10-05"}, "b", "user3")
+	insertFlows(h, []string{"2020-10-06"}, "b", "user1")
+	insertFlows(h, []string{"2020-10-12"}, "a", "user4")
+	insertFlows(h, []string{"2020-11-02"}, "c", "user5")
+	if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// the daily rollups extend the range to the start of the day
+	from, to := parseTime("2020-10-05T09:30:00Z"), parseTime("2020-11-30T00:00:00Z")
+	tests := []struct {
+		opts *FlowOpts
+		want []*UserFlowDst
+	}{
+		{
+			// top 1 of each hour, not of the whole range
+			opts: &FlowOpts{Granularity: Hour, Limit: 1},
+			want: []*UserFlowDst{
+				{Ts: parseTime("2020-10-05T10:00:00Z"), Referrer: "a", Total: 2},
+				{Ts: parseTime("2020-10-06T10:00:00Z"), Referrer: "b", Total: 1},
+				{Ts: parseTime("2020-10-12T10:00:00Z"), Referrer: "a", Total: 1},
+				{Ts: parseTime("2020-11-02T10:00:00Z"), Referrer: "c", Total: 1},
+			},
+		},
+		{
+			opts: &FlowOpts{Granularity: Day},
+			want: []*UserFlowDst{
+				{Ts: parseTime("2020-10-05T00:00:00Z"), Referrer: "a", Total: 2},
+				{Ts: parseTime("2020-10-05T00:00:00Z"), Referrer: "b", Total: 1},
+				{Ts: parseTime("2020-10-06T00:00:00Z"), Referrer: "b", Total: 1},
+				{Ts: parseTime("2020-10-12T00:00:00Z"), Referrer: "a", Total: 1},
+				{Ts: parseTime("2020-11-02T00:00:00Z"), Referrer: "c", Total: 1},
+			},
+		},
+		{
+			// user1 came from b on two days of the week but is counted once
+			opts: &FlowOpts{Granularity: Week},
+			want: []*UserFlowDst{
+				{Ts: parseTime("2020-10-05T00:00:00Z"), Referrer: "a", Total: 2},
+				{Ts: parseTime("2020-10-05T00:00:00Z"), Referrer: "b", Total: 2},
+				{Ts: parseTime("2020-10-12T00:00:00Z"), Referrer: "a", Total: 1},
+				{Ts: parseTime("2020-11-02T00:00:00Z"), Referrer: "c", Total: 1},
+			},
+		},
+		{
+			opts: &FlowOpts{Granularity: Month},
+			want: []*UserFlowDst{
+				{Ts: parseTime("2020-10-01T00:00:00Z"), Referrer: "a", Total: 3},
+				{Ts: parseTime("2020-10-01T00:00:00Z"), Referrer: "b", Total: 2},
+				{Ts: parseTime("2020-11-01T00:00:00Z"), Referrer: "c", Total: 1},
+			},
+		},
+		{
+			opts: &FlowOpts{Granularity: Total, Limit: 2},
+			want: []*UserFlowDst{
+				{Ts: from, Referrer: "a", Total: 3},
+				{Ts: from, Referrer: "b", Total: 2},
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := h.repo.UserFlowsByDst("x", from, to, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(got, test.want); diff != nil {
+			t.Fatalf("%s: %v", test.opts.Granularity, diff)
+		}
+	}
+
+	got, err := h.repo.UserFlowsBySrc("a", from, to, &FlowOpts{Granularity: Month})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*UserFlowSrc{
+		{Ts: parseTime("2020-10-01T00:00:00Z"), Channel: "x", Total: 3},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	if _, err := h.repo.UserFlowsByDst("x", from, to, &FlowOpts{Granularity: "year"}); !errors.Is(err, ErrUnknownGranularity) {
+		t.Fatalf("expected ErrUnknownGranularity, got %v", err)
+	}
+}
+
+func testFlowsOptions(t *testing.T, h *harness) {
+	insertFlows(h, []string{"2020-10-05"}, "a", "user1", "user2", "user3")
+	insertFlows(h, []string{"2020-10-05"}, "b", "user4", "user5")
+	insertFlows(h, []string{"2020-10-05"}, "c", "user6")
+	insertFlows(h, []string{"2020-10-06"}, "d", "user7")
+	if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	from, to := parseTime("2020-10-05T00:00:00Z"), parseTime("2020-10-07T00:00:00Z")
+	day5, day6 := parseTime("2020-10-05T00:00:00Z"), parseTime("2020-10-06T00:00:00Z")
+	tests := []struct {
+		name string
+		opts *FlowOpts
+		want []*UserFlowDst
+	}{
+		{
+			// the offset applies to each bucket
+			name: "offset",
+			opts: &FlowOpts{Granularity: Day, Limit: 1, Offset: 1},
+			want: []*UserFlowDst{
+				{Ts: day5, Referrer: "b", Total: 2},
+			},
+		},
+		{
+			name: "min total",
+			opts: &FlowOpts{Granularity: Day, MinTotal: 2},
+			want: []*UserFlowDst{
+				{Ts: day5, Referrer: "a", Total: 3},
+				{Ts: day5, Referrer: "b", Total: 2},
+			},
+		},
+		{
+			name: "include",
+			opts: &FlowOpts{Granularity: Day, Include: []string{"c", "d"}},
+			want: []*UserFlowDst{
+				{Ts: day5, Referrer: "c", Total: 1},
+				{Ts: day6, Referrer: "d", Total: 1},
+			},
+		},
+		{
+			name: "exclude",
+			opts: &FlowOpts{Granularity: Day, Exclude: []string{"a", "d"}},
+			want: []*UserFlowDst{
+				{Ts: day5, Referrer: "b", Total: 2},
+				{Ts: day5, Referrer: "c", Total: 1},
+			},
+		},
+		{
+			name: "order by channel",
+			opts: &FlowOpts{Granularity: Total, Order: OrderChannel, Exclude: []string{"a"}},
+			want: []*UserFlowDst{
+				{Ts: from, Referrer: "b", Total: 2},
+				{Ts: from, Referrer: "c", Total: 1},
+				{Ts: from, Referrer: "d", Total: 1},
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := h.repo.UserFlowsByDst("x", from, to, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(got, test.want); diff != nil {
+			t.Fatalf("%s: %v", test.name, diff)
+		}
+	}
+
+	got, err := h.repo.UserFlowsBySrc("a", from, to, &FlowOpts{Granularity: Total, Include: []string{"y"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no flows, got %d", len(got))
+	}
+
+	for _, test := range []struct {
+		opts *FlowOpts
+		err  error
+	}{
+		{&FlowOpts{Limit: MaxFlowLimit + 1}, ErrInvalidLimit},
+		{&FlowOpts{Offset: -1}, ErrInvalidOffset},
+		{&FlowOpts{Order: "users"}, ErrUnknownFlowOrder},
+	} {
+		if _, err := h.repo.UserFlowsByDst("x", from, to, test.opts); !errors.Is(err, test.err) {
+			t.Fatalf("expected %v, got %v", test.err, err)
+		}
+	}
+}
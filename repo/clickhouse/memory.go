@@ -0,0 +1,786 @@
+package clickhouse
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is an in-memory Repository with the same semantics as DB, for tests
+// without a ClickHouse server: times are stored with second precision in UTC,
+// events are deduplicated like in the ReplacingMergeTree and flows count unique
+// users.
+type Memory struct {
+	mu     sync.Mutex
+	raw    []*RawEvent
+	events map[Event]struct{}
+	// snapshots are the stream and snapshot columns of the raw views. The
+	// snapshot defaults to the time of the event, the stream to empty.
+	snapshots []snapshotView
+	// similar is the latest ranking of similar channels of each channel
+	similar map[string][]*SimilarChannel
+	// communities are the stored communities of every computation
+	communities []*ChannelCommunity
+	// raids are deduplicated like in the ReplacingMergeTree
+	raids map[raidKey]*Raid
+}
+
+type raidKey struct {
+	from, id string
+}
+
+// snapshotView is a chatter present in a snapshot of a stream
+type snapshotView struct {
+	channel  string
+	stream   string
+	snapshot time.Time
+	username string
+}
+
+var _ Repository = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		events:  make(map[Event]struct{}),
+		similar: make(map[string][]*SimilarChannel),
+		raids:   make(map[raidKey]*Raid),
+	}
+}
+
+// InsertRawEvent inserts `evt` as is, like inserting into the raw_events table.
+func (r *Memory) InsertRawEvent(evt *RawEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.insertRaw(evt, "", evt.Ts)
+}
+
+// insertRaw inserts `evt`, taken in the `snapshot` of `stream`
+func (r *Memory) insertRaw(evt *RawEvent, stream string, snapshot time.Time) {
+	cp := *evt
+	cp.Ts = toDateTime(cp.Ts)
+	r.raw = append(r.raw, &cp)
+	if evt.EventType == "view" {
+		r.snapshots = append(r.snapshots, snapshotView{
+			channel:  evt.Channel,
+			stream:   stream,
+			snapshot: toDateTime(snapshot),
+			username: evt.Username,
+		})
+	}
+}
+
+// RawEvents returns the raw events, in the order of the raw_events table.
+func (r *Memory) RawEvents() []*RawEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	evts := make([]*RawEvent, 0, len(r.raw))
+	for _, evt := range r.raw {
+		cp := *evt
+		evts = append(evts, &cp)
+	}
+	sort.SliceStable(evts, func(i, j int) bool {
+		a, b := evts[i], evts[j]
+		if a.Username != b.Username {
+			return a.Username < b.Username
+		}
+		if !a.Ts.Equal(b.Ts) {
+			return a.Ts.Before(b.Ts)
+		}
+		return a.Channel < b.Channel
+	})
+	return evts
+}
+
+// Events returns the reconciled events, in the order of the events table.
+func (r *Memory) Events() []*Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	evts := make([]*Event, 0, len(r.events))
+	for evt := range r.events {
+		evt := evt
+		evts = append(evts, &evt)
+	}
+	sort.Slice(evts, func(i, j int) bool {
+		a, b := evts[i], evts[j]
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		if !a.Ts.Equal(b.Ts) {
+			return a.Ts.Before(b.Ts)
+		}
+		if a.Referrer != b.Referrer {
+			return a.Referrer < b.Referrer
+		}
+		return a.Username < b.Username
+	})
+	return evts
+}
+
+func (r *Memory) InsertViewers(ctx context.Context, vw *Viewers) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := startOfHour(vw.Ts)
+	for _, usr := range vw.Viewers {
+		r.insertRaw(&RawEvent{
+			Ts:        t,
+			Username:  usr,
+			Channel:   vw.Channel,
+			EventType: "view",
+		}, vw.Stream, vw.Ts)
+	}
+	return nil
+}
+
+func (r *Memory) ReconcileEvents(lastAt time.Time, window time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	since := reconcileSince(lastAt, window)
+	byUser := make(map[string][]*RawEvent)
+	for _, evt := range r.raw {
+		if evt.EventType != "view" || evt.Ts.Before(since) {
+			continue
+		}
+		byUser[evt.Username] = append(byUser[evt.Username], evt)
+	}
+
+	// The referrers of an event are the channels viewed by the same user in
+	// [ts - window, ts], including the events at the same time
+	for _, views := range byUser {
+		for _, evt := range views {
+			for _, prev := range views {
+				if prev.Ts.After(evt.Ts) || evt.Ts.Sub(prev.Ts) > window ||
+					prev.Channel == evt.Channel {
+					continue
+				}
+				r.events[Event{
+					Ts:       evt.Ts,
+					Username: evt.Username,
+					Channel:  evt.Channel,
+					Referrer: prev.Channel,
+				}] = struct{}{}
+			}
+		}
+	}
+	return nil
+}
+
+// flow is an aggregated flow of unique users between two channels
+type flow struct {
+	ts      time.Time
+	channel string
+	total   uint64
+}
+
+// flows counts the unique users of the events matched by `match` in the
+// range, grouped by bucket and the channel returned by `match`. Flows are
+// filtered, sorted and paginated in each bucket, like the flow queries.
+func (r *Memory) flows(from, to time.Time, opts *FlowOpts, match func(evt Event) (string, bool)) ([]flow, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	g := opts.Granularity
+
+	type key struct {
+		ts      time.Time
+		channel string
+	}
+	users := make(map[key]map[string]struct{})
+	for evt := range r.events {
+		// the daily rollups are filtered by the start of the day
+		ts := evt.Ts
+		if g.daily() {
+			ts = startOfDay(ts)
+		}
+		if ts.Before(g.since(from)) || ts.After(to) {
+			continue
+		}
+		ch, ok := match(evt)
+		if !ok {
+			continue
+		}
+		k := key{ts: g.bucket(ts, from), channel: ch}
+		if users[k] == nil {
+			users[k] = make(map[string]struct{})
+		}
+		users[k][evt.Username] = struct{}{}
+	}
+
+	f := make([]flow, 0, len(users))
+	for k, u := range users {
+		if total := uint64(len(u)); opts.filter(k.channel, total) {
+			f = append(f, flow{ts: k.ts, channel: k.channel, total: total})
+		}
+	}
+	sort.Slice(f, func(i, j int) bool {
+		a, b := f[i], f[j]
+		if !a.ts.Equal(b.ts) {
+			return a.ts.Before(b.ts)
+		}
+		if a.total != b.total && opts.Order == OrderTotal {
+			return a.total > b.total
+		}
+		return a.channel < b.channel
+	})
+
+	page := f[:0]
+	n := 0
+	for i, fl := range f {
+		if i > 0 && !fl.ts.Equal(f[i-1].ts) {
+			n = 0
+		}
+		if n >= opts.Offset && n < opts.Offset+opts.Limit {
+			page = append(page, fl)
+		}
+		n++
+	}
+	return page, nil
+}
+
+func (r *Memory) UserFlowsByDst(channel string, from, to time.Time, opts *FlowOpts) ([]*UserFlowDst, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	flows, err := r.flows(from, to, opts, func(evt Event) (string, bool) {
+		return evt.Referrer, evt.Channel == channel
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*UserFlowDst, 0, len(flows))
+	for _, f := range flows {
+		res = append(res, &UserFlowDst{Ts: f.ts, Referrer: f.channel, Total: f.total})
+	}
+	return res, nil
+}
+
+func (r *Memory) UserFlowsBySrc(referrer string, from, to time.Time, opts *FlowOpts) ([]*UserFlowSrc, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	flows, err := r.flows(from, to, opts, func(evt Event) (string, bool) {
+		return evt.Channel, evt.Referrer == referrer
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*UserFlowSrc, 0, len(flows))
+	for _, f := range flows {
+		res = append(res, &UserFlowSrc{Ts: f.ts, Channel: f.channel, Total: f.total})
+	}
+	return res, nil
+}
+
+func (r *Memory) FlowEdges(from, to time.Time, minWeight uint64) ([]*FlowEdge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type key struct {
+		referrer string
+		channel  string
+	}
+	users := make(map[key]map[string]struct{})
+	for evt := range r.events {
+		if evt.Ts.Before(from) || evt.Ts.After(to) {
+			continue
+		}
+		k := key{referrer: evt.Referrer, channel: evt.Channel}
+		if users[k] == nil {
+			users[k] = make(map[string]struct{})
+		}
+		users[k][evt.Username] = struct{}{}
+	}
+
+	edges := make([]*FlowEdge, 0, len(users))
+	for k, u := range users {
+		if total := uint64(len(u)); total >= minWeight {
+			edges = append(edges, &FlowEdge{Referrer: k.referrer, Channel: k.channel, Total: total})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		if a.Referrer != b.Referrer {
+			return a.Referrer < b.Referrer
+		}
+		return a.Channel < b.Channel
+	})
+	return edges, nil
+}
+
+func (r *Memory) AudienceOverlap(channels []string, from, to time.Time) ([]*Overlap, error) {
+	channels, err := overlapChannels(channels)
+	if err != nil {
+		return nil, err
+	}
+	if len(channels) < 2 {
+		return []*Overlap{}, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the rollup is fed by the raw views, rounded to the hour by InsertViewers
+	audience := make(map[string]map[string]struct{}, len(channels))
+	for _, ch := range channels {
+		audience[ch] = make(map[string]struct{})
+	}
+	for _, evt := range r.raw {
+		users, ok := audience[evt.Channel]
+		if !ok || evt.EventType != "view" || evt.Ts.Before(from) || evt.Ts.After(to) {
+			continue
+		}
+		users[evt.Username] = struct{}{}
+	}
+
+	viewers := make(map[string]uint64, len(channels))
+	shared := make(map[[2]string]uint64)
+	for i, a := range channels {
+		viewers[a] = uint64(len(audience[a]))
+		for _, b := range channels[i+1:] {
+			var n uint64
+			for usr := range audience[a] {
+				if _, ok := audience[b][usr]; ok {
+					n++
+				}
+			}
+			shared[[2]string{a, b}] = n
+		}
+	}
+	return overlapMatrix(channels, viewers, shared), nil
+}
+
+func (r *Memory) Streams(channel string, from, to time.Time) ([]*Stream, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	since := from.Add(-StreamLookback)
+	chatters := make(map[string]map[time.Time]map[string]struct{})
+	for _, v := range r.snapshots {
+		if v.stream == "" || v.channel != channel || v.snapshot.Before(since) || v.snapshot.After(to) {
+			continue
+		}
+		if chatters[v.stream] == nil {
+			chatters[v.stream] = make(map[time.Time]map[string]struct{})
+		}
+		if chatters[v.stream][v.snapshot] == nil {
+			chatters[v.stream][v.snapshot] = make(map[string]struct{})
+		}
+		chatters[v.stream][v.snapshot][v.username] = struct{}{}
+	}
+
+	users := make(map[string]map[string]struct{}, len(chatters))
+	streams := make([]*Stream, 0, len(chatters))
+	for id, snapshots := range chatters {
+		users[id] = make(map[string]struct{})
+		var (
+			startedAt, endedAt time.Time
+			peak, presences    uint64
+		)
+		for ts, u := range snapshots {
+			if startedAt.IsZero() || ts.Before(startedAt) {
+				startedAt = ts
+			}
+			if ts.After(endedAt) {
+				endedAt = ts
+			}
+			if n := uint64(len(u)); n > peak {
+				peak = n
+			}
+			presences += uint64(len(u))
+			for usr := range u {
+				users[id][usr] = struct{}{}
+			}
+		}
+		streams = append(streams, newStream(id, channel, startedAt, endedAt,
+			uint64(len(snapshots)), uint64(len(users[id])), peak, presences))
+	}
+
+	streams = linkStreams(streams, from)
+	for _, s := range streams {
+		if s.PreviousStream == "" {
+			continue
+		}
+		var n uint64
+		for usr := range users[s.ID] {
+			if _, ok := users[s.PreviousStream][usr]; ok {
+				n++
+			}
+		}
+		s.setReturning(n)
+	}
+	return streams, nil
+}
+
+// weeklyViewers returns the viewers of `channel` by week and when each one was
+// first seen, like the weekly_viewers and first_seen rollups
+func (r *Memory) weeklyViewers(channel string) (map[time.Time]map[string]struct{}, map[string]time.Time) {
+	weeks := make(map[time.Time]map[string]struct{})
+	firstSeen := make(map[string]time.Time)
+	for _, evt := range r.raw {
+		if evt.Channel != channel || evt.EventType != "view" {
+			continue
+		}
+		w := startOfWeek(evt.Ts)
+		if weeks[w] == nil {
+			weeks[w] = make(map[string]struct{})
+		}
+		weeks[w][evt.Username] = struct{}{}
+		if ts, ok := firstSeen[evt.Username]; !ok || evt.Ts.Before(ts) {
+			firstSeen[evt.Username] = evt.Ts
+		}
+	}
+	return weeks, firstSeen
+}
+
+func (r *Memory) Cohorts(channel string, from, to time.Time) ([]*Cohort, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	weeks, firstSeen := r.weeklyViewers(channel)
+	cohorts := make([]*Cohort, 0)
+	for w := startOfWeek(from); !w.After(to); w = w.AddDate(0, 0, 7) {
+		c := &Cohort{Week: w}
+		for usr := range weeks[w] {
+			c.Viewers++
+			if startOfWeek(firstSeen[usr]).Equal(w) {
+				c.New++
+			} else {
+				c.Returning++
+			}
+		}
+		for usr := range weeks[w.AddDate(0, 0, -7)] {
+			if _, ok := weeks[w][usr]; !ok {
+				c.Lapsed++
+			}
+		}
+		if c.Viewers > 0 || c.Lapsed > 0 {
+			cohorts = append(cohorts, c)
+		}
+	}
+	return cohorts, nil
+}
+
+func (r *Memory) Churn(channel string, week time.Time, limit int) ([]*ChurnDestination, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limit <= 0 {
+		limit = DefaultChurnLimit
+	}
+	week = startOfWeek(week)
+	prev := week.AddDate(0, 0, -7)
+	weeks, _ := r.weeklyViewers(channel)
+
+	// the last destination of each user, ties broken by channel
+	last := make(map[string]Event)
+	for evt := range r.events {
+		if evt.Referrer != channel || evt.Ts.Before(prev) || !evt.Ts.Before(week) {
+			continue
+		}
+		if _, ok := weeks[prev][evt.Username]; !ok {
+			continue
+		}
+		if _, ok := weeks[week][evt.Username]; ok {
+			continue
+		}
+		cur, ok := last[evt.Username]
+		if !ok || evt.Ts.After(cur.Ts) || evt.Ts.Equal(cur.Ts) && evt.Channel > cur.Channel {
+			last[evt.Username] = evt
+		}
+	}
+	users := make(map[string]uint64)
+	for _, evt := range last {
+		users[evt.Channel]++
+	}
+
+	dsts := make([]*ChurnDestination, 0, len(users))
+	for ch, n := range users {
+		dsts = append(dsts, &ChurnDestination{Channel: ch, Users: n})
+	}
+	sort.Slice(dsts, func(i, j int) bool {
+		a, b := dsts[i], dsts[j]
+		if a.Users != b.Users {
+			return a.Users > b.Users
+		}
+		return a.Channel < b.Channel
+	})
+	if len(dsts) > limit {
+		dsts = dsts[:limit]
+	}
+	return dsts, nil
+}
+
+func (r *Memory) RefreshSimilarChannels(channels []string, from, to time.Time, limit int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limit <= 0 {
+		limit = DefaultSimilarLimit
+	}
+	audience := make(map[string]map[string]struct{})
+	for _, evt := range r.raw {
+		if evt.EventType != "view" || evt.Ts.Before(from) || evt.Ts.After(to) {
+			continue
+		}
+		if audience[evt.Channel] == nil {
+			audience[evt.Channel] = make(map[string]struct{})
+		}
+		audience[evt.Channel][evt.Username] = struct{}{}
+	}
+	flows := make(map[[2]string]map[string]struct{})
+	for evt := range r.events {
+		if evt.Ts.Before(from) || evt.Ts.After(to) {
+			continue
+		}
+		k := [2]string{evt.Referrer, evt.Channel}
+		if flows[k] == nil {
+			flows[k] = make(map[string]struct{})
+		}
+		flows[k][evt.Username] = struct{}{}
+	}
+
+	var similar []*SimilarChannel
+	for _, a := range channels {
+		for b, users := range audience {
+			if a == b {
+				continue
+			}
+			var shared uint64
+			for usr := range audience[a] {
+				if _, ok := users[usr]; ok {
+					shared++
+				}
+			}
+			if shared == 0 {
+				continue
+			}
+			va, vb := uint64(len(audience[a])), uint64(len(users))
+			similar = append(similar, &SimilarChannel{
+				Channel:        a,
+				Similar:        b,
+				Score:          cosine(va, vb, shared),
+				Shared:         shared,
+				Viewers:        va,
+				SimilarViewers: vb,
+				FlowIn:         uint64(len(flows[[2]string{b, a}])),
+				FlowOut:        uint64(len(flows[[2]string{a, b}])),
+				ComputedAt:     toDateTime(to),
+			})
+		}
+	}
+
+	// like in the table, channels without similar channels keep their
+	// previous ranking
+	byChannel := make(map[string][]*SimilarChannel)
+	for _, s := range rankSimilar(similar, limit) {
+		byChannel[s.Channel] = append(byChannel[s.Channel], s)
+	}
+	for ch, s := range byChannel {
+		r.similar[ch] = s
+	}
+	return nil
+}
+
+func (r *Memory) SimilarChannels(channel string, limit int) ([]*SimilarChannel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limit <= 0 {
+		limit = DefaultSimilarLimit
+	}
+	similar := r.similar[channel]
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	res := make([]*SimilarChannel, 0, len(similar))
+	for _, s := range similar {
+		cp := *s
+		res = append(res, &cp)
+	}
+	return res, nil
+}
+
+func (r *Memory) InsertCommunities(cs []*ChannelCommunity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range cs {
+		cp := *c
+		cp.WindowStart = toDateTime(c.WindowStart)
+		cp.WindowEnd = toDateTime(c.WindowEnd)
+		cp.ComputedAt = toDateTime(c.ComputedAt)
+		r.communities = append(r.communities, &cp)
+	}
+	return nil
+}
+
+func (r *Memory) Communities(from, to time.Time) ([]*ChannelCommunity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	overlaps := func(c *ChannelCommunity) bool {
+		return (c.WindowStart.Before(to) && c.WindowEnd.After(from)) ||
+			(!c.WindowStart.After(from) && !c.WindowEnd.Before(to))
+	}
+	// newer reports whether `a` is of a later computation than `b`, ties
+	// broken by the latest window
+	newer := func(a, b *ChannelCommunity) bool {
+		if !a.ComputedAt.Equal(b.ComputedAt) {
+			return a.ComputedAt.After(b.ComputedAt)
+		}
+		if !a.WindowEnd.Equal(b.WindowEnd) {
+			return a.WindowEnd.After(b.WindowEnd)
+		}
+		return a.WindowStart.After(b.WindowStart)
+	}
+	var latest *ChannelCommunity
+	for _, c := range r.communities {
+		if overlaps(c) && (latest == nil || newer(c, latest)) {
+			latest = c
+		}
+	}
+	res := make([]*ChannelCommunity, 0)
+	for _, c := range r.communities {
+		if latest != nil && c.ComputedAt.Equal(latest.ComputedAt) &&
+			c.WindowStart.Equal(latest.WindowStart) && c.WindowEnd.Equal(latest.WindowEnd) {
+			cp := *c
+			res = append(res, &cp)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Community != b.Community {
+			return a.Community < b.Community
+		}
+		if a.PageRank != b.PageRank {
+			return a.PageRank > b.PageRank
+		}
+		return a.Channel < b.Channel
+	})
+	return res, nil
+}
+
+func (r *Memory) InsertRaid(raid *Raid) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cp := *raid
+	cp.Ts = toDateTime(raid.Ts)
+	// the first delivery is kept, like the version of the raids table
+	k := raidKey{cp.From, cp.ID}
+	if prev, ok := r.raids[k]; ok && !cp.Ts.Before(prev.Ts) {
+		return nil
+	}
+	r.raids[k] = &cp
+	return nil
+}
+
+func (r *Memory) RaidReports(channel string, from, to time.Time) ([]*RaidReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	raids := make([]Raid, 0)
+	for _, raid := range r.raids {
+		if raid.From == channel && !raid.Ts.Before(from) && !raid.Ts.After(to) {
+			raids = append(raids, *raid)
+		}
+	}
+	sort.Slice(raids, func(i, j int) bool {
+		a, b := raids[i], raids[j]
+		if !a.Ts.Equal(b.Ts) {
+			return a.Ts.After(b.Ts)
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.ID < b.ID
+	})
+	if len(raids) > MaxRaids {
+		raids = raids[:MaxRaids]
+	}
+
+	reports := make([]*RaidReport, 0, len(raids))
+	for _, raid := range raids {
+		reports = append(reports, r.raidReport(raid))
+	}
+	return reports, nil
+}
+
+// raidReport returns the report of `raid`, like the raid report query
+func (r *Memory) raidReport(raid Raid) *RaidReport {
+	since := raid.Ts.Add(-RaidSnapshotLookback)
+	var last time.Time
+	for _, v := range r.snapshots {
+		if v.channel == raid.From && !v.snapshot.Before(since) && !v.snapshot.After(raid.Ts) && v.snapshot.After(last) {
+			last = v.snapshot
+		}
+	}
+	viewers := make(map[string]struct{})
+	for _, v := range r.snapshots {
+		if v.channel == raid.From && !last.IsZero() && v.snapshot.Equal(last) {
+			viewers[v.username] = struct{}{}
+		}
+	}
+
+	end, returnEnd := raid.Ts.Add(RaidHours*time.Hour), raid.Ts.Add(RaidReturnWindow)
+	users := make(map[int64]map[string]struct{})
+	add := func(k int64, usr string) {
+		if users[k] == nil {
+			users[k] = make(map[string]struct{})
+		}
+		users[k][usr] = struct{}{}
+	}
+	for usr := range viewers {
+		add(raidViewersKey, usr)
+	}
+	for _, v := range r.snapshots {
+		if _, ok := viewers[v.username]; !ok || !v.snapshot.After(raid.Ts) {
+			continue
+		}
+		switch {
+		case v.channel == raid.To && !v.snapshot.After(end):
+			h := raidHour(raid.Ts, v.snapshot)
+			add(h, v.username)
+			add(raidArrivedKey, v.username)
+			if h >= 1 {
+				add(raidStayedKey, v.username)
+			}
+		case v.channel == raid.From && !v.snapshot.After(returnEnd):
+			add(raidReturnedKey, v.username)
+		}
+	}
+
+	counts := make(map[int64]uint64, len(users))
+	for k, u := range users {
+		counts[k] = uint64(len(u))
+	}
+	return newRaidReport(raid, counts)
+}
+
+func (r *Memory) RaidPartners(channel string, from, to time.Time, limit int) ([]*RaidPartner, error) {
+	if limit <= 0 {
+		limit = DefaultRaidPartnersLimit
+	}
+	reports, err := r.RaidReports(channel, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return rankRaidPartners(reports, limit), nil
+}
+
+// toDateTime truncates `t` to the precision of the DateTime type of ClickHouse
+func toDateTime(t time.Time) time.Time {
+	return t.Truncate(time.Second).UTC()
+}
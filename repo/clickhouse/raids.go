@@ -0,0 +1,369 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pmrt/viewergraph/database"
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/utils"
+)
+
+const (
+	// RaidSnapshotLookback is how old the last snapshot of the raiding channel
+	// can be to take its chatters as the raid viewers
+	RaidSnapshotLookback = 2 * time.Hour
+	// RaidHours is the number of hours after a raid the raided channel is
+	// followed
+	RaidHours = 6
+	// RaidReturnWindow is how long after a raid the raid viewers are looked
+	// for back in the raiding channel
+	RaidReturnWindow = 7 * 24 * time.Hour
+	// MaxRaids is the maximum number of raids of a report, the most recent
+	MaxRaids = 200
+	// DefaultRaidPartnersLimit is the default number of raid partners
+	DefaultRaidPartnersLimit = 20
+)
+
+// the keys of the rows of the raid report query, non-negative keys are the
+// hours of the retention curve
+const (
+	raidViewersKey  = -1
+	raidArrivedKey  = -2
+	raidStayedKey   = -3
+	raidReturnedKey = -4
+)
+
+// Raid is a raid of the channel From to the channel To
+type Raid struct {
+	// ID is the id of the notification message of the raid, the same across
+	// deliveries of the notification. Raids are deduplicated by ID,
+	// keeping the earliest delivery.
+	ID   string    `json:"id"`
+	Ts   time.Time `json:"ts"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	// Viewers is the number of viewers of the raid reported by Twitch
+	Viewers uint32 `json:"viewers"`
+}
+
+// RaidReport measures how many of the viewers of a raid stuck around in the
+// raided channel.
+type RaidReport struct {
+	Raid
+	// RaidViewers are the chatters of From in its last snapshot before the
+	// raid, within RaidSnapshotLookback
+	RaidViewers uint64 `json:"raid_viewers"`
+	// Arrived are the raid viewers seen in To within RaidHours after the raid
+	Arrived uint64 `json:"arrived"`
+	// Stayed are the raid viewers seen in To more than one hour after the
+	// raid, within RaidHours
+	Stayed uint64 `json:"stayed"`
+	// Retention are the raid viewers seen in To in each of the RaidHours
+	// after the raid. Hours without a snapshot of To are 0.
+	Retention []uint64 `json:"retention"`
+	// Returned are the raid viewers seen in From again within
+	// RaidReturnWindow after the raid
+	Returned    uint64  `json:"returned"`
+	ArrivalRate float64 `json:"arrival_rate"`
+	StayRate    float64 `json:"stay_rate"`
+	ReturnRate  float64 `json:"return_rate"`
+}
+
+// RaidPartner is a channel raided by another one, with the aggregated impact
+// of the raids
+type RaidPartner struct {
+	Channel     string  `json:"channel"`
+	Raids       uint64  `json:"raids"`
+	RaidViewers uint64  `json:"raid_viewers"`
+	Arrived     uint64  `json:"arrived"`
+	Stayed      uint64  `json:"stayed"`
+	Returned    uint64  `json:"returned"`
+	ArrivalRate float64 `json:"arrival_rate"`
+	StayRate    float64 `json:"stay_rate"`
+}
+
+// raidHour returns the hour after the raid at `ts` of the `snapshot`
+func raidHour(ts, snapshot time.Time) int64 {
+	return int64((snapshot.Sub(ts) - time.Second) / time.Hour)
+}
+
+func rate(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// newRaidReport returns the report of `raid` from the unique users of each
+// key of the raid report query
+func newRaidReport(raid Raid, users map[int64]uint64) *RaidReport {
+	rep := &RaidReport{
+		Raid:        raid,
+		RaidViewers: users[raidViewersKey],
+		Arrived:     users[raidArrivedKey],
+		Stayed:      users[raidStayedKey],
+		Returned:    users[raidReturnedKey],
+		Retention:   make([]uint64, RaidHours),
+	}
+	for h := range rep.Retention {
+		rep.Retention[h] = users[int64(h)]
+	}
+	rep.ArrivalRate = rate(rep.Arrived, rep.RaidViewers)
+	rep.StayRate = rate(rep.Stayed, rep.RaidViewers)
+	rep.ReturnRate = rate(rep.Returned, rep.RaidViewers)
+	return rep
+}
+
+// rankRaidPartners aggregates the `reports` by raided channel, sorted by the
+// raid viewers that stayed, and keeps the top `limit`
+func rankRaidPartners(reports []*RaidReport, limit int) []*RaidPartner {
+	byChannel := make(map[string]*RaidPartner)
+	partners := make([]*RaidPartner, 0)
+	for _, rep := range reports {
+		p, ok := byChannel[rep.To]
+		if !ok {
+			p = &RaidPartner{Channel: rep.To}
+			byChannel[rep.To] = p
+			partners = append(partners, p)
+		}
+		p.Raids++
+		p.RaidViewers += rep.RaidViewers
+		p.Arrived += rep.Arrived
+		p.Stayed += rep.Stayed
+		p.Returned += rep.Returned
+	}
+	for _, p := range partners {
+		p.ArrivalRate = rate(p.Arrived, p.RaidViewers)
+		p.StayRate = rate(p.Stayed, p.RaidViewers)
+	}
+	sort.Slice(partners, func(i, j int) bool {
+		a, b := partners[i], partners[j]
+		if a.Stayed != b.Stayed {
+			return a.Stayed > b.Stayed
+		}
+		if a.Arrived != b.Arrived {
+			return a.Arrived > b.Arrived
+		}
+		return a.Channel < b.Channel
+	})
+	if len(partners) > limit {
+		partners = partners[:limit]
+	}
+	return partners
+}
+
+// InsertRaid stores the `raid`, with its time truncated to the second.
+func InsertRaid(db *sql.DB, raid *Raid) error {
+	defer metrics.ObserveQuery("clickhouse", "InsertRaid")()
+	l := utils.Logger("query", "q", "InsertRaid")
+
+	err := database.WithTx(context.Background(), db, func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("INSERT INTO raids (message_id, ts, from_channel, to_channel, viewers)")
+		if err != nil {
+			l.Error().Err(err).Msg("error while preparing statement")
+			return err
+		}
+		if _, err := stmt.Exec(raid.ID, raid.Ts.Truncate(time.Second), raid.From, raid.To, raid.Viewers); err != nil {
+			l.Error().Err(err).Msg("error while adding values to the batch")
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		l.Error().Err(err).Msg("error while inserting raid")
+		return err
+	}
+	return nil
+}
+
+// raidList are the raids of a report: the most recent MaxRaids raids of
+// @Channel in the time range
+var raidList = fmt.Sprintf(`
+  SELECT
+    message_id, ts, to_channel, viewers
+  FROM raids FINAL
+  WHERE
+    from_channel = @Channel AND
+    ts >= @From AND
+    ts <= @To
+  ORDER BY ts DESC, to_channel ASC, message_id ASC
+  LIMIT %d
+`, MaxRaids)
+
+// raidViewers are the chatters of @Channel in its last snapshot before each
+// raid of raidList, within RaidSnapshotLookback
+var raidViewers = fmt.Sprintf(`
+  SELECT
+    r.raid_id AS raid_id,
+    r.raid_ts AS raid_ts,
+    r.raid_to AS raid_to,
+    e.username AS username
+  FROM (
+    SELECT
+      rl.message_id AS raid_id,
+      any(rl.ts) AS raid_ts,
+      any(rl.to_channel) AS raid_to,
+      max(s.snapshot) AS last_snapshot
+    FROM (`+raidList+`) AS rl
+    CROSS JOIN (
+      SELECT DISTINCT snapshot
+      FROM raw_events
+      WHERE
+        event_type = 'view' AND
+        channel = @Channel AND
+        ts >= toStartOfHour(@Since) AND
+        ts <= @To
+    ) AS s
+    WHERE
+      s.snapshot >= subtractSeconds(rl.ts, %d) AND
+      s.snapshot <= rl.ts
+    GROUP BY rl.message_id
+  ) AS r
+  INNER JOIN (
+    SELECT username, snapshot
+    FROM raw_events
+    WHERE
+      event_type = 'view' AND
+      channel = @Channel AND
+      ts >= toStartOfHour(@Since) AND
+      ts <= @To
+  ) AS e ON e.snapshot = r.last_snapshot
+`, int64(RaidSnapshotLookback/time.Second))
+
+// RaidReports returns the reports of the raids of `channel` to other channels
+// in the time range, the most recent first. At most MaxRaids raids are
+// reported.
+//
+// The impact of all the raids is computed in one query joined on the raid
+// list: the raid viewers of each raid are the chatters of the last snapshot
+// of `channel` before it, each of their later views is turned into the keys
+// it counts for and the unique users of each raid and key are counted.
+func RaidReports(db *sql.DB, channel string, from, to time.Time) ([]*RaidReport, error) {
+	defer metrics.ObserveQuery("clickhouse", "RaidReports")()
+	l := utils.Logger("query", "q", "RaidReports")
+
+	params := []any{
+		sql.Named("Channel", channel),
+		sql.Named("From", from),
+		sql.Named("To", to),
+		sql.Named("Since", from.Add(-RaidSnapshotLookback)),
+		sql.Named("ReturnEnd", to.Add(RaidReturnWindow)),
+	}
+	rows, err := db.Query(raidList, params...)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	raids := make([]Raid, 0)
+	for rows.Next() {
+		raid := Raid{From: channel}
+		if err := rows.Scan(
+			&raid.ID,
+			&raid.Ts,
+			&raid.To,
+			&raid.Viewers,
+		); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		raid.Ts = raid.Ts.UTC()
+		raids = append(raids, raid)
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+	if len(raids) == 0 {
+		return []*RaidReport{}, nil
+	}
+
+	rows, err = db.Query(fmt.Sprintf(`
+    SELECT
+      raid_id, k, uniqExact(username) AS users
+    FROM (
+      SELECT
+        rv.raid_id AS raid_id,
+        rv.username AS username,
+        intDiv(toInt64(dateDiff('second', rv.raid_ts, v.snapshot)) - 1, 3600) AS hour,
+        arrayJoin(multiIf(
+          v.channel = @Channel AND v.snapshot <= rv.raid_ts, [%d],
+          v.channel = @Channel, [%d],
+          hour >= 1, [hour, %d, %d],
+          [hour, %d]
+        )) AS k
+      FROM (`+raidViewers+`) AS rv
+      INNER JOIN (
+        SELECT username, channel, snapshot
+        FROM raw_events
+        WHERE
+          event_type = 'view' AND
+          ts >= toStartOfHour(@Since) AND
+          ts <= @ReturnEnd AND
+          (channel = @Channel OR channel IN (SELECT to_channel FROM (`+raidList+`)))
+      ) AS v ON v.username = rv.username
+      WHERE
+        (v.channel = @Channel AND v.snapshot >= subtractSeconds(rv.raid_ts, %d) AND v.snapshot <= rv.raid_ts) OR
+        (v.channel = rv.raid_to AND v.snapshot > rv.raid_ts AND v.snapshot <= addSeconds(rv.raid_ts, %d)) OR
+        (v.channel = @Channel AND v.snapshot > rv.raid_ts AND v.snapshot <= addSeconds(rv.raid_ts, %d))
+    )
+    GROUP BY raid_id, k
+  `,
+		raidViewersKey, raidReturnedKey, raidArrivedKey, raidStayedKey, raidArrivedKey,
+		int64(RaidSnapshotLookback/time.Second),
+		RaidHours*3600,
+		int64(RaidReturnWindow/time.Second),
+	), params...)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make(map[string]map[int64]uint64, len(raids))
+	for rows.Next() {
+		var (
+			id string
+			k  int64
+			n  uint64
+		)
+		if err := rows.Scan(&id, &k, &n); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		if users[id] == nil {
+			users[id] = make(map[int64]uint64)
+		}
+		users[id][k] = n
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+
+	r := make([]*RaidReport, 0, len(raids))
+	for _, raid := range raids {
+		r = append(r, newRaidReport(raid, users[raid.ID]))
+	}
+	return r, nil
+}
+
+// RaidPartners returns the channels raided by `channel` in the time range,
+// with the aggregated impact of their raids, sorted by the raid viewers that
+// stayed. At most `limit` partners are returned, DefaultRaidPartnersLimit if
+// 0. See RaidReports.
+func RaidPartners(db *sql.DB, channel string, from, to time.Time, limit int) ([]*RaidPartner, error) {
+	if limit <= 0 {
+		limit = DefaultRaidPartnersLimit
+	}
+	reports, err := RaidReports(db, channel, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return rankRaidPartners(reports, limit), nil
+}
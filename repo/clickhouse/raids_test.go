@@ -0,0 +1,88 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func testRaids(t *testing.T, h *harness) {
+	// the first raid from a to b
+	h.insertRawEvent("2020-10-11T07:30:00Z", "user1", "a", "view")
+	h.insertRawEvent("2020-10-11T08:30:00Z", "user1", "b", "view")
+	// the second raid from a to b: only the last snapshot of a counts
+	h.insertRawEvent("2020-10-11T10:00:00Z", "user5", "a", "view")
+	for _, usr := range []string{"user1", "user2", "user3", "user4"} {
+		h.insertRawEvent("2020-10-11T11:30:00Z", usr, "a", "view")
+	}
+	h.insertRawEvent("2020-10-11T12:10:00Z", "user1", "b", "view")
+	h.insertRawEvent("2020-10-11T12:10:00Z", "user2", "b", "view")
+	h.insertRawEvent("2020-10-11T12:10:00Z", "user9", "b", "view")
+	h.insertRawEvent("2020-10-11T13:30:00Z", "user1", "b", "view")
+	// after RaidHours
+	h.insertRawEvent("2020-10-11T19:00:00Z", "user2", "b", "view")
+	// back to a the next day
+	h.insertRawEvent("2020-10-12T11:00:00Z", "user3", "a", "view")
+
+	raids := []*Raid{
+		{ID: "1", Ts: parseTime("2020-10-11T08:00:00Z"), From: "a", To: "b", Viewers: 1},
+		// delivered twice, the retry first
+		{ID: "2", Ts: parseTime("2020-10-11T12:00:05Z"), From: "a", To: "b", Viewers: 4},
+		{ID: "2", Ts: parseTime("2020-10-11T12:00:00Z"), From: "a", To: "b", Viewers: 4},
+		// delivered again later, the earliest delivery is kept
+		{ID: "2", Ts: parseTime("2020-10-11T12:00:10Z"), From: "a", To: "b", Viewers: 4},
+		// without a recent snapshot of a
+		{ID: "3", Ts: parseTime("2020-10-11T15:00:00Z"), From: "a", To: "c", Viewers: 2},
+		{ID: "4", Ts: parseTime("2020-10-11T16:00:00Z"), From: "b", To: "a", Viewers: 3},
+	}
+	for _, raid := range raids {
+		if err := h.repo.InsertRaid(raid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	from, to := parseTime("2020-10-11T00:00:00Z"), parseTime("2020-10-12T00:00:00Z")
+	got, err := h.repo.RaidReports("a", from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*RaidReport{
+		{Raid: *raids[4], Retention: make([]uint64, RaidHours)},
+		{
+			Raid: *raids[2], RaidViewers: 4, Arrived: 2, Stayed: 1,
+			Retention: []uint64{2, 1, 0, 0, 0, 0}, Returned: 1,
+			ArrivalRate: 0.5, StayRate: 0.25, ReturnRate: 0.25,
+		},
+		{
+			Raid: *raids[0], RaidViewers: 1, Arrived: 1, Stayed: 1,
+			Retention: []uint64{1, 0, 0, 0, 1, 1}, Returned: 1,
+			ArrivalRate: 1, StayRate: 1, ReturnRate: 1,
+		},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	partners, err := h.repo.RaidPartners("a", from, to, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPartners := []*RaidPartner{
+		{
+			Channel: "b", Raids: 2, RaidViewers: 5, Arrived: 3, Stayed: 2, Returned: 2,
+			ArrivalRate: 0.6, StayRate: 0.4,
+		},
+		{Channel: "c", Raids: 1},
+	}
+	if diff := deep.Equal(partners, wantPartners); diff != nil {
+		t.Fatal(diff)
+	}
+
+	partners, err = h.repo.RaidPartners("a", from, to, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partners) != 1 || partners[0].Channel != "b" {
+		t.Fatalf("expected only b, got %+v", partners)
+	}
+}
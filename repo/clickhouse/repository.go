@@ -0,0 +1,135 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Repository is the event store of viewergraph. DB implements it on top of
+// ClickHouse and Memory in memory, for tests.
+type Repository interface {
+	// InsertViewers inserts the viewers `vw` as view raw events, with their
+	// time rounded to the start of the hour, and the snapshot of their stream.
+	InsertViewers(ctx context.Context, vw *Viewers) error
+	// ReconcileEvents turns the raw events since `lastAt` into events with a
+	// referrer, the channels viewed by the same user within `window`.
+	ReconcileEvents(lastAt time.Time, window time.Duration) error
+	// UserFlowsByDst returns the unique users that came to `channel` from
+	// other channels, by bucket and referrer. See FlowOpts.
+	UserFlowsByDst(channel string, from, to time.Time, opts *FlowOpts) ([]*UserFlowDst, error)
+	// UserFlowsBySrc returns the unique users that went from `referrer` to
+	// other channels, by bucket and channel. See FlowOpts.
+	UserFlowsBySrc(referrer string, from, to time.Time, opts *FlowOpts) ([]*UserFlowSrc, error)
+	// FlowEdges returns the unique users that went from a channel to another,
+	// for the pairs with at least `minWeight` users. See FlowEdges.
+	FlowEdges(from, to time.Time, minWeight uint64) ([]*FlowEdge, error)
+	// AudienceOverlap returns the unique viewers shared by every pair of
+	// `channels`. See AudienceOverlap.
+	AudienceOverlap(channels []string, from, to time.Time) ([]*Overlap, error)
+	// Streams returns the stream sessions of `channel` live in the time range
+	// and the stats of their chatters. See Streams.
+	Streams(channel string, from, to time.Time) ([]*Stream, error)
+	// Cohorts returns the new, returning and lapsed viewers of `channel` by
+	// week. See Cohorts.
+	Cohorts(channel string, from, to time.Time) ([]*Cohort, error)
+	// Churn returns where the viewers of `channel` lapsed in the week of
+	// `week` went last. See Churn.
+	Churn(channel string, week time.Time, limit int) ([]*ChurnDestination, error)
+	// RefreshSimilarChannels computes and stores the similar channels of
+	// `channels` from the co-viewing data in the time range. See
+	// RefreshSimilarChannels.
+	RefreshSimilarChannels(channels []string, from, to time.Time, limit int) error
+	// SimilarChannels returns the channels similar to `channel` of the latest
+	// refresh. See SimilarChannels.
+	SimilarChannels(channel string, limit int) ([]*SimilarChannel, error)
+	// InsertCommunities stores the communities of the channels of a
+	// viewer-flow graph. See InsertCommunities.
+	InsertCommunities(cs []*ChannelCommunity) error
+	// Communities returns the communities of the latest computation whose
+	// time window overlaps the time range. See Communities.
+	Communities(from, to time.Time) ([]*ChannelCommunity, error)
+	// InsertRaid stores a raid. See InsertRaid.
+	InsertRaid(raid *Raid) error
+	// RaidReports returns how many viewers of the raids of `channel` in the
+	// time range stuck around. See RaidReports.
+	RaidReports(channel string, from, to time.Time) ([]*RaidReport, error)
+	// RaidPartners returns the channels raided by `channel` in the time
+	// range, ranked by the impact of the raids. See RaidPartners.
+	RaidPartners(channel string, from, to time.Time, limit int) ([]*RaidPartner, error)
+}
+
+// DB is the Repository of a ClickHouse `db` source.
+type DB struct {
+	db *sql.DB
+}
+
+var _ Repository = (*DB)(nil)
+
+func New(db *sql.DB) *DB {
+	return &DB{db: db}
+}
+
+func (r *DB) InsertViewers(ctx context.Context, vw *Viewers) error {
+	return InsertViewersContext(ctx, r.db, vw)
+}
+
+func (r *DB) ReconcileEvents(lastAt time.Time, window time.Duration) error {
+	return ReconcileEvents(r.db, lastAt, window)
+}
+
+func (r *DB) UserFlowsByDst(channel string, from, to time.Time, opts *FlowOpts) ([]*UserFlowDst, error) {
+	return UserFlowsByDst(r.db, channel, from, to, opts)
+}
+
+func (r *DB) UserFlowsBySrc(referrer string, from, to time.Time, opts *FlowOpts) ([]*UserFlowSrc, error) {
+	return UserFlowsBySrc(r.db, referrer, from, to, opts)
+}
+
+func (r *DB) FlowEdges(from, to time.Time, minWeight uint64) ([]*FlowEdge, error) {
+	return FlowEdges(r.db, from, to, minWeight)
+}
+
+func (r *DB) AudienceOverlap(channels []string, from, to time.Time) ([]*Overlap, error) {
+	return AudienceOverlap(r.db, channels, from, to)
+}
+
+func (r *DB) Streams(channel string, from, to time.Time) ([]*Stream, error) {
+	return Streams(r.db, channel, from, to)
+}
+
+func (r *DB) Cohorts(channel string, from, to time.Time) ([]*Cohort, error) {
+	return Cohorts(r.db, channel, from, to)
+}
+
+func (r *DB) Churn(channel string, week time.Time, limit int) ([]*ChurnDestination, error) {
+	return Churn(r.db, channel, week, limit)
+}
+
+func (r *DB) RefreshSimilarChannels(channels []string, from, to time.Time, limit int) error {
+	return RefreshSimilarChannels(r.db, channels, from, to, limit)
+}
+
+func (r *DB) SimilarChannels(channel string, limit int) ([]*SimilarChannel, error) {
+	return SimilarChannels(r.db, channel, limit)
+}
+
+func (r *DB) InsertCommunities(cs []*ChannelCommunity) error {
+	return InsertCommunities(r.db, cs)
+}
+
+func (r *DB) Communities(from, to time.Time) ([]*ChannelCommunity, error) {
+	return Communities(r.db, from, to)
+}
+
+func (r *DB) InsertRaid(raid *Raid) error {
+	return InsertRaid(r.db, raid)
+}
+
+func (r *DB) RaidReports(channel string, from, to time.Time) ([]*RaidReport, error) {
+	return RaidReports(r.db, channel, from, to)
+}
+
+func (r *DB) RaidPartners(channel string, from, to time.Time, limit int) ([]*RaidPartner, error) {
+	return RaidPartners(r.db, channel, from, to, limit)
+}
@@ -0,0 +1,214 @@
+package clickhouse
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/utils"
+)
+
+// DefaultSimilarLimit is the default number of similar channels of a channel
+const DefaultSimilarLimit = 20
+
+// SimilarChannel is a channel whose viewers also watch Channel, with the
+// explanation of its score.
+type SimilarChannel struct {
+	Channel string `json:"channel"`
+	Similar string `json:"similar"`
+	// Score is the cosine similarity of the audiences, |A ∩ B| / sqrt(|A||B|),
+	// so big channels don't rank first just because of their size
+	Score float64 `json:"score"`
+	// Shared are the unique viewers of both channels
+	Shared         uint64 `json:"shared"`
+	Viewers        uint64 `json:"viewers"`
+	SimilarViewers uint64 `json:"similar_viewers"`
+	// FlowIn are the unique users that went from Similar to Channel and
+	// FlowOut from Channel to Similar
+	FlowIn     uint64    `json:"flow_in"`
+	FlowOut    uint64    `json:"flow_out"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// cosine returns the cosine similarity of two audiences of `a` and `b`
+// viewers with `shared` viewers in common
+func cosine(a, b, shared uint64) float64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return float64(shared) / math.Sqrt(float64(a)*float64(b))
+}
+
+// rankSimilar sorts the similar channels of each channel by score and keeps
+// the top `limit` of each one
+func rankSimilar(similar []*SimilarChannel, limit int) []*SimilarChannel {
+	sort.Slice(similar, func(i, j int) bool {
+		a, b := similar[i], similar[j]
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Similar < b.Similar
+	})
+	r := similar[:0]
+	n := 0
+	for i, s := range similar {
+		if i > 0 && s.Channel != similar[i-1].Channel {
+			n = 0
+		}
+		if n < limit {
+			r = append(r, s)
+		}
+		n++
+	}
+	return r
+}
+
+// flowsByPair selects the unique users that went from a channel to another in
+// the time range
+const flowsByPair = `
+      SELECT
+        referrer, channel,
+        uniqMerge(total_users) AS total
+      FROM aggregated_flows_by_dst
+      WHERE
+        ts >= @From AND
+        ts <= @To
+      GROUP BY referrer, channel
+`
+
+// RefreshSimilarChannels computes the top `limit` similar channels of each
+// of `channels` from the co-viewing data in the time range and stores them
+// with `to` as their computation time. DefaultSimilarLimit if `limit` is 0.
+//
+// The shared viewers are |A| + |B| - |A ∪ B|, where the union is the merge of
+// the uniq states of both channels. See AudienceOverlap.
+func RefreshSimilarChannels(db *sql.DB, channels []string, from, to time.Time, limit int) error {
+	defer metrics.ObserveQuery("clickhouse", "RefreshSimilarChannels")()
+	l := utils.Logger("query", "q", "RefreshSimilarChannels")
+
+	if len(channels) == 0 {
+		return nil
+	}
+	if limit <= 0 {
+		limit = DefaultSimilarLimit
+	}
+	row := db.QueryRow(fmt.Sprintf(`
+    INSERT INTO similar_channels (
+      computed_at, channel, similar, score, shared,
+      viewers, similar_viewers, flow_in, flow_out
+    )
+    SELECT
+      @To,
+      p.a_channel, p.b_channel,
+      p.shared_viewers / sqrt(p.a_total * p.b_total) AS cosine,
+      p.shared_viewers,
+      p.a_total, p.b_total,
+      fin.total, fout.total
+    FROM (
+      SELECT
+        a.channel AS a_channel,
+        b.channel AS b_channel,
+        a.total AS a_total,
+        b.total AS b_total,
+        toUInt64(greatest(
+          toInt64(a.total) + toInt64(b.total) -
+          toInt64(arrayReduce('uniqMerge', [a.state, b.state])),
+          0
+        )) AS shared_viewers
+      FROM (`+audienceByChannel+`) AS a
+      CROSS JOIN (
+        SELECT
+          channel,
+          uniqMergeState(viewers) AS state,
+          uniqMerge(viewers) AS total
+        FROM aggregated_audience
+        WHERE
+          ts >= @From AND
+          ts <= @To
+        GROUP BY channel
+      ) AS b
+      WHERE a.channel != b.channel
+    ) AS p
+    LEFT JOIN (`+flowsByPair+`) AS fin
+      ON fin.referrer = p.b_channel AND fin.channel = p.a_channel
+    LEFT JOIN (`+flowsByPair+`) AS fout
+      ON fout.referrer = p.a_channel AND fout.channel = p.b_channel
+    WHERE p.shared_viewers > 0
+    ORDER BY p.a_channel ASC, cosine DESC, p.b_channel ASC
+    LIMIT %d BY p.a_channel
+  `, limit),
+		sql.Named("Channels", channels),
+		sql.Named("From", from),
+		sql.Named("To", to),
+	)
+	if err := row.Err(); err != nil && err != io.EOF {
+		l.Error().Err(err).Msg("error while refreshing similar channels")
+		return err
+	}
+	return nil
+}
+
+// SimilarChannels returns the top `limit` channels similar to `channel` of
+// its latest refresh, sorted by score. DefaultSimilarLimit if `limit` is 0.
+// See RefreshSimilarChannels.
+func SimilarChannels(db *sql.DB, channel string, limit int) ([]*SimilarChannel, error) {
+	defer metrics.ObserveQuery("clickhouse", "SimilarChannels")()
+	l := utils.Logger("query", "q", "SimilarChannels")
+
+	if limit <= 0 {
+		limit = DefaultSimilarLimit
+	}
+	rows, err := db.Query(fmt.Sprintf(`
+    SELECT
+      computed_at, similar, score, shared,
+      viewers, similar_viewers, flow_in, flow_out
+    FROM similar_channels
+    WHERE
+      channel = @Channel AND
+      computed_at = (
+        SELECT max(computed_at)
+        FROM similar_channels
+        WHERE channel = @Channel
+      )
+    ORDER BY score DESC, similar ASC
+    LIMIT %d
+  `, limit),
+		sql.Named("Channel", channel),
+	)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := make([]*SimilarChannel, 0, limit)
+	for rows.Next() {
+		s := &SimilarChannel{Channel: channel}
+		if err := rows.Scan(
+			&s.ComputedAt,
+			&s.Similar,
+			&s.Score,
+			&s.Shared,
+			&s.Viewers,
+			&s.SimilarViewers,
+			&s.FlowIn,
+			&s.FlowOut,
+		); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		r = append(r, s)
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,80 @@
+package clickhouse
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func testSimilarChannels(t *testing.T, h *harness) {
+	h.insertRawEvent("2020-10-11T09:00:00Z", "user1", "b", "view")
+	h.insertRawEvent("2020-10-11T09:00:00Z", "user2", "b", "view")
+	for _, usr := range []string{"user1", "user2", "user3", "user4"} {
+		h.insertRawEvent("2020-10-11T10:00:00Z", usr, "a", "view")
+	}
+	h.insertRawEvent("2020-10-11T10:00:00Z", "user3", "c", "view")
+	h.insertRawEvent("2020-10-11T10:00:00Z", "user5", "d", "view")
+	if err := h.repo.ReconcileEvents(time.Time{}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	from, to := parseTime("2020-10-11T00:00:00Z"), parseTime("2020-10-12T00:00:00Z")
+	if err := h.repo.RefreshSimilarChannels([]string{"a", "b"}, from, to, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := h.repo.SimilarChannels("a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*SimilarChannel{
+		{
+			Channel: "a", Similar: "b", Score: 2 / math.Sqrt(8), Shared: 2,
+			Viewers: 4, SimilarViewers: 2, FlowIn: 2, ComputedAt: to,
+		},
+		{
+			Channel: "a", Similar: "c", Score: 0.5, Shared: 1,
+			Viewers: 4, SimilarViewers: 1, FlowIn: 1, FlowOut: 1, ComputedAt: to,
+		},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	got, err = h.repo.SimilarChannels("b", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []*SimilarChannel{
+		{
+			Channel: "b", Similar: "a", Score: 2 / math.Sqrt(8), Shared: 2,
+			Viewers: 2, SimilarViewers: 4, FlowOut: 2, ComputedAt: to,
+		},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// only the latest refresh is served
+	later := to.Add(time.Hour)
+	if err := h.repo.RefreshSimilarChannels([]string{"a"}, from, later, 1); err != nil {
+		t.Fatal(err)
+	}
+	got, err = h.repo.SimilarChannels("a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Similar != "b" || !got[0].ComputedAt.Equal(later) {
+		t.Fatalf("expected only b from the latest refresh, got %+v", got)
+	}
+
+	got, err = h.repo.SimilarChannels("d", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no similar channels, got %d", len(got))
+	}
+}
@@ -0,0 +1,226 @@
+package clickhouse
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/utils"
+)
+
+// StreamLookback is how long before `from` the stream sessions are read, so
+// the first stream of the range can be compared with the previous one.
+const StreamLookback = 30 * 24 * time.Hour
+
+// Stream is a stream session of a channel and the stats of its chatters, from
+// the snapshots taken while the channel was live.
+type Stream struct {
+	ID      string `json:"id"`
+	Channel string `json:"channel"`
+	// StartedAt and EndedAt are the times of the first and last snapshots
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	Snapshots uint64    `json:"snapshots"`
+	// UniqueChatters are the unique chatters of all the snapshots
+	UniqueChatters uint64 `json:"unique_chatters"`
+	// PeakChatters is the largest number of chatters of a single snapshot
+	PeakChatters uint64 `json:"peak_chatters"`
+	// AvgSnapshots is the average number of snapshots each chatter was present
+	// in, an approximation of the watch time
+	AvgSnapshots float64 `json:"avg_snapshots"`
+	// PreviousStream is the id of the previous stream of the channel, empty if
+	// there is none in StreamLookback
+	PreviousStream string `json:"previous_stream,omitempty"`
+	// Returning are the chatters of the previous stream present in this one
+	Returning uint64 `json:"returning"`
+	// ReturningRate is Returning / UniqueChatters
+	ReturningRate float64 `json:"returning_rate"`
+}
+
+// newStream returns the stream `id` of `channel`, where `presences` is the
+// sum of the chatters of every snapshot
+func newStream(id, channel string, startedAt, endedAt time.Time, snapshots, unique, peak, presences uint64) *Stream {
+	s := &Stream{
+		ID:             id,
+		Channel:        channel,
+		StartedAt:      startedAt,
+		EndedAt:        endedAt,
+		Snapshots:      snapshots,
+		UniqueChatters: unique,
+		PeakChatters:   peak,
+	}
+	if unique > 0 {
+		s.AvgSnapshots = float64(presences) / float64(unique)
+	}
+	return s
+}
+
+func (s *Stream) setReturning(n uint64) {
+	s.Returning = n
+	if s.UniqueChatters > 0 {
+		s.ReturningRate = float64(n) / float64(s.UniqueChatters)
+	}
+}
+
+// linkStreams sorts the `streams` of a channel by start, sets the previous
+// stream of each one and returns the streams which ended at or after `from`
+func linkStreams(streams []*Stream, from time.Time) []*Stream {
+	sort.Slice(streams, func(i, j int) bool {
+		a, b := streams[i], streams[j]
+		if !a.StartedAt.Equal(b.StartedAt) {
+			return a.StartedAt.Before(b.StartedAt)
+		}
+		return a.ID < b.ID
+	})
+	r := make([]*Stream, 0, len(streams))
+	for i, s := range streams {
+		if i > 0 {
+			s.PreviousStream = streams[i-1].ID
+		}
+		if !s.EndedAt.Before(from) {
+			r = append(r, s)
+		}
+	}
+	return r
+}
+
+// streamAudience selects the unique chatters of the given streams of a channel
+// as a uniq state
+const streamAudience = `
+    SELECT
+      stream,
+      uniqMergeState(chatters) AS state,
+      uniqMerge(chatters) AS total
+    FROM stream_snapshots
+    WHERE
+      channel = @Channel AND
+      (has(@Streams, stream) OR has(@Previous, stream)) AND
+      snapshot >= @Since AND
+      snapshot <= @To
+    GROUP BY stream
+`
+
+// Streams returns the stream sessions of `channel` live in the time range,
+// sorted by start, with the stats of their chatters. See Stream.
+//
+// The returning chatters of a stream are |A| + |B| - |A ∪ B|, where B are the
+// chatters of the previous stream and the union is the merge of the uniq
+// states of both streams.
+func Streams(db *sql.DB, channel string, from, to time.Time) ([]*Stream, error) {
+	defer metrics.ObserveQuery("clickhouse", "Streams")()
+	l := utils.Logger("query", "q", "Streams")
+
+	since := from.Add(-StreamLookback)
+	rows, err := db.Query(`
+    SELECT
+      stream,
+      min(snapshot) AS started_at,
+      max(snapshot) AS ended_at,
+      count() AS snapshots,
+      uniqMerge(state) AS unique_chatters,
+      max(snapshot_chatters) AS peak,
+      sum(snapshot_chatters) AS presences
+    FROM (
+      SELECT
+        stream, snapshot,
+        uniqMerge(chatters) AS snapshot_chatters,
+        uniqMergeState(chatters) AS state
+      FROM stream_snapshots
+      WHERE
+        channel = @Channel AND
+        snapshot >= @Since AND
+        snapshot <= @To
+      GROUP BY stream, snapshot
+    )
+    GROUP BY stream
+  `,
+		sql.Named("Channel", channel),
+		sql.Named("Since", since),
+		sql.Named("To", to),
+	)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var streams []*Stream
+	for rows.Next() {
+		var (
+			id                             string
+			startedAt, endedAt             time.Time
+			snapshots, unique, peak, total uint64
+		)
+		if err := rows.Scan(&id, &startedAt, &endedAt, &snapshots, &unique, &peak, &total); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		streams = append(streams, newStream(id, channel, startedAt, endedAt, snapshots, unique, peak, total))
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+
+	streams = linkStreams(streams, from)
+	var cur, prev []string
+	byID := make(map[string]*Stream, len(streams))
+	for _, s := range streams {
+		if s.PreviousStream != "" {
+			cur = append(cur, s.ID)
+			prev = append(prev, s.PreviousStream)
+			byID[s.ID] = s
+		}
+	}
+	if len(cur) == 0 {
+		return streams, nil
+	}
+
+	// a stream is at the same index in @Streams as its previous one in
+	// @Previous
+	rows, err = db.Query(`
+    SELECT
+      a.stream,
+      greatest(
+        toInt64(a.total) + toInt64(b.total) -
+        toInt64(arrayReduce('uniqMerge', [a.state, b.state])),
+        0
+      ) AS shared
+    FROM (`+streamAudience+`) AS a
+    CROSS JOIN (`+streamAudience+`) AS b
+    WHERE
+      indexOf(@Streams, a.stream) > 0 AND
+      indexOf(@Streams, a.stream) = indexOf(@Previous, b.stream)
+  `,
+		sql.Named("Channel", channel),
+		sql.Named("Streams", cur),
+		sql.Named("Previous", prev),
+		sql.Named("Since", since),
+		sql.Named("To", to),
+	)
+	if err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id string
+			n  int64
+		)
+		if err := rows.Scan(&id, &n); err != nil {
+			l.Error().Err(err).Msg("error while scanning")
+			return nil, err
+		}
+		if s, ok := byID[id]; ok {
+			s.setReturning(uint64(n))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		l.Error().Err(err).Msg("error while iterating rows")
+		return nil, err
+	}
+	return streams, nil
+}
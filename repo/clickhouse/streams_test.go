@@ -0,0 +1,69 @@
+package clickhouse
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func testStreams(t *testing.T, h *harness) {
+	for _, vw := range []*Viewers{
+		// the previous stream, before the range
+		{Ts: parseTime("2020-10-10T20:00:00Z"), Channel: "a", Stream: "s1", Viewers: []string{"user1", "user2"}},
+		// two snapshots in the same hour
+		{Ts: parseTime("2020-10-11T20:00:00Z"), Channel: "a", Stream: "s2", Viewers: []string{"user1", "user3"}},
+		{Ts: parseTime("2020-10-11T20:10:00Z"), Channel: "a", Stream: "s2", Viewers: []string{"user1"}},
+		{Ts: parseTime("2020-10-11T20:10:00Z"), Channel: "a", Stream: "s2", Viewers: []string{"user3", "user4"}},
+		{Ts: parseTime("2020-10-12T20:00:00Z"), Channel: "a", Stream: "s3", Viewers: []string{"user5"}},
+		// other channels and views without stream are ignored
+		{Ts: parseTime("2020-10-11T20:00:00Z"), Channel: "b", Stream: "s4", Viewers: []string{"user1"}},
+		{Ts: parseTime("2020-10-11T20:00:00Z"), Channel: "a", Viewers: []string{"user6"}},
+	} {
+		if err := h.repo.InsertViewers(context.Background(), vw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := h.repo.Streams("a", parseTime("2020-10-11T00:00:00Z"), parseTime("2020-10-13T00:00:00Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Stream{
+		{
+			ID:             "s2",
+			Channel:        "a",
+			StartedAt:      parseTime("2020-10-11T20:00:00Z"),
+			EndedAt:        parseTime("2020-10-11T20:10:00Z"),
+			Snapshots:      2,
+			UniqueChatters: 3,
+			PeakChatters:   3,
+			AvgSnapshots:   5.0 / 3,
+			PreviousStream: "s1",
+			Returning:      1,
+			ReturningRate:  1.0 / 3,
+		},
+		{
+			ID:             "s3",
+			Channel:        "a",
+			StartedAt:      parseTime("2020-10-12T20:00:00Z"),
+			EndedAt:        parseTime("2020-10-12T20:00:00Z"),
+			Snapshots:      1,
+			UniqueChatters: 1,
+			PeakChatters:   1,
+			AvgSnapshots:   1,
+			PreviousStream: "s2",
+		},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	got, err = h.repo.Streams("c", parseTime("2020-10-11T00:00:00Z"), parseTime("2020-10-13T00:00:00Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no streams, got %d", len(got))
+	}
+}
@@ -8,15 +8,20 @@ import (
 	//lint:ignore ST1001 This library is prepared for dot imports
 	"github.com/pmrt/viewergraph/gen/vg/public/model"
 	. "github.com/pmrt/viewergraph/gen/vg/public/table"
+	"github.com/pmrt/viewergraph/metrics"
 	"github.com/pmrt/viewergraph/utils"
 )
 
-// Tracked retrieves the tracked channels ids from a `db` source
+// Tracked retrieves the tracked channels ids and their scheduling settings
+// from a `db` source
 func Tracked(db *sql.DB) (f []*model.TrackedChannels, err error) {
+	defer metrics.ObserveQuery("postgres", "Tracked")()
 	l := utils.Logger("query")
 
 	stmt := SELECT(
 		TrackedChannels.BroadcasterID,
+		TrackedChannels.TrackIntervalMinutes,
+		TrackedChannels.Priority,
 	).FROM(TrackedChannels)
 
 	if err = stmt.Query(db, &f); err != nil {
@@ -25,3 +30,143 @@ func Tracked(db *sql.DB) (f []*model.TrackedChannels, err error) {
 	}
 	return f, nil
 }
+
+// Channels retrieves the tracked channels with all their metadata from a `db`
+// source
+func Channels(db *sql.DB) (f []*model.TrackedChannels, err error) {
+	defer metrics.ObserveQuery("postgres", "Channels")()
+	l := utils.Logger("query")
+
+	stmt := SELECT(
+		TrackedChannels.AllColumns,
+	).FROM(TrackedChannels)
+
+	if err = stmt.Query(db, &f); err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return f, err
+	}
+	return f, nil
+}
+
+// ScheduleMinutes retrieves the persisted schedule minutes of the tracked
+// channels from a `db` source, keyed by broadcaster id. Channels without a
+// minute are omitted
+func ScheduleMinutes(db *sql.DB) (map[string]uint32, error) {
+	defer metrics.ObserveQuery("postgres", "ScheduleMinutes")()
+	l := utils.Logger("query")
+
+	stmt := SELECT(
+		TrackedChannels.BroadcasterID,
+		TrackedChannels.ScheduleMinute,
+	).FROM(
+		TrackedChannels,
+	).WHERE(
+		TrackedChannels.ScheduleMinute.IS_NOT_NULL(),
+	)
+
+	var f []*model.TrackedChannels
+	if err := stmt.Query(db, &f); err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	m := make(map[string]uint32, len(f))
+	for _, ch := range f {
+		m[ch.BroadcasterID] = uint32(*ch.ScheduleMinute)
+	}
+	return m, nil
+}
+
+// SetScheduleMinute persists the schedule minute `min` of the tracked channel
+// `bid`
+func SetScheduleMinute(db *sql.DB, bid string, min uint32) error {
+	defer metrics.ObserveQuery("postgres", "SetScheduleMinute")()
+	l := utils.Logger("query")
+
+	stmt := TrackedChannels.UPDATE(
+		TrackedChannels.ScheduleMinute,
+	).SET(
+		Int(int64(min)),
+	).WHERE(
+		TrackedChannels.BroadcasterID.EQ(String(bid)),
+	)
+
+	if _, err := stmt.Exec(db); err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return err
+	}
+	return nil
+}
+
+// ScheduleLoads retrieves the persisted schedule loads of the tracked channels
+// from a `db` source, keyed by broadcaster id. Channels without a load are
+// omitted
+func ScheduleLoads(db *sql.DB) (map[string]uint64, error) {
+	defer metrics.ObserveQuery("postgres", "ScheduleLoads")()
+	l := utils.Logger("query")
+
+	stmt := SELECT(
+		TrackedChannels.BroadcasterID,
+		TrackedChannels.ScheduleLoad,
+	).FROM(
+		TrackedChannels,
+	).WHERE(
+		TrackedChannels.ScheduleLoad.IS_NOT_NULL(),
+	)
+
+	var f []*model.TrackedChannels
+	if err := stmt.Query(db, &f); err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return nil, err
+	}
+	m := make(map[string]uint64, len(f))
+	for _, ch := range f {
+		m[ch.BroadcasterID] = uint64(*ch.ScheduleLoad)
+	}
+	return m, nil
+}
+
+// SetScheduleLoad persists the schedule load `load` of the tracked channel
+// `bid`
+func SetScheduleLoad(db *sql.DB, bid string, load uint64) error {
+	defer metrics.ObserveQuery("postgres", "SetScheduleLoad")()
+	l := utils.Logger("query")
+
+	stmt := TrackedChannels.UPDATE(
+		TrackedChannels.ScheduleLoad,
+	).SET(
+		Int(int64(load)),
+	).WHERE(
+		TrackedChannels.BroadcasterID.EQ(String(bid)),
+	)
+
+	if _, err := stmt.Exec(db); err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return err
+	}
+	return nil
+}
+
+// SetChannelSettings persists the scheduling settings of the tracked channel
+// `bid`. A nil `intervalMinutes` means the default interval of the planner
+func SetChannelSettings(db *sql.DB, bid string, intervalMinutes *int32, priority int16) error {
+	defer metrics.ObserveQuery("postgres", "SetChannelSettings")()
+	l := utils.Logger("query")
+
+	stmt := TrackedChannels.UPDATE(
+		TrackedChannels.TrackIntervalMinutes,
+		TrackedChannels.Priority,
+	).MODEL(
+		model.TrackedChannels{
+			TrackIntervalMinutes: intervalMinutes,
+			Priority:             priority,
+		},
+	).WHERE(
+		TrackedChannels.BroadcasterID.EQ(String(bid)),
+	)
+
+	if _, err := stmt.Exec(db); err != nil {
+		l.Error().Err(err).Msg("error while executing query")
+		return err
+	}
+	return nil
+}
@@ -1,30 +1,16 @@
 package postgres
 
 import (
-	"log"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 	"github.com/pmrt/viewergraph/gen/vg/public/model"
 	"github.com/pmrt/viewergraph/utils"
-
-	//lint:ignore ST1001 This library is prepared for dot imports
-	. "github.com/pmrt/viewergraph/gen/vg/public/table"
 )
 
-func insertChannel(channel *model.TrackedChannels) {
-	stmt := TrackedChannels.INSERT(
-		TrackedChannels.AllColumns,
-	).MODEL(channel)
-
-	_, err := stmt.Exec(db)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func TestChannels(t *testing.T) {
-	insertChannel(&model.TrackedChannels{
+func testChannels(t *testing.T, h *harness) {
+	h.insertChannel(&model.TrackedChannels{
 		BroadcasterID:          "36138196",
 		BroadcasterDisplayName: "alexelcapo",
 		BroadcasterUsername:    "alexelcapo",
@@ -33,7 +19,7 @@ func TestChannels(t *testing.T) {
 		OfflineImageURL:        utils.StrPtr("https://static-cdn.jtvnw.net/jtv_user_pictures/bf455aac-4ce9-4daa-94a0-c6c0a1b2500d-channel_offline_image-1920x1080.png"),
 	})
 
-	rows, err := Tracked(db)
+	rows, err := h.repo.Tracked()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -49,3 +35,139 @@ func TestChannels(t *testing.T) {
 		t.Fatal(diff)
 	}
 }
+
+func testScheduleMinutes(t *testing.T, h *harness) {
+	h.insertChannel(&model.TrackedChannels{
+		BroadcasterID:          "234783",
+		BroadcasterDisplayName: "ibai",
+		BroadcasterUsername:    "ibai",
+		BroadcasterType:        model.Broadcastertype_Partner,
+	})
+	h.insertChannel(&model.TrackedChannels{
+		BroadcasterID:          "36138196",
+		BroadcasterDisplayName: "alexelcapo",
+		BroadcasterUsername:    "alexelcapo",
+		BroadcasterType:        model.Broadcastertype_Partner,
+	})
+
+	if err := h.repo.SetScheduleMinute("234783", 42); err != nil {
+		t.Fatal(err)
+	}
+	// untracked channels are ignored
+	if err := h.repo.SetScheduleMinute("1", 10); err != nil {
+		t.Fatal(err)
+	}
+	got, err := h.repo.ScheduleMinutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// channels without a minute are omitted
+	want := map[string]uint32{"234783": 42}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func testScheduleLoads(t *testing.T, h *harness) {
+	h.insertChannel(&model.TrackedChannels{
+		BroadcasterID:          "234783",
+		BroadcasterDisplayName: "ibai",
+		BroadcasterUsername:    "ibai",
+		BroadcasterType:        model.Broadcastertype_Partner,
+	})
+	h.insertChannel(&model.TrackedChannels{
+		BroadcasterID:          "36138196",
+		BroadcasterDisplayName: "alexelcapo",
+		BroadcasterUsername:    "alexelcapo",
+		BroadcasterType:        model.Broadcastertype_Partner,
+	})
+
+	if err := h.repo.SetScheduleLoad("234783", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.repo.SetScheduleLoad("234783", 25000); err != nil {
+		t.Fatal(err)
+	}
+	// untracked channels are ignored
+	if err := h.repo.SetScheduleLoad("1", 10); err != nil {
+		t.Fatal(err)
+	}
+	got, err := h.repo.ScheduleLoads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// channels without a load are omitted
+	want := map[string]uint64{"234783": 25000}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func testSetChannelSettings(t *testing.T, h *harness) {
+	h.insertChannel(&model.TrackedChannels{
+		BroadcasterID:          "1",
+		BroadcasterDisplayName: "partner",
+		BroadcasterUsername:    "partner",
+		BroadcasterType:        model.Broadcastertype_Partner,
+	})
+
+	interval := int32(15)
+	if err := h.repo.SetChannelSettings("1", &interval, 1); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := h.repo.Tracked()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *model.TrackedChannels
+	for _, row := range rows {
+		if row.BroadcasterID == "1" {
+			got = row
+		}
+	}
+	want := &model.TrackedChannels{
+		BroadcasterID:        "1",
+		TrackIntervalMinutes: &interval,
+		Priority:             1,
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// a nil interval restores the default interval of the planner
+	if err := h.repo.SetChannelSettings("1", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	rows, err = h.repo.Tracked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &model.TrackedChannels{BroadcasterID: "1"}
+	if diff := deep.Equal(rows[0], want); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func testChannelMetadata(t *testing.T, h *harness) {
+	want := &model.TrackedChannels{
+		BroadcasterID:          "36138196",
+		BroadcasterDisplayName: "AlexElCapo",
+		BroadcasterUsername:    "alexelcapo",
+		BroadcasterType:        model.Broadcastertype_Partner,
+		ProfileImageURL:        utils.StrPtr("https://static-cdn.jtvnw.net/jtv_user_pictures/alexelcapo-profile_image-300x300.png"),
+		TrackedSince:           time.Date(2022, 6, 22, 15, 0, 0, 0, time.UTC),
+		Priority:               1,
+	}
+	h.insertChannel(want)
+
+	got, err := h.repo.Channels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, []*model.TrackedChannels{want}); diff != nil {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,160 @@
+package postgres
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+)
+
+var ErrAlreadyTracked = errors.New("channel is already tracked")
+
+// Memory is an in-memory Repository with the same semantics as DB, for tests
+// without a Postgres server. Channels are returned in insertion order.
+type Memory struct {
+	mu                 sync.Mutex
+	channels           []*model.TrackedChannels
+	lastReconciliation time.Time
+}
+
+var _ Repository = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Track inserts the tracked channel `ch`, like inserting into the
+// tracked_channels table.
+func (r *Memory) Track(ch *model.TrackedChannels) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.find(ch.BroadcasterID) != nil {
+		return ErrAlreadyTracked
+	}
+	cp := *ch
+	r.channels = append(r.channels, &cp)
+	return nil
+}
+
+// SetLastReconciliation sets the time of the last event reconciliation to `t`
+func (r *Memory) SetLastReconciliation(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastReconciliation = t
+}
+
+func (r *Memory) find(bid string) *model.TrackedChannels {
+	for _, ch := range r.channels {
+		if ch.BroadcasterID == bid {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (r *Memory) Tracked() ([]*model.TrackedChannels, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// only the selected columns are returned, like in Tracked
+	f := make([]*model.TrackedChannels, 0, len(r.channels))
+	for _, ch := range r.channels {
+		var interval *int32
+		if ch.TrackIntervalMinutes != nil {
+			v := *ch.TrackIntervalMinutes
+			interval = &v
+		}
+		f = append(f, &model.TrackedChannels{
+			BroadcasterID:        ch.BroadcasterID,
+			TrackIntervalMinutes: interval,
+			Priority:             ch.Priority,
+		})
+	}
+	return f, nil
+}
+
+func (r *Memory) Channels() ([]*model.TrackedChannels, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := make([]*model.TrackedChannels, 0, len(r.channels))
+	for _, ch := range r.channels {
+		cp := *ch
+		f = append(f, &cp)
+	}
+	return f, nil
+}
+
+func (r *Memory) ScheduleMinutes() (map[string]uint32, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := make(map[string]uint32, len(r.channels))
+	for _, ch := range r.channels {
+		if ch.ScheduleMinute != nil {
+			m[ch.BroadcasterID] = uint32(*ch.ScheduleMinute)
+		}
+	}
+	return m, nil
+}
+
+func (r *Memory) SetScheduleMinute(bid string, min uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// updating an untracked channel is a no-op, like an UPDATE without rows
+	if ch := r.find(bid); ch != nil {
+		v := int16(min)
+		ch.ScheduleMinute = &v
+	}
+	return nil
+}
+
+func (r *Memory) ScheduleLoads() (map[string]uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := make(map[string]uint64, len(r.channels))
+	for _, ch := range r.channels {
+		if ch.ScheduleLoad != nil {
+			m[ch.BroadcasterID] = uint64(*ch.ScheduleLoad)
+		}
+	}
+	return m, nil
+}
+
+func (r *Memory) SetScheduleLoad(bid string, load uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ch := r.find(bid); ch != nil {
+		v := int64(load)
+		ch.ScheduleLoad = &v
+	}
+	return nil
+}
+
+func (r *Memory) SetChannelSettings(bid string, intervalMinutes *int32, priority int16) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ch := r.find(bid); ch != nil {
+		ch.TrackIntervalMinutes = nil
+		if intervalMinutes != nil {
+			v := *intervalMinutes
+			ch.TrackIntervalMinutes = &v
+		}
+		ch.Priority = priority
+	}
+	return nil
+}
+
+func (r *Memory) LastReconciliation() (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.lastReconciliation, nil
+}
@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	//lint:ignore ST1001 This library is prepared for dot imports
+	. "github.com/go-jet/jet/v2/postgres"
+	"github.com/go-jet/jet/v2/qrm"
+
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+	//lint:ignore ST1001 This library is prepared for dot imports
+	. "github.com/pmrt/viewergraph/gen/vg/public/table"
+	"github.com/pmrt/viewergraph/metrics"
+	"github.com/pmrt/viewergraph/utils"
+)
+
+// LastReconciliation retrieves the time of the last event reconciliation from
+// a `db` source. It returns the zero time if there was none
+func LastReconciliation(db *sql.DB) (time.Time, error) {
+	defer metrics.ObserveQuery("postgres", "LastReconciliation")()
+	l := utils.Logger("query")
+
+	stmt := SELECT(
+		VgOptions.LastReconciliationAt,
+	).FROM(VgOptions)
+
+	var opts model.VgOptions
+	if err := stmt.Query(db, &opts); err != nil {
+		if errors.Is(err, qrm.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		l.Error().Err(err).Msg("error while executing query")
+		return time.Time{}, err
+	}
+	if opts.LastReconciliationAt == nil {
+		return time.Time{}, nil
+	}
+	return *opts.LastReconciliationAt, nil
+}
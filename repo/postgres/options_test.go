@@ -0,0 +1,27 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func testLastReconciliation(t *testing.T, h *harness) {
+	got, err := h.repo.LastReconciliation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsZero() {
+		t.Fatalf("expected zero time without reconciliations, got %s", got)
+	}
+
+	want := time.Date(2022, 6, 22, 15, 0, 0, 0, time.UTC)
+	h.setLastReconciliation(want)
+
+	got, err = h.repo.LastReconciliation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
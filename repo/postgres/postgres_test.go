@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"os"
@@ -13,13 +14,28 @@ import (
 	pg "github.com/pmrt/viewergraph/database/postgres"
 )
 
+// db is nil when docker is unavailable, see requireDB
 var db *sql.DB
 
+// requireDB skips the test when there is no database to run it against
+func requireDB(t *testing.T) {
+	t.Helper()
+	if db == nil {
+		t.Skip("docker is unavailable, skipping database test")
+	}
+}
+
 func TestMain(m *testing.M) {
-	// Run a docker with a database for testing
+	// Run a docker with a database for testing. Without docker only the tests
+	// which don't need a database are run, e.g. the conformance suite against
+	// the in-memory repository.
 	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
 	if err != nil {
-		panic(err)
+		log.Printf("docker is unavailable: %v", err)
+		os.Exit(m.Run())
 	}
 	res, err := pool.RunWithOptions(&dockertest.RunOptions{
 		Repository: "postgres",
@@ -40,7 +56,7 @@ func TestMain(m *testing.M) {
 	res.Expire(120)
 
 	// Prepare a connection to the db in the docker
-	sto := database.New(
+	sto, err := database.New(
 		pg.New(&database.StorageOptions{
 			StorageHost:            res.GetBoundIP("5432/tcp"),
 			StoragePort:            res.GetPort("5432/tcp"),
@@ -53,14 +69,19 @@ func TestMain(m *testing.M) {
 			StorageConnTimeout:     60 * time.Second,
 			DebugMode:              true,
 
-			MigrationVersion: 1,
-			MigrationPath:    "../../database/postgres/migrations",
+			MigrationVersion: 3,
 		}))
+	if err != nil {
+		panic(err)
+	}
 	db = sto.Conn()
 
 	// Run tests
 	code := m.Run()
 
+	if err := sto.Close(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 	if err := pool.Purge(res); err != nil {
 		log.Fatal(err)
 	}
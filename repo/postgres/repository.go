@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+)
+
+// Repository is the store of the tracked channels and the options of
+// viewergraph. DB implements it on top of Postgres and Memory in memory, for
+// tests.
+type Repository interface {
+	// Tracked returns the tracked channels ids and their scheduling settings
+	Tracked() ([]*model.TrackedChannels, error)
+	// Channels returns the tracked channels with all their metadata
+	Channels() ([]*model.TrackedChannels, error)
+	// ScheduleMinutes returns the persisted schedule minutes of the tracked
+	// channels, keyed by broadcaster id
+	ScheduleMinutes() (map[string]uint32, error)
+	SetScheduleMinute(bid string, min uint32) error
+	// ScheduleLoads returns the persisted schedule loads of the tracked
+	// channels, keyed by broadcaster id
+	ScheduleLoads() (map[string]uint64, error)
+	SetScheduleLoad(bid string, load uint64) error
+	SetChannelSettings(bid string, intervalMinutes *int32, priority int16) error
+	// LastReconciliation returns the time of the last event reconciliation,
+	// the zero time if there was none
+	LastReconciliation() (time.Time, error)
+}
+
+// DB is the Repository of a Postgres `db` source.
+type DB struct {
+	db *sql.DB
+}
+
+var _ Repository = (*DB)(nil)
+
+func New(db *sql.DB) *DB {
+	return &DB{db: db}
+}
+
+func (r *DB) Tracked() ([]*model.TrackedChannels, error) {
+	return Tracked(r.db)
+}
+
+func (r *DB) Channels() ([]*model.TrackedChannels, error) {
+	return Channels(r.db)
+}
+
+func (r *DB) ScheduleMinutes() (map[string]uint32, error) {
+	return ScheduleMinutes(r.db)
+}
+
+func (r *DB) SetScheduleMinute(bid string, min uint32) error {
+	return SetScheduleMinute(r.db, bid, min)
+}
+
+func (r *DB) ScheduleLoads() (map[string]uint64, error) {
+	return ScheduleLoads(r.db)
+}
+
+func (r *DB) SetScheduleLoad(bid string, load uint64) error {
+	return SetScheduleLoad(r.db, bid, load)
+}
+
+func (r *DB) SetChannelSettings(bid string, intervalMinutes *int32, priority int16) error {
+	return SetChannelSettings(r.db, bid, intervalMinutes, priority)
+}
+
+func (r *DB) LastReconciliation() (time.Time, error) {
+	return LastReconciliation(r.db)
+}
@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	//lint:ignore ST1001 This library is prepared for dot imports
+	. "github.com/go-jet/jet/v2/postgres"
+
+	"github.com/pmrt/viewergraph/gen/vg/public/model"
+	//lint:ignore ST1001 This library is prepared for dot imports
+	. "github.com/pmrt/viewergraph/gen/vg/public/table"
+)
+
+// harness is a Repository under test along with the helpers to seed its
+// tables
+type harness struct {
+	repo                  Repository
+	insertChannel         func(ch *model.TrackedChannels)
+	setLastReconciliation func(t time.Time)
+}
+
+// conformance are the tests every Repository must pass
+var conformance = []struct {
+	name string
+	test func(t *testing.T, h *harness)
+}{
+	{"Channels", testChannels},
+	{"ChannelMetadata", testChannelMetadata},
+	{"ScheduleMinutes", testScheduleMinutes},
+	{"ScheduleLoads", testScheduleLoads},
+	{"SetChannelSettings", testSetChannelSettings},
+	{"LastReconciliation", testLastReconciliation},
+}
+
+func runConformance(t *testing.T, newHarness func(t *testing.T) *harness) {
+	for _, c := range conformance {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.test(t, newHarness(t))
+		})
+	}
+}
+
+func TestConformanceMemory(t *testing.T) {
+	runConformance(t, func(t *testing.T) *harness {
+		m := NewMemory()
+		return &harness{
+			repo: m,
+			insertChannel: func(ch *model.TrackedChannels) {
+				if err := m.Track(ch); err != nil {
+					t.Fatal(err)
+				}
+			},
+			setLastReconciliation: m.SetLastReconciliation,
+		}
+	})
+}
+
+func TestConformanceDB(t *testing.T) {
+	requireDB(t)
+	runConformance(t, func(t *testing.T) *harness {
+		cleanTables()
+		t.Cleanup(cleanTables)
+		return &harness{
+			repo:                  New(db),
+			insertChannel:         insertChannel,
+			setLastReconciliation: setLastReconciliation,
+		}
+	})
+}
+
+func cleanTables() {
+	if _, err := db.Exec("TRUNCATE TABLE tracked_channels, vg_options"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func insertChannel(channel *model.TrackedChannels) {
+	stmt := TrackedChannels.INSERT(
+		TrackedChannels.AllColumns,
+	).MODEL(channel)
+
+	_, err := stmt.Exec(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setLastReconciliation(t time.Time) {
+	stmt := VgOptions.INSERT(
+		VgOptions.SinglerowID, VgOptions.LastReconciliationAt,
+	).VALUES(
+		true, TimestampT(t),
+	)
+	if _, err := stmt.Exec(db); err != nil {
+		log.Fatal(err)
+	}
+}
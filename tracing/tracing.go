@@ -0,0 +1,128 @@
+// Package tracing sets up OpenTelemetry tracing. Spans follow a stream from
+// the webhook notification to the inserts in ClickHouse:
+//
+//	helix.webhook -> planner.stream_online -> planner.worker -> batcher.flush
+//	-> clickhouse.InsertViewers
+//
+// Runs of the worker started by the ticker of an executor are new traces
+// (planner.tick) linked to the planner.stream_online span, so traces don't
+// last as long as the stream.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/pmrt/viewergraph/utils"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrUnknownExporter = errors.New("unknown tracing exporter")
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+const instrumentationName = "github.com/pmrt/viewergraph"
+
+type TracingOpts struct {
+	// Exporter is one of ExporterOTLP, ExporterStdout or ExporterNone. Empty
+	// means ExporterNone.
+	Exporter string
+	// OTLPEndpoint is the host:port of the OTLP/HTTP collector. Defaults to
+	// localhost:4318.
+	OTLPEndpoint string
+	// OTLPInsecure disables TLS for the OTLP exporter, e.g. for a local
+	// collector.
+	OTLPInsecure bool
+	// Writer is where the stdout exporter writes the spans. Defaults to
+	// os.Stdout.
+	Writer io.Writer
+	// ServiceName is the name of the service in the spans. Defaults to
+	// viewergraph.
+	ServiceName string
+	// SampleRatio is the ratio of traces sampled, in the range [0, 1].
+	SampleRatio float64
+}
+
+// Setup sets up the global tracer provider with the exporter in `opts`. The
+// returned function flushes the pending spans and shuts down the exporter.
+//
+// With ExporterNone nothing is set up, spans are no-ops.
+func Setup(ctx context.Context, opts *TracingOpts) (shutdown func(context.Context) error, err error) {
+	var exp sdktrace.SpanExporter
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w := opts.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		endpoint := opts.OTLPEndpoint
+		if endpoint == "" {
+			endpoint = "localhost:4318"
+		}
+		o := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+		if opts.OTLPInsecure {
+			o = append(o, otlptracehttp.WithInsecure())
+		}
+		exp, err = otlptracehttp.New(ctx, o...)
+	default:
+		return nil, ErrUnknownExporter
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name := opts.ServiceName
+	if name == "" {
+		name = "viewergraph"
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(name),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	return tp.Shutdown, nil
+}
+
+// Start starts a span with the global tracer provider. See trace.Tracer.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// Logger returns the logger carried by `ctx` (see utils.Ctx) with the ids of
+// the current span, if any, so logs can be correlated with traces.
+func Logger(ctx context.Context) zerolog.Logger {
+	l := utils.Ctx(ctx)
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return l
+	}
+	return l.With().
+		Str("trace_id", sc.TraceID().String()).
+		Str("span_id", sc.SpanID().String()).
+		Logger()
+}
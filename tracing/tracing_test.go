@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetupStdout(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+
+	var buf bytes.Buffer
+	shutdown, err := Setup(context.Background(), &TracingOpts{
+		Exporter:    ExporterStdout,
+		Writer:      &buf,
+		SampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := Start(context.Background(), "test.span")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Name     string
+		Resource []struct {
+			Key   string
+			Value struct{ Value any }
+		}
+	}
+	if err := json.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "test.span" {
+		t.Fatalf("got span name %q, want test.span", got.Name)
+	}
+	var service any
+	for _, kv := range got.Resource {
+		if kv.Key == "service.name" {
+			service = kv.Value.Value
+		}
+	}
+	if service != "viewergraph" {
+		t.Fatalf("got service name %v, want viewergraph", service)
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), &TracingOpts{Exporter: "jaeger"}); !errors.Is(err, ErrUnknownExporter) {
+		t.Fatalf("expected ErrUnknownExporter, got %v", err)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := zerolog.New(&buf).WithContext(context.Background())
+
+	// without span, the logger of the context is used as is
+	l := Logger(ctx)
+	l.Info().Msg("")
+	if strings.Contains(buf.String(), "trace_id") {
+		t.Fatal("expected no trace_id without span")
+	}
+	buf.Reset()
+
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+	if _, err := Setup(context.Background(), &TracingOpts{Exporter: ExporterStdout, Writer: &bytes.Buffer{}, SampleRatio: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := Start(ctx, "test.span")
+	defer span.End()
+
+	l = Logger(ctx)
+	l.Info().Msg("")
+	var got map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["trace_id"] != span.SpanContext().TraceID().String() || got["span_id"] != span.SpanContext().SpanID().String() {
+		t.Fatalf("expected log to carry the ids of the span, got %v", got)
+	}
+}
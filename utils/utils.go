@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"math"
 	"reflect"
 	"unsafe"
@@ -89,3 +90,12 @@ func Prepend(dst []byte, src []byte) []byte {
 	// return dst with the new length
 	return dst
 }
+
+// Ctx returns the logger carried by `ctx`, see zerolog.Logger.WithContext().
+// If `ctx` carries no logger, it returns the global logger.
+func Ctx(ctx context.Context) zerolog.Logger {
+	if lg := zerolog.Ctx(ctx); lg.GetLevel() != zerolog.Disabled {
+		return *lg
+	}
+	return l.Logger
+}